@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// ErrorResponse is the JSON body of a failed API request
+// Invalid says which input was rejected and why, when it was a domain name that didn't pass validation
+type ErrorResponse struct {
+	Error   string                `json:"error"`
+	Invalid *services.DomainError `json:"invalid,omitempty"`
+}
+
+// StatsResponse is the JSON body returned by /api/v1/stats
+type StatsResponse struct {
+	Domain     string                      `json:"domain"`
+	TotalCerts int                         `json:"totalCerts"`
+	Issuers    services.IssuerDistribution `json:"issuers"`
+	Lifetimes  services.LifetimeHistogram  `json:"lifetimes"`
+	Error      string                      `json:"error,omitempty"`
+}
+
+// apiSearchHandler returns the same grouped results as /search, but as JSON
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	writeJSON(w, data.status, data)
+}
+
+// EstimateResponse is the pre-flight answer of /api/v1/estimate
+type EstimateResponse struct {
+	Domain string `json:"domain"`
+	Known  bool   `json:"known"` // Whether the domain has been searched; the estimate is left out if not
+	*services.ResultEstimate
+	Error string `json:"error,omitempty"`
+}
+
+// apiEstimateHandler says how large a search for ?domain= will likely be, without searching, from the
+// last search's results, so clients can warn about or split up large domains before fetching them
+func apiEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	domain, err := services.NormalizeDomain(strings.TrimSpace(r.URL.Query().Get("domain")))
+	if err != nil {
+		status, message := serviceError(err)
+		writeJSON(w, status, EstimateResponse{Domain: r.URL.Query().Get("domain"), Error: message})
+		return
+	}
+	response := EstimateResponse{Domain: domain}
+	if estimate, ok := services.EstimateResults(domain); ok {
+		response.Known, response.ResultEstimate = true, &estimate
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// apiStatsHandler returns the analytics for a search (same filters as /search)
+func apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	writeJSON(w, data.status, StatsResponse{
+		Domain:     data.Domain,
+		TotalCerts: data.TotalCerts,
+		Issuers:    data.Stats,
+		Lifetimes:  data.Lifetimes,
+		Error:      data.Error,
+	})
+}
+
+// TimelineResponse is the JSON body returned by /api/v1/timeline
+type TimelineResponse struct {
+	Domain   string                   `json:"domain"`
+	Timeline []services.TimelinePoint `json:"timeline"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// apiTimelineHandler returns month-by-month issuance and active counts for charting
+func apiTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	writeJSON(w, data.status, TimelineResponse{
+		Domain:   data.Domain,
+		Timeline: services.IssuanceTimeline(data.groups, time.Now()),
+		Error:    data.Error,
+	})
+}
+
+// InventoryResponse is the JSON body returned by /api/v1/inventory
+type InventoryResponse struct {
+	Domain    string                      `json:"domain"`
+	Inventory services.SubdomainInventory `json:"inventory"`
+	Error     string                      `json:"error,omitempty"`
+}
+
+// apiInventoryHandler returns every hostname seen in CT for a domain
+func apiInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	writeJSON(w, data.status, InventoryResponse{
+		Domain:    data.Domain,
+		Inventory: services.BuildSubdomainInventory(data.Domain, data.groups, time.Now()),
+		Error:     data.Error,
+	})
+}
+
+// apiCoOccurrenceHandler returns the other domains that share certificates with the searched domain
+func apiCoOccurrenceHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Shared)
+}
+
+// apiRenewalsHandler returns renewal intervals and coverage gaps per hostname
+func apiRenewalsHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, services.AnalyzeRenewals(data.Domain, data.groups))
+}
+
+// apiLetsEncryptHandler estimates the domain's use of Let's Encrypt's rate limits from CT
+func apiLetsEncryptHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, services.EstimateLetsEncryptUsage(data.Domain, data.groups, time.Now()))
+}
+
+// apiWatchlistHandler lists (GET), adds (POST) or removes (DELETE) the logged-in user's watched domains
+// The domain is passed as ?domain= for POST and DELETE
+func apiWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
+	if domain != "" {
+		ascii, err := services.NormalizeDomain(domain)
+		if err != nil {
+			status, message := serviceError(err)
+			response := ErrorResponse{Error: message}
+			errors.As(err, &response.Invalid)
+			writeJSON(w, status, response)
+			return
+		}
+		domain = ascii
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, watchlist.ListFor(currentUsername(r)))
+	case http.MethodPost:
+		if err := watchlist.Add(currentUsername(r), domain); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		auditAction(r, "watchlist.add", services.NormalizeName(domain), "")
+		// Record the baseline right away rather than waiting for the next refresh
+		go checkWatchedDomain(watchlist, services.NormalizeName(domain))
+		writeJSON(w, http.StatusCreated, map[string]string{"domain": services.NormalizeName(domain)})
+	case http.MethodDelete:
+		removed, err := watchlist.Remove(currentUsername(r), domain)
+		if errors.Is(err, services.ErrSharedDomain) {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if !removed {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "domain is not on the watchlist"})
+			return
+		}
+		auditAction(r, "watchlist.remove", services.NormalizeName(domain), "")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// apiAlertsHandler returns the most recent alerts for the logged-in user's watchlist, newest first (?limit=, default 100)
+func apiAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	writeJSON(w, http.StatusOK, watchlist.RecentAlerts(currentUsername(r), limit))
+}
+
+// apiAcknowledgeAlertHandler marks an alert for the logged-in user's watchlist as seen to (POST), returning it
+func apiAcknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	alert, err := watchlist.AcknowledgeAlert(currentUsername(r), r.PathValue("id"), time.Now())
+	if errors.Is(err, services.ErrAlertNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	auditAction(r, "alert.acknowledge", alert.ID, alert.Message)
+	writeJSON(w, http.StatusOK, alert)
+}
+
+// setRetryAfter tells API clients how many seconds to wait before trying again, when crt.sh asked us to wait
+func setRetryAfter(w http.ResponseWriter, seconds int) {
+	if seconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
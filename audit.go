@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// auditPageLimit is how many entries the audit page shows
+const auditPageLimit = 500
+
+// exportFlushRows is how many rows a streaming export writes between flushes
+const exportFlushRows = 500
+
+// auditLog records who did what, nil-safe via auditAction and auditSystemAction
+var auditLog *services.AuditLog
+
+// auditedSearchParams are the query parameters that make a GET request a search worth auditing
+var auditedSearchParams = []string{"domain", "keyword", "host", "email"}
+
+// AuditData holds data to pass to the audit template
+type AuditData struct {
+	Actor   string
+	Action  string
+	Text    string
+	Since   string
+	Until   string
+	Entries []services.AuditEntry
+	Limit   int
+	Error   string
+}
+
+// auditAction records an action by the user making the request
+func auditAction(r *http.Request, action, target, detail string) {
+	auditActorAction(r, currentUsername(r), action, target, detail)
+}
+
+// auditActorAction records an action by a named user, for requests made before they're logged in
+func auditActorAction(r *http.Request, actor, action, target, detail string) {
+	if actor == "" {
+		actor = "anonymous"
+	}
+	recordAudit(services.AuditEntry{
+		Actor:      actor,
+		Action:     action,
+		Target:     target,
+		Detail:     detail,
+		RemoteAddr: r.RemoteAddr,
+	})
+}
+
+// auditSystemAction records an action the server took on its own
+func auditSystemAction(action, target, detail string) {
+	recordAudit(services.AuditEntry{
+		Actor:  services.AuditSystem,
+		Action: action,
+		Target: target,
+		Detail: detail,
+	})
+}
+
+// recordAudit writes an entry, logging rather than failing the request if it can't
+func recordAudit(entry services.AuditEntry) {
+	if auditLog == nil {
+		return
+	}
+	if err := auditLog.Record(entry); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// auditSearches records every GET request that looks up a domain, keyword, host or email address
+// It must run inside requireLogin so the user is known
+func auditSearches(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			query := r.URL.Query()
+			for _, param := range auditedSearchParams {
+				if value := strings.TrimSpace(query.Get(param)); value != "" {
+					auditAction(r, "search", value, r.URL.Path+"?"+r.URL.RawQuery)
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditHandler lets admins browse the audit log (?format=csv or json to export)
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	data := runAuditQuery(r)
+
+	switch r.URL.Query().Get("format") {
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+		writeJSON(w, http.StatusOK, data.Entries)
+		return
+	case "csv":
+		writeAuditCSV(w, data.Entries)
+		return
+	}
+
+	tmpl, err := parseTemplate("audit.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiAuditHandler returns matching audit entries, newest first
+// Filters: ?actor=, ?action= (a trailing "." matches a group, e.g. "user."), ?q=, ?since=, ?until= (YYYY-MM-DD), ?limit=
+func apiAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminJSON(w, r) {
+		return
+	}
+
+	data := runAuditQuery(r)
+	if data.Error != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, http.StatusOK, data.Entries)
+}
+
+// runAuditQuery parses the audit filters from the query string and runs them
+// The page shows the newest auditPageLimit entries unless ?limit= says otherwise; exports default to everything
+func runAuditQuery(r *http.Request) AuditData {
+	data, query := parseAuditQuery(r)
+	if data.Error != "" || auditLog == nil {
+		return data
+	}
+	data.Entries = auditLog.Query(query)
+	return data
+}
+
+// parseAuditQuery reads the audit filters from the query string; data.Error says what was invalid
+func parseAuditQuery(r *http.Request) (AuditData, services.AuditQuery) {
+	params := r.URL.Query()
+	data := AuditData{
+		Actor:  strings.TrimSpace(params.Get("actor")),
+		Action: strings.TrimSpace(params.Get("action")),
+		Text:   strings.TrimSpace(params.Get("q")),
+		Since:  params.Get("since"),
+		Until:  params.Get("until"),
+	}
+	query := services.AuditQuery{Actor: data.Actor, Action: data.Action, Text: data.Text}
+
+	if data.Since != "" {
+		since, err := time.Parse("2006-01-02", data.Since)
+		if err != nil {
+			data.Error = "Invalid since date, use YYYY-MM-DD"
+			data.Entries = make([]services.AuditEntry, 0)
+			return data, query
+		}
+		query.Since = since
+	}
+	if data.Until != "" {
+		until, err := time.Parse("2006-01-02", data.Until)
+		if err != nil {
+			data.Error = "Invalid until date, use YYYY-MM-DD"
+			data.Entries = make([]services.AuditEntry, 0)
+			return data, query
+		}
+		// Include the whole day
+		query.Until = until.AddDate(0, 0, 1)
+	}
+
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit > 0 {
+		query.Limit = limit
+	} else if params.Get("format") == "" && !strings.HasPrefix(r.URL.Path, "/api/") {
+		query.Limit = auditPageLimit
+	}
+	data.Limit = query.Limit
+	data.Entries = make([]services.AuditEntry, 0)
+	return data, query
+}
+
+// apiAuditExportHandler streams every matching audit entry in the log file, oldest first, as NDJSON
+// (the default) or ?format=csv, writing each row as it's read so a log of any size can be exported
+// Takes the same filters as apiAuditHandler; ?limit= defaults to everything
+func apiAuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminJSON(w, r) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown format, use ndjson or csv"})
+		return
+	}
+	data, query := parseAuditQuery(r)
+	if data.Error != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": data.Error})
+		return
+	}
+
+	var emit func(services.AuditEntry) error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		out := csv.NewWriter(w)
+		out.Write(auditCSVHeader)
+		emit = func(entry services.AuditEntry) error {
+			out.Write(auditCSVRow(entry))
+			return out.Error()
+		}
+		defer out.Flush()
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		encoder := json.NewEncoder(w)
+		emit = func(entry services.AuditEntry) error {
+			return encoder.Encode(entry)
+		}
+	}
+
+	// Sending the headers straight away switches the compression middleware to streaming,
+	// and every exportFlushRows rows go out rather than piling up in buffers
+	controller := http.NewResponseController(w)
+	controller.Flush()
+	if auditLog == nil {
+		return
+	}
+	rows := 0
+	err := auditLog.Export(query, func(entry services.AuditEntry) error {
+		if err := emit(entry); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			controller.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status has gone out; a truncated file is all the client can be told
+		log.Printf("audit export: %v", err)
+	}
+}
+
+// writeAuditCSV sends audit entries as a CSV download
+func writeAuditCSV(w http.ResponseWriter, entries []services.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write(auditCSVHeader)
+	for _, entry := range entries {
+		out.Write(auditCSVRow(entry))
+	}
+	out.Flush()
+}
+
+// auditCSVHeader names the columns of an audit CSV export
+var auditCSVHeader = []string{"time", "actor", "action", "target", "detail", "remote_addr"}
+
+// auditCSVRow is an audit entry as a CSV export row
+func auditCSVRow(entry services.AuditEntry) []string {
+	return []string{
+		entry.Time.Format(time.RFC3339),
+		services.CSVSafe(entry.Actor),
+		entry.Action,
+		services.CSVSafe(entry.Target),
+		services.CSVSafe(entry.Detail),
+		entry.RemoteAddr,
+	}
+}
+
+// requireAdmin reports whether the request may use admin pages, writing a 403 if not
+// Without accounts there are no admins, so everyone may
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if users == nil {
+		return true
+	}
+	if user, _ := currentUser(r); !user.Admin {
+		http.Error(w, tr(r, "Only admins can view this page"), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireAdminJSON is requireAdmin for the API, writing the 403 as JSON
+func requireAdminJSON(w http.ResponseWriter, r *http.Request) bool {
+	if users == nil {
+		return true
+	}
+	if user, _ := currentUser(r); !user.Admin {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "only admins can do this"})
+		return false
+	}
+	return true
+}
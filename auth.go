@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// sessionCookie holds the session token
+const sessionCookie = "session"
+
+// Accounts and sessions, nil unless the server was started with -users
+var (
+	users    *services.UserStore
+	sessions services.SessionStore
+)
+
+// loginThrottle locks out usernames and addresses that keep getting the password wrong
+var loginThrottle = services.NewLoginThrottle()
+
+// tooManyLogins is the error shown while the login throttle refuses a password
+const tooManyLogins = "Too many failed logins, try again later"
+
+// secureCookies marks the session cookie Secure even on plain HTTP, for servers behind a TLS-terminating proxy
+var secureCookies bool
+
+// userKey is the request context key for the logged-in user
+type userKey struct{}
+
+// publicPaths can be visited without logging in
+var publicPaths = map[string]bool{
+	"/login":               true,
+	"/setup":               true,
+	"/login/oidc":          true,
+	"/login/oidc/callback": true,
+	"/healthz":             true,
+}
+
+// AuthData holds data to pass to the login, account and users templates
+type AuthData struct {
+	User     services.User
+	Users    []services.User    // For admins managing accounts
+	Sessions []services.Session // The user's logged-in browsers
+	Current  string             // ID of this browser's session
+	Setup    bool               // Creating the first account
+	SSO      bool               // Single sign-on is available
+	Proxy    bool               // Logins come from an authenticating reverse proxy
+	Local    bool               // Logging in with a password is available
+	Next     string             // Where to go after logging in
+	Message  string
+	Error    string
+}
+
+// requireLogin only lets logged-in users through, except to the login and setup pages
+// Browsers are sent to /login; API clients get a 401 and may use HTTP Basic auth instead of a session,
+// or a 429 while too many wrong passwords have locked them out
+func requireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Branding files are public too, so the login page can show the logo
+		if publicPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Nobody can log in until the first account exists, unless accounts come from single sign-on or a proxy
+		if users.Count() == 0 && oidcProvider == nil && proxyAuth == nil {
+			http.Redirect(w, r, "/setup", http.StatusSeeOther)
+			return
+		}
+
+		user, ok, throttled := authenticate(r)
+		if ok {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+			return
+		}
+
+		if throttled {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": tr(r, tooManyLogins)})
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="certificate-viewer"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "login required"})
+			return
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// authenticate finds the user from a trusted proxy's headers, the session cookie, or HTTP Basic auth on
+// machine endpoints, reporting whether Basic auth was refused by the login throttle
+// Browsers resend Basic credentials on every request to the site, including form posts from other sites, so
+// pages only take the session cookie, which is SameSite
+func authenticate(r *http.Request) (user services.User, ok, throttled bool) {
+	if user, ok := proxyUser(r); ok {
+		return user, true, false
+	}
+	if session, ok := currentSession(r); ok {
+		user, ok := users.Get(session.Username)
+		return user, ok, false
+	}
+	if !basicAuthPath(r.URL.Path) {
+		return services.User{}, false, false
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		return passwordLogin(r, username, password)
+	}
+	return services.User{}, false, false
+}
+
+// basicAuthPath reports whether HTTP Basic auth is taken on path: the API and Prometheus scrapes
+func basicAuthPath(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/metrics"
+}
+
+// passwordLogin checks a username and password unless the login throttle has locked either the username
+// or the client's address out, recording how it went
+func passwordLogin(r *http.Request, username, password string) (user services.User, ok, throttled bool) {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	username = strings.ToLower(strings.TrimSpace(username))
+	now := time.Now()
+	if !loginThrottle.Allow(username, addr, now) {
+		return services.User{}, false, true
+	}
+	user, ok = users.Authenticate(username, password)
+	if !ok {
+		loginThrottle.Failed(username, addr, now)
+		return services.User{}, false, false
+	}
+	loginThrottle.Succeeded(username)
+	return user, true, false
+}
+
+// currentSession returns the session the request's cookie belongs to, if it's still live
+func currentSession(r *http.Request) (services.Session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return services.Session{}, false
+	}
+	session, ok, err := sessions.Get(cookie.Value)
+	if err != nil {
+		log.Printf("sessions: %v", err)
+		return services.Session{}, false
+	}
+	return session, ok
+}
+
+// currentUser returns the logged-in user, if authentication is enabled and someone is logged in
+func currentUser(r *http.Request) (services.User, bool) {
+	user, ok := r.Context().Value(userKey{}).(services.User)
+	return user, ok
+}
+
+// currentUsername returns the logged-in user's name, or "" when accounts are disabled
+// Per-user state such as saved searches and watchlists is keyed by it
+func currentUsername(r *http.Request) string {
+	user, _ := currentUser(r)
+	return user.Username
+}
+
+// loginHandler shows the login form (GET) and logs in (POST)
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	data := AuthData{
+		Next:  safeNext(r.FormValue("next")),
+		SSO:   oidcProvider != nil,
+		Proxy: proxyAuth != nil,
+		Local: (oidcProvider == nil && proxyAuth == nil) || users.HasLocalUsers(),
+	}
+
+	// The proxy has already logged them in
+	if _, ok := proxyUser(r); ok {
+		http.Redirect(w, r, data.Next, http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		user, ok, throttled := passwordLogin(r, r.FormValue("username"), r.FormValue("password"))
+		if ok {
+			if err := startSession(w, r, user.Username); err != nil {
+				data.Error = err.Error()
+			} else {
+				auditActorAction(r, user.Username, "user.login", user.Username, "password")
+				http.Redirect(w, r, data.Next, http.StatusSeeOther)
+				return
+			}
+		} else if throttled {
+			auditAction(r, "user.login_failed", r.FormValue("username"), "password: locked out after too many failures")
+			data.Error = tooManyLogins
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			auditAction(r, "user.login_failed", r.FormValue("username"), "password")
+			data.Error = "Incorrect username or password"
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}
+
+	renderAuthPage(w, r, "login.html", data)
+}
+
+// setupHandler creates the first (admin) account; it is only available while there are none
+// With single sign-on or proxy auth, admins come from the identity provider's groups instead
+func setupHandler(w http.ResponseWriter, r *http.Request) {
+	if users.Count() > 0 || oidcProvider != nil || proxyAuth != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	data := AuthData{Setup: true, Next: "/"}
+
+	if r.Method == http.MethodPost {
+		username := strings.ToLower(strings.TrimSpace(r.FormValue("username")))
+		if err := users.Create(username, r.FormValue("password"), true); err != nil {
+			data.Error = err.Error()
+		} else if err := startSession(w, r, username); err != nil {
+			data.Error = err.Error()
+		} else {
+			auditActorAction(r, username, "user.create", username, "first admin account")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	renderAuthPage(w, r, "login.html", data)
+}
+
+// logoutHandler ends the session
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	if session, ok := currentSession(r); ok {
+		auditActorAction(r, session.Username, "user.logout", session.Username, "")
+	}
+	endSession(w, r)
+
+	// The proxy would log them straight back in, so end its session too
+	if user, ok := proxyUser(r); ok && proxyLogoutURL != "" {
+		auditActorAction(r, user.Username, "user.logout", user.Username, "proxy")
+		http.Redirect(w, r, proxyLogoutURL, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// accountHandler lets users change their password and see and end their sessions (?action=)
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	data := AuthData{User: user}
+
+	if r.Method == http.MethodPost {
+		switch {
+		case r.FormValue("action") == "logout-everywhere":
+			if err := sessions.DeleteUser(user.Username); err != nil {
+				data.Error = err.Error()
+				break
+			}
+			auditAction(r, "user.logout_everywhere", user.Username, "")
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		case r.FormValue("action") == "end-session":
+			if removed, err := sessions.DeleteID(user.Username, r.FormValue("id")); err != nil {
+				data.Error = err.Error()
+			} else if removed {
+				auditAction(r, "user.session_end", user.Username, "")
+				data.Message = tr(r, "Logged out that browser")
+			}
+		case r.FormValue("new") != r.FormValue("confirm"):
+			data.Error = "The new passwords don't match"
+		default:
+			if _, ok := users.Authenticate(user.Username, r.FormValue("current")); !ok {
+				data.Error = "Current password is incorrect"
+			} else if err := users.SetPassword(user.Username, r.FormValue("new")); err != nil {
+				data.Error = err.Error()
+			} else {
+				auditAction(r, "user.password_change", user.Username, "")
+				// Log out everywhere else, but keep this browser logged in
+				if err := sessions.DeleteUser(user.Username); err != nil {
+					data.Error = err.Error()
+				} else if err := startSession(w, r, user.Username); err != nil {
+					data.Error = err.Error()
+				} else {
+					data.Message = tr(r, "Password changed")
+				}
+			}
+		}
+	}
+
+	list, err := sessions.List(user.Username)
+	if err != nil {
+		data.Error = err.Error()
+	}
+	data.Sessions = list
+	if session, ok := currentSession(r); ok {
+		data.Current = session.ID
+	}
+
+	renderAuthPage(w, r, "account.html", data)
+}
+
+// usersHandler lets admins list, add and delete accounts
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	if !user.Admin {
+		http.Error(w, tr(r, "Only admins can manage accounts"), http.StatusForbidden)
+		return
+	}
+	data := AuthData{User: user}
+
+	if r.Method == http.MethodPost {
+		username := r.FormValue("username")
+		switch r.FormValue("action") {
+		case "add":
+			if err := users.Create(username, r.FormValue("password"), r.FormValue("admin") != ""); err != nil {
+				data.Error = err.Error()
+			} else {
+				auditAction(r, "user.create", strings.ToLower(strings.TrimSpace(username)), fmt.Sprintf("admin=%t", r.FormValue("admin") != ""))
+				data.Message = tr(r, "Added %s", username)
+			}
+		case "delete":
+			if username == user.Username {
+				data.Error = "You can't delete your own account"
+			} else if removed, err := users.Delete(username); err != nil {
+				data.Error = err.Error()
+			} else if removed {
+				if err := sessions.DeleteUser(username); err != nil {
+					data.Error = err.Error()
+				}
+				if err := teams.RemoveUser(username); err != nil {
+					data.Error = err.Error()
+				}
+				if err := notificationPrefs.Delete(username); err != nil {
+					data.Error = err.Error()
+				}
+				auditAction(r, "user.delete", username, "")
+				data.Message = tr(r, "Deleted %s", username)
+			}
+		}
+	}
+
+	data.Users = users.List()
+	renderAuthPage(w, r, "users.html", data)
+}
+
+// startSession creates a session and sets its cookie
+// Any session the browser already had is ended, so a token planted before login can't be reused
+func startSession(w http.ResponseWriter, r *http.Request, username string) error {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := sessions.Delete(cookie.Value); err != nil {
+			return err
+		}
+	}
+	session, err := sessions.Create(username, r.UserAgent(), r.RemoteAddr)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true, // Scripts can't read it
+		Secure:   r.TLS != nil || secureCookies,
+		SameSite: http.SameSiteLaxMode, // Not sent on cross-site POSTs
+	})
+	return nil
+}
+
+// endSession ends the browser's session and clears its cookie
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := sessions.Delete(cookie.Value); err != nil {
+			log.Printf("sessions: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil || secureCookies, SameSite: http.SameSiteLaxMode})
+}
+
+// safeNext only allows redirects to paths on this site
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// renderAuthPage renders one of the account templates
+func renderAuthPage(w http.ResponseWriter, r *http.Request, name string, data AuthData) {
+	tmpl, err := parseTemplate(name)
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
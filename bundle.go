@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// bundleHandler streams a ZIP of every certificate in a search's results, with a manifest
+// It takes the same query parameters as /search, so the bundle matches the filtered results
+func bundleHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		http.Error(w, tr(r, data.Error), data.status)
+		return
+	}
+	if data.TotalCerts > services.MaxBundleCertificates {
+		http.Error(w, tr(r, "%d certificates is too many for one bundle (the limit is %d); narrow the search with a date or name filter", data.TotalCerts, services.MaxBundleCertificates), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+services.BaseDomain(data.Domain)+`-certificates.zip"`)
+	if err := services.WriteCertificateBundle(r.Context(), w, data.groups); err != nil {
+		// The archive has already started, so all we can do is stop and log it
+		log.Printf("bundle %s: %v", data.Domain, err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// CertData holds data to pass to the certificate permalink template
+type CertData struct {
+	ID          int64                      `json:"id"` // crt.sh ID
+	Certificate services.PastedCertificate `json:"certificate"`
+	Domain      string                     `json:"-"` // Registrable domain of its first name, for a search link
+	Error       string                     `json:"error,omitempty"`
+
+	pem    []byte
+	status int // HTTP status for API responses
+}
+
+// certHandler is the permalink for a certificate by crt.sh ID (?format=pem downloads it)
+func certHandler(w http.ResponseWriter, r *http.Request) {
+	data := runCertLookup(r.Context(), r.PathValue("id"))
+	if data.Error == "" && r.URL.Query().Get("format") == "pem" {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%d.pem"`, data.ID))
+		w.Write(data.pem)
+		return
+	}
+
+	tmpl, err := parseTemplate("cert.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// serialHandler is the permalink for a certificate by its issuing CA's crt.sh ID and hex serial number,
+// redirecting to the certificate's /cert/ permalink
+func serialHandler(w http.ResponseWriter, r *http.Request) {
+	var data CertData
+	issuerCAID, err := strconv.ParseInt(r.PathValue("caid"), 10, 64)
+	if err != nil || issuerCAID <= 0 {
+		data.Error = "Issuer must be a crt.sh CA ID"
+	} else if id, err := services.ResolveSerial(r.Context(), issuerCAID, r.PathValue("serial")); err != nil {
+		_, data.Error = serviceError(err)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/cert/%d", id), http.StatusFound)
+		return
+	}
+
+	tmpl, err := parseTemplate("cert.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiCertHandler returns a certificate's analysis by crt.sh ID as JSON
+func apiCertHandler(w http.ResponseWriter, r *http.Request) {
+	data := runCertLookup(r.Context(), r.PathValue("id"))
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runCertLookup fetches a certificate by crt.sh ID, from the cache when it has been seen before, and analyzes it
+func runCertLookup(ctx context.Context, rawID string) CertData {
+	data := CertData{status: http.StatusOK}
+
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || id <= 0 {
+		data.Error = "Please enter a crt.sh certificate ID"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.ID = id
+
+	cert, err := services.LoggedCertificate(ctx, id)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		return data
+	}
+
+	data.Certificate = services.InspectPastedCertificate(ctx, cert, time.Now())
+	if len(data.Certificate.Names) > 0 {
+		data.Domain = services.RegistrableDomain(data.Certificate.Names[0])
+	}
+	data.pem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return data
+}
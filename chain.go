@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// ChainData holds data to pass to the chain validation template
+type ChainData struct {
+	Host       string                   `json:"-"`
+	Validation services.ChainValidation `json:"validation"`
+	Input      string                   `json:"-"`
+	Submitted  bool                     `json:"-"`
+	Error      string                   `json:"error,omitempty"`
+	Invalid    *services.DomainError    `json:"invalid,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// chainHandler shows the chain upload form (GET) and the validation (POST)
+func chainHandler(w http.ResponseWriter, r *http.Request) {
+	var data ChainData
+	if r.Method == http.MethodPost {
+		data = runChainValidation(w, r)
+	}
+
+	tmpl, err := parseTemplate("chain.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiChainHandler validates a PEM chain sent as the raw request body, or a "chain" or "file" multipart form field
+// ?host= (or a "host" form field) checks the leaf's names too
+func apiChainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runChainValidation(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Validation)
+}
+
+// runChainValidation parses the posted chain and validates it like a browser would
+func runChainValidation(w http.ResponseWriter, r *http.Request) ChainData {
+	data := ChainData{Submitted: true, status: http.StatusOK}
+
+	input, err := pastedInput(w, r, "chain")
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Input = string(input)
+
+	// Options come from the upload form, or the query string for raw bodies
+	if host := formOption(r, "host"); host != "" {
+		if data.Host, err = services.NormalizeField("host", host); err != nil {
+			data.status, data.Error = serviceError(err)
+			errors.As(err, &data.Invalid)
+			return data
+		}
+	}
+
+	chain, err := services.ParseCertificates(input)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Validation = services.ValidateChain(data.Host, chain, time.Now())
+
+	return data
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// Exit statuses for check, so cron and CI can tell a failing domain from a failing scan
+const (
+	exitViolations = 1
+	exitScanFailed = 3
+)
+
+// checkCommand scans domains once, prints a summary and exits non-zero if any break the policy
+func checkCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	expiring := days(14 * 24 * time.Hour)
+	maxAge := days(0)
+	flags.Var(&expiring, "expiring", "fail hostnames whose newest certificate expires within this long (e.g. 14d, 36h)")
+	flags.Var(&maxAge, "max-age", "fail hostnames whose newest certificate was issued longer ago than this (e.g. 30d); 0 for no limit")
+	issuers := flags.String("issuers", "", "comma-separated issuers allowed to issue valid certificates, e.g. \"Let's Encrypt,DigiCert\"")
+	format := addFormatFlag(flags, formatTable)
+	server := addServerFlag(flags)
+	domains, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(domains) == 0 {
+		return usageError{"expected at least one domain"}
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+
+	policy := services.Policy{
+		ExpiringWithin: time.Duration(expiring),
+		MaxAge:         time.Duration(maxAge),
+	}
+	policy.Issuers = splitIssuers(*issuers)
+
+	results := make([]services.PolicyResult, 0, len(domains))
+	violations, failed := 0, 0
+	for _, domain := range domains {
+		result, err := search(domain, searchOptions{server: server})
+		if err != nil {
+			if _, ok := err.(usageError); ok {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "certviewer check: %s: %v\n", domain, err)
+			failed++
+			continue
+		}
+
+		// Group again from the entries, since the certificates' parsed times aren't part of the JSON
+		entries := make([]services.Certificate, 0, result.TotalCerts)
+		for _, group := range result.certificates() {
+			entries = append(entries, group.Entries...)
+		}
+		checked := services.CheckPolicy(result.Domain, services.GroupCertificates(entries), policy, time.Now())
+		violations += len(checked.Violations)
+		results = append(results, checked)
+	}
+
+	switch *format {
+	case formatJSON:
+		err = writeJSON(stdout, results)
+	case formatCSV, formatNDJSON:
+		err = policyRecords(results).write(stdout, *format)
+	default:
+		printPolicyResults(stdout, results)
+	}
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return exitError{exitScanFailed, fmt.Errorf("%d domain(s) could not be checked", failed)}
+	}
+	if violations > 0 {
+		return exitError{exitViolations, fmt.Errorf("%d policy violation(s)", violations)}
+	}
+	return nil
+}
+
+// printPolicyResults writes a line per domain and one per violation
+func printPolicyResults(w io.Writer, results []services.PolicyResult) {
+	for _, result := range results {
+		if result.Passed() {
+			fmt.Fprintf(w, "%s: OK, %d hostname(s) on %d valid certificate(s)\n", result.Domain, result.Hostnames, result.ActiveCertificates)
+			continue
+		}
+		fmt.Fprintf(w, "%s: FAIL, %d violation(s) across %d hostname(s)\n", result.Domain, len(result.Violations), result.Hostnames)
+		for _, violation := range result.Violations {
+			fmt.Fprintf(w, "  [%s] %s %s: %s\n", violation.Severity, violation.Check, violation.Subject, violation.Message)
+		}
+	}
+}
+
+// policyRecords lists one row per violation, and one with status "pass" for each domain without any
+func policyRecords(results []services.PolicyResult) *records {
+	out := newRecords("domain", "status", "severity", "check", "subject", "message")
+	for _, result := range results {
+		if result.Passed() {
+			out.add(result.Domain, "pass", "", "", "", "")
+		}
+		for _, violation := range result.Violations {
+			out.add(result.Domain, "fail", violation.Severity, violation.Check, violation.Subject, violation.Message)
+		}
+	}
+	return out
+}
+
+// splitIssuers splits an -issuers flag, dropping empty entries
+func splitIssuers(value string) []string {
+	issuers := make([]string, 0)
+	for _, issuer := range strings.Split(value, ",") {
+		if issuer = strings.TrimSpace(issuer); issuer != "" {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return issuers
+}
+
+// days is a duration flag that also accepts whole days, e.g. "30d" or just "30"
+type days time.Duration
+
+func (d *days) String() string {
+	duration := time.Duration(*d)
+	if duration%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", duration/(24*time.Hour))
+	}
+	return duration.String()
+}
+
+func (d *days) Set(value string) error {
+	count, found := strings.CutSuffix(value, "d")
+	if _, err := strconv.Atoi(value); err == nil {
+		count, found = value, true
+	}
+	if found {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of days %q", value)
+		}
+		*d = days(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return fmt.Errorf("invalid duration %q, use e.g. 30d or 12h", value)
+	}
+	*d = days(duration)
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// clientTimeout bounds each API call; crt.sh lookups through the server can be slow
+const clientTimeout = 3 * time.Minute
+
+// apiClient talks to a running certificate-viewer server's JSON API over HTTP or a Unix socket
+// so ad-hoc queries share the server's watchlist, alerts and accounts
+type apiClient struct {
+	base     string // Scheme and host requests are sent to
+	http     *http.Client
+	username string
+	password string
+}
+
+// addServerFlag registers --server, defaulting to $CERTVIEWER_SERVER
+// Commands work locally when it's empty
+func addServerFlag(flags *flag.FlagSet) *string {
+	return flags.String("server", os.Getenv("CERTVIEWER_SERVER"), "server to send the request to instead of working locally: http(s)://host:port or unix:///path/to/socket")
+}
+
+// newAPIClient connects to server, which is an http(s) URL or unix:// socket path
+// Credentials for servers with accounts come from $CERTVIEWER_USER and $CERTVIEWER_PASSWORD
+func newAPIClient(server string) (*apiClient, error) {
+	c := &apiClient{
+		http: &http.Client{
+			Timeout: clientTimeout,
+			// The API doesn't redirect; a redirect means the server wants a browser, e.g. for /setup
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		username: os.Getenv("CERTVIEWER_USER"),
+		password: os.Getenv("CERTVIEWER_PASSWORD"),
+	}
+
+	parsed, err := url.Parse(server)
+	if err != nil {
+		return nil, usageError{fmt.Sprintf("invalid server %q", server)}
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		c.base = strings.TrimSuffix(server, "/")
+	case "unix":
+		socket := parsed.Path
+		if socket == "" {
+			return nil, usageError{"unix server needs a socket path, e.g. unix:///run/certificate-viewer.sock"}
+		}
+		c.base = "http://certificate-viewer"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+	default:
+		return nil, usageError{fmt.Sprintf("server must be an http(s):// URL or unix:// socket, not %q", server)}
+	}
+
+	return c, nil
+}
+
+// call sends a request to an API path and decodes the JSON response into v (if not nil)
+func (c *apiClient) call(method, path string, query url.Values, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return fmt.Errorf("server redirected to %s; finish setting it up in a browser first", resp.Header.Get("Location"))
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("server requires a login; set CERTVIEWER_USER and CERTVIEWER_PASSWORD")
+	}
+	if resp.StatusCode >= 400 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+			return errors.New(body.Error)
+		}
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse server response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Command certviewer runs certificate searches, probes, watchlist checks, policy checks and exports from the command line,
+// using the same services as the web server
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// usage is printed for -h and unknown commands
+const usage = `Usage: certviewer <command> [flags] [arguments]
+
+Commands:
+  search <domain>       Search Certificate Transparency logs for a domain
+  probe <host[:port]>   Show the certificate chain a server presents
+  watch [domain...]     Check watched domains for new hostnames, adding any given
+  export <domain>       Write every certificate for a domain as CSV or JSON
+  check <domain...>     Check domains against an expiry, age and issuer policy, exiting 1 on violations
+  nagios <domain>       Check a domain as a Nagios/Icinga plugin: status line, perfdata and exit status
+
+Run "certviewer <command> -h" for a command's flags.
+`
+
+// command runs one subcommand with its arguments, writing results to stdout
+type command func(args []string, stdout io.Writer) error
+
+var commands = map[string]command{
+	"search": searchCommand,
+	"probe":  probeCommand,
+	"watch":  watchCommand,
+	"export": exportCommand,
+	"check":  checkCommand,
+	"nagios": nagiosCommand,
+}
+
+// usageError means the command line was wrong rather than the work failing
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+// exitError ends the command with a particular exit status
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "certviewer: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := run(os.Args[2:], os.Stdout); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(2)
+		}
+		// The command already said why, e.g. a Nagios plugin's status line
+		if exit, ok := err.(exitError); ok && exit.err == nil {
+			os.Exit(exit.code)
+		}
+		fmt.Fprintf(os.Stderr, "certviewer %s: %v\n", os.Args[1], err)
+		if _, ok := err.(usageError); ok {
+			os.Exit(2)
+		}
+		if exit, ok := err.(exitError); ok {
+			os.Exit(exit.code)
+		}
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses flags that may come before or after the positional arguments,
+// so "certviewer search example.com --output json" works as well as "certviewer search --output json example.com"
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := flags.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, usageError{err.Error()}
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// nagiosCommand checks one domain as a Nagios or Icinga plugin: it prints the plugin output and exits
+// with the state, 0 OK, 1 WARNING, 2 CRITICAL or 3 UNKNOWN, even for a wrong command line
+func nagiosCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("nagios", flag.ContinueOnError)
+	warning := days(21 * 24 * time.Hour)
+	critical := days(7 * 24 * time.Hour)
+	flags.Var(&warning, "w", "warn when a hostname's newest certificate expires within this long (e.g. 21 or 21d, 36h)")
+	flags.Var(&critical, "c", "critical when a hostname's newest certificate expires within this long (e.g. 7 or 7d)")
+	issuers := flags.String("issuers", "", "comma-separated issuers expected to issue valid certificates, e.g. \"Let's Encrypt,DigiCert\"; any other is critical")
+	server := addServerFlag(flags)
+	domains, err := parseArgs(flags, args)
+	if err == flag.ErrHelp {
+		return err
+	}
+	if err == nil && len(domains) != 1 {
+		err = errors.New("expected exactly one domain")
+	}
+	if err != nil {
+		return nagiosExit(stdout, services.NagiosFailure("certviewer", err))
+	}
+	domain := domains[0]
+
+	result, err := search(domain, searchOptions{server: server})
+	if err != nil {
+		return nagiosExit(stdout, services.NagiosFailure(domain, err))
+	}
+	// Group again from the entries, since the certificates' parsed times aren't part of the JSON
+	entries := make([]services.Certificate, 0, result.TotalCerts)
+	for _, group := range result.certificates() {
+		entries = append(entries, group.Entries...)
+	}
+	thresholds := services.NagiosThresholds{
+		Warning:  time.Duration(warning),
+		Critical: time.Duration(critical),
+		Issuers:  splitIssuers(*issuers),
+	}
+	return nagiosExit(stdout, services.CheckNagios(result.Domain, services.GroupCertificates(entries), thresholds, time.Now()))
+}
+
+// nagiosExit prints a plugin result and ends with its state, saying nothing on stderr
+func nagiosExit(stdout io.Writer, result services.NagiosResult) error {
+	fmt.Fprint(stdout, result.Output())
+	if result.State == services.NagiosOK {
+		return nil
+	}
+	return exitError{code: result.State}
+}
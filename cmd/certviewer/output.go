@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats every command supports
+// json is the command's whole result, matching the server's API where there is one;
+// csv, ndjson and generic tables are flat records whose column names are the same in each
+const (
+	formatTable  = "table"
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// addFormatFlag registers --format, and --output as its older name
+func addFormatFlag(flags *flag.FlagSet, value string) *string {
+	format := flags.String("format", value, "output format: table, json, csv or ndjson")
+	flags.StringVar(format, "output", value, "same as --format")
+	return format
+}
+
+// checkFormat rejects unknown output formats, accepting "text" as the older name for table
+func checkFormat(format *string) error {
+	if *format == "text" {
+		*format = formatTable
+	}
+	switch *format {
+	case formatTable, formatJSON, formatCSV, formatNDJSON:
+		return nil
+	}
+	return usageError{fmt.Sprintf("unknown format %q, use table, json, csv or ndjson", *format)}
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// records are a command's results as rows of named columns
+type records struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// newRecords starts an empty set of records with the given column names
+func newRecords(columns ...string) *records {
+	return &records{columns: columns, rows: make([][]interface{}, 0)}
+}
+
+// add appends a row, one value per column
+func (r *records) add(values ...interface{}) {
+	r.rows = append(r.rows, values)
+}
+
+// write writes the records as a table, CSV or one JSON object per line
+// Commands write their own JSON, since it's the whole result rather than flat rows
+func (r *records) write(w io.Writer, format string) error {
+	switch format {
+	case formatCSV:
+		out := csv.NewWriter(w)
+		out.Write(r.columns)
+		for _, row := range r.rows {
+			values := make([]string, len(row))
+			for i, value := range row {
+				values[i] = formatValue(value)
+			}
+			out.Write(values)
+		}
+		out.Flush()
+		return out.Error()
+
+	case formatNDJSON:
+		for _, row := range r.rows {
+			// Built by hand so keys keep the column order
+			var line bytes.Buffer
+			line.WriteByte('{')
+			for i, value := range row {
+				if i > 0 {
+					line.WriteByte(',')
+				}
+				name, _ := json.Marshal(r.columns[i])
+				content, err := json.Marshal(value)
+				if err != nil {
+					return fmt.Errorf("failed to encode %s: %w", r.columns[i], err)
+				}
+				line.Write(name)
+				line.WriteByte(':')
+				line.Write(content)
+			}
+			line.WriteString("}\n")
+			if _, err := w.Write(line.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, strings.ToUpper(strings.Join(r.columns, "\t")))
+	for _, row := range r.rows {
+		values := make([]string, len(row))
+		for i, value := range row {
+			values[i] = formatValue(value)
+		}
+		fmt.Fprintln(table, strings.Join(values, "\t"))
+	}
+	return table.Flush()
+}
+
+// formatValue writes a record value as text for CSV and tables; lists are space separated
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, " ")
+	case []int64:
+		parts := make([]string, len(v))
+		for i, n := range v {
+			parts[i] = fmt.Sprint(n)
+		}
+		return strings.Join(parts, " ")
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// probeCommand prints the certificate chain a server presents
+func probeCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("probe", flag.ContinueOnError)
+	format := addFormatFlag(flags, formatTable)
+	server := addServerFlag(flags)
+	targets, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(targets) != 1 {
+		return usageError{"expected one host, optionally with :port"}
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+
+	host, port, err := splitTarget(targets[0])
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	result, err := probe(*server, host, port)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case formatJSON:
+		err = writeJSON(stdout, result)
+	case formatCSV, formatNDJSON:
+		err = chainRecords(result).write(stdout, *format)
+	default:
+		printProbe(stdout, result)
+	}
+	if err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// probe connects from the server if one is given (its DANE check includes the probe), otherwise from here
+func probe(server, host string, port int) (services.ProbeResult, error) {
+	if server == "" {
+		return services.ProbeTLS(context.Background(), host, port), nil
+	}
+
+	client, err := newAPIClient(server)
+	if err != nil {
+		return services.ProbeResult{}, err
+	}
+	var response struct {
+		Probe services.ProbeResult `json:"probe"`
+	}
+	err = client.call(http.MethodGet, "/api/v1/dane", url.Values{"host": {host}, "port": {strconv.Itoa(port)}}, &response)
+	return response.Probe, err
+}
+
+// splitTarget parses host or host:port, defaulting to port 443
+func splitTarget(target string) (string, int, error) {
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, 443, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portText)
+	}
+	return host, port, nil
+}
+
+// printProbe writes a probe result as readable text
+func printProbe(w io.Writer, result services.ProbeResult) {
+	fmt.Fprintf(w, "%s:%d", result.Host, result.Port)
+	if result.STARTTLS {
+		fmt.Fprint(w, " (STARTTLS)")
+	}
+	fmt.Fprintln(w)
+	if result.Error != "" {
+		return
+	}
+	fmt.Fprintf(w, "%s, %s\n", result.TLSVersion, result.CipherSuite)
+	if result.VerifyError != "" {
+		fmt.Fprintf(w, "Chain does not validate: %s\n", result.VerifyError)
+	} else {
+		fmt.Fprintln(w, "Chain validates against the system roots")
+	}
+	for _, issue := range result.Issues {
+		where := "chain"
+		if issue.Certificate >= 0 {
+			where = fmt.Sprintf("certificate %d", issue.Certificate)
+		}
+		fmt.Fprintf(w, "  [%s] %s: %s\n", issue.Severity, where, issue.Message)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, cert := range result.Chain {
+		fmt.Fprintf(table, "\n%d\tSubject\t%s\n", i, cert.Subject)
+		fmt.Fprintf(table, "\tIssuer\t%s\n", cert.Issuer)
+		fmt.Fprintf(table, "\tValid\t%s to %s\n", cert.NotBefore.Format(time.DateOnly), cert.NotAfter.Format(time.DateOnly))
+		fmt.Fprintf(table, "\tSerial\t%s\n", cert.SerialNumber)
+		fmt.Fprintf(table, "\tSHA-256\t%s\n", cert.SHA256)
+	}
+	table.Flush()
+}
+
+// chainRecords lists one row per served certificate, leaf first
+func chainRecords(result services.ProbeResult) *records {
+	out := newRecords("host", "port", "position", "subject", "issuer", "serial_number", "not_before", "not_after", "sha256", "verify_error")
+	for i, cert := range result.Chain {
+		out.add(result.Host, result.Port, i, cert.Subject, cert.Issuer, cert.SerialNumber, cert.NotBefore, cert.NotAfter, cert.SHA256, result.VerifyError)
+	}
+	return out
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"text/tabwriter"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// SearchResult is what the search command prints as JSON, matching /api/v1/search
+type SearchResult struct {
+	Domain     string                 `json:"domain"`
+	Sort       string                 `json:"sort"`
+	Issuers    []services.IssuerGroup `json:"issuers"`
+	TotalCerts int                    `json:"totalCerts"`
+}
+
+// certificates lists the result's certificates, issuer by issuer
+func (r SearchResult) certificates() []services.CertificateGroup {
+	groups := make([]services.CertificateGroup, 0, r.TotalCerts)
+	for _, issuer := range r.Issuers {
+		groups = append(groups, issuer.Certificates...)
+	}
+	return groups
+}
+
+// searchOptions are the filters shared by search and export, and where to run the search
+type searchOptions struct {
+	notBefore string
+	san       string
+	sanRegex  bool
+	purpose   string
+	sort      string
+	server    *string
+}
+
+// addSearchFlags registers the search filters on flags
+func addSearchFlags(flags *flag.FlagSet) *searchOptions {
+	options := &searchOptions{}
+	flags.StringVar(&options.notBefore, "not-before", "", "only certificates issued on or after this date (YYYY-MM-DD)")
+	flags.StringVar(&options.san, "san", "", "only certificates with a name containing this text")
+	flags.BoolVar(&options.sanRegex, "san-regex", false, "treat --san as a regular expression")
+	flags.StringVar(&options.purpose, "purpose", "", "only TLS server certificates (tls) or only the rest, such as code signing and S/MIME (non-tls); certificates whose purpose is only guessed are kept")
+	flags.StringVar(&options.sort, "sort", "", "issuer and certificate order, as on the results page")
+	options.server = addServerFlag(flags)
+	return options
+}
+
+// searchCommand prints a domain's certificates grouped by issuer
+func searchCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	options := addSearchFlags(flags)
+	format := addFormatFlag(flags, formatTable)
+	domains, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(domains) != 1 {
+		return usageError{"expected one domain"}
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+
+	result, err := search(domains[0], *options)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case formatJSON:
+		return writeJSON(stdout, result)
+	case formatCSV, formatNDJSON:
+		return certificateRecords(result.certificates()).write(stdout, *format)
+	}
+
+	fmt.Fprintf(stdout, "%s: %d certificate(s)\n", result.Domain, result.TotalCerts)
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, issuer := range result.Issuers {
+		fmt.Fprintf(table, "\n%s (%d)\n", issuer.DisplayName, len(issuer.Certificates))
+		for _, group := range issuer.Certificates {
+			fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", group.CommonName, group.NotBefore, group.NotAfter, group.SerialNumber)
+		}
+	}
+	return table.Flush()
+}
+
+// exportCommand writes every certificate for a domain, one row per certificate
+func exportCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	options := addSearchFlags(flags)
+	format := flags.String("format", formatCSV, "file format: csv, ndjson, json or table")
+	out := flags.String("out", "", "file to write; standard output when empty")
+	domains, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(domains) != 1 {
+		return usageError{"expected one domain"}
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+
+	result, err := search(domains[0], *options)
+	if err != nil {
+		return err
+	}
+	groups := result.certificates()
+
+	w := stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == formatJSON {
+		return writeJSON(w, groups)
+	}
+	return certificateRecords(groups).write(w, *format)
+}
+
+// search runs the search on the server if one is given, otherwise locally
+func search(domain string, options searchOptions) (SearchResult, error) {
+	if *options.server == "" {
+		return searchLocally(domain, options)
+	}
+
+	client, err := newAPIClient(*options.server)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	query := url.Values{
+		"domain":    {domain},
+		"notBefore": {options.notBefore},
+		"san":       {options.san},
+		"purpose":   {options.purpose},
+		"sort":      {options.sort},
+	}
+	if options.sanRegex {
+		query.Set("sanRegex", "on")
+	}
+
+	var result SearchResult
+	err = client.call(http.MethodGet, "/api/v1/search", query, &result)
+	return result, err
+}
+
+// searchLocally fetches, filters and groups a domain's certificates like the web server's runSearch
+func searchLocally(domain string, options searchOptions) (SearchResult, error) {
+	ascii, err := services.NormalizeDomain(domain)
+	if err != nil {
+		return SearchResult{}, usageError{err.Error()}
+	}
+	order, err := services.ParseSortOrder(options.sort)
+	if err != nil {
+		return SearchResult{}, usageError{err.Error()}
+	}
+	if err := services.CheckPurposeFilter(options.purpose); err != nil {
+		return SearchResult{}, usageError{err.Error()}
+	}
+
+	// Compile the SAN filter before fetching so a bad pattern fails fast
+	var sanFilter *regexp.Regexp
+	if options.san != "" {
+		sanFilter, err = services.CompileSANFilter(options.san, options.sanRegex)
+		if err != nil {
+			return SearchResult{}, usageError{err.Error()}
+		}
+	}
+
+	certs, err := services.FetchCertificates(context.Background(), ascii)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	if options.notBefore != "" {
+		certs = services.FilterByNotBefore(certs, options.notBefore)
+	}
+	if sanFilter != nil {
+		certs = services.FilterBySAN(certs, sanFilter)
+	}
+
+	groups := services.GroupCertificates(certs)
+	services.VerifyPurposes(groups)
+	groups = services.FilterByPurpose(groups, options.purpose)
+	services.AnnotateSharedNames(ascii, groups)
+	return SearchResult{
+		Domain:     ascii,
+		Sort:       order.String(),
+		Issuers:    services.GroupByIssuer(groups, order),
+		TotalCerts: len(groups),
+	}, nil
+}
+
+// certificateRecords lists one row per certificate with its names and crt.sh IDs
+func certificateRecords(groups []services.CertificateGroup) *records {
+	out := newRecords("serial_number", "common_name", "issuer", "not_before", "not_after", "names", "crt_sh_ids")
+	for _, group := range groups {
+		ids := make([]int64, 0, len(group.Entries))
+		for _, entry := range group.Entries {
+			ids = append(ids, entry.ID)
+		}
+		out.add(group.SerialNumber, group.CommonName, group.IssuerName, group.NotBefore, group.NotAfter, services.GroupNames(group), ids)
+	}
+	return out
+}
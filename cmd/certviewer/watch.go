@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// watchCommand adds any given domains to a watchlist file, checks every watched domain and prints new alerts
+// It uses the same file format as the web server's -watchlist, but shouldn't share a file with a running server;
+// with --server it adds the domains to the server's watchlist and prints the server's recent alerts instead
+func watchCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	path := flags.String("watchlist", "watchlist.json", "watchlist file to read and update")
+	format := addFormatFlag(flags, formatTable)
+	server := addServerFlag(flags)
+	since := flags.Duration("since", 24*time.Hour, "with --server, how far back to list alerts")
+	domains, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+	if *server != "" {
+		return watchOnServer(*server, domains, time.Now().Add(-*since), *format, stdout)
+	}
+
+	watchlist, err := services.LoadWatchlist(*path)
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		ascii, err := services.NormalizeDomain(domain)
+		if err != nil {
+			return usageError{err.Error()}
+		}
+		if err := watchlist.Add("", ascii); err != nil {
+			return err
+		}
+	}
+
+	alerts := make([]services.Alert, 0)
+	failed := 0
+	for _, watched := range watchlist.List() {
+		certs, err := services.FetchCertificates(context.Background(), watched.Domain)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "certviewer watch: %s: %v\n", watched.Domain, err)
+			failed++
+			continue
+		}
+		now := time.Now()
+		inventory := services.BuildSubdomainInventory(watched.Domain, services.GroupCertificates(certs), now)
+		found, err := watchlist.RecordInventory(watched.Domain, inventory, now)
+		if err != nil {
+			return err
+		}
+		if watched.LastChecked.IsZero() && *format == formatTable {
+			fmt.Fprintf(stdout, "%s: recorded %d known hostname(s) as the baseline\n", watched.Domain, inventory.Hostnames)
+		}
+		alerts = append(alerts, found...)
+	}
+
+	if err := printAlerts(stdout, alerts, *format); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d domain(s) could not be checked", failed)
+	}
+	return nil
+}
+
+// watchOnServer adds domains to the server's watchlist and prints its alerts since a time
+// The server checks new domains in the background, so their baseline may not be recorded yet
+func watchOnServer(server string, domains []string, since time.Time, format string, stdout io.Writer) error {
+	client, err := newAPIClient(server)
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		if err := client.call(http.MethodPost, "/api/v1/watchlist", url.Values{"domain": {domain}}, nil); err != nil {
+			return fmt.Errorf("%s: %w", domain, err)
+		}
+		if format == formatTable {
+			fmt.Fprintf(stdout, "%s: added to the server's watchlist\n", domain)
+		}
+	}
+
+	var recent []services.Alert
+	if err := client.call(http.MethodGet, "/api/v1/alerts", url.Values{"limit": {"1000"}}, &recent); err != nil {
+		return err
+	}
+	alerts := make([]services.Alert, 0, len(recent))
+	for _, alert := range recent {
+		if !alert.CreatedAt.Before(since) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return printAlerts(stdout, alerts, format)
+}
+
+// printAlerts writes alerts as JSON or one record each
+func printAlerts(w io.Writer, alerts []services.Alert, format string) error {
+	if format == formatJSON {
+		return writeJSON(w, alerts)
+	}
+	out := newRecords("created_at", "type", "domain", "subject", "message")
+	for _, alert := range alerts {
+		out.add(alert.CreatedAt, alert.Type, alert.Domain, alert.Subject, alert.Message)
+	}
+	return out.write(w, format)
+}
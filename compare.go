@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// CompareData holds data to pass to the comparison template
+type CompareData struct {
+	A          string                         `json:"-"` // crt.sh IDs as entered
+	B          string                         `json:"-"`
+	Comparison services.CertificateComparison `json:"comparison"`
+	Compared   bool                           `json:"-"`
+	Error      string                         `json:"error,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// compareHandler shows two certificates side by side, ?a= and ?b= being crt.sh IDs
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := CompareData{A: strings.TrimSpace(query.Get("a")), B: strings.TrimSpace(query.Get("b"))}
+	if data.A != "" || data.B != "" {
+		data = runCompare(r.Context(), query)
+	}
+
+	tmpl, err := parseTemplate("compare.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiCompareHandler returns the comparison of ?a= and ?b= as JSON
+func apiCompareHandler(w http.ResponseWriter, r *http.Request) {
+	data := runCompare(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data.Comparison)
+}
+
+// runCompare downloads both certificates from crt.sh and diffs them
+func runCompare(ctx context.Context, query url.Values) CompareData {
+	data := CompareData{
+		A:      strings.TrimSpace(query.Get("a")),
+		B:      strings.TrimSpace(query.Get("b")),
+		status: http.StatusOK,
+	}
+
+	ids := make([]int64, 0, 2)
+	for _, value := range []string{data.A, data.B} {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			data.Error = fmt.Sprintf("invalid crt.sh ID %q, expected a= and b= to be numbers", value)
+			data.status = http.StatusBadRequest
+			return data
+		}
+		ids = append(ids, id)
+	}
+
+	infos, errs := services.FetchCertificateInfos(ctx, ids)
+	for _, id := range ids {
+		if err, failed := errs[id]; failed {
+			data.status, data.Error = serviceError(err)
+			return data
+		}
+	}
+
+	data.Comparison = services.CompareCertificates(infos[ids[0]], infos[ids[1]])
+	data.Compared = true
+	return data
+}
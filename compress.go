@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the smallest response worth compressing; below it, the headers outweigh the savings
+const minCompressSize = 1024
+
+// brotliQuality trades speed for size: 5 is quick enough to compress results on every request, and
+// still smaller than gzip's best
+const brotliQuality = 5
+
+// compressibleTypes are the media types compressResponses compresses: pages, API answers and exports
+var compressibleTypes = map[string]bool{
+	"text/html":            true,
+	"text/plain":           true,
+	"text/csv":             true,
+	"text/css":             true,
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"application/xml":      true,
+	"image/svg+xml":        true,
+}
+
+// Compressors are reused, since each one allocates large buffers
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, brotliQuality) }}
+)
+
+// compressResponses compresses pages, JSON and CSV with brotli or gzip, whichever the client prefers of
+// those it accepts (brotli on a tie); grouped results for a large domain shrink from megabytes to a tenth
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		compressed := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer compressed.Close()
+		next.ServeHTTP(compressed, r)
+	})
+}
+
+// negotiateEncoding picks "br" or "gzip" from an Accept-Encoding header, or "" to send the response as is
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		if name != "br" && name != "gzip" || quality <= 0 {
+			continue
+		}
+		if quality > bestQuality || quality == bestQuality && name == "br" {
+			best, bestQuality = name, quality
+		}
+	}
+	return best
+}
+
+// compressWriter holds the start of a response back until it knows whether to compress it: the type
+// must be compressible, nothing may have encoded it already, and there must be enough of it
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	held     bytes.Buffer
+	decided  bool
+	encoder  io.WriteCloser // Nil when sending as is
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	// Informational and bodiless answers go out straight away
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.held.Write(b)
+	if w.held.Len() >= minCompressSize {
+		w.decide(true)
+	}
+	return len(b), nil
+}
+
+// Flush decides on what's been written so far, so streamed pages like the progress page arrive as they're written
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		encoder.Flush()
+	case *brotli.Writer:
+		encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close sends a response too short to have been decided on, or finishes the compressed stream
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder == nil {
+		return nil
+	}
+	err := w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *brotli.Writer:
+		brotliWriters.Put(encoder)
+	}
+	w.encoder = nil
+	return err
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide sends the headers, compressing from here on if worthwhile is set and the response qualifies,
+// then what was held back
+func (w *compressWriter) decide(worthwhile bool) {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && w.held.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.held.Bytes()))
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	// Ranges of a file are byte offsets into it as is
+	if worthwhile && compressibleTypes[mediaType] && header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" &&
+		w.status >= http.StatusOK && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		// The compressed bytes differ, so a strong ETag of the original only holds weakly
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		if w.encoding == "br" {
+			encoder := brotliWriters.Get().(*brotli.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.held.Len() > 0 {
+		if w.encoder != nil {
+			w.encoder.Write(w.held.Bytes())
+		} else {
+			w.ResponseWriter.Write(w.held.Bytes())
+		}
+		w.held.Reset()
+	}
+}
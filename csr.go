@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// CSRData holds data to pass to the CSR decoder template
+type CSRData struct {
+	CSR       services.CSRInfo            `json:"csr"`
+	Names     []string                    `json:"names"`             // DNS names requested, including a hostname common name
+	CTQuery   string                      `json:"ctQuery,omitempty"` // The name looked up in CT
+	Matches   []services.CertificateMatch `json:"matches"`           // Certificates in CT covering every requested name
+	CTError   string                      `json:"ctError,omitempty"` // The CT lookup failed, but the CSR was still decoded
+	Input     string                      `json:"-"`
+	Submitted bool                        `json:"-"`
+	Error     string                      `json:"error,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// csrHandler shows the CSR form (GET) and the decoded request (POST)
+func csrHandler(w http.ResponseWriter, r *http.Request) {
+	var data CSRData
+	if r.Method == http.MethodPost {
+		data = runCSRDecode(w, r)
+	}
+
+	tmpl, err := parseTemplate("csr.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiCSRHandler decodes a CSR sent as the raw request body, or a "csr" or "file" multipart form field
+func apiCSRHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runCSRDecode(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runCSRDecode decodes the posted CSR and looks for certificates in CT that already cover its names
+func runCSRDecode(w http.ResponseWriter, r *http.Request) CSRData {
+	data := CSRData{
+		Submitted: true,
+		Matches:   make([]services.CertificateMatch, 0),
+		status:    http.StatusOK,
+	}
+
+	input, err := pastedInput(w, r, "csr")
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Input = string(input)
+
+	csr, err := services.ParseCSR(input)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.CSR = services.InspectCSR(csr)
+	data.Names = data.CSR.Names()
+	if len(data.Names) == 0 {
+		return data
+	}
+
+	// Certificates covering every name must cover the first, so one lookup finds them all
+	data.CTQuery = data.Names[0]
+	auditAction(r, "csr.decode", data.CTQuery, strings.Join(data.Names, ", "))
+	certs, err := services.FetchCertificates(r.Context(), data.CTQuery)
+	if err != nil {
+		_, data.CTError = serviceError(err)
+		return data
+	}
+	data.Matches = services.MatchCertificates(data.Names, services.GroupCertificates(certs), time.Now())
+
+	return data
+}
+
+// pastedInput reads text pasted into a multipart form field or uploaded as its "file", or the raw request body
+func pastedInput(w http.ResponseWriter, r *http.Request, field string) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	// Raw bodies are read as-is, whatever content type the client claims
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, errors.New("could not read request: " + err.Error())
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil, errors.New("please paste or upload something to decode")
+		}
+		return body, nil
+	}
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, errors.New("could not read upload: " + err.Error())
+	}
+
+	// An uploaded file wins over the text box
+	if file, _, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	value := strings.TrimSpace(r.PostFormValue(field))
+	if value == "" {
+		return nil, errors.New("please paste or upload something to decode")
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// tlsaNameserver answers TLSA queries (the first /etc/resolv.conf nameserver unless -resolver is set)
+var tlsaNameserver string
+
+// DANEData holds data to pass to the DANE template
+type DANEData struct {
+	Host    string
+	Port    int
+	Probe   services.ProbeResult
+	DANE    services.DANEResult
+	Error   string
+	Invalid *services.DomainError
+	status  int // HTTP status for API responses
+}
+
+// daneHandler probes a service and checks its TLSA records
+func daneHandler(w http.ResponseWriter, r *http.Request) {
+	data := runDANECheck(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("dane.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiDANEHandler returns the probe and TLSA results as JSON
+func apiDANEHandler(w http.ResponseWriter, r *http.Request) {
+	data := runDANECheck(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, map[string]interface{}{
+		"probe": data.Probe,
+		"dane":  data.DANE,
+	})
+}
+
+// runDANECheck parses host and port (default 443), probes the service and verifies its TLSA records
+func runDANECheck(ctx context.Context, query url.Values) DANEData {
+	data := DANEData{
+		Host:   services.NormalizeName(strings.TrimSpace(query.Get("host"))),
+		Port:   443,
+		status: http.StatusOK,
+	}
+	if data.Host == "" {
+		data.Error = "Please enter a host"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	ascii, err := services.NormalizeField("host", data.Host)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		errors.As(err, &data.Invalid)
+		return data
+	}
+	data.Host = ascii
+	if raw := strings.TrimSpace(query.Get("port")); raw != "" {
+		port, err := strconv.Atoi(raw)
+		if err != nil || port < 1 || port > 65535 {
+			data.Error = "Port must be a number between 1 and 65535"
+			data.status = http.StatusBadRequest
+			return data
+		}
+		data.Port = port
+	}
+
+	data.Probe = services.ProbeTLS(ctx, data.Host, data.Port)
+	data.DANE = services.CheckDANE(tlsaNameserver, data.Probe)
+	return data
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// dashboardAlerts is how many recent alerts the dashboard shows
+const dashboardAlerts = 20
+
+// savedSearches holds every user's saved searches
+var savedSearches *services.SavedSearchStore
+
+// DashboardData holds data to pass to the dashboard template
+type DashboardData struct {
+	User      string // Empty when accounts are disabled
+	Searches  []services.SavedSearch
+	Watchlist []services.WatchedDomain
+	Alerts    []services.Alert
+	Message   string
+	Error     string
+}
+
+// dashboardHandler shows the logged-in user's saved searches, watchlist and alerts
+// POSTs save or delete a search, watch or unwatch a domain and acknowledge an alert (?action=)
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+	data := DashboardData{User: username}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "save":
+			search, err := saveSearch(r)
+			if err != nil {
+				data.Error = err.Error()
+			} else {
+				data.Message = tr(r, "Saved %s", search.Name)
+			}
+		case "delete":
+			if removed, err := savedSearches.Delete(username, r.FormValue("id")); err != nil {
+				data.Error = err.Error()
+			} else if removed {
+				auditAction(r, "saved_search.delete", r.FormValue("id"), "")
+			}
+		case "watch":
+			domain, err := services.NormalizeDomain(r.FormValue("domain"))
+			if err == nil {
+				err = watchlist.Add(username, domain)
+			}
+			if err != nil {
+				data.Error = err.Error()
+			} else {
+				auditAction(r, "watchlist.add", services.NormalizeName(domain), "")
+				go checkWatchedDomain(watchlist, services.NormalizeName(domain))
+				data.Message = tr(r, "Watching %s", services.NormalizeName(domain))
+			}
+		case "unwatch":
+			domain, err := services.NormalizeDomain(r.FormValue("domain"))
+			removed := false
+			if err == nil {
+				removed, err = watchlist.Remove(username, domain)
+			}
+			if err != nil {
+				data.Error = err.Error()
+			} else if removed {
+				auditAction(r, "watchlist.remove", domain, "")
+			}
+		case "acknowledge":
+			if alert, err := watchlist.AcknowledgeAlert(username, r.FormValue("id"), time.Now()); err != nil {
+				data.Error = err.Error()
+			} else {
+				auditAction(r, "alert.acknowledge", alert.ID, alert.Message)
+				data.Message = tr(r, "Acknowledged %s", alert.Message)
+			}
+		}
+	}
+
+	data.Searches = savedSearches.List(username)
+	data.Watchlist = watchlist.ListFor(username)
+	data.Alerts = watchlist.RecentAlerts(username, dashboardAlerts)
+
+	tmpl, err := parseTemplate("dashboard.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiSavedSearchesHandler lists (GET), saves (POST) or deletes (DELETE ?id=) the logged-in user's saved searches
+// POST takes the search as ?name=&domain=&notBefore=&san=&sanRegex=&purpose=&sort=
+func apiSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	username := currentUsername(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, savedSearches.List(username))
+	case http.MethodPost:
+		search, err := saveSearch(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, search)
+	case http.MethodDelete:
+		removed, err := savedSearches.Delete(username, r.URL.Query().Get("id"))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if !removed {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "saved search not found"})
+			return
+		}
+		auditAction(r, "saved_search.delete", r.URL.Query().Get("id"), "")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// saveSearch saves the search described by the request's form values for the logged-in user
+func saveSearch(r *http.Request) (services.SavedSearch, error) {
+	domain, err := services.NormalizeDomain(r.FormValue("domain"))
+	if err != nil {
+		return services.SavedSearch{}, err
+	}
+
+	search, err := savedSearches.Add(services.SavedSearch{
+		Username:  currentUsername(r),
+		Name:      r.FormValue("name"),
+		Domain:    domain,
+		NotBefore: r.FormValue("notBefore"),
+		SAN:       r.FormValue("san"),
+		SANRegex:  r.FormValue("sanRegex") != "",
+		Purpose:   r.FormValue("purpose"),
+		Sort:      r.FormValue("sort"),
+	})
+	if err == nil {
+		auditAction(r, "saved_search.add", search.Domain, search.Name)
+	}
+	return search, err
+}
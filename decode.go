@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// DecodeData holds data to pass to the certificate decoder template
+type DecodeData struct {
+	Certificate services.PastedCertificate `json:"certificate"`
+	CTQuery     string                     `json:"ctQuery,omitempty"` // The name looked up in CT
+	CT          *services.CTPresence       `json:"ct,omitempty"`      // Whether this certificate is logged; nil when there was no name to look up
+	CTError     string                     `json:"ctError,omitempty"` // The CT lookup failed, but the certificate was still decoded
+	Input       string                     `json:"-"`
+	Submitted   bool                       `json:"-"`
+	Error       string                     `json:"error,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// decodeHandler shows the certificate form (GET) and the decoded certificate (POST)
+func decodeHandler(w http.ResponseWriter, r *http.Request) {
+	var data DecodeData
+	if r.Method == http.MethodPost {
+		data = runDecode(w, r)
+	}
+
+	tmpl, err := parseTemplate("decode.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiDecodeHandler decodes a certificate sent as the raw request body, or a "certificate" or "file" multipart form field
+func apiDecodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runDecode(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runDecode analyzes the posted certificate and checks whether it is logged in CT
+func runDecode(w http.ResponseWriter, r *http.Request) DecodeData {
+	data := DecodeData{Submitted: true, status: http.StatusOK}
+
+	input, err := pastedInput(w, r, "certificate")
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Input = string(input)
+
+	cert, err := services.ParseCertificate(input)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Certificate = services.InspectPastedCertificate(r.Context(), cert, time.Now())
+	if len(data.Certificate.Names) == 0 {
+		return data
+	}
+
+	// Every log entry for the certificate carries its names, so searching one finds it
+	data.CTQuery = data.Certificate.Names[0]
+	auditAction(r, "certificate.decode", data.CTQuery, "serial "+data.Certificate.Certificate.SerialNumber)
+	certs, err := services.FetchCertificates(r.Context(), data.CTQuery)
+	if err != nil {
+		_, data.CTError = serviceError(err)
+		return data
+	}
+	presence := services.LocateInCT(r.Context(), cert, services.GroupCertificates(certs))
+	data.CT = &presence
+
+	return data
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// maxDNSHostnames caps how many hostnames are resolved per request
+const maxDNSHostnames = 200
+
+// resolver is used for the DNS panel (system resolver unless -resolver is set)
+var (
+	resolver     = net.DefaultResolver
+	resolverName = "the system resolver"
+)
+
+// DNSData holds data to pass to the DNS template
+type DNSData struct {
+	Domain   string
+	Records  []services.DNSRecord
+	Skipped  int // Hostnames over maxDNSHostnames
+	Error    string
+	Resolver string
+
+	status int // HTTP status for API responses
+}
+
+// dnsHandler shows where the hostnames seen in CT currently point
+func dnsHandler(w http.ResponseWriter, r *http.Request) {
+	data := runDNSLookup(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("dns.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiDNSHandler returns the DNS records as JSON
+func apiDNSHandler(w http.ResponseWriter, r *http.Request) {
+	data := runDNSLookup(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data.Records)
+}
+
+// runDNSLookup searches CT for the domain and resolves every hostname in its inventory
+func runDNSLookup(ctx context.Context, query url.Values) DNSData {
+	search := runSearch(ctx, query)
+	data := DNSData{
+		Domain:   search.Domain,
+		Error:    search.Error,
+		Resolver: resolverName,
+		status:   search.status,
+	}
+	if data.Error != "" {
+		return data
+	}
+
+	hostnames := make([]string, 0)
+	inventory := services.BuildSubdomainInventory(search.Domain, search.groups, time.Now())
+	for _, subdomain := range inventory.Subdomains {
+		for _, host := range subdomain.Hosts {
+			hostnames = append(hostnames, host.Name)
+		}
+	}
+	if len(hostnames) > maxDNSHostnames {
+		data.Skipped = len(hostnames) - maxDNSHostnames
+		hostnames = hostnames[:maxDNSHostnames]
+	}
+
+	data.Records = services.ResolveHostnames(ctx, resolver, hostnames)
+	return data
+}
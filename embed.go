@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+const (
+	// embedCacheTTL is how long a widget's status is reused; wikis and dashboards load it on every view
+	embedCacheTTL = 15 * time.Minute
+
+	// maxEmbedCache caps how many domains' statuses are kept
+	maxEmbedCache = 1000
+)
+
+// embedCache holds recent widget statuses by domain
+var embedCache = struct {
+	sync.Mutex
+	statuses map[string]services.DomainStatus
+}{statuses: make(map[string]services.DomainStatus)}
+
+// EmbedData holds data to pass to the embeddable status widget template
+type EmbedData struct {
+	Domain string
+	Status services.DomainStatus
+	Error  string
+
+	status int // HTTP status for API responses
+}
+
+// embedHandler shows a domain's certificate status as a small page for other sites to put in an iframe
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	data := runEmbed(r)
+
+	tmpl, err := parseTemplate("embed.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	// Any page may frame the widget; it runs no script and loads nothing, so framing it can't do harm
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors *")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(embedCacheTTL.Seconds())))
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiEmbedHandler returns the widget's status as JSON
+func apiEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	data := runEmbed(r)
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data.Status)
+}
+
+// runEmbed returns the domain's status, searching CT only when the cached status is older than embedCacheTTL
+func runEmbed(r *http.Request) EmbedData {
+	domain := strings.ToLower(strings.TrimSpace(r.PathValue("domain")))
+	if status, ok := cachedEmbedStatus(domain, time.Now()); ok {
+		return EmbedData{Domain: status.Domain, Status: status, status: http.StatusOK}
+	}
+
+	// The domain is in the path, where the audit middleware doesn't look; cached views aren't audited
+	auditAction(r, "search", domain, r.URL.Path)
+	search := runSearch(r.Context(), url.Values{"domain": {domain}, "purpose": {services.PurposeFilterTLS}})
+	data := EmbedData{Domain: search.Domain, Error: search.Error, status: search.status}
+	if data.Error != "" {
+		// An older status beats none while crt.sh is down
+		if status, ok := lastEmbedStatus(domain); ok && search.status >= http.StatusInternalServerError {
+			status.Stale = true
+			return EmbedData{Domain: status.Domain, Status: status, status: http.StatusOK}
+		}
+		return data
+	}
+
+	data.Status = services.CheckDomainStatus(search.Domain, search.groups, time.Now())
+	if search.StaleSince != nil {
+		// Found from older results; say so, and try crt.sh again next time
+		data.Status.Stale = true
+		data.Status.CheckedAt = *search.StaleSince
+		return data
+	}
+	cacheEmbedStatus(domain, data.Status)
+	return data
+}
+
+// cachedEmbedStatus returns the status cached for a domain, if it's recent enough
+func cachedEmbedStatus(domain string, now time.Time) (services.DomainStatus, bool) {
+	embedCache.Lock()
+	defer embedCache.Unlock()
+
+	status, ok := embedCache.statuses[domain]
+	if !ok || now.Sub(status.CheckedAt) >= embedCacheTTL {
+		return services.DomainStatus{}, false
+	}
+	return status, true
+}
+
+// lastEmbedStatus returns the status cached for a domain, however old
+func lastEmbedStatus(domain string) (services.DomainStatus, bool) {
+	embedCache.Lock()
+	defer embedCache.Unlock()
+
+	status, ok := embedCache.statuses[domain]
+	return status, ok
+}
+
+// cacheEmbedStatus keeps a domain's status, making room by dropping stale ones (or all, if none are)
+func cacheEmbedStatus(domain string, status services.DomainStatus) {
+	embedCache.Lock()
+	defer embedCache.Unlock()
+
+	if len(embedCache.statuses) >= maxEmbedCache {
+		for cached, old := range embedCache.statuses {
+			if status.CheckedAt.Sub(old.CheckedAt) >= embedCacheTTL {
+				delete(embedCache.statuses, cached)
+			}
+		}
+		if len(embedCache.statuses) >= maxEmbedCache {
+			clear(embedCache.statuses)
+		}
+	}
+	embedCache.statuses[domain] = status
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// etagFreshness is how long a domain's last search answers a conditional request without crt.sh being
+// searched again; after that the request is answered as usual, and gets the new search's ETag
+const etagFreshness = 5 * time.Minute
+
+// etagPaths are the API endpoints whose answer is worked out from one domain's search, so stays the same
+// until the domain is searched again
+var etagPaths = map[string]bool{
+	"/api/v1/search":       true,
+	"/api/v1/stats":        true,
+	"/api/v1/timeline":     true,
+	"/api/v1/inventory":    true,
+	"/api/v1/cooccurrence": true,
+	"/api/v1/renewals":     true,
+}
+
+// conditionalAPI gives successful GETs of search-based API endpoints an ETag and answers 304 Not Modified
+// when the client already has that version, so pollers and caching proxies don't download unchanged
+// result sets again
+// The ETag comes from the query and when the domain's certificates were last fetched, so it is known
+// before the answer is worked out: a client whose ETag matches a search from the last etagFreshness
+// gets its 304 without crt.sh being searched, and answers are passed through as they're written
+func conditionalAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !etagPaths[r.URL.Path] || r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		domain, err := services.NormalizeDomain(strings.TrimSpace(r.URL.Query().Get("domain")))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if fetchedAt, ok := services.LastSearchedAt(domain); ok && time.Since(fetchedAt) < etagFreshness {
+			etag := searchETag(r, domain, fetchedAt)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				setETag(w.Header(), etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		next.ServeHTTP(&etagWriter{ResponseWriter: w, request: r, domain: domain}, r)
+	})
+}
+
+// searchETag identifies an answer to r worked out from the domain's certificates as fetched at fetchedAt
+// It is weak, since stale results and certificates downloaded meanwhile can change details of an answer
+// without changing its data
+func searchETag(r *http.Request, domain string, fetchedAt time.Time) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		r.URL.Path,
+		r.URL.Query().Encode(),
+		domain,
+		fetchedAt.UTC().Format(time.RFC3339Nano),
+		currentUsername(r),
+		requestLanguage(r),
+	}, "\n")))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setETag sends etag with the caching headers that go with it
+func setETag(header http.Header, etag string) {
+	header.Set("ETag", etag)
+	if header.Get("Cache-Control") == "" {
+		// Proxies may keep the answer, but must check it's still current before reusing it
+		header.Set("Cache-Control", "no-cache")
+	}
+	header.Add("Vary", "Cookie, Authorization")
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing weakly as RFC 9110 says
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter adds the ETag of the search a successful answer was worked out from as its headers go out,
+// and turns the answer into a 304 when the client has it already; nothing is held back
+type etagWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	domain      string
+	wroteHeader bool
+	notModified bool // The client has this answer, so its body is dropped
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	fetchedAt, ok := services.LastSearchedAt(w.domain)
+	if status != http.StatusOK || !ok || header.Get("ETag") != "" {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	etag := searchETag(w.request, w.domain, fetchedAt)
+	setETag(header, etag)
+	if etagMatches(w.request.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		w.notModified = true
+		status = http.StatusNotModified
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers if they haven't gone yet, then whatever has been written
+func (w *etagWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
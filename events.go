@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// Delivery to event sinks
+const (
+	// eventAttempts is how many times a batch is sent to a sink before it's given up on
+	eventAttempts = 3
+
+	// eventRetryDelay is how long to wait before the first retry; each retry waits that much longer
+	eventRetryDelay = 5 * time.Second
+
+	// eventTimeout bounds one attempt to deliver a batch
+	eventTimeout = 30 * time.Second
+)
+
+// eventSinks forward watched domains' new certificates and alerts server-wide, e.g. to a SOC's SIEM
+var eventSinks []services.EventSink
+
+// publishEvents forwards events to every sink in the background
+func publishEvents(events []services.Event) {
+	if len(events) == 0 {
+		return
+	}
+	for _, sink := range eventSinks {
+		go deliverEvents(sink, events)
+	}
+}
+
+// deliverEvents sends events to one sink, retrying a few times, and logs and audits the outcome
+func deliverEvents(sink services.EventSink, events []services.Event) {
+	var err error
+	for attempt := range eventAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * eventRetryDelay)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		err = sink.Send(ctx, events)
+		cancel()
+		if err == nil {
+			auditSystemAction("events.sent", sink.Name(), fmt.Sprintf("%d event(s) for %s", len(events), events[0].Domain))
+			return
+		}
+	}
+	log.Printf("events: %s: %v", sink.Name(), err)
+	auditSystemAction("events.failed", sink.Name(), fmt.Sprintf("%d event(s) for %s: %v", len(events), events[0].Domain, err))
+}
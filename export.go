@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// apiCertificateExportHandler streams the stored certificates of a list of domains as NDJSON (the default)
+// or ?format=csv, one row per certificate written a domain at a time, so exports of any size don't have
+// to fit in memory and crt.sh isn't searched. Domains come from ?domain= (repeated or comma separated) on a GET, or a
+// domain list in the body of a POST, as for /api/v1/import
+func apiCertificateExportHandler(w http.ResponseWriter, r *http.Request) {
+	var domains []string
+	switch r.Method {
+	case http.MethodGet:
+		for _, value := range r.URL.Query()["domain"] {
+			for _, input := range splitList(value) {
+				domain, err := services.ValidateDomain(input)
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s: %v", input, err)})
+					return
+				}
+				domains = append(domains, domain)
+			}
+		}
+	case http.MethodPost:
+		body, err := importBody(w, r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		defer body.Close()
+		list, err := services.ParseDomainList(body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		domains = list.Domains
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if len(domains) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "No valid domains found"})
+		return
+	}
+	if len(domains) > services.MaxImportDomains {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d domains can be exported at once", services.MaxImportDomains)})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unknown format, use ndjson or csv"})
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	var emit func(services.CertificateRow) error
+	flush := func() { controller.Flush() }
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="certificates.csv"`)
+		out := csv.NewWriter(w)
+		out.Write(services.CertificateCSVHeader)
+		emit = func(row services.CertificateRow) error {
+			out.Write(row.CSV())
+			return out.Error()
+		}
+		flush = func() {
+			out.Flush()
+			controller.Flush()
+		}
+		defer out.Flush()
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="certificates.ndjson"`)
+		encoder := json.NewEncoder(w)
+		emit = func(row services.CertificateRow) error {
+			return encoder.Encode(row)
+		}
+	}
+	auditAction(r, "export.certificates", fmt.Sprintf("%d domains", len(domains)), strings.Join(domains, " "))
+
+	// As for the audit export, sending the headers straight away switches the compression middleware
+	// to streaming, and every exportFlushRows rows go out rather than piling up in buffers
+	flush()
+	rows := 0
+	err := services.ExportCertificates(r.Context(), domains, func(row services.CertificateRow) error {
+		if row.Err != nil {
+			_, row.Error = serviceError(row.Err)
+		}
+		if err := emit(row); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status has gone out; a truncated file is all the client can be told
+		log.Printf("certificate export: %v", err)
+	}
+}
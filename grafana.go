@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// GrafanaQuery is the body of a Grafana JSON datasource query
+type GrafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Hide   bool   `json:"hide"`
+	} `json:"targets"`
+}
+
+// GrafanaSeries is a time series answer to a target
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaTable is a table answer to a target
+type GrafanaTable struct {
+	Type    string          `json:"type"` // Always "table"
+	RefID   string          `json:"refId,omitempty"`
+	Columns []GrafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+// GrafanaColumn is a table column's name and type ("string", "number" or "time")
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// expiringColumns are the columns of an expiring target's table
+var expiringColumns = []GrafanaColumn{
+	{Text: "Domain", Type: "string"},
+	{Text: "Common name", Type: "string"},
+	{Text: "Issuer", Type: "string"},
+	{Text: "Serial number", Type: "string"},
+	{Text: "Not after", Type: "time"},
+	{Text: "Days left", Type: "number"},
+}
+
+// apiGrafanaHandler implements the Grafana simple JSON datasource (and Infinity's) over the watched
+// domains' last results: GET is the connection test, POST search (or metrics) lists the targets and
+// POST query answers them
+// It never searches crt.sh, so dashboards refreshing often cost nothing upstream
+func apiGrafanaHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.PathValue("endpoint")
+	if endpoint == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	domains := make([]string, 0)
+	for _, watched := range watchlist.ListFor(currentUsername(r)) {
+		domains = append(domains, watched.Domain)
+	}
+
+	switch endpoint {
+	case "search":
+		writeJSON(w, http.StatusOK, services.GrafanaTargets(domains))
+	case "metrics":
+		// The newer JSON datasource plugin wants labels and values
+		options := make([]map[string]string, 0)
+		for _, target := range services.GrafanaTargets(domains) {
+			options = append(options, map[string]string{"label": target, "value": target})
+		}
+		writeJSON(w, http.StatusOK, options)
+	case "query":
+		answerGrafanaQuery(w, r, domains)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown Grafana endpoint, use search, metrics or query"})
+	}
+}
+
+// answerGrafanaQuery answers each target of a query: a time series for issuance, a table for expiring
+func answerGrafanaQuery(w http.ResponseWriter, r *http.Request, domains []string) {
+	var query GrafanaQuery
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&query); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid query: " + err.Error()})
+		return
+	}
+
+	now := time.Now()
+	answers := make([]any, 0, len(query.Targets))
+	for _, target := range query.Targets {
+		if target.Hide {
+			continue
+		}
+		kind, domain, err := services.ParseGrafanaTarget(target.Target)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		targetDomains := domains
+		if domain != "" {
+			if !slices.Contains(domains, domain) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s isn't on your watchlist", domain)})
+				return
+			}
+			targetDomains = []string{domain}
+		}
+
+		switch kind {
+		case services.GrafanaIssuance:
+			points, err := services.IssuanceSeries(targetDomains, query.Range.From, query.Range.To, time.Duration(query.IntervalMs)*time.Millisecond)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			answers = append(answers, GrafanaSeries{Target: target.Target, Datapoints: points})
+		case services.GrafanaExpiring:
+			table := GrafanaTable{Type: "table", RefID: target.RefID, Columns: expiringColumns, Rows: make([][]any, 0)}
+			for _, cert := range services.ExpiringCertificates(targetDomains, now) {
+				table.Rows = append(table.Rows, []any{
+					cert.Domain, cert.CommonName, ctsearch.IssuerDisplayName(cert.IssuerName), cert.SerialNumber,
+					cert.NotAfterTime.UnixMilli(), cert.DaysLeft,
+				})
+			}
+			answers = append(answers, table)
+		}
+	}
+	writeJSON(w, http.StatusOK, answers)
+}
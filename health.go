@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// Overall health statuses
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded" // Some data source's breaker isn't closed, so some pages fail or show older data
+)
+
+// HealthData is the health endpoint's answer
+type HealthData struct {
+	Status  string                       `json:"status"`
+	Sources []services.BreakerStatus     `json:"sources"`
+	Limits  []services.RateLimiterStatus `json:"limits"` // Outbound request budgets, and the requests queued for them
+	Panics  int64                        `json:"panics"` // Handler panics recovered since the server started
+}
+
+// healthHandler reports that the server is up, the circuit breaker state of each data source and how
+// much of each outbound request budget is left
+// It answers 200 even while a source is down, since restarting or replacing this server wouldn't help
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	data := HealthData{Status: healthOK, Sources: services.Breakers(time.Now()), Limits: services.RateLimiters(time.Now()), Panics: panicsRecovered.Load()}
+	for _, source := range data.Sources {
+		if source.State != services.BreakerClosed {
+			data.Status = healthDegraded
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, data)
+}
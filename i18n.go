@@ -0,0 +1,59 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// languageCookie remembers a language picked with ?lang=, over the browser's Accept-Language
+const languageCookie = "lang"
+
+// requestLanguage is the language to show a page in: ?lang=, then the lang cookie, then Accept-Language
+func requestLanguage(r *http.Request) string {
+	if lang := services.SupportedLanguage(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+	if cookie, err := r.Cookie(languageCookie); err == nil {
+		if lang := services.SupportedLanguage(cookie.Value); lang != "" {
+			return lang
+		}
+	}
+	return services.NegotiateLanguage(r.Header.Get("Accept-Language"))
+}
+
+// rememberLanguage keeps a language picked with ?lang= in a cookie for a year, so the following pages use it too
+func rememberLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lang := services.SupportedLanguage(r.URL.Query().Get("lang")); lang != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     languageCookie,
+				Value:    lang,
+				Path:     "/",
+				Expires:  time.Now().AddDate(1, 0, 0),
+				HttpOnly: true,
+				Secure:   r.TLS != nil || secureCookies,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// languageFuncs replaces the language template functions with ones for the visitor's language
+func languageFuncs(r *http.Request) template.FuncMap {
+	lang := requestLanguage(r)
+	return template.FuncMap{
+		"lang": func() string { return lang },
+		"t": func(message string, args ...any) string {
+			return services.Translate(lang, message, args...)
+		},
+	}
+}
+
+// tr translates a message the server sends outside a template, such as a plain-text error
+func tr(r *http.Request, message string, args ...any) string {
+	return services.Translate(requestLanguage(r), message, args...)
+}
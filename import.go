@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// maxImportSize caps uploaded domain lists
+const maxImportSize = 1 << 20
+
+// ImportData holds data to pass to the import template
+type ImportData struct {
+	Action    string                      `json:"action"` // "search" or "watch"
+	Import    services.DomainImport       `json:"import"`
+	Results   []services.BulkSearchResult `json:"results,omitempty"` // Bulk search
+	Skipped   int                         `json:"skipped,omitempty"` // Domains over MaxBulkSearchDomains not searched
+	Added     []string                    `json:"added,omitempty"`   // Newly watched domains
+	Submitted bool                        `json:"-"`
+	Error     string                      `json:"error,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// MaxDomains is the most domains one upload may contain
+func (ImportData) MaxDomains() int {
+	return services.MaxImportDomains
+}
+
+// MaxSearch is the most domains searched from one upload
+func (ImportData) MaxSearch() int {
+	return services.MaxBulkSearchDomains
+}
+
+// importHandler shows the upload form (GET) and the import results (POST)
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	data := ImportData{Action: "search"}
+	if r.Method == http.MethodPost {
+		data = runImport(w, r)
+	}
+
+	tmpl, err := parseTemplate("import.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiImportHandler imports a domain list sent as a multipart "file" field or as the raw request body
+func apiImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runImport(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runImport reads the uploaded list and either bulk searches it or adds it to the watchlist (?action=)
+func runImport(w http.ResponseWriter, r *http.Request) ImportData {
+	data := ImportData{
+		Submitted: true,
+		status:    http.StatusOK,
+	}
+
+	body, err := importBody(w, r)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	defer body.Close()
+
+	// The action comes from the upload form, or the query string for raw bodies
+	data.Action = formOption(r, "action")
+	if data.Action == "" {
+		data.Action = "search"
+	}
+	if data.Action != "search" && data.Action != "watch" {
+		data.Error = `action must be "search" or "watch"`
+		data.status = http.StatusBadRequest
+		return data
+	}
+
+	data.Import, err = services.ParseDomainList(body)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	if len(data.Import.Domains) == 0 {
+		data.Error = "No valid domains found"
+		data.status = http.StatusBadRequest
+		return data
+	}
+
+	switch data.Action {
+	case "search":
+		domains := data.Import.Domains
+		if len(domains) > services.MaxBulkSearchDomains {
+			data.Skipped = len(domains) - services.MaxBulkSearchDomains
+			domains = domains[:services.MaxBulkSearchDomains]
+		}
+		data.Results = services.BulkSearch(r.Context(), domains, time.Now())
+		auditAction(r, "import.search", fmt.Sprintf("%d domains", len(domains)), strings.Join(domains, " "))
+	case "watch":
+		data.Added, err = watchlist.AddAll(currentUsername(r), data.Import.Domains)
+		if err != nil {
+			data.Error = err.Error()
+			data.status = http.StatusInternalServerError
+			return data
+		}
+		auditAction(r, "watchlist.import", fmt.Sprintf("%d domains", len(data.Added)), strings.Join(data.Added, " "))
+		// Record baselines one at a time rather than hitting crt.sh with hundreds of queries at once
+		go func(domains []string) {
+			for _, domain := range domains {
+				checkWatchedDomain(watchlist, domain)
+			}
+			log.Printf("import: recorded baselines for %d domain(s)", len(domains))
+		}(data.Added)
+		data.status = http.StatusCreated
+	}
+
+	return data
+}
+
+// importBody returns the uploaded file, or the request body when it isn't a multipart upload
+func importBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	// Raw bodies are read as-is, whatever content type the client claims
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.Body, nil
+	}
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, errors.New("could not read upload: " + err.Error())
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, errors.New("please choose a file to upload")
+	}
+	return file, nil
+}
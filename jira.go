@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// jiraTracker opens Jira issues for watched domains' expiring certificates and unexpected issuers; nil
+// when Jira isn't configured
+var jiraTracker *services.JiraTracker
+
+// openJiraIssues opens an issue in the background for each of a check's findings that has none open,
+// auditing what it opens
+func openJiraIssues(domain string, groups []services.CertificateGroup, now time.Time) {
+	if jiraTracker == nil {
+		return
+	}
+	findings := jiraTracker.Findings(domain, groups, now)
+	if len(findings) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		defer cancel()
+		for _, finding := range findings {
+			key, created, err := jiraTracker.Open(ctx, domain, finding)
+			if err != nil {
+				log.Printf("jira: %s: %v", domain, err)
+				auditSystemAction("jira.failed", domain, fmt.Sprintf("%s %s: %v", finding.Subject, finding.Message, err))
+				return
+			}
+			if created {
+				auditSystemAction("jira.created", key, fmt.Sprintf("%s: %s %s", domain, finding.Subject, finding.Message))
+			}
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// jobs runs bulk scans in the background for the job API
+var jobs *services.JobStore
+
+// JobSubmission is the answer to a submitted job: the job, and any lines of the list that weren't domains
+type JobSubmission struct {
+	services.Job
+	Invalid    []services.RejectedDomain `json:"invalid"`
+	Duplicates int                       `json:"duplicates"`
+}
+
+// JobResults is a job with every result so far
+type JobResults struct {
+	services.Job
+	Results []services.JobResult `json:"results"`
+}
+
+// apiJobsHandler lists the user's jobs (GET) or submits one (POST)
+// A job takes a CSV or newline-delimited domain list like /api/v1/import (multipart file field or raw
+// body), and ?type=search (the default) or report
+func apiJobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, jobs.List(currentUsername(r)))
+	case http.MethodPost:
+		submitJob(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// submitJob queues a job over the uploaded domain list and answers 202 with where to poll it
+func submitJob(w http.ResponseWriter, r *http.Request) {
+	body, err := importBody(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	jobType := formOption(r, "type")
+	if jobType == "" {
+		jobType = services.JobSearch
+	}
+	list, err := services.ParseDomainList(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	job, err := jobs.Submit(currentUsername(r), jobType, list.Domains)
+	if errors.Is(err, services.ErrTooManyJobs) {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	auditAction(r, "job.submit", job.ID, fmt.Sprintf("%s of %d domains: %s", job.Type, job.Total, strings.Join(job.Domains, " ")))
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, JobSubmission{Job: job, Invalid: list.Invalid, Duplicates: list.Duplicates})
+}
+
+// apiJobHandler returns a job's status and progress (GET), or cancels it (DELETE)
+// Deleting a job that has already finished removes it and its results
+func apiJobHandler(w http.ResponseWriter, r *http.Request) {
+	username, id := currentUsername(r), r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		job, err := jobs.Get(username, id)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case http.MethodDelete:
+		job, err := jobs.Get(username, id)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		if job.Finished() {
+			if err := jobs.Delete(username, id); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			auditAction(r, "job.delete", id, "")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		job, err = jobs.Cancel(username, id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		auditAction(r, "job.cancel", id, fmt.Sprintf("after %d of %d domains", job.Completed, job.Total))
+		writeJSON(w, http.StatusOK, job)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// apiJobResultsHandler returns a job with its results so far, sorted by domain
+// Results of a running job grow as it goes; status says whether it has finished
+func apiJobResultsHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Results(currentUsername(r), r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, JobResults{Job: job, Results: job.Results})
+}
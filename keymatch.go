@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// KeyMatchData holds data to pass to the key match template
+type KeyMatchData struct {
+	ID          string            `json:"-"` // crt.sh ID, when the certificate isn't pasted
+	Certificate string            `json:"-"` // Pasted certificate; the key is never echoed back
+	Result      services.KeyMatch `json:"result"`
+	Submitted   bool              `json:"-"`
+	Error       string            `json:"error,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// keyMatchHandler shows the key match form (GET) and the result (POST)
+func keyMatchHandler(w http.ResponseWriter, r *http.Request) {
+	data := KeyMatchData{ID: r.URL.Query().Get("id")}
+	if r.Method == http.MethodPost {
+		data = runKeyMatch(w, r)
+	}
+
+	tmpl, err := parseTemplate("keymatch.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiKeyMatchHandler checks a certificate ("certificate" field, or "id" for a crt.sh ID) against
+// a public key or CSR ("key" field), posted as a form
+func apiKeyMatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runKeyMatch(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data.Result)
+}
+
+// runKeyMatch compares the SPKI of the certificate and the key
+// Nothing posted is stored, logged or audited
+func runKeyMatch(w http.ResponseWriter, r *http.Request) KeyMatchData {
+	data := KeyMatchData{Submitted: true, status: http.StatusOK}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		data.Error = "could not read form: " + err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.ID = strings.TrimSpace(r.FormValue("id"))
+	data.Certificate = strings.TrimSpace(r.PostFormValue("certificate"))
+
+	// Check for private keys first, so one pasted in either box is refused and never shown again
+	if strings.Contains(data.Certificate, "PRIVATE KEY") {
+		data.Certificate = ""
+		data.Error = services.ErrPrivateKey.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	keyInput := strings.TrimSpace(r.PostFormValue("key"))
+	if keyInput == "" {
+		data.Error = "please paste a public key or CSR"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	key, err := services.ParsePublicKey([]byte(keyInput))
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+
+	var pemData []byte
+	switch {
+	case data.Certificate != "":
+		pemData = []byte(data.Certificate)
+	case data.ID != "":
+		id, err := strconv.ParseInt(data.ID, 10, 64)
+		if err != nil || id <= 0 {
+			data.Error = "invalid crt.sh ID " + strconv.Quote(data.ID)
+			data.status = http.StatusBadRequest
+			return data
+		}
+		if pemData, err = services.FetchPEM(r.Context(), id); err != nil {
+			data.status, data.Error = serviceError(err)
+			return data
+		}
+	default:
+		data.Error = "please paste a certificate or enter its crt.sh ID"
+		data.status = http.StatusBadRequest
+		return data
+	}
+
+	cert, err := services.ParseCertificate(pemData)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	if data.Result, err = services.MatchKey(cert, key); err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+	}
+	return data
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// KeysData holds data to pass to the key rotation template
+type KeysData struct {
+	Domain  string
+	Report  services.KeyRotationReport
+	Error   string
+	Invalid *services.DomainError
+
+	status int // HTTP status for API responses
+}
+
+// keysHandler shows whether each hostname's renewals come with new keys
+func keysHandler(w http.ResponseWriter, r *http.Request) {
+	data := runKeyRotation(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("keys.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiKeysHandler returns the key rotation report as JSON
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	data := runKeyRotation(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runKeyRotation searches CT for the domain and follows the keys of its newest certificates
+func runKeyRotation(ctx context.Context, query url.Values) KeysData {
+	search := runSearch(ctx, query)
+	data := KeysData{Domain: search.Domain, Error: search.Error, Invalid: search.Invalid, status: search.status}
+	if data.Error != "" {
+		return data
+	}
+
+	data.Report = services.AnalyzeKeyRotation(ctx, search.Domain, search.groups, time.Now())
+	return data
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// KeystoreData holds data to pass to the keystore template
+type KeystoreData struct {
+	Filename  string              `json:"filename,omitempty"`
+	Format    string              `json:"format,omitempty"`
+	Verified  bool                `json:"verified"`
+	Entries   []KeystoreEntryData `json:"entries,omitempty"`
+	Truncated bool                `json:"truncated,omitempty"`
+	Submitted bool                `json:"-"`
+	Error     string              `json:"error,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// KeystoreEntryData is a key entry's chain, or a trusted certificate, with each certificate analyzed
+type KeystoreEntryData struct {
+	services.KeystoreEntry
+	Certificates []KeystoreCertificate `json:"certificates"`
+}
+
+// KeyState is "matches", "differs" or "" when the private key couldn't be compared, for the template
+func (e KeystoreEntryData) KeyState() string {
+	switch {
+	case e.KeyMatches == nil:
+		return ""
+	case *e.KeyMatches:
+		return "matches"
+	}
+	return "differs"
+}
+
+// KeystoreCertificate is one certificate from a keystore and whether it is logged in CT
+type KeystoreCertificate struct {
+	Certificate services.PastedCertificate `json:"certificate"`
+	CTQuery     string                     `json:"ctQuery,omitempty"`
+	CT          *services.CTPresence       `json:"ct,omitempty"`
+	CTError     string                     `json:"ctError,omitempty"`
+}
+
+// keystoreHandler shows the upload form (GET) and the keystore's certificates (POST)
+func keystoreHandler(w http.ResponseWriter, r *http.Request) {
+	var data KeystoreData
+	if r.Method == http.MethodPost {
+		data = runKeystoreInspection(w, r)
+	}
+
+	tmpl, err := parseTemplate("keystore.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiKeystoreHandler lists the certificates in a PKCS#12 or JKS "file" posted as a multipart form with its "password"
+func apiKeystoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runKeystoreInspection(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runKeystoreInspection opens the uploaded keystore, analyzes every certificate in it and checks each against CT
+// The password and private keys are never stored, logged or echoed back
+func runKeystoreInspection(w http.ResponseWriter, r *http.Request) KeystoreData {
+	data := KeystoreData{Submitted: true, status: http.StatusOK}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		data.Error = "could not read upload: " + err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		data.Error = "please upload a .p12, .pfx or .jks keystore"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	defer file.Close()
+	data.Filename = header.Filename
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		data.Error = "could not read upload: " + err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	keystore, err := services.ParseKeystore(content, r.PostFormValue("password"))
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Format, data.Verified, data.Truncated = keystore.Format, keystore.Verified, keystore.Truncated
+
+	now := time.Now()
+	queries := make([]string, 0)
+	for _, entry := range keystore.Entries {
+		entryData := KeystoreEntryData{KeystoreEntry: entry, Certificates: make([]KeystoreCertificate, 0, len(entry.Chain))}
+		for _, cert := range entry.Chain {
+			checked := KeystoreCertificate{Certificate: services.InspectPastedCertificate(r.Context(), cert, now)}
+			// Every log entry for the certificate carries its names, so searching one finds it
+			if len(checked.Certificate.Names) > 0 {
+				checked.CTQuery = checked.Certificate.Names[0]
+				if !slices.Contains(queries, checked.CTQuery) {
+					queries = append(queries, checked.CTQuery)
+				}
+			}
+			entryData.Certificates = append(entryData.Certificates, checked)
+		}
+		data.Entries = append(data.Entries, entryData)
+	}
+	if len(queries) == 0 {
+		return data
+	}
+
+	// Search each name once, however many certificates share it
+	auditAction(r, "keystore.inspect", data.Filename, fmt.Sprintf("%s, looking up %s", data.Format, strings.Join(queries, " ")))
+	searched := make(map[string][]services.CertificateGroup)
+	failed := make(map[string]string) // Error messages by query
+	for _, query := range queries {
+		certs, err := services.FetchCertificates(r.Context(), query)
+		if err != nil {
+			_, failed[query] = serviceError(err)
+			continue
+		}
+		searched[query] = services.GroupCertificates(certs)
+	}
+	for i := range data.Entries {
+		for j, checked := range data.Entries[i].Certificates {
+			if checked.CTQuery == "" {
+				continue
+			}
+			if message := failed[checked.CTQuery]; message != "" {
+				data.Entries[i].Certificates[j].CTError = message
+				continue
+			}
+			presence := services.LocateInCT(r.Context(), data.Entries[i].Chain[j], searched[checked.CTQuery])
+			data.Entries[i].Certificates[j].CT = &presence
+		}
+	}
+	return data
+}
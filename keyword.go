@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// KeywordData holds data to pass to the keyword template
+type KeywordData struct {
+	Keyword string
+	Exclude string // Comma-separated domains as entered
+	Report  services.KeywordReport
+	Error   string
+	Invalid *services.DomainError
+
+	status int // HTTP status for API responses
+}
+
+// keywordHandler hunts for certificates naming a keyword on any domain
+func keywordHandler(w http.ResponseWriter, r *http.Request) {
+	data := KeywordData{}
+	// Show just the form until a keyword is entered
+	if r.URL.Query().Has("keyword") {
+		data = runKeywordSearch(r.Context(), r.URL.Query())
+	}
+
+	tmpl, err := parseTemplate("keyword.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiKeywordHandler returns the keyword report as JSON
+func apiKeywordHandler(w http.ResponseWriter, r *http.Request) {
+	data := runKeywordSearch(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runKeywordSearch parses ?keyword= and ?exclude= (repeatable or comma-separated) and runs the search
+func runKeywordSearch(ctx context.Context, query url.Values) KeywordData {
+	data := KeywordData{
+		Keyword: strings.TrimSpace(query.Get("keyword")),
+		Exclude: strings.Join(query["exclude"], ","),
+		status:  http.StatusOK,
+	}
+
+	keyword, err := services.NormalizeKeyword(data.Keyword)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Keyword = keyword
+
+	excluded := make([]string, 0)
+	for _, value := range query["exclude"] {
+		for _, domain := range strings.Split(value, ",") {
+			if domain = strings.TrimSpace(domain); domain == "" {
+				continue
+			}
+			ascii, err := services.NormalizeField("exclude", domain)
+			if err != nil {
+				data.status, data.Error = serviceError(err)
+				errors.As(err, &data.Invalid)
+				return data
+			}
+			excluded = append(excluded, ascii)
+		}
+	}
+
+	data.Report, err = services.SearchKeyword(ctx, keyword, excluded, time.Now())
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+	}
+
+	return data
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// Lookalike sweeps query crt.sh once per permutation, so keep them bounded
+const (
+	defaultLookalikeLimit = 50
+	maxLookalikeLimit     = 200
+)
+
+// LookalikeData holds data to pass to the lookalikes template
+type LookalikeData struct {
+	Domain  string
+	Engines []EngineOption
+	Report  services.LookalikeReport
+	Error   string
+	Invalid *services.DomainError
+
+	status int // HTTP status for API responses
+}
+
+// EngineOption is a permutation engine checkbox on the lookalikes page
+type EngineOption struct {
+	Name        string
+	Description string
+	Selected    bool
+}
+
+// lookalikesHandler renders the phishing-infrastructure report for a domain
+func lookalikesHandler(w http.ResponseWriter, r *http.Request) {
+	data := runLookalikeSweep(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("lookalikes.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiLookalikesHandler returns the lookalike report as JSON
+func apiLookalikesHandler(w http.ResponseWriter, r *http.Request) {
+	data := runLookalikeSweep(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runLookalikeSweep parses ?domain=, ?engine= (repeatable or comma-separated) and ?limit=
+// and runs the sweep
+func runLookalikeSweep(ctx context.Context, query url.Values) LookalikeData {
+	data := LookalikeData{
+		Domain: strings.TrimSpace(query.Get("domain")),
+		status: http.StatusOK,
+	}
+
+	// Collect the chosen engines
+	engines := make([]string, 0)
+	for _, value := range query["engine"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				engines = append(engines, name)
+			}
+		}
+	}
+	if len(engines) == 0 {
+		engines = services.DefaultPermutationEngines
+	}
+	data.Engines = engineOptions(engines)
+
+	if data.Domain == "" {
+		data.Error = "Please enter a domain name"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	ascii, err := services.NormalizeDomain(data.Domain)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		errors.As(err, &data.Invalid)
+		return data
+	}
+	data.Domain = ascii
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLookalikeLimit
+	}
+	if limit > maxLookalikeLimit {
+		limit = maxLookalikeLimit
+	}
+
+	data.Report, err = services.SweepLookalikes(ctx, data.Domain, engines, limit, time.Now())
+	switch {
+	case ctx.Err() != nil:
+		data.status, data.Error = serviceError(err)
+	case err != nil:
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+	}
+
+	return data
+}
+
+// engineOptions lists every permutation engine, marking the selected ones
+func engineOptions(selected []string) []EngineOption {
+	options := make([]EngineOption, 0, len(services.PermutationEngines))
+	for name, engine := range services.PermutationEngines {
+		option := EngineOption{Name: name, Description: engine.Description}
+		for _, s := range selected {
+			if s == name {
+				option.Selected = true
+			}
+		}
+		options = append(options, option)
+	}
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Name < options[j].Name
+	})
+	return options
+}
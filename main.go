@@ -1,23 +1,582 @@
 package main
 
 import (
-	"certificate-viewer/services"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
 )
 
+// watchlist holds the domains monitored in the background
+var watchlist *services.Watchlist
+
+// publicURL is the scheme and host share links start with, for servers behind a proxy or with several names
+var publicURL string
+
+// templateFuncs are available to templates that need them
+var templateFuncs = template.FuncMap{
+	"displayName":         services.DisplayName,
+	"purposeLabel":        services.PurposeLabel,
+	"issuerName":          services.IssuerDisplayName,
+	"distrust":            services.IssuerDistrust,
+	"issuedAfterDistrust": services.IssuedAfterDistrust,
+	"internalNames":       services.CertificateInternalNames,
+	"flush":               func() string { return "" }, // Page handlers that stream bind it with executeStreamed
+
+	// The light theme in English and UTC; page handlers swap these for the visitor's with pageFuncs
+	"theme":      func() string { return themeLight },
+	"themeStyle": func() template.HTML { return "" },
+	"lang":       func() string { return services.DefaultLanguage },
+	"t": func(message string, args ...any) string {
+		return services.Translate(services.DefaultLanguage, message, args...)
+	},
+	"timezone":     func() string { return "UTC" },
+	"localTime":    func(t time.Time) string { return localTime(t, time.UTC) },
+	"relativeTime": func(t time.Time) string { return relativeTime(services.DefaultLanguage, t) },
+	"expiry":       func(t time.Time) string { return expiry(services.DefaultLanguage, t) },
+}
+
 func main() {
+	watchlistPath := flag.String("watchlist", "watchlist.json", "file to store watched domains and alerts in")
+	refreshInterval := flag.Duration("refresh", time.Hour, "how often to check watched domains")
+	refreshConcurrency := flag.Int("refresh-concurrency", 4, "how many watched domains may be checked at once")
+	expectedIssuers := flag.String("expected-issuers", "", `comma-separated issuers watched domains' certificates should come from, matched against the issuer name, e.g. "Let's Encrypt,DigiCert"; enables unexpected_issuer_total on /metrics`)
+	flag.StringVar(&pdfCommand, "pdf-command", "", `command converting HTML on stdin to PDF on stdout for reports, e.g. "wkhtmltopdf --quiet - -"`)
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server (host:port) for summary emails; password is read from SMTP_PASSWORD")
+	smtpUser := flag.String("smtp-user", "", "SMTP username for summary emails")
+	mailFrom := flag.String("summary-from", "", "From address for summary emails")
+	mailTo := flag.String("summary-to", "", "comma-separated distribution list for summary emails")
+	resolverAddr := flag.String("resolver", "", "DNS server (host or host:port) for the DNS panel, MTA-STS and TLSA lookups; system resolver when empty")
+	summaryInterval := flag.Duration("summary-interval", 7*24*time.Hour, "how often to email the watchlist summary")
+	usersPath := flag.String("users", "", "file to store user accounts in; enables login when set")
+	sessionTTL := flag.Duration("session-ttl", 12*time.Hour, "how long a login lasts, however active")
+	sessionIdle := flag.Duration("session-idle", time.Hour, "log out sessions unused for this long; 0 to only use -session-ttl")
+	sessionRedis := flag.String("session-redis", "", "redis:// URL to keep sessions in, so they survive restarts and are shared between servers; in memory when empty")
+	flag.BoolVar(&secureCookies, "secure-cookies", false, "always mark the session cookie Secure, e.g. behind a proxy that terminates TLS")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL for single sign-on; enables login when set (client secret is read from OIDC_CLIENT_SECRET)")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "", "this server's /login/oidc/callback URL as registered with the identity provider")
+	oidcUsernameClaim := flag.String("oidc-username-claim", "preferred_username", "ID token claim to use as the username")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", "groups", "ID token claim listing the user's groups")
+	oidcAdminGroups := flag.String("oidc-admin-groups", "", "comma-separated groups whose members are admins")
+	oidcAllowedGroups := flag.String("oidc-allowed-groups", "", "comma-separated groups allowed to log in; everyone the provider authenticates when empty")
+	proxyUserHeader := flag.String("proxy-user-header", "", "header a trusted reverse proxy puts the authenticated username in, e.g. X-Remote-User; enables login when set")
+	proxyGroupsHeader := flag.String("proxy-groups-header", "", "header a trusted reverse proxy puts the user's comma-separated groups in")
+	proxyAdminGroups := flag.String("proxy-admin-groups", "", "comma-separated proxy groups whose members are admins")
+	trustedProxies := flag.String("trusted-proxies", "127.0.0.1,::1", "comma-separated addresses or CIDRs allowed to set the proxy auth headers")
+	proxyLogout := flag.String("proxy-logout-url", "", "where Log out sends proxy-authenticated users, e.g. /oauth2/sign_out")
+	templatesDir := flag.String("templates-dir", "", "directory of templates and partials to use instead of the built-in ones (files it lacks fall back to the built-in copies); its static/ subdirectory is served at /static/")
+	listenAddr := flag.String("addr", ":8080", "TCP address to serve HTTP on; empty to only use -socket")
+	socketPath := flag.String("socket", "", "Unix socket to also serve HTTP on, e.g. for the certviewer command-line client")
+	savedSearchesPath := flag.String("saved-searches", "saved_searches.json", "file to store saved searches in")
+	jobsPath := flag.String("jobs", "jobs", "directory to store background scan jobs and their results in; kept in memory only when empty")
+	jobWorkers := flag.Int("job-workers", 1, "how many background scan jobs may run at once")
+	teamsPath := flag.String("teams", "teams.json", "file to store team workspaces in")
+	notificationsPath := flag.String("notifications", "notifications.json", "file to store users' notification preferences in")
+	deliveriesPath := flag.String("webhook-deliveries", "deliveries.json", "file to keep the webhook delivery log in, so pending deliveries survive a restart; kept in memory only when empty")
+	flag.StringVar(&analyzersPath, "analyzers", "", "JSON file of custom report checks (naming conventions, approved key types)")
+	flag.StringVar(&publicURL, "public-url", "", "this server's address as users reach it, e.g. https://certs.example.com, for share links; taken from each request when empty")
+	flag.DurationVar(&maxSearchTimeout, "search-timeout", maxSearchTimeout, "longest a crt.sh search may take; a search's ?timeout= (in seconds) can only shorten it")
+	auditPath := flag.String("audit", "audit.log", "file to append the audit log to (JSON lines); kept in memory only when empty")
+	cachePath := flag.String("cache", "", "bbolt file to cache downloaded certificates and the last search results in, so they survive restarts; in memory only when empty")
+	cacheSizeMB := flag.Int64("cache-size", 256, "largest the -cache file's contents may grow, in megabytes; 0 for no limit")
+	cacheEviction := flag.String("cache-eviction", services.EvictLRU, "what -cache drops when full: lru (least recently used) or fifo (oldest written)")
+	splunkURL := flag.String("splunk-url", "", "Splunk HTTP Event Collector to forward new certificates and alerts to, e.g. https://splunk.example.com:8088; token is read from SPLUNK_HEC_TOKEN")
+	splunkIndex := flag.String("splunk-index", "", "Splunk index for forwarded events; the token's default when empty")
+	splunkSourceType := flag.String("splunk-sourcetype", "certviewer:event", "Splunk sourcetype for forwarded events")
+	syslogAddr := flag.String("syslog-addr", "", "Syslog server to forward new certificates and alerts to, e.g. udp://siem.example.com:514, tcp://... or tls://...")
+	syslogFormat := flag.String("syslog-format", services.SyslogCEF, "Format of forwarded syslog messages: cef (ArcSight) or leef (QRadar)")
+	jiraURL := flag.String("jira-url", "", "Jira Cloud or Server to open issues in for watched domains' expiring certificates and unexpected issuers, e.g. https://example.atlassian.net; token is read from JIRA_API_TOKEN")
+	jiraUser := flag.String("jira-user", "", "Jira Cloud account email the API token belongs to; empty uses the token as a Jira Server/Data Center personal access token")
+	jiraProject := flag.String("jira-project", "", "key of the Jira project to open issues in, e.g. OPS")
+	jiraIssueType := flag.String("jira-issue-type", "Task", "type of the Jira issues opened")
+	jiraExpiring := flag.Duration("jira-expiring", 21*24*time.Hour, "open a Jira issue when a hostname's newest certificate expires within this")
+	jiraFields := flag.String("jira-fields", "", `JSON file of fields to set on new Jira issues by field ID, e.g. {"priority": {"name": "High"}, "customfield_10042": "{domain}"}`)
+	issuesProvider := flag.String("issues-provider", services.IssuesGitHub, "where -issues-repo is: github or gitlab")
+	issuesRepo := flag.String("issues-repo", "", "repository to file an issue in when a watched domain's certificate is about to expire, closed again once CT shows a renewal: owner/repo on GitHub, group/project on GitLab; token is read from ISSUES_TOKEN")
+	issuesAPI := flag.String("issues-api", "", "API URL for GitHub Enterprise or self-managed GitLab, e.g. https://github.example.com/api/v3 or https://gitlab.example.com/api/v4")
+	issuesLabels := flag.String("issues-labels", "", "comma-separated labels to put on filed issues besides certviewer")
+	issuesExpiring := flag.Duration("issues-expiring", 21*24*time.Hour, "file an issue when a hostname's newest certificate expires within this")
+	rateLimits := flag.String("rate-limits", "", `outbound requests per second by source, e.g. "crtsh=4,ctlogs=1,ocsp=5" (ocsp is per responder host, 0 is unlimited); sources left out keep those defaults`)
+	flag.DurationVar(&rateLimitWait, "rate-limit-wait", 10*time.Second, "longest an outbound request may queue for its source's budget before failing")
+	flag.StringVar(&rateLimitsPath, "rate-limits-file", "", `JSON file of rate limits over -rate-limits and -rate-limit-wait, e.g. {"limits": {"crtsh": 2}, "wait": "5s"}; re-read on reload`)
+	flag.StringVar(&settingsPath, "settings-file", "", `JSON file of expected issuers and integration credentials over -expected-issuers and the SPLUNK_HEC_TOKEN, JIRA_API_TOKEN, ISSUES_TOKEN, SMTP_PASSWORD and OIDC_CLIENT_SECRET variables, e.g. {"expected_issuers": ["Let's Encrypt"], "jira_api_token": "..."}; re-read on reload`)
+	flag.Parse()
+
+	if *resolverAddr != "" {
+		resolver = services.NewResolver(*resolverAddr)
+		resolverName = *resolverAddr
+		tlsaNameserver = *resolverAddr
+	}
+
+	if *templatesDir != "" {
+		if err := useTemplateDir(*templatesDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	settingsDefaults = services.SettingsFile{
+		ExpectedIssuers:  splitList(*expectedIssuers),
+		SplunkHECToken:   os.Getenv("SPLUNK_HEC_TOKEN"),
+		JiraAPIToken:     os.Getenv("JIRA_API_TOKEN"),
+		IssuesToken:      os.Getenv("ISSUES_TOKEN"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+	}
+	settings, err := loadSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	services.SetExpectedIssuers(settings.ExpectedIssuers)
+	smtpPassword.value = settings.SMTPPassword
+
+	rateLimitFlags, err = services.ParseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := applyRateLimits(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *cachePath != "" {
+		cache, err := services.OpenDiskCache(*cachePath, *cacheSizeMB<<20, *cacheEviction)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cache.Close()
+		services.SetDiskCache(cache)
+	}
+
+	auditLog, err = services.OpenAuditLog(*auditPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watchlist, err = services.LoadWatchlist(*watchlistPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	savedSearches, err = services.LoadSavedSearches(*savedSearchesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	teams, err = services.LoadTeamStore(*teamsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notificationPrefs, err = services.LoadNotificationStore(*notificationsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webhookDeliveries, err = services.LoadDeliveryLog(*deliveriesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	jobs, err = services.LoadJobStore(*jobsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if analyzersPath != "" {
+		configured, err := services.LoadAnalyzers(analyzersPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		services.SetConfiguredAnalyzers(configured)
+	}
+
+	// Forward watched domains' new certificates and alerts to the configured sinks
+	if *splunkURL != "" {
+		sink, err := services.NewSplunkSink(*splunkURL, settings.SplunkHECToken, *splunkIndex, *splunkSourceType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		eventSinks = append(eventSinks, sink)
+	}
+	if *syslogAddr != "" {
+		sink, err := services.NewSyslogSink(*syslogAddr, *syslogFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		eventSinks = append(eventSinks, sink)
+	}
+
+	if *jiraURL != "" {
+		config := services.JiraConfig{
+			URL:            *jiraURL,
+			User:           *jiraUser,
+			Token:          settings.JiraAPIToken,
+			Project:        *jiraProject,
+			IssueType:      *jiraIssueType,
+			ExpiringWithin: *jiraExpiring,
+		}
+		if *jiraFields != "" {
+			config.Fields, err = services.LoadJiraFields(*jiraFields)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		jiraTracker, err = services.NewJiraTracker(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *issuesRepo != "" {
+		repoIssues, err = services.NewRepoIssues(services.RepoIssuesConfig{
+			Provider:       *issuesProvider,
+			Repository:     *issuesRepo,
+			APIURL:         *issuesAPI,
+			Token:          settings.IssuesToken,
+			Labels:         splitList(*issuesLabels),
+			ExpiringWithin: *issuesExpiring,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Check watched domains in the background, sending alerts held over quiet hours once they end
+	go runMonitor(watchlist, *refreshInterval, *refreshConcurrency)
+	go runHeldAlerts()
+
+	// Run submitted scan jobs, resuming any the last run didn't finish
+	go jobs.Run(context.Background(), *jobWorkers)
+
+	// Send the webhook deliveries the last run didn't finish
+	resumeWebhookDeliveries()
+
+	// Re-read the configuration files on SIGHUP, without restarting anything
+	go reloadOnSIGHUP()
+
+	// Email the watchlist summary, and teams' summaries to their alert emails, if a mail server is configured
+	if *smtpAddr != "" && *mailFrom != "" {
+		summaryMail = &mailConfig{
+			Addr:     *smtpAddr,
+			Username: *smtpUser,
+			From:     *mailFrom,
+		}
+		for _, to := range strings.Split(*mailTo, ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				summaryMail.To = append(summaryMail.To, to)
+			}
+		}
+		go runSummaryScheduler(*summaryInterval)
+	}
+
 	// Handle requests to the root path "/"
 	http.HandleFunc("/", homeHandler)
 
 	// Handle search requests
 	http.HandleFunc("/search", searchHandler)
 
-	// Start the server on port 8080
-	fmt.Println("Server starting on http://localhost:8080")
-	http.ListenAndServe(":8080", nil)
+	// Handle one issuer's certificates for a domain
+	http.HandleFunc("/search/{domain}/issuer/{slug}", issuerHandler)
+
+	// Handle printable results, every page at once
+	http.HandleFunc("/search/print", printHandler)
+
+	// Handle subdomain inventory requests
+	http.HandleFunc("/inventory", inventoryHandler)
+
+	// Handle DNS lookups for hostnames seen in CT
+	http.HandleFunc("/dns", dnsHandler)
+
+	// Handle DANE/TLSA checks against probed services
+	http.HandleFunc("/dane", daneHandler)
+
+	// Handle MTA-STS and TLS-RPT checks
+	http.HandleFunc("/mta-sts", mtastsHandler)
+
+	// Handle bulk domain imports
+	http.HandleFunc("/import", importHandler)
+
+	// Handle zone file imports
+	http.HandleFunc("/zone", zoneHandler)
+
+	// Handle CSR decoding
+	http.HandleFunc("/csr", csrHandler)
+
+	// Handle pasted certificate decoding
+	http.HandleFunc("/decode", decodeHandler)
+
+	// Handle certificate permalinks, by crt.sh ID or by issuer and serial number
+	http.HandleFunc("/cert/{id}", certHandler)
+	http.HandleFunc("/serial/{caid}/{serial}", serialHandler)
+
+	// Handle TLSA record generation for a certificate
+	http.HandleFunc("/tlsa", tlsaHandler)
+
+	// Handle certificate and public key matching
+	http.HandleFunc("/keymatch", keyMatchHandler)
+
+	// Handle PKCS#12 and JKS keystore inspection
+	http.HandleFunc("/keystore", keystoreHandler)
+
+	// Handle uploaded chain validation
+	http.HandleFunc("/chain", chainHandler)
+
+	// Handle side-by-side certificate comparisons
+	http.HandleFunc("/compare", compareHandler)
+
+	// Handle OCSP responder reachability and latency checks
+	http.HandleFunc("/ocsp", ocspHandler)
+
+	// Handle key rotation tracking by SPKI hash
+	http.HandleFunc("/keys", keysHandler)
+
+	// Handle post-quantum readiness reports
+	http.HandleFunc("/pqc", pqcHandler)
+
+	// Handle the status widget other sites embed in an iframe
+	http.HandleFunc("/embed/{domain}", embedHandler)
+
+	// Handle standalone assessment reports
+	http.HandleFunc("/report", reportHandler)
+
+	// Handle ZIP bundles of a search's certificates
+	http.HandleFunc("/bundle", bundleHandler)
+
+	// Handle watchlist summary previews
+	http.HandleFunc("/summary", summaryHandler)
+
+	// Handle keyword searches across all domains
+	http.HandleFunc("/keyword", keywordHandler)
+
+	// Handle S/MIME certificate searches by email address
+	http.HandleFunc("/smime", smimeHandler)
+
+	// Handle lookalike domain sweeps
+	http.HandleFunc("/lookalikes", lookalikesHandler)
+
+	// Handle personal dashboards of saved searches and watched domains
+	http.HandleFunc("/dashboard", dashboardHandler)
+
+	// Handle each user's alert notification preferences
+	http.HandleFunc("/notifications", notificationsHandler)
+
+	// Handle team workspaces
+	http.HandleFunc("/teams", teamsHandler)
+	http.HandleFunc("/team", teamHandler)
+
+	// Handle theme changes, saved in a cookie
+	http.HandleFunc("/theme", themeHandler)
+
+	// Handle timezone changes, saved in a cookie
+	http.HandleFunc("/timezone", timezoneHandler)
+
+	// Serve logos and stylesheets for the branding partials from the templates directory
+	if *templatesDir != "" {
+		http.Handle("/static/", staticHandler(*templatesDir))
+	}
+
+	// Handle the audit log for admins
+	http.HandleFunc("/audit", auditHandler)
+
+	// Handle health checks from load balancers and monitoring
+	http.HandleFunc("/healthz", healthHandler)
+
+	// Handle Prometheus scrapes of the watched domains' certificate expiry
+	http.HandleFunc("/metrics", metricsHandler)
+
+	// Handle Nagios/Icinga checks of a domain's certificate expiry and issuers
+	http.HandleFunc("/api/v1/nagios", apiNagiosHandler)
+
+	// Handle Zabbix low-level discovery of watched domains and their item values
+	http.HandleFunc("/api/v1/zabbix/discovery", apiZabbixDiscoveryHandler)
+	http.HandleFunc("/api/v1/zabbix/items", apiZabbixItemsHandler)
+
+	// Handle Grafana JSON datasource requests over the watched domains' last results
+	http.HandleFunc("/api/v1/grafana", apiGrafanaHandler)
+	http.HandleFunc("/api/v1/grafana/{endpoint}", apiGrafanaHandler)
+
+	// Handle JSON API requests
+	http.HandleFunc("/api/v1/search", apiSearchHandler)
+	http.HandleFunc("/api/v1/stats", apiStatsHandler)
+	http.HandleFunc("/api/v1/estimate", apiEstimateHandler)
+	http.HandleFunc("/api/v1/timeline", apiTimelineHandler)
+	http.HandleFunc("/api/v1/inventory", apiInventoryHandler)
+	http.HandleFunc("/api/v1/cooccurrence", apiCoOccurrenceHandler)
+	http.HandleFunc("/api/v1/renewals", apiRenewalsHandler)
+	http.HandleFunc("/api/v1/letsencrypt", apiLetsEncryptHandler)
+	http.HandleFunc("/api/v1/dns", apiDNSHandler)
+	http.HandleFunc("/api/v1/dane", apiDANEHandler)
+	http.HandleFunc("/api/v1/cert/{id}", apiCertHandler)
+	http.HandleFunc("/api/v1/tlsa", apiTLSAHandler)
+	http.HandleFunc("/api/v1/mta-sts", apiMTASTSHandler)
+	http.HandleFunc("/api/v1/ocsp", apiOCSPHandler)
+	http.HandleFunc("/api/v1/keys", apiKeysHandler)
+	http.HandleFunc("/api/v1/pqc", apiPQCHandler)
+	http.HandleFunc("/api/v1/embed/{domain}", apiEmbedHandler)
+	http.HandleFunc("/api/v1/report", apiReportHandler)
+	http.HandleFunc("/api/v1/lookalikes", apiLookalikesHandler)
+	http.HandleFunc("/api/v1/keyword", apiKeywordHandler)
+	http.HandleFunc("/api/v1/smime", apiSMIMEHandler)
+	http.HandleFunc("/api/v1/watchlist", apiWatchlistHandler)
+	http.HandleFunc("/api/v1/saved-searches", apiSavedSearchesHandler)
+	http.HandleFunc("/api/v1/teams", apiTeamsHandler)
+	http.HandleFunc("/api/v1/notifications", apiNotificationsHandler)
+	http.HandleFunc("/api/v1/notifications/deliveries", apiWebhookDeliveriesHandler)
+	http.HandleFunc("/api/v1/notifications/deliveries/{id}/redeliver", apiRedeliverWebhookHandler)
+	http.HandleFunc("/api/v1/import", apiImportHandler)
+	http.HandleFunc("/api/v1/jobs", apiJobsHandler)
+	http.HandleFunc("/api/v1/jobs/{id}", apiJobHandler)
+	http.HandleFunc("/api/v1/jobs/{id}/results", apiJobResultsHandler)
+	http.HandleFunc("/api/v1/zone", apiZoneHandler)
+	http.HandleFunc("/api/v1/csr", apiCSRHandler)
+	http.HandleFunc("/api/v1/decode", apiDecodeHandler)
+	http.HandleFunc("/api/v1/compare", apiCompareHandler)
+	http.HandleFunc("/api/v1/chain", apiChainHandler)
+	http.HandleFunc("/api/v1/keymatch", apiKeyMatchHandler)
+	http.HandleFunc("/api/v1/keystore", apiKeystoreHandler)
+	http.HandleFunc("/api/v1/alerts", apiAlertsHandler)
+	http.HandleFunc("/api/v1/alerts/{id}/ack", apiAcknowledgeAlertHandler)
+	http.HandleFunc("/api/v1/audit", apiAuditHandler)
+	http.HandleFunc("/api/v1/audit/export", apiAuditExportHandler)
+	http.HandleFunc("/api/v1/export", apiCertificateExportHandler)
+	http.HandleFunc("/api/v1/admin/reload", apiReloadHandler)
+
+	// Let API clients skip downloading answers they already have; inside the login and audit, so a 304 still needs both
+	handler := conditionalAPI(http.DefaultServeMux)
+	// Record searches in the audit log, and require a login for everything when accounts are enabled
+	handler = auditSearches(handler)
+	if *usersPath != "" || *oidcIssuer != "" || *proxyUserHeader != "" {
+		// Without -users, single sign-on and proxy accounts are only kept in memory
+		users, err = services.LoadUserStore(*usersPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		timeouts := services.SessionTimeouts{Idle: *sessionIdle, Absolute: *sessionTTL}
+		if *sessionRedis != "" {
+			sessions, err = services.NewRedisSessionStore(*sessionRedis, timeouts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			sessions = services.NewMemorySessionStore(timeouts)
+		}
+
+		if *oidcIssuer != "" {
+			oidcProvider, err = services.NewOIDCProvider(context.Background(), services.OIDCConfig{
+				Issuer:        *oidcIssuer,
+				ClientID:      *oidcClientID,
+				ClientSecret:  settings.OIDCClientSecret,
+				RedirectURL:   *oidcRedirectURL,
+				UsernameClaim: *oidcUsernameClaim,
+				GroupsClaim:   *oidcGroupsClaim,
+				AdminGroups:   splitList(*oidcAdminGroups),
+				AllowedGroups: splitList(*oidcAllowedGroups),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			http.HandleFunc("/login/oidc", oidcLoginHandler)
+			http.HandleFunc("/login/oidc/callback", oidcCallbackHandler)
+		}
+
+		if *proxyUserHeader != "" {
+			proxyAuth, err = services.NewProxyAuth(services.ProxyAuthConfig{
+				UserHeader:     *proxyUserHeader,
+				GroupsHeader:   *proxyGroupsHeader,
+				AdminGroups:    splitList(*proxyAdminGroups),
+				TrustedProxies: splitList(*trustedProxies),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			proxyLogoutURL = *proxyLogout
+		}
+
+		http.HandleFunc("/login", loginHandler)
+		http.HandleFunc("/setup", setupHandler)
+		http.HandleFunc("/logout", logoutHandler)
+		http.HandleFunc("/account", accountHandler)
+		http.HandleFunc("/users", usersHandler)
+		handler = requireLogin(handler)
+	}
+
+	// Keep a language picked with ?lang= for the following pages, logged in or not
+	handler = rememberLanguage(handler)
+	// Outermost but for compression, so a panic anywhere gets a friendly answer
+	handler = recoverPanics(handler)
+	// Compress whatever is sent, the panic page included
+	handler = compressResponses(handler)
+
+	// Serve on the sockets systemd passed us, or else on TCP and/or a Unix socket
+	listeners, err := systemdListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, listener := range listeners {
+		fmt.Printf("Server listening on %s://%s from systemd\n", listener.Addr().Network(), listener.Addr())
+	}
+	if len(listeners) == 0 {
+		if *listenAddr == "" && *socketPath == "" {
+			log.Fatal("nothing to listen on: set -addr or -socket")
+		}
+		if *socketPath != "" {
+			listener, err := listenUnix(*socketPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("Server listening on unix://" + *socketPath)
+			listeners = append(listeners, listener)
+		}
+		if *listenAddr != "" {
+			listener, err := net.Listen("tcp", *listenAddr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			display := *listenAddr
+			if strings.HasPrefix(display, ":") {
+				display = "localhost" + display
+			}
+			fmt.Println("Server starting on http://" + display)
+			listeners = append(listeners, listener)
+		}
+	}
+
+	// Run until any listener fails, telling systemd once we're accepting connections
+	served := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) { served <- http.Serve(listener, handler) }(listener)
+	}
+	notifySystemd("READY=1")
+	go runSystemdWatchdog()
+	log.Fatal(<-served)
+}
+
+// listenUnix listens on a Unix socket only the server's user and group can use
+// A socket left behind by a previous run is replaced
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return listener, nil
 }
 
 // homeHandler serves the homepage
@@ -28,63 +587,480 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/index.html")
+	tmpl, err := parseTemplate("index.html")
 	if err != nil {
-		http.Error(w, "Could not load page", http.StatusInternalServerError)
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
 		return
 	}
 
-	tmpl.Execute(w, nil)
+	data := IndexData{Languages: services.Languages, Timezones: services.CommonTimezones}
+	if user, ok := currentUser(r); ok {
+		data.User = user.Username
+		data.Admin = user.Admin
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// IndexData holds data to pass to the homepage template
+type IndexData struct {
+	User      string // Logged-in username, empty when accounts are disabled
+	Admin     bool
+	Languages []services.Language // For the language picker
+	Timezones []string            // Suggestions for the timezone picker
 }
 
 // SearchData holds data to pass to the results template
 type SearchData struct {
-	Domain     string
-	NotBefore  string
-	Issuers    []services.IssuerGroup
-	TotalCerts int
-	Error      string
+	Domain        string                   `json:"domain"`                  // Punycode form used for queries
+	UnicodeDomain string                   `json:"unicodeDomain,omitempty"` // Display form, for internationalized domains
+	NotBefore     string                   `json:"notBefore,omitempty"`
+	SAN           string                   `json:"san,omitempty"`
+	SANRegex      bool                     `json:"sanRegex,omitempty"`
+	Purpose       string                   `json:"purpose,omitempty"` // "tls" or "non-tls" when filtered by purpose
+	Issuer        string                   `json:"issuer,omitempty"`  // Issuer slug when showing one issuer's certificates
+	IssuerDisplay string                   `json:"-"`                 // That issuer's display name, when it has certificates
+	Sort          string                   `json:"sort"`
+	Issuers       []services.IssuerGroup   `json:"issuers"`
+	TotalCerts    int                      `json:"totalCerts"`
+	Error         string                   `json:"error,omitempty"`
+	Invalid       *services.DomainError    `json:"invalid,omitempty"`     // Which input was rejected and why, when the error is a bad domain name
+	RetryAfter    int                      `json:"retryAfter,omitempty"`  // Seconds until crt.sh takes requests again, when it asked us to wait
+	Skipped       int                      `json:"skipped,omitempty"`     // Malformed rows in crt.sh's answer, left out of the results
+	Truncated     bool                     `json:"truncated,omitempty"`   // crt.sh's answer stopped at its row limit, so certificates are likely missing
+	FillCurrent   bool                     `json:"-"`                     // Fetch the current certificates separately when the answer was truncated
+	Filled        bool                     `json:"filled,omitempty"`      // The current certificates were fetched separately
+	Added         int                      `json:"added,omitempty"`       // How many of them the answer was missing
+	FillError     string                   `json:"fillError,omitempty"`   // Why they couldn't be
+	AutoFill      bool                     `json:"autoFill,omitempty"`    // FillCurrent was chosen because the last search was truncated
+	Estimate      *services.ResultEstimate `json:"estimate,omitempty"`    // The last search's size, when the domain is large
+	StaleSince    *time.Time               `json:"staleSince,omitempty"`  // When the results were found, when crt.sh failed and older results are shown
+	StaleReason   string                   `json:"staleReason,omitempty"` // Why crt.sh couldn't be searched, when older results are shown
+
+	// Analytics shown on the results page and served by /api/v1/stats
+	Stats     services.IssuerDistribution `json:"-"`
+	Lifetimes services.LifetimeHistogram  `json:"-"`
+	Shared    services.CoOccurrenceReport `json:"-"`
+
+	// Names on the certificates that could be mistaken for others
+	Confusables []services.ConfusableName `json:"confusables,omitempty"`
+
+	// Issuers that browsers no longer trust
+	Distrusted          []services.IssuerTrust     `json:"distrusted,omitempty"`
+	IssuedAfterDistrust []services.DistrustAnomaly `json:"issuedAfterDistrust,omitempty"` // Certificates those issuers issued after their cutoff
+
+	// Certificates naming private addresses or hosts
+	InternalNames []services.InternalNameCertificate `json:"internalNames,omitempty"`
+
+	// Certificates whose serial numbers look too short, patterned or sequential to be random, or appear
+	// under another issuer
+	WeakSerials      []services.SerialIssue     `json:"weakSerials,omitempty"`
+	DuplicateSerials []services.DuplicateSerial `json:"duplicateSerials,omitempty"` // Serials under more than one issuer
+
+	// The results page shows one page of Issuers at a time; the API returns them all
+	Page         services.Page  `json:"-"`
+	IssuerTotals map[string]int `json:"-"` // Certificates per issuer across every page
+	ShareURL     string         `json:"-"` // Absolute link to exactly this view, to send to teammates
+
+	groups []services.CertificateGroup // Ungrouped-by-issuer results for the API
+	status int                         // HTTP status for API responses
 }
 
+// resultsPageSize is how many certificates the results page shows at once
+const resultsPageSize = 50
+
 // searchHandler handles certificate lookups
 func searchHandler(w http.ResponseWriter, r *http.Request) {
-	// Get the domain and date filter from the query string
-	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
-	notBefore := strings.TrimSpace(r.URL.Query().Get("notBefore"))
+	// Check the page before fetching so a bad one fails fast
+	var data SearchData
+	if page, err := services.ParsePage(r.URL.Query().Get("page")); err != nil {
+		data = SearchData{Domain: strings.TrimSpace(r.URL.Query().Get("domain")), Error: err.Error()}
+	} else {
+		// A slow search is answered with a progress page, which comes back for the results
+		var answered bool
+		if data, answered = searchWithProgress(w, r); answered {
+			return
+		}
+		if data.Error == "" {
+			paginateResults(&data, page)
+			data.ShareURL = absoluteURL(r, string(data.CanonicalURL()))
+		}
+	}
+
+	// Parse and execute the results template
+	tmpl, err := parseTemplate("results.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	executeStreamed(w, r, tmpl, data)
+}
+
+// issuerHandler shows the results page for one issuer's certificates, with the filters in the query string
+func issuerHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// The domain is in the path, where the audit middleware doesn't look
+	if query.Get("domain") == "" {
+		auditAction(r, "search", r.PathValue("domain"), r.URL.Path+"?"+r.URL.RawQuery)
+	}
+	query.Set("domain", r.PathValue("domain"))
+	query.Set("issuer", r.PathValue("slug"))
+	r.URL.RawQuery = query.Encode()
+	searchHandler(w, r)
+}
+
+// paginateResults cuts the issuer sections down to one page of certificates
+func paginateResults(data *SearchData, page int) {
+	data.IssuerTotals = make(map[string]int, len(data.Issuers))
+	for _, issuer := range data.Issuers {
+		data.IssuerTotals[issuer.IssuerName] = len(issuer.Certificates)
+	}
+	data.Issuers, data.Page = services.PaginateIssuers(data.Issuers, page, resultsPageSize)
+}
+
+// query is the canonical query string for the search and its filters, without the page or issuer
+func (d SearchData) query() url.Values {
+	query := services.SearchQuery(d.Domain, d.NotBefore, d.SAN, d.SANRegex, d.Purpose, d.Sort)
+	if d.FillCurrent {
+		query.Set("fill", fillCurrent)
+	}
+	return query
+}
+
+// fillCurrent is the ?fill= value that fetches current certificates separately when crt.sh's answer is truncated
+const fillCurrent = "current"
+
+// ResultLimit is how many rows crt.sh sends at most, for the truncation warning
+func (d SearchData) ResultLimit() int {
+	return services.ResultLimit
+}
+
+// SerialIssue returns what looks wrong with a certificate's serial number, for its badge, or nil
+func (d SearchData) SerialIssue(group services.CertificateGroup) *services.SerialIssue {
+	for i, issue := range d.WeakSerials {
+		if issue.IssuerName == group.IssuerName && issue.SerialNumber == group.SerialNumber {
+			return &d.WeakSerials[i]
+		}
+	}
+	return nil
+}
+
+// DuplicateSerial returns the other issuers' certificates with a certificate's serial number, or nil
+func (d SearchData) DuplicateSerial(group services.CertificateGroup) *services.DuplicateSerial {
+	for i, duplicate := range d.DuplicateSerials {
+		for _, holder := range duplicate.Certificates {
+			if holder.IssuerName == group.IssuerName && duplicate.SerialNumber == group.SerialNumber {
+				return &d.DuplicateSerials[i]
+			}
+		}
+	}
+	return nil
+}
+
+// FillURL shows these results with the current certificates fetched separately
+func (d SearchData) FillURL() template.URL {
+	d.FillCurrent = true
+	return d.PageURL(d.Page.Number)
+}
+
+// PageURL links to a page of these results; the first page has no page parameter
+// On an issuer's page the domain and issuer are in the path, so the query holds just the filters
+func (d SearchData) PageURL(page int) template.URL {
+	query := d.query()
+	if d.Issuer != "" {
+		query.Del("domain")
+	}
+	if page > 1 {
+		query.Set("page", fmt.Sprint(page))
+	}
+	return pathWithQuery(d.Path(), query)
+}
+
+// Path is where these results live: /search, or /search/{domain}/issuer/{slug} for one issuer
+func (d SearchData) Path() string {
+	if d.Issuer == "" {
+		return "/search"
+	}
+	return issuerPath(d.Domain, d.Issuer)
+}
+
+// CanonicalURL is the bookmarkable link to the page being shown
+func (d SearchData) CanonicalURL() template.URL {
+	return d.PageURL(d.Page.Number)
+}
 
-	// Prepare data for the template
+// IssuerURL links to one issuer's page with the same filters
+func (d SearchData) IssuerURL(slug string) template.URL {
+	query := d.query()
+	query.Del("domain")
+	return pathWithQuery(issuerPath(d.Domain, slug), query)
+}
+
+// AllIssuersURL links from an issuer's page back to the whole search
+func (d SearchData) AllIssuersURL() template.URL {
+	return pathWithQuery("/search", d.query())
+}
+
+// BundleURL downloads every certificate in these results, on all pages
+func (d SearchData) BundleURL() template.URL {
+	query := d.query()
+	if d.Issuer != "" {
+		query.Set("issuer", d.Issuer)
+	}
+	return pathWithQuery("/bundle", query)
+}
+
+// PrintURL shows these results, on all pages, laid out for printing
+func (d SearchData) PrintURL() template.URL {
+	query := d.query()
+	if d.Issuer != "" {
+		query.Set("issuer", d.Issuer)
+	}
+	return pathWithQuery("/search/print", query)
+}
+
+// issuerPath is the drill-down page for one issuer's certificates for a domain
+func issuerPath(domain, slug string) string {
+	return "/search/" + url.PathEscape(domain) + "/issuer/" + url.PathEscape(slug)
+}
+
+// absoluteURL turns a path into a full link, starting with -public-url or else the host the request came to
+func absoluteURL(r *http.Request, path string) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/") + path
+	}
+	scheme := "http"
+	if r.TLS != nil || secureCookies {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// pathWithQuery joins a path and query string for a template link
+func pathWithQuery(path string, query url.Values) template.URL {
+	if len(query) == 0 {
+		return template.URL(path)
+	}
+	return template.URL(path + "?" + query.Encode())
+}
+
+// InventoryData holds data to pass to the inventory template
+type InventoryData struct {
+	SearchData
+	Inventory services.SubdomainInventory
+	Renewals  map[string]services.HostRenewals // Keyed by hostname
+}
+
+// inventoryHandler lists every hostname seen in CT for a domain
+func inventoryHandler(w http.ResponseWriter, r *http.Request) {
+	data := InventoryData{SearchData: runSearch(r.Context(), r.URL.Query())}
+	data.Inventory = services.BuildSubdomainInventory(data.Domain, data.groups, time.Now())
+
+	// Index the renewal history by hostname for the table
+	data.Renewals = make(map[string]services.HostRenewals)
+	for _, host := range services.AnalyzeRenewals(data.Domain, data.groups).Hosts {
+		data.Renewals[host.Name] = host
+	}
+
+	tmpl, err := parseTemplate("inventory.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	executeStreamed(w, r, tmpl, data)
+}
+
+// statusClientClosedRequest is nginx's status for a request the client abandoned before the answer was ready
+const statusClientClosedRequest = 499
+
+// serviceError picks the status and message for an error from a service call, logging the underlying
+// detail; the message is one users can act on and the templates can translate
+func serviceError(err error) (int, string) {
+	var domainErr *services.DomainError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The visitor gave up waiting, so nobody sees this; it only shows in access logs
+		return statusClientClosedRequest, "The search was cancelled"
+	case errors.As(err, &domainErr):
+		// Say which rule the name broke
+		return http.StatusBadRequest, domainErr.Reason
+	case errors.Is(err, services.ErrSourceDown):
+		// The failures that opened the breaker were logged; the requests it turns away aren't
+		return http.StatusServiceUnavailable, "crt.sh has been failing, so we're giving it a short rest, please try again shortly"
+	case errors.Is(err, services.ErrBudgetExhausted):
+		// Nothing went wrong upstream; we're holding back so crt.sh doesn't block us
+		return http.StatusServiceUnavailable, "We're already sending crt.sh as many requests as it allows, please try again shortly"
+	case errors.Is(err, services.ErrInvalidDomain):
+		return http.StatusBadRequest, "That isn't a valid domain name, try one like example.com"
+	case errors.Is(err, services.ErrInvalidSerial):
+		return http.StatusBadRequest, "That isn't a valid serial number, use hex digits"
+	case errors.Is(err, services.ErrCertificateNotFound):
+		return http.StatusNotFound, "crt.sh has no such certificate"
+	case errors.Is(err, services.ErrNoResults):
+		return http.StatusNotFound, "No certificates found"
+	case errors.Is(err, services.ErrNotSearched):
+		return http.StatusNotFound, "This domain hasn't been searched yet; search it or add it to the watchlist first"
+	}
+
+	log.Printf("upstream: %v", err)
+	switch {
+	case errors.Is(err, services.ErrRateLimited):
+		return http.StatusServiceUnavailable, "crt.sh is rate limiting us, please try again shortly"
+	case errors.Is(err, services.ErrUpstreamMaintenance):
+		return http.StatusServiceUnavailable, "crt.sh is down for maintenance or overloaded, please try again shortly"
+	case errors.Is(err, services.ErrUpstreamTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "crt.sh took too long to answer; large domains can time out, so try again or narrow the search"
+	default:
+		return http.StatusBadGateway, "Could not get certificates from crt.sh, please try again later"
+	}
+}
+
+// runSearch fetches, filters and groups certificates for the given query string
+// It is shared by the HTML results page and the JSON API; cancelling ctx abandons the crt.sh query
+func runSearch(ctx context.Context, query url.Values) SearchData {
+	// Get the domain and filters from the query string
 	data := SearchData{
-		Domain:    domain,
-		NotBefore: notBefore,
+		Domain:      strings.TrimSpace(query.Get("domain")),
+		NotBefore:   strings.TrimSpace(query.Get("notBefore")),
+		SAN:         strings.TrimSpace(query.Get("san")),
+		SANRegex:    query.Get("sanRegex") != "",
+		Purpose:     strings.TrimSpace(query.Get("purpose")),
+		Issuer:      strings.TrimSpace(query.Get("issuer")),
+		FillCurrent: query.Get("fill") == fillCurrent,
+		status:      http.StatusOK,
 	}
 
 	// Validate domain
-	if domain == "" {
+	if data.Domain == "" {
 		data.Error = "Please enter a domain name"
-	} else {
-		// Fetch certificates
-		certs, err := services.FetchCertificates(domain)
+		data.status = http.StatusBadRequest
+		return data
+	}
+
+	// Internationalized domains are queried in punycode
+	ascii, err := services.NormalizeDomain(data.Domain)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		errors.As(err, &data.Invalid)
+		return data
+	}
+	data.Domain = ascii
+	if unicodeName := services.ToUnicode(ascii); unicodeName != ascii {
+		data.UnicodeDomain = unicodeName
+	}
+
+	// Parse the sort order
+	order, err := services.ParseSortOrder(strings.TrimSpace(query.Get("sort")))
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Sort = order.String()
+
+	if err := services.CheckPurposeFilter(data.Purpose); err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+
+	// Compile the SAN filter before fetching so a bad pattern fails fast
+	var sanFilter *regexp.Regexp
+	if data.SAN != "" {
+		sanFilter, err = services.CompileSANFilter(data.SAN, data.SANRegex)
 		if err != nil {
 			data.Error = err.Error()
-		} else {
-			// Filter by date if provided
-			if notBefore != "" {
-				certs = services.FilterByNotBefore(certs, notBefore)
-			}
-			// Group certificates by serial number
-			groups := services.GroupCertificates(certs)
-			// Then group by issuer
-			data.Issuers = services.GroupByIssuer(groups)
-			data.TotalCerts = len(groups)
+			data.status = http.StatusBadRequest
+			return data
 		}
 	}
 
-	// Parse and execute the results template
-	tmpl, err := template.ParseFiles("templates/results.html")
+	// Give up on crt.sh after the timeout asked for, which can't be longer than the server allows
+	timeout, err := searchTimeout(query.Get("timeout"))
 	if err != nil {
-		http.Error(w, "Could not load page", http.StatusInternalServerError)
-		return
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	ctx, cancel := services.WithSearchTimeout(ctx, timeout, timeout < maxSearchTimeout)
+	defer cancel()
+
+	// A domain whose last answer was cut short will be again, so fetch its current certificates
+	// separately without waiting to be asked
+	if estimate, ok := services.EstimateResults(data.Domain); ok && estimate.Large {
+		data.Estimate = &estimate
+		if estimate.Truncated && !data.FillCurrent {
+			data.FillCurrent, data.AutoFill = true, true
+		}
+	}
+
+	// Fetch certificates
+	certs, info, err := services.SearchCertificates(ctx, data.Domain)
+	var stale *services.StaleResultsError
+	switch {
+	case errors.As(err, &stale):
+		// Old results beat none while crt.sh is down
+		_, data.StaleReason = serviceError(stale.Err)
+		data.StaleSince = &stale.FetchedAt
+	case err != nil:
+		data.status, data.Error = serviceError(err)
+		data.RetryAfter = int(services.RetryAfter(err).Seconds())
+		return data
+	}
+	data.Skipped = info.Skipped
+	data.Truncated = info.Truncated
+
+	// crt.sh stopped at its row limit; fetch the current certificates on their own if asked
+	if data.Truncated && data.FillCurrent {
+		if certs, data.Added, err = services.FillCurrentCertificates(ctx, data.Domain, certs); err != nil {
+			_, data.FillError = serviceError(err)
+		} else {
+			data.Filled = true
+		}
+	}
+
+	// Filter by date if provided
+	if data.NotBefore != "" {
+		certs = services.FilterByNotBefore(certs, data.NotBefore)
+	}
+	// Filter by SAN if provided
+	if sanFilter != nil {
+		certs = services.FilterBySAN(certs, sanFilter)
+	}
+	// Group certificates by serial number
+	groups := services.GroupCertificates(certs)
+	// Use the real purpose of certificates downloaded before, then keep TLS server certificates, or everything else, if asked
+	services.VerifyPurposes(groups)
+	groups = services.FilterByPurpose(groups, data.Purpose)
+	// Keep one issuer's certificates for its drill-down page
+	if data.Issuer != "" {
+		groups = services.FilterByIssuerSlug(groups, data.Issuer)
+	}
+	// Note which certificates also cover other domains
+	services.AnnotateSharedNames(data.Domain, groups)
+	data.groups = groups
+	// Then group by issuer
+	data.Issuers = services.GroupByIssuer(groups, order)
+	if data.Issuer != "" && len(data.Issuers) > 0 {
+		data.IssuerDisplay = data.Issuers[0].DisplayName
+	}
+	data.TotalCerts = len(groups)
+	// Summarize the issuers for the analytics section
+	data.Stats = services.IssuerDistributionStats(groups, time.Now())
+	data.Lifetimes = services.LifetimeHistogramStats(groups)
+	data.Shared = services.CoOccurringDomains(data.Domain, groups)
+	names := make([]string, 0)
+	for _, group := range groups {
+		names = append(names, services.GroupNames(group)...)
 	}
+	data.Confusables = services.FindConfusables(names)
+	data.Distrusted = services.DistrustedIssuers(groups, time.Now())
+	data.IssuedAfterDistrust = services.DistrustAnomalies(groups, time.Now())
+	data.InternalNames = services.FindInternalNames(groups, time.Now())
+	data.WeakSerials = services.CheckSerialEntropy(groups)
+	data.DuplicateSerials = services.FindDuplicateSerials(groups)
 
-	tmpl.Execute(w, data)
+	return data
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// metricLabel escapes a label value for the Prometheus text format
+var metricLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler exposes the watched domains' certificate expiry to Prometheus in its text format, from
+// each domain's last check, so existing alerting rules can page on it
+// Domains not checked since the server started, and not in the disk cache, are left out until they are
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	domains := make([]string, 0)
+	for _, watched := range watchlist.ListFor(currentUsername(r)) {
+		domains = append(domains, watched.Domain)
+	}
+	metrics := services.MetricsFor(domains)
+	now := time.Now()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	// Domains with no valid certificate have no expiry to report
+	writeMetricFamily(out, "cert_not_after_timestamp_seconds", "gauge", "When the first of the domain's hostnames runs out of valid certificates, as a Unix timestamp")
+	for _, m := range metrics {
+		if !m.NotAfter.IsZero() {
+			writeMetricSample(out, "cert_not_after_timestamp_seconds", m.Domain, m.NotAfter.Unix())
+		}
+	}
+	writeMetricFamily(out, "cert_days_remaining", "gauge", "Whole days until the first of the domain's hostnames runs out of valid certificates")
+	for _, m := range metrics {
+		if !m.NotAfter.IsZero() {
+			writeMetricSample(out, "cert_days_remaining", m.Domain, int64(m.DaysRemaining(now)))
+		}
+	}
+	writeMetricFamily(out, "cert_count_active", "gauge", "Certificates for the domain valid at its last check")
+	for _, m := range metrics {
+		writeMetricSample(out, "cert_count_active", m.Domain, int64(m.ActiveCertificates))
+	}
+	if services.ExpectingIssuers() {
+		writeMetricFamily(out, "unexpected_issuer_total", "counter", "Certificates ever logged for the domain by an issuer outside -expected-issuers")
+		for _, m := range metrics {
+			writeMetricSample(out, "unexpected_issuer_total", m.Domain, int64(m.UnexpectedIssuer))
+		}
+	}
+	writeMetricFamily(out, "cert_last_check_timestamp_seconds", "gauge", "When the domain was last checked, as a Unix timestamp")
+	for _, m := range metrics {
+		writeMetricSample(out, "cert_last_check_timestamp_seconds", m.Domain, m.CheckedAt.Unix())
+	}
+}
+
+// writeMetricFamily writes a metric's HELP and TYPE lines
+func writeMetricFamily(out *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeMetricSample writes one domain's value of a metric
+func writeMetricSample(out *bufio.Writer, name, domain string, value int64) {
+	fmt.Fprintf(out, "%s{domain=\"%s\"} %d\n", name, metricLabel.Replace(domain), value)
+}
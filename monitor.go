@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// refreshShards is how many slices each refresh interval is cut into; every watched domain is checked
+// in the slice its name hashes to, at a random moment within it
+const refreshShards = 60
+
+// rateLimitAlertQuiet is how long a Let's Encrypt rate limit alert isn't raised again for the same limit,
+// the window Let's Encrypt counts over, unless the limit goes from warning to exceeded
+const rateLimitAlertQuiet = 7 * 24 * time.Hour
+
+// scheduledRefresh is when, into a refresh interval, a watched domain is checked
+type scheduledRefresh struct {
+	domain string
+	offset time.Duration
+}
+
+// runMonitor checks every watched domain once per interval, spread evenly across it so a large
+// watchlist doesn't reach crt.sh all at once, with at most concurrency checks running at a time
+func runMonitor(watchlist *services.Watchlist, interval time.Duration, concurrency int) {
+	slots := make(chan struct{}, max(concurrency, 1))
+	for {
+		start := time.Now()
+		domains := make([]string, 0)
+		for _, watched := range watchlist.List() {
+			domains = append(domains, watched.Domain)
+		}
+
+		for _, refresh := range refreshSchedule(domains, interval) {
+			time.Sleep(time.Until(start.Add(refresh.offset)))
+			// A slow crt.sh holds the schedule back rather than piling up checks
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				checkWatchedDomain(watchlist, refresh.domain)
+			}()
+		}
+		time.Sleep(time.Until(start.Add(interval)))
+	}
+}
+
+// refreshSchedule spreads domains over an interval, in order of when each is due: the shard a domain
+// hashes to stays the same from one interval to the next, and jitter within it keeps a shard's domains apart
+func refreshSchedule(domains []string, interval time.Duration) []scheduledRefresh {
+	width := interval / refreshShards
+	schedule := make([]scheduledRefresh, 0, len(domains))
+	for _, domain := range domains {
+		hash := fnv.New32a()
+		hash.Write([]byte(domain))
+		shard := time.Duration(hash.Sum32() % refreshShards)
+		offset := shard * width
+		if width > 0 {
+			offset += rand.N(width)
+		}
+		schedule = append(schedule, scheduledRefresh{domain: domain, offset: offset})
+	}
+	sort.Slice(schedule, func(i, j int) bool {
+		return schedule[i].offset < schedule[j].offset
+	})
+	return schedule
+}
+
+// checkWatchedDomain fetches the latest certificates for a watched domain and records any alerts
+func checkWatchedDomain(watchlist *services.Watchlist, domain string) {
+	certs, err := services.FetchCertificates(context.Background(), domain)
+	if err != nil {
+		log.Printf("monitor: %s: %v", domain, err)
+		auditSystemAction("monitor.failed", domain, err.Error())
+		return
+	}
+
+	now := time.Now()
+	groups := services.GroupCertificates(certs)
+	inventory := services.BuildSubdomainInventory(domain, groups, now)
+	services.RecordDomainMetrics(domain, groups, now)
+
+	fresh, err := watchlist.RecordCertificates(domain, groups)
+	if err != nil {
+		log.Printf("monitor: %s: %v", domain, err)
+		auditSystemAction("monitor.failed", domain, err.Error())
+		return
+	}
+	alerts, err := watchlist.RecordInventory(domain, inventory, now)
+	if err != nil {
+		log.Printf("monitor: %s: %v", domain, err)
+		auditSystemAction("monitor.failed", domain, err.Error())
+		return
+	}
+	limits, err := watchlist.RaiseAlerts(services.RateLimitAlerts(domain, services.EstimateLetsEncryptUsage(domain, groups, now), now), rateLimitAlertQuiet)
+	if err != nil {
+		log.Printf("monitor: %s: %v", domain, err)
+		auditSystemAction("monitor.failed", domain, err.Error())
+		return
+	}
+	alerts = append(alerts, limits...)
+	auditSystemAction("monitor.check", domain, fmt.Sprintf("%d certificates (%d new), %d new alerts", len(groups), len(fresh), len(alerts)))
+	for _, alert := range alerts {
+		log.Printf("alert: [%s] %s: %s", alert.Type, alert.Domain, alert.Message)
+	}
+	notifyAlerts(domain, alerts)
+	publishEvents(append(services.CertificateEvents(domain, fresh, now), services.AlertEvents(alerts)...))
+	openJiraIssues(domain, groups, now)
+	syncRepoIssues(domain, groups, now)
+}
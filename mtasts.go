@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// MTASTSData holds data to pass to the MTA-STS template
+type MTASTSData struct {
+	Domain  string
+	Report  services.MailTransportReport
+	Error   string
+	Invalid *services.DomainError
+	status  int // HTTP status for API responses
+}
+
+// mtastsHandler shows the domain's MTA-STS policy, TLS-RPT record and MX certificates
+func mtastsHandler(w http.ResponseWriter, r *http.Request) {
+	data := runMTASTSCheck(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("mtasts.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiMTASTSHandler returns the email transport security report as JSON
+func apiMTASTSHandler(w http.ResponseWriter, r *http.Request) {
+	data := runMTASTSCheck(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runMTASTSCheck checks the email transport security of the domain parameter
+func runMTASTSCheck(ctx context.Context, query url.Values) MTASTSData {
+	data := MTASTSData{
+		Domain: services.BaseDomain(strings.TrimSpace(query.Get("domain"))),
+		status: http.StatusOK,
+	}
+	if data.Domain == "" {
+		data.Error = "Please enter a domain"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	ascii, err := services.NormalizeDomain(data.Domain)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		errors.As(err, &data.Invalid)
+		return data
+	}
+	data.Domain = ascii
+
+	data.Report = services.CheckMailTransport(ctx, resolver, data.Domain)
+	return data
+}
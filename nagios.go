@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// Default Nagios thresholds, in days
+const (
+	nagiosWarningDays  = 21
+	nagiosCriticalDays = 7
+)
+
+// apiNagiosHandler checks a domain for Nagios or Icinga, e.g. through check_http or a wrapper script,
+// answering in plugin output: status and summary, performance data and long output
+// ?warning= and ?critical= are days (default 21 and 7), ?issuers= the comma-separated expected issuers;
+// the state is also in X-Nagios-State as the plugin's exit status, and the answer is 200 whatever it is
+func apiNagiosHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	domain := strings.TrimSpace(query.Get("domain"))
+	result := nagiosCheck(r, domain, query)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Nagios-State", strconv.Itoa(result.State))
+	fmt.Fprint(w, result.Output())
+}
+
+// nagiosCheck searches crt.sh for domain and checks it against the query's thresholds
+// Results from the last search stand in while crt.sh is failing, with a note saying so
+func nagiosCheck(r *http.Request, domain string, query url.Values) services.NagiosResult {
+	warning, err := nagiosDays(query, "warning", nagiosWarningDays)
+	if err != nil {
+		return services.NagiosFailure(domain, err)
+	}
+	critical, err := nagiosDays(query, "critical", nagiosCriticalDays)
+	if err != nil {
+		return services.NagiosFailure(domain, err)
+	}
+	thresholds := services.NagiosThresholds{Warning: warning, Critical: critical, Issuers: splitList(query.Get("issuers"))}
+
+	ascii, err := services.NormalizeDomain(domain)
+	if err != nil {
+		return services.NagiosFailure(domain, err)
+	}
+	certs, _, err := services.SearchCertificates(r.Context(), ascii)
+	var stale *services.StaleResultsError
+	if err != nil && !errors.As(err, &stale) {
+		_, message := serviceError(err)
+		return services.NagiosFailure(ascii, errors.New(message))
+	}
+
+	result := services.CheckNagios(ascii, services.GroupCertificates(certs), thresholds, time.Now())
+	if stale != nil {
+		result.Summary += fmt.Sprintf(" (results from %s, crt.sh can't be searched now)", stale.FetchedAt.Format(time.RFC3339))
+	}
+	return result
+}
+
+// nagiosDays reads a threshold in whole days from the query, or fallback when it isn't given
+func nagiosDays(query url.Values, name string, fallback int) (time.Duration, error) {
+	days := fallback
+	if value := strings.TrimSpace(query.Get(name)); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, fmt.Errorf("invalid %s %q, use a whole number of days", name, value)
+		}
+		days = parsed
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// Quiet hours need timezone data even on hosts without it installed
+	_ "time/tzdata"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// notificationPrefs holds how each user wants to hear about alerts
+var notificationPrefs *services.NotificationStore
+
+// webhookClient posts alerts to users' webhooks
+// Users choose where it connects, so it refuses internal addresses as it dials, whatever the name
+// resolved to, and goes direct rather than through a proxy that would dial for it; redirects aren't
+// followed, since they could lead anywhere
+var webhookClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: webhookTransport(),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookTransport is the default transport without a proxy and with internal addresses refused
+func webhookTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: services.WebhookDialControl}
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// heldAlerts are alerts waiting for a user's quiet hours to end, by username
+var heldAlerts = struct {
+	mu     sync.Mutex
+	alerts map[string][]services.Alert
+	until  map[string]time.Time
+}{
+	alerts: make(map[string][]services.Alert),
+	until:  make(map[string]time.Time),
+}
+
+// NotificationsData holds data to pass to the notifications template
+type NotificationsData struct {
+	Prefs      services.NotificationPrefs
+	Types      []AlertTypeOption
+	Mail       bool // Whether the server can send email at all
+	Deliveries []services.Delivery
+	Message    string
+	Error      string
+}
+
+// AlertTypeOption is one alert type checkbox on the notifications page
+type AlertTypeOption struct {
+	Type        string
+	Description string
+	Selected    bool
+}
+
+// AlertNotification is the JSON body POSTed to a user's webhook
+type AlertNotification struct {
+	Username string           `json:"username,omitempty"`
+	Alerts   []services.Alert `json:"alerts"`
+}
+
+// notifyAlerts sends new alerts to every user who watches the domain and wants them
+// Users in quiet hours get them once their quiet hours end
+func notifyAlerts(domain string, alerts []services.Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+	watched, ok := watchlist.Get(domain)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	for _, prefs := range notificationPrefs.List() {
+		if !watchesDomain(prefs.Username, watched) {
+			continue
+		}
+		wanted := make([]services.Alert, 0, len(alerts))
+		for _, alert := range alerts {
+			if prefs.Wants(alert) {
+				wanted = append(wanted, alert)
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+
+		if until := prefs.QuietUntil(now); !until.IsZero() {
+			holdAlerts(prefs.Username, wanted, until)
+			continue
+		}
+		deliverAlerts(prefs, wanted)
+	}
+}
+
+// watchesDomain reports whether username sees the domain, directly or through one of their teams
+// With accounts enabled, preferences saved while they were disabled get nothing
+func watchesDomain(username string, watched services.WatchedDomain) bool {
+	if users != nil && username == "" {
+		return false
+	}
+	if watched.WatchedBy(username) {
+		return true
+	}
+	for _, slug := range watched.Teams {
+		if team, ok := teams.Get(slug); ok && team.Role(username) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// holdAlerts keeps alerts until a user's quiet hours end
+func holdAlerts(username string, alerts []services.Alert, until time.Time) {
+	heldAlerts.mu.Lock()
+	defer heldAlerts.mu.Unlock()
+
+	heldAlerts.alerts[username] = append(heldAlerts.alerts[username], alerts...)
+	heldAlerts.until[username] = until
+}
+
+// runHeldAlerts sends held alerts once each user's quiet hours are over
+func runHeldAlerts() {
+	for {
+		time.Sleep(time.Minute)
+		releaseHeldAlerts(time.Now())
+	}
+}
+
+// releaseHeldAlerts delivers the alerts whose quiet hours have ended by now
+// Preferences are read again so changes made during quiet hours apply
+func releaseHeldAlerts(now time.Time) {
+	heldAlerts.mu.Lock()
+	due := make(map[string][]services.Alert)
+	for username, until := range heldAlerts.until {
+		if now.Before(until) {
+			continue
+		}
+		due[username] = heldAlerts.alerts[username]
+		delete(heldAlerts.alerts, username)
+		delete(heldAlerts.until, username)
+	}
+	heldAlerts.mu.Unlock()
+
+	for username, alerts := range due {
+		prefs := notificationPrefs.Get(username)
+		wanted := make([]services.Alert, 0, len(alerts))
+		for _, alert := range alerts {
+			if prefs.Wants(alert) {
+				wanted = append(wanted, alert)
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+		if until := prefs.QuietUntil(now); !until.IsZero() {
+			holdAlerts(username, wanted, until)
+			continue
+		}
+		deliverAlerts(prefs, wanted)
+	}
+}
+
+// deliverAlerts sends alerts to each of a user's channels, auditing the outcome
+// Webhooks are delivered in the background, with retries, and audited once they're done
+func deliverAlerts(prefs services.NotificationPrefs, alerts []services.Alert) {
+	target := notifyTarget(prefs.Username)
+
+	if prefs.Email != "" {
+		if err := emailAlerts(prefs.Email, alerts); err != nil {
+			log.Printf("notify: %s: %v", target, err)
+			auditSystemAction("notify.failed", target, "email: "+err.Error())
+		} else {
+			auditSystemAction("notify.sent", target, fmt.Sprintf("%d alert(s) by email to %s", len(alerts), prefs.Email))
+		}
+	}
+	if prefs.WebhookURL != "" {
+		payload, err := json.Marshal(AlertNotification{Username: prefs.Username, Alerts: alerts})
+		if err != nil {
+			log.Printf("notify: %s: %v", target, err)
+			auditSystemAction("notify.failed", target, "webhook: "+err.Error())
+		} else {
+			deliverWebhook(prefs, "alerts", payload, fmt.Sprintf("%d alert(s)", len(alerts)))
+		}
+	}
+	if prefs.TeamsWebhookURL != "" {
+		if err := postTeamsAlerts(prefs.TeamsWebhookURL, alerts); err != nil {
+			log.Printf("notify: %s: %v", target, err)
+			auditSystemAction("notify.failed", target, "teams: "+err.Error())
+		} else {
+			auditSystemAction("notify.sent", target, fmt.Sprintf("%d alert(s) to Teams", len(alerts)))
+		}
+	}
+}
+
+// emailAlerts sends alerts to one address using the summary email's SMTP settings
+func emailAlerts(address string, alerts []services.Alert) error {
+	if summaryMail == nil {
+		return fmt.Errorf("email is not configured on this server")
+	}
+
+	tmpl, err := parseTemplate("alert_email.html")
+	if err != nil {
+		return fmt.Errorf("failed to render alert email: %w", err)
+	}
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, alerts); err != nil {
+		return fmt.Errorf("failed to render alert email: %w", err)
+	}
+
+	config := *summaryMail
+	config.To = []string{address}
+	subject := fmt.Sprintf("Certificate alert: %s", alerts[0].Message)
+	if len(alerts) > 1 {
+		subject = fmt.Sprintf("%d certificate alerts", len(alerts))
+	}
+	return sendMail(&config, subject, html.Bytes())
+}
+
+// postTeamsAlerts posts alerts to a Microsoft Teams webhook as an Adaptive Card
+// Its buttons need -public-url, since alerts aren't sent in answer to a request
+func postTeamsAlerts(url string, alerts []services.Alert) error {
+	body, err := json.Marshal(services.TeamsAlertMessage(alerts, publicURL))
+	if err != nil {
+		return fmt.Errorf("failed to encode alerts: %w", err)
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post alerts to Teams: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Teams returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notificationsHandler shows (GET) and saves (POST) the logged-in user's notification preferences, with their
+// latest webhook deliveries; POST ?action=redeliver&id= sends a failed delivery again
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	data := NotificationsData{Mail: summaryMail != nil, Prefs: notificationPrefs.Get(currentUsername(r))}
+
+	switch {
+	case r.Method == http.MethodPost && r.FormValue("action") == "redeliver":
+		if delivery, ok := redeliverWebhook(currentUsername(r), r.FormValue("id")); ok {
+			auditAction(r, "notify.redeliver", delivery.ID, delivery.URL)
+			data.Message = tr(r, "Sending delivery %s again", delivery.ID)
+		} else {
+			data.Error = "delivery not found, or not failed"
+		}
+	case r.Method == http.MethodPost:
+		prefs, err := saveNotificationPrefs(r)
+		if err != nil {
+			data.Error = err.Error()
+		} else {
+			data.Message = tr(r, "Notification preferences saved")
+		}
+		data.Prefs = prefs
+	}
+	data.Types = alertTypeOptions(data.Prefs.Types)
+	data.Deliveries = webhookDeliveries.List(currentUsername(r), webhookDeliveriesShown)
+
+	tmpl, err := parseTemplate("notifications.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiNotificationsHandler returns (GET) or replaces (POST) the logged-in user's notification preferences
+// POST takes ?types= (comma separated), &email=, &webhookUrl=, &webhookSecret=, &teamsWebhookUrl=, &quietStart=, &quietEnd= and &timezone=
+// An empty or missing webhookSecret keeps the current one; &clearWebhookSecret=1 removes it. The secret is never returned
+func apiNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		prefs := notificationPrefs.Get(currentUsername(r))
+		prefs.WebhookSecret = ""
+		writeJSON(w, http.StatusOK, prefs)
+	case http.MethodPost:
+		prefs, err := saveNotificationPrefs(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		prefs.WebhookSecret = ""
+		writeJSON(w, http.StatusOK, prefs)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// apiWebhookDeliveriesHandler lists the logged-in user's latest webhook deliveries and their attempts, newest first
+func apiWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, webhookDeliveries.List(currentUsername(r), webhookDeliveriesShown))
+}
+
+// apiRedeliverWebhookHandler sends one of the logged-in user's failed webhook deliveries again (POST)
+func apiRedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	delivery, ok := redeliverWebhook(currentUsername(r), r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "delivery not found, or not failed"})
+		return
+	}
+	auditAction(r, "notify.redeliver", delivery.ID, delivery.URL)
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+// saveNotificationPrefs saves the preferences described by the request's form values for the logged-in user
+// Types come from repeated ?type= checkboxes or a comma-separated ?types=
+func saveNotificationPrefs(r *http.Request) (services.NotificationPrefs, error) {
+	r.ParseForm()
+	types := r.Form["type"]
+	if list := r.FormValue("types"); list != "" {
+		types = append(types, splitList(list)...)
+	}
+
+	current := notificationPrefs.Get(currentUsername(r))
+	secret := r.FormValue("webhookSecret")
+	if secret == "" {
+		secret = current.WebhookSecret
+	}
+	if r.FormValue("clearWebhookSecret") != "" {
+		secret = ""
+	}
+
+	prefs := services.NotificationPrefs{
+		Username:        currentUsername(r),
+		Types:           types,
+		Email:           strings.TrimSpace(r.FormValue("email")),
+		WebhookURL:      strings.TrimSpace(r.FormValue("webhookUrl")),
+		WebhookSecret:   secret,
+		TeamsWebhookURL: strings.TrimSpace(r.FormValue("teamsWebhookUrl")),
+		QuietStart:      strings.TrimSpace(r.FormValue("quietStart")),
+		QuietEnd:        strings.TrimSpace(r.FormValue("quietEnd")),
+		Timezone:        strings.TrimSpace(r.FormValue("timezone")),
+	}
+	if prefs.Types == nil {
+		prefs.Types = make([]string, 0)
+	}
+	if err := notificationPrefs.Set(prefs); err != nil {
+		return prefs, err
+	}
+
+	auditAction(r, "notify.prefs_set", prefs.Username, strings.Join(prefs.Types, ", "))
+	return notificationPrefs.Get(prefs.Username), nil
+}
+
+// alertTypeOptions lists every alert type, marking the selected ones
+func alertTypeOptions(selected []string) []AlertTypeOption {
+	options := make([]AlertTypeOption, 0, len(services.AlertTypes))
+	for alertType, description := range services.AlertTypes {
+		option := AlertTypeOption{Type: alertType, Description: description}
+		for _, s := range selected {
+			if s == alertType {
+				option.Selected = true
+			}
+		}
+		options = append(options, option)
+	}
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Type < options[j].Type
+	})
+	return options
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// OCSPData holds data to pass to the OCSP responder template
+type OCSPData struct {
+	Domain string
+	Report services.OCSPHealthReport
+	Error  string
+
+	status int // HTTP status for API responses
+}
+
+// ocspHandler shows how quickly each issuer's OCSP responder answers from this server
+func ocspHandler(w http.ResponseWriter, r *http.Request) {
+	data := runOCSPCheck(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("ocsp.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiOCSPHandler returns the OCSP responder health report as JSON
+func apiOCSPHandler(w http.ResponseWriter, r *http.Request) {
+	data := runOCSPCheck(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runOCSPCheck searches CT for the domain and checks the responder of every issuer with a valid certificate
+func runOCSPCheck(ctx context.Context, query url.Values) OCSPData {
+	search := runSearch(ctx, query)
+	data := OCSPData{Domain: search.Domain, Error: search.Error, status: search.status}
+	if data.Error != "" {
+		return data
+	}
+
+	data.Report = services.CheckOCSPResponders(ctx, search.Domain, search.groups, time.Now())
+	return data
+}
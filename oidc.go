@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// oidcCookie carries the state, nonce, PKCE verifier and destination of a login in progress
+const oidcCookie = "oidc_login"
+
+// oidcLoginTimeout is how long someone has to finish logging in at the identity provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcProvider is the single sign-on provider, nil unless the server was started with -oidc-issuer
+var oidcProvider *services.OIDCProvider
+
+// oidcLoginHandler sends the browser to the identity provider
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, tr(r, "Could not start login"), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		http.Error(w, tr(r, "Could not start login"), http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	login := url.Values{
+		"state":    {state},
+		"nonce":    {nonce},
+		"verifier": {verifier},
+		"next":     {safeNext(r.FormValue("next"))},
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    login.Encode(),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || secureCookies,
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's top-level redirect back to us
+	})
+
+	http.Redirect(w, r, oidcProvider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// oidcCallbackHandler finishes a single sign-on login and starts a session
+// What went wrong at the identity provider only goes to the log and audit log; the login page shows a fixed message
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	data := AuthData{SSO: true, Next: "/"}
+
+	cookie, err := r.Cookie(oidcCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: "/login/oidc", MaxAge: -1})
+	if err != nil {
+		data.Error = "Your login took too long, please try again"
+		renderLoginError(w, r, data)
+		return
+	}
+	login, err := url.ParseQuery(cookie.Value)
+	if err != nil || login.Get("state") == "" || login.Get("state") != r.URL.Query().Get("state") {
+		data.Error = "Login could not be verified, please try again"
+		renderLoginError(w, r, data)
+		return
+	}
+	data.Next = safeNext(login.Get("next"))
+
+	if providerError := r.URL.Query().Get("error"); providerError != "" {
+		detail := strings.TrimSpace(providerError + " " + r.URL.Query().Get("error_description"))
+		log.Printf("oidc: identity provider refused the login: %s", detail)
+		auditAction(r, "user.login_failed", "", "sso: "+detail)
+		data.Error = "The identity provider refused the login"
+		renderLoginError(w, r, data)
+		return
+	}
+
+	identity, err := oidcProvider.Exchange(r.Context(), r.URL.Query().Get("code"), login.Get("nonce"), login.Get("verifier"))
+	if err != nil {
+		log.Printf("oidc: %v", err)
+		auditAction(r, "user.login_failed", identity.Username, "sso: "+err.Error())
+		data.Error = "Single sign-on failed, please try again"
+		renderLoginError(w, r, data)
+		return
+	}
+
+	user, err := users.UpsertSSO(identity.Username, identity.Admin)
+	if err != nil {
+		log.Printf("oidc: %s: %v", identity.Username, err)
+		data.Error = "Single sign-on failed, please try again"
+		renderLoginError(w, r, data)
+		return
+	}
+	if err := startSession(w, r, user.Username); err != nil {
+		log.Printf("oidc: %s: %v", identity.Username, err)
+		data.Error = "Single sign-on failed, please try again"
+		renderLoginError(w, r, data)
+		return
+	}
+	auditActorAction(r, user.Username, "user.login", user.Username, fmt.Sprintf("sso, groups=%s, admin=%t", strings.Join(identity.Groups, ","), identity.Admin))
+
+	http.Redirect(w, r, data.Next, http.StatusSeeOther)
+}
+
+// renderLoginError shows the login page with a failed single sign-on
+func renderLoginError(w http.ResponseWriter, r *http.Request, data AuthData) {
+	data.Local = users.HasLocalUsers()
+	w.WriteHeader(http.StatusUnauthorized)
+	renderAuthPage(w, r, "login.html", data)
+}
+
+// randomToken returns 16 random bytes, hex encoded
+func randomToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,434 @@
+// Package ctsearch searches Certificate Transparency logs through crt.sh and groups the results
+// into certificates (precertificate and leaf together) and issuers
+package ctsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Certificate represents a certificate record from crt.sh
+type Certificate struct {
+	ID             int64      `json:"id"`
+	IssuerCAID     int64      `json:"issuer_ca_id"`
+	IssuerName     string     `json:"issuer_name"`
+	CommonName     string     `json:"common_name"`
+	NameValue      string     `json:"name_value"`
+	NotBefore      string     `json:"not_before"`
+	NotAfter       string     `json:"not_after"`
+	SerialNumber   string     `json:"serial_number"`
+	EntryTimestamp string     `json:"entry_timestamp"`
+	EntryTime      time.Time  `json:"-"`          // EntryTimestamp parsed - we set this
+	EntryType      string     `json:"entry_type"` // "Precertificate" or "Leaf Certificate" - we set this
+	Sightings      []Sighting `json:"sightings"`  // Every CT log entry seen for this exact certificate - we set this
+}
+
+// Sighting records one time a certificate was seen in a CT log
+type Sighting struct {
+	EntryTimestamp string    `json:"entry_timestamp"`
+	Time           time.Time `json:"-"` // EntryTimestamp parsed
+}
+
+// SANs returns the names covered by the certificate
+// crt.sh puts one name per line in name_value
+func (c Certificate) SANs() []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(c.NameValue, "\n") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CertificateGroup holds certificates that share the same issuer and serial number
+// (typically a precertificate and its corresponding leaf certificate)
+type CertificateGroup struct {
+	SerialNumber  string        `json:"serialNumber"`
+	CommonName    string        `json:"commonName"`
+	IssuerName    string        `json:"issuerName"`
+	NotBefore     string        `json:"notBefore"`
+	NotAfter      string        `json:"notAfter"`
+	NotBeforeTime time.Time     `json:"-"` // Parsed times for sorting
+	NotAfterTime  time.Time     `json:"-"`
+	Entries       []Certificate `json:"entries"`
+	SharedWith    []string      `json:"sharedWith,omitempty"` // Names on the certificate outside the searched domain
+	Purpose       string        `json:"purpose"`              // What it's for, e.g. PurposeTLS, guessed from its names and issuer
+	PurposeKnown  bool          `json:"purposeKnown"`         // Purpose came from the certificate's extended key usages, not a guess
+}
+
+// IssuerGroup holds all certificate groups from the same issuer
+type IssuerGroup struct {
+	IssuerName   string             `json:"issuerName"`
+	DisplayName  string             `json:"displayName"` // Shortened/cleaned name for display
+	Slug         string             `json:"slug"`        // URL-safe form of DisplayName, for per-issuer pages
+	Certificates []CertificateGroup `json:"certificates"`
+}
+
+// httpClient queries crt.sh, with a long timeout because it can be slow
+var httpClient = &http.Client{
+	Timeout: 120 * time.Second,
+}
+
+// FetchCertificates queries crt.sh for certificates matching the domain
+// The domain may use crt.sh's wildcards, e.g. "%.example.com"; cancelling ctx abandons the query
+// When some rows are malformed the rest are returned with a *SkippedRowsError
+func FetchCertificates(ctx context.Context, domain string) ([]Certificate, error) {
+	return fetchJSON(ctx, fmt.Sprintf("https://crt.sh/?q=%s&output=json", url.QueryEscape(domain)))
+}
+
+// FetchCurrentCertificates is FetchCertificates for unexpired certificates only, a much smaller answer
+// for large domains whose full answer stops at ResultLimit
+func FetchCurrentCertificates(ctx context.Context, domain string) ([]Certificate, error) {
+	return fetchJSON(ctx, fmt.Sprintf("https://crt.sh/?q=%s&output=json&exclude=expired", url.QueryEscape(domain)))
+}
+
+// FetchBySerial queries crt.sh for every certificate with a hex serial number, from any issuer
+// Malformed rows are handled as in FetchCertificates
+func FetchBySerial(ctx context.Context, serial string) ([]Certificate, error) {
+	return fetchJSON(ctx, fmt.Sprintf("https://crt.sh/?serial=%s&output=json", url.QueryEscape(serial)))
+}
+
+// ResultLimit is the most rows crt.sh has been seen to send for one JSON query; it stops there without
+// saying so, so an answer that long is most likely missing certificates
+const ResultLimit = 10000
+
+// Truncated says whether an answer of this many rows, malformed ones included, was likely cut short
+func Truncated(rows int) bool {
+	return rows >= ResultLimit
+}
+
+// fetchJSON runs a crt.sh JSON query
+func fetchJSON(ctx context.Context, apiURL string) ([]Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch certificates: %w", err)
+	}
+
+	// Make the request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch certificates: %w", RequestError(err))
+	}
+	defer resp.Body.Close()
+
+	// Check for non-200 status
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewStatusError(resp)
+	}
+
+	// Parse JSON response; an overloaded crt.sh can cut it short or answer with an HTML error page
+	var rows []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse response: %w", ErrUnavailable, err)
+	}
+
+	// Rows are read one by one, so a malformed row costs only itself
+	certs := make([]Certificate, 0, len(rows))
+	var skipped *SkippedRowsError
+	for _, row := range rows {
+		var cert Certificate
+		if err := json.Unmarshal(row, &cert); err != nil {
+			if skipped == nil {
+				skipped = &SkippedRowsError{Total: len(rows), First: err}
+			}
+			skipped.Skipped++
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	if skipped == nil {
+		return certs, nil
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: failed to parse response: %v", ErrUnavailable, skipped)
+	}
+	return certs, skipped
+}
+
+// TimeLayout is how crt.sh writes times: UTC without a zone; entry timestamps add fractional seconds
+const TimeLayout = "2006-01-02T15:04:05"
+
+// ParseTime parses a time from crt.sh, which is always UTC
+// Fractional seconds are accepted even though the layout has none
+func ParseTime(value string) (time.Time, error) {
+	return time.Parse(TimeLayout, value)
+}
+
+// FilterByNotBefore filters certificates to only include those issued on or after the given date
+func FilterByNotBefore(certs []Certificate, notBeforeDate string) []Certificate {
+	// Parse the filter date (format: 2006-01-02 from HTML date input)
+	filterDate, err := time.Parse("2006-01-02", notBeforeDate)
+	if err != nil {
+		// If date parsing fails, return all certificates
+		return certs
+	}
+
+	filtered := make([]Certificate, 0)
+	for _, cert := range certs {
+		// Parse the certificate's NotBefore date
+		certDate, err := ParseTime(cert.NotBefore)
+		if err != nil {
+			// If we can't parse the cert date, include it anyway
+			filtered = append(filtered, cert)
+			continue
+		}
+
+		// Include certificate if it was issued on or after the filter date
+		if !certDate.Before(filterDate) {
+			filtered = append(filtered, cert)
+		}
+	}
+
+	return filtered
+}
+
+// CompileSANFilter builds the matcher used by FilterBySAN
+// Plain patterns are matched as case-insensitive substrings; with useRegex the
+// pattern is treated as a regular expression (e.g. `vpn.*\.example\.com`)
+func CompileSANFilter(pattern string, useRegex bool) (*regexp.Regexp, error) {
+	if !useRegex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAN filter: %w", err)
+	}
+
+	return re, nil
+}
+
+// FilterBySAN filters certificates to only include those with at least one SAN matching the filter
+func FilterBySAN(certs []Certificate, filter *regexp.Regexp) []Certificate {
+	filtered := make([]Certificate, 0)
+	for _, cert := range certs {
+		for _, name := range cert.SANs() {
+			if filter.MatchString(name) {
+				filtered = append(filtered, cert)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// GroupCertificates groups certificates by their identity (issuer + serial number)
+func GroupCertificates(certs []Certificate) []CertificateGroup {
+	// Map to collect certificates by identity
+	groupMap := make(map[string]*CertificateGroup)
+
+	for _, cert := range certs {
+		// Serial numbers are only unique per issuer, so key on both
+		key := fmt.Sprintf("%d/%s", cert.IssuerCAID, cert.SerialNumber)
+
+		// Check if we already have a group for this certificate
+		if group, exists := groupMap[key]; exists {
+			// Add to existing group
+			group.Entries = append(group.Entries, cert)
+		} else {
+			// Parse the validity dates for sorting
+			notBeforeTime, _ := ParseTime(cert.NotBefore)
+			notAfterTime, _ := ParseTime(cert.NotAfter)
+
+			// Create new group
+			groupMap[key] = &CertificateGroup{
+				SerialNumber:  cert.SerialNumber,
+				CommonName:    cert.CommonName,
+				IssuerName:    cert.IssuerName,
+				NotBefore:     cert.NotBefore,
+				NotAfter:      cert.NotAfter,
+				NotBeforeTime: notBeforeTime,
+				NotAfterTime:  notAfterTime,
+				Entries:       []Certificate{cert},
+			}
+		}
+	}
+
+	// Convert map to slice, collapse duplicates and label entries
+	groups := make([]CertificateGroup, 0, len(groupMap))
+	for _, group := range groupMap {
+		collapseEntries(group)
+		labelEntries(group)
+		group.Purpose = guessPurpose(*group)
+		groups = append(groups, *group)
+	}
+
+	return groups
+}
+
+// GroupByIssuer groups certificate groups by their issuer, ordered by the given sort order
+func GroupByIssuer(groups []CertificateGroup, order SortOrder) []IssuerGroup {
+	// Map to collect groups by issuer
+	issuerMap := make(map[string]*IssuerGroup)
+
+	for _, group := range groups {
+		if issuer, exists := issuerMap[group.IssuerName]; exists {
+			issuer.Certificates = append(issuer.Certificates, group)
+		} else {
+			issuerMap[group.IssuerName] = &IssuerGroup{
+				IssuerName:   group.IssuerName,
+				DisplayName:  IssuerDisplayName(group.IssuerName),
+				Slug:         IssuerSlug(group.IssuerName),
+				Certificates: []CertificateGroup{group},
+			}
+		}
+	}
+
+	// Convert map to slice
+	issuers := make([]IssuerGroup, 0, len(issuerMap))
+	for _, issuer := range issuerMap {
+		// Sort certificates within each issuer
+		order.sortCertificates(issuer.Certificates)
+		issuers = append(issuers, *issuer)
+	}
+
+	// Sort the issuer sections themselves
+	order.sortIssuers(issuers)
+
+	return issuers
+}
+
+// IssuerDisplayName pulls out a friendly name from the full issuer string
+// e.g., "C=US, O=Let's Encrypt, CN=R3" -> "Let's Encrypt (R3)"
+func IssuerDisplayName(issuerName string) string {
+	var org, cn string
+
+	parts := strings.Split(issuerName, ", ")
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "O=") {
+			org = strings.TrimPrefix(part, "O=")
+		} else if strings.HasPrefix(part, "CN=") {
+			cn = strings.TrimPrefix(part, "CN=")
+		}
+	}
+
+	if org != "" && cn != "" {
+		return fmt.Sprintf("%s (%s)", org, cn)
+	} else if org != "" {
+		return org
+	} else if cn != "" {
+		return cn
+	}
+
+	return issuerName
+}
+
+// IssuerSlug turns an issuer's display name into a URL path segment
+// e.g., "C=US, O=Let's Encrypt, CN=R3" -> "lets-encrypt-r3"
+// Issuers with the same display name share a slug
+func IssuerSlug(issuerName string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(IssuerDisplayName(issuerName)) {
+		switch {
+		case r == '\'' || r == '’':
+			// Dropped, so "Let's" becomes "lets"
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	if slug.Len() == 0 {
+		return "unknown"
+	}
+	return slug.String()
+}
+
+// FilterByIssuerSlug keeps the certificates from issuers with the given slug
+func FilterByIssuerSlug(groups []CertificateGroup, slug string) []CertificateGroup {
+	filtered := make([]CertificateGroup, 0)
+	for _, group := range groups {
+		if IssuerSlug(group.IssuerName) == slug {
+			filtered = append(filtered, group)
+		}
+	}
+	return filtered
+}
+
+// collapseEntries merges rows for the same certificate (same crt.sh ID) that
+// were logged in several CT logs, keeping each log entry as a Sighting
+func collapseEntries(group *CertificateGroup) {
+	// Index into collapsed by crt.sh ID
+	byID := make(map[int64]int)
+	collapsed := make([]Certificate, 0, len(group.Entries))
+
+	for _, entry := range group.Entries {
+		entryTime, _ := ParseTime(entry.EntryTimestamp)
+		sighting := Sighting{EntryTimestamp: entry.EntryTimestamp, Time: entryTime}
+
+		i, exists := byID[entry.ID]
+		if !exists {
+			entry.Sightings = []Sighting{sighting}
+			byID[entry.ID] = len(collapsed)
+			collapsed = append(collapsed, entry)
+			continue
+		}
+
+		// crt.sh repeats identical rows when several names match, so skip exact duplicates
+		if !hasSighting(collapsed[i].Sightings, sighting) {
+			collapsed[i].Sightings = append(collapsed[i].Sightings, sighting)
+		}
+	}
+
+	for i := range collapsed {
+		// Sort sightings oldest first and show the first time it was logged
+		sort.Slice(collapsed[i].Sightings, func(a, b int) bool {
+			return collapsed[i].Sightings[a].EntryTimestamp < collapsed[i].Sightings[b].EntryTimestamp
+		})
+		collapsed[i].EntryTimestamp = collapsed[i].Sightings[0].EntryTimestamp
+		collapsed[i].EntryTime = collapsed[i].Sightings[0].Time
+	}
+
+	group.Entries = collapsed
+}
+
+// hasSighting reports whether sighting is already in the list
+func hasSighting(sightings []Sighting, sighting Sighting) bool {
+	for _, s := range sightings {
+		if s == sighting {
+			return true
+		}
+	}
+	return false
+}
+
+// labelEntries marks entries as Precertificate or Leaf Certificate
+// The entry with the earlier timestamp is the precertificate
+func labelEntries(group *CertificateGroup) {
+	if len(group.Entries) == 1 {
+		// Only one entry - we can't be sure, label as Leaf Certificate
+		group.Entries[0].EntryType = "Leaf Certificate"
+		return
+	}
+
+	// Sort entries by ID (lower ID = logged first = precertificate)
+	sort.Slice(group.Entries, func(i, j int) bool {
+		return group.Entries[i].ID < group.Entries[j].ID
+	})
+
+	// Label them
+	for i := range group.Entries {
+		if i == 0 {
+			group.Entries[i].EntryType = "Precertificate"
+		} else {
+			group.Entries[i].EntryType = "Leaf Certificate"
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ctsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of crt.sh failure; errors from this package wrap one of them, so callers can tell users
+// what went wrong without showing them the raw error
+var (
+	ErrTimeout     = errors.New("crt.sh took too long to answer")
+	ErrRateLimited = errors.New("crt.sh is rate limiting requests")
+	ErrMaintenance = errors.New("crt.sh is temporarily unavailable")
+	ErrUnavailable = errors.New("crt.sh is unavailable")
+)
+
+// StatusError is a crt.sh answer other than 200 OK
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // From the Retry-After header, zero when there wasn't one
+}
+
+// NewStatusError records a crt.sh answer other than 200 OK, with when it said to try again
+func NewStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *StatusError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("crt.sh returned status: %d, retry after %s", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("crt.sh returned status: %d", e.StatusCode)
+}
+
+// Unwrap returns the kind of failure the status means
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrMaintenance
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	default:
+		return ErrUnavailable
+	}
+}
+
+// SkippedRowsError is returned along with the rows that could be read when others in crt.sh's answer
+// were malformed; the results are usable, but incomplete
+type SkippedRowsError struct {
+	Skipped int
+	Total   int
+	First   error // Why the first skipped row couldn't be read
+}
+
+func (e *SkippedRowsError) Error() string {
+	return fmt.Sprintf("skipped %d of %d malformed rows: %v", e.Skipped, e.Total, e.First)
+}
+
+// RequestError marks a failed request to crt.sh as ErrTimeout or ErrUnavailable, keeping the cause
+func RequestError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// ParseRetryAfter reads a Retry-After header, either seconds or an HTTP date, as a wait from now
+// A missing, malformed or past value is zero
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now).Round(time.Second), 0)
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+package ctsearch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What a certificate is for
+const (
+	PurposeTLS             = "tls"              // TLS server authentication, the default for web PKI
+	PurposeClientAuth      = "client-auth"      // TLS client authentication only
+	PurposeEmail           = "email"            // S/MIME
+	PurposeCodeSigning     = "code-signing"     // Software and driver signing
+	PurposeDocumentSigning = "document-signing" // PDF and Office document signing
+	PurposeOther           = "other"
+)
+
+// Purpose filters accepted by FilterByPurpose
+const (
+	PurposeFilterTLS    = "tls"     // Only TLS server certificates
+	PurposeFilterNonTLS = "non-tls" // Only certificates for something else
+)
+
+// PurposeLabel names a purpose for display
+func PurposeLabel(purpose string) string {
+	switch purpose {
+	case PurposeTLS:
+		return "TLS server"
+	case PurposeClientAuth:
+		return "Client authentication"
+	case PurposeEmail:
+		return "S/MIME email"
+	case PurposeCodeSigning:
+		return "Code signing"
+	case PurposeDocumentSigning:
+		return "Document signing"
+	}
+	return "Other"
+}
+
+// guessPurpose works out what a certificate is for from what crt.sh returns, which doesn't include
+// extended key usages: the issuing CA's name usually says, then email addresses mean S/MIME and
+// hostnames mean TLS. Downloading the certificate (x509info.CertificatePurpose) gives the real answer,
+// and until then the guess is shown as unverified and doesn't decide filters
+func guessPurpose(group CertificateGroup) string {
+	issuer := strings.ToLower(group.IssuerName)
+	switch {
+	case strings.Contains(issuer, "code signing") || strings.Contains(issuer, "codesigning"):
+		return PurposeCodeSigning
+	case strings.Contains(issuer, "document signing"):
+		return PurposeDocumentSigning
+	}
+
+	hostnames, addresses := false, false
+	for _, name := range append(groupSANs(group), group.CommonName) {
+		switch {
+		case strings.Contains(name, "@"):
+			addresses = true
+		case strings.Contains(name, ".") && !strings.Contains(name, " "):
+			hostnames = true
+		}
+	}
+	switch {
+	case addresses && !hostnames:
+		return PurposeEmail
+	case hostnames:
+		return PurposeTLS
+	case strings.Contains(issuer, "client"):
+		return PurposeClientAuth
+	case strings.Contains(issuer, "email") || strings.Contains(issuer, "s/mime"):
+		return PurposeEmail
+	}
+	return PurposeOther
+}
+
+// groupSANs lists the names on every entry of a group
+func groupSANs(group CertificateGroup) []string {
+	names := make([]string, 0)
+	for _, entry := range group.Entries {
+		names = append(names, entry.SANs()...)
+	}
+	return names
+}
+
+// CheckPurposeFilter validates a purpose filter; empty keeps every certificate
+func CheckPurposeFilter(filter string) error {
+	switch filter {
+	case "", PurposeFilterTLS, PurposeFilterNonTLS:
+		return nil
+	}
+	return fmt.Errorf("invalid purpose %q, use %s or %s", filter, PurposeFilterTLS, PurposeFilterNonTLS)
+}
+
+// FilterByPurpose keeps TLS server certificates (PurposeFilterTLS) or everything else (PurposeFilterNonTLS)
+// Certificates whose purpose is only guessed are kept either way, so a wrong guess can't hide one
+func FilterByPurpose(groups []CertificateGroup, filter string) []CertificateGroup {
+	if filter == "" {
+		return groups
+	}
+	filtered := make([]CertificateGroup, 0, len(groups))
+	for _, group := range groups {
+		if !group.PurposeKnown || (group.Purpose == PurposeTLS) == (filter == PurposeFilterTLS) {
+			filtered = append(filtered, group)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,105 @@
+package ctsearch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sort fields accepted by ParseSortOrder
+const (
+	SortByExpiry = "expiry"
+	SortByIssued = "issued"
+	SortByIssuer = "issuer"
+	SortByName   = "name"
+)
+
+// SortOrder describes how issuers and the certificates within them are ordered
+type SortOrder struct {
+	Field      string
+	Descending bool
+}
+
+// DefaultSortOrder keeps issuers alphabetical with the newest expiry first inside each
+var DefaultSortOrder = SortOrder{Field: SortByIssuer}
+
+// ParseSortOrder parses a sort parameter like "expiry", "issued-asc" or "name-desc"
+// Without a direction, dates sort newest first and names sort A-Z
+func ParseSortOrder(value string) (SortOrder, error) {
+	if value == "" {
+		return DefaultSortOrder, nil
+	}
+
+	field, direction, _ := strings.Cut(strings.ToLower(value), "-")
+
+	var order SortOrder
+	switch field {
+	case SortByExpiry, SortByIssued:
+		order = SortOrder{Field: field, Descending: true}
+	case SortByIssuer, SortByName:
+		order = SortOrder{Field: field}
+	default:
+		return order, fmt.Errorf("unknown sort field: %q", field)
+	}
+
+	switch direction {
+	case "":
+	case "asc":
+		order.Descending = false
+	case "desc":
+		order.Descending = true
+	default:
+		return order, fmt.Errorf("unknown sort direction: %q", direction)
+	}
+
+	return order, nil
+}
+
+// String returns the order in the form accepted by ParseSortOrder
+func (o SortOrder) String() string {
+	if o.Descending {
+		return o.Field + "-desc"
+	}
+	return o.Field + "-asc"
+}
+
+// sortCertificates orders the certificate groups within one issuer
+func (o SortOrder) sortCertificates(certs []CertificateGroup) {
+	sort.SliceStable(certs, func(i, j int) bool {
+		return o.compareCertificates(certs[i], certs[j]) < 0
+	})
+}
+
+// sortIssuers orders issuer sections, using each issuer's first certificate
+// (already sorted) unless we are sorting by the issuer name itself
+func (o SortOrder) sortIssuers(issuers []IssuerGroup) {
+	sort.SliceStable(issuers, func(i, j int) bool {
+		if o.Field == SortByIssuer || len(issuers[i].Certificates) == 0 || len(issuers[j].Certificates) == 0 {
+			return o.direct(strings.Compare(issuers[i].DisplayName, issuers[j].DisplayName)) < 0
+		}
+		return o.compareCertificates(issuers[i].Certificates[0], issuers[j].Certificates[0]) < 0
+	})
+}
+
+// compareCertificates returns -1, 0 or 1 for a before, equal to or after b
+func (o SortOrder) compareCertificates(a, b CertificateGroup) int {
+	switch o.Field {
+	case SortByIssued:
+		return o.direct(a.NotBeforeTime.Compare(b.NotBeforeTime))
+	case SortByName:
+		return o.direct(strings.Compare(strings.ToLower(a.CommonName), strings.ToLower(b.CommonName)))
+	case SortByIssuer:
+		// Issuer order is handled by sortIssuers - keep newest expiry first inside each issuer
+		return b.NotAfterTime.Compare(a.NotAfterTime)
+	default:
+		return o.direct(a.NotAfterTime.Compare(b.NotAfterTime))
+	}
+}
+
+// direct flips an ascending comparison result when sorting descending
+func (o SortOrder) direct(result int) int {
+	if o.Descending {
+		return -result
+	}
+	return result
+}
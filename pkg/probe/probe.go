@@ -0,0 +1,181 @@
+// Package probe connects to TLS servers, directly or with SMTP STARTTLS, and records the certificate chain they serve
+package probe
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+// probeTimeout bounds connecting to and handshaking with a server
+const probeTimeout = 10 * time.Second
+
+// Result is what a server presented during a TLS handshake
+type Result struct {
+	Host        string                `json:"host"`
+	Port        int                   `json:"port"`
+	STARTTLS    bool                  `json:"starttls"` // Upgraded a plain SMTP connection
+	TLSVersion  string                `json:"tlsVersion"`
+	CipherSuite string                `json:"cipherSuite"`
+	Chain       []Certificate         `json:"chain"`                 // As served, leaf first
+	VerifyError string                `json:"verifyError,omitempty"` // Why the chain doesn't validate against the system roots
+	Issues      []x509info.ChainIssue `json:"issues,omitempty"`      // What a browser would complain about, see x509info.ValidateChain
+	Error       string                `json:"error,omitempty"`
+
+	certificates []*x509.Certificate // Parsed chain for further checks
+}
+
+// Certificate summarizes one certificate from a served chain
+type Certificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	SHA256       string    `json:"sha256"`
+}
+
+// Certificates returns the parsed chain, leaf first
+func (p Result) Certificates() []*x509.Certificate {
+	return p.certificates
+}
+
+// TLS connects to host:port and records the served certificate chain
+// SMTP ports (25, 587) are probed with STARTTLS; connecting and the handshake stop when ctx is cancelled
+func TLS(ctx context.Context, host string, port int) Result {
+	result := Result{
+		Host:  strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), "."),
+		Port:  port,
+		Chain: make([]Certificate, 0),
+	}
+	address := net.JoinHostPort(result.Host, strconv.Itoa(port))
+
+	// We want to see whatever is served, so verification is done separately below
+	config := &tls.Config{
+		ServerName:         result.Host,
+		InsecureSkipVerify: true,
+	}
+
+	var state tls.ConnectionState
+	var err error
+	if port == 25 || port == 587 {
+		result.STARTTLS = true
+		state, err = probeSTARTTLS(ctx, address, config)
+	} else {
+		state, err = probeDirect(ctx, address, config)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.TLSVersion = tls.VersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	result.certificates = state.PeerCertificates
+
+	for _, cert := range state.PeerCertificates {
+		fingerprint := sha256.Sum256(cert.Raw)
+		result.Chain = append(result.Chain, Certificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: cert.SerialNumber.Text(16),
+			NotBefore:    cert.NotBefore,
+			NotAfter:     cert.NotAfter,
+			SHA256:       hex.EncodeToString(fingerprint[:]),
+		})
+	}
+
+	validation := x509info.ValidateChain(result.Host, state.PeerCertificates, time.Now())
+	result.VerifyError = validation.VerifyError
+	result.Issues = validation.Issues
+
+	return result
+}
+
+// x25519MLKEM768 is the hybrid post-quantum key exchange browsers offer (draft-ietf-tls-ecdhe-mlkem),
+// named by its TLS code point since crypto/tls only has a constant for it from Go 1.24
+const x25519MLKEM768 tls.CurveID = 0x11ec
+
+// HybridKeyExchange reports whether host:port completes a handshake offering only the X25519MLKEM768
+// key exchange; a server that doesn't speak it refuses the handshake, which isn't an error
+// Toolchains before Go 1.24 can't offer it and return an error
+func HybridKeyExchange(ctx context.Context, host string, port int) (bool, error) {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		CurvePreferences:   []tls.CurveID{x25519MLKEM768},
+	}
+
+	var err error
+	if port == 25 || port == 587 {
+		_, err = probeSTARTTLS(ctx, address, config)
+	} else {
+		_, err = probeDirect(ctx, address, config)
+	}
+	// crypto/tls reports the server's alert as a "remote error"
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// probeDirect performs a TLS handshake straight away
+func probeDirect(ctx context.Context, address string, config *tls.Config) (tls.ConnectionState, error) {
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: probeTimeout}, Config: config}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+	}
+	defer conn.Close()
+
+	return conn.(*tls.Conn).ConnectionState(), nil
+}
+
+// probeSTARTTLS speaks SMTP until the server offers STARTTLS, then upgrades
+func probeSTARTTLS(ctx context.Context, address string, config *tls.Config) (tls.ConnectionState, error) {
+	dialer := &net.Dialer{Timeout: probeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	// Unblock the SMTP conversation if ctx is cancelled or expires part way through
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, config.ServerName)
+	if err != nil {
+		conn.Close()
+		return tls.ConnectionState{}, fmt.Errorf("SMTP greeting from %s failed: %w", address, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); !ok {
+		return tls.ConnectionState{}, fmt.Errorf("%s does not offer STARTTLS", address)
+	}
+	if err := client.StartTLS(config); err != nil {
+		return tls.ConnectionState{}, fmt.Errorf("STARTTLS with %s failed: %w", address, err)
+	}
+
+	state, _ := client.TLSConnectionState()
+	return state, nil
+}
@@ -0,0 +1,261 @@
+package x509info
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Chain issue severities
+const (
+	IssueError   = "error"   // Browsers refuse the connection
+	IssueWarning = "warning" // Some clients fail, or will soon
+	IssueInfo    = "info"    // Harmless, but worth fixing
+)
+
+// chainExpiryWarning is how far ahead ValidateChain warns about expiring certificates
+const chainExpiryWarning = 30 * 24 * time.Hour
+
+// ChainIssue is one problem with a certificate chain
+type ChainIssue struct {
+	Severity    string `json:"severity"`
+	Certificate int    `json:"certificate"`       // Position in the chain (leaf is 0), or -1 for the chain as a whole
+	Browser     string `json:"browser,omitempty"` // The error Chrome shows for it, e.g. NET::ERR_CERT_DATE_INVALID
+	Message     string `json:"message"`
+}
+
+// ChainCertificate summarizes one certificate in a chain
+type ChainCertificate struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serialNumber"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	CA                 bool      `json:"ca"`
+	SelfSigned         bool      `json:"selfSigned"`
+	Key                KeyInfo   `json:"key"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	SHA256             string    `json:"sha256"`
+}
+
+// ChainValidation is the outcome of checking a chain the way a browser would
+type ChainValidation struct {
+	Host         string             `json:"host,omitempty"` // The name the leaf was checked against; names aren't checked when empty
+	Certificates []ChainCertificate `json:"certificates"`   // As given, leaf first
+	Trusted      bool               `json:"trusted"`        // Builds to a root in the system trust store
+	Path         []string           `json:"path,omitempty"` // Subjects from the leaf to the trusted root
+	VerifyError  string             `json:"verifyError,omitempty"`
+	Issues       []ChainIssue       `json:"issues"`
+}
+
+// ParseCertificates decodes every certificate in PEM data (or a single DER certificate), in order
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return nil, errors.New("no CERTIFICATE blocks found")
+	}
+	certs, err := x509.ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return certs, nil
+}
+
+// ValidateChain checks a chain, leaf first, as a browser connecting to host would: its order,
+// whether it builds to a trusted root, expiry, and key and signature strength
+func ValidateChain(host string, chain []*x509.Certificate, now time.Time) ChainValidation {
+	validation := ChainValidation{
+		Host:         host,
+		Certificates: make([]ChainCertificate, 0, len(chain)),
+		Issues:       make([]ChainIssue, 0),
+	}
+	issue := func(severity string, index int, browser, format string, args ...any) {
+		validation.Issues = append(validation.Issues, ChainIssue{
+			Severity:    severity,
+			Certificate: index,
+			Browser:     browser,
+			Message:     fmt.Sprintf(format, args...),
+		})
+	}
+
+	if len(chain) == 0 {
+		validation.VerifyError = "no certificates"
+		issue(IssueError, -1, "", "the chain is empty")
+		return validation
+	}
+
+	for _, cert := range chain {
+		fingerprint := sha256.Sum256(cert.Raw)
+		validation.Certificates = append(validation.Certificates, ChainCertificate{
+			Subject:            cert.Subject.String(),
+			Issuer:             cert.Issuer.String(),
+			SerialNumber:       cert.SerialNumber.Text(16),
+			NotBefore:          cert.NotBefore,
+			NotAfter:           cert.NotAfter,
+			CA:                 cert.IsCA,
+			SelfSigned:         selfSigned(cert),
+			Key:                InspectKey(cert.PublicKey, cert.PublicKeyAlgorithm),
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+			SHA256:             hex.EncodeToString(fingerprint[:]),
+		})
+	}
+
+	// Order: the leaf first, then each certificate issued by the next
+	if chain[0].IsCA {
+		issue(IssueWarning, 0, "", "the first certificate is a CA certificate; the leaf must come first")
+	}
+	seen := make(map[string]int)
+	for i, cert := range validation.Certificates {
+		if first, duplicate := seen[cert.SHA256]; duplicate {
+			issue(IssueWarning, i, "", "duplicate of certificate %d", first)
+			continue
+		}
+		seen[cert.SHA256] = i
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		duplicate := validation.Certificates[i].SHA256 == validation.Certificates[i+1].SHA256
+		if duplicate || validation.Certificates[i].SelfSigned || issuedBy(chain[i], chain[i+1]) {
+			continue
+		}
+		issuer := -1
+		for j := range chain {
+			if j != i && issuedBy(chain[i], chain[j]) {
+				issuer = j
+				break
+			}
+		}
+		if issuer >= 0 {
+			issue(IssueWarning, i, "", "issued by certificate %d rather than the next one; the chain is out of order, which strict clients reject", issuer)
+		} else {
+			issue(IssueWarning, i+1, "", "does not issue certificate %d and doesn't belong in the chain", i)
+		}
+	}
+	last := len(chain) - 1
+	if last > 0 && validation.Certificates[last].SelfSigned {
+		issue(IssueInfo, last, "", "the root certificate is included; clients use their own copy, so it only makes the handshake bigger")
+	}
+
+	// Trust: build a path to a root in the system store
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if err != nil {
+		validation.VerifyError = err.Error()
+		browser, message := verifyIssue(err, chain)
+		issue(IssueError, -1, browser, "%s", message)
+	} else {
+		validation.Trusted = true
+		for _, cert := range chains[0] {
+			validation.Path = append(validation.Path, cert.Subject.String())
+		}
+	}
+
+	// Expiry, keys and signatures; clients ignore a root's own copy, so it isn't judged
+	for i, cert := range chain {
+		if i > 0 && i == last && validation.Certificates[i].SelfSigned {
+			continue
+		}
+		switch {
+		case now.After(cert.NotAfter):
+			issue(IssueError, i, "NET::ERR_CERT_DATE_INVALID", "expired on %s", cert.NotAfter.UTC().Format("2006-01-02"))
+		case now.Before(cert.NotBefore):
+			issue(IssueError, i, "NET::ERR_CERT_DATE_INVALID", "not valid until %s", cert.NotBefore.UTC().Format("2006-01-02"))
+		case cert.NotAfter.Sub(now) < chainExpiryWarning:
+			issue(IssueWarning, i, "", "expires in %d day(s) on %s", int(cert.NotAfter.Sub(now).Hours()/24), cert.NotAfter.UTC().Format("2006-01-02"))
+		}
+
+		if weakness := SignatureWeakness(cert.SignatureAlgorithm); weakness != "" {
+			issue(IssueError, i, "NET::ERR_CERT_WEAK_SIGNATURE_ALGORITHM", "%s", weakness)
+		}
+		for _, weakness := range validation.Certificates[i].Key.Weaknesses {
+			issue(IssueError, i, "NET::ERR_CERT_WEAK_KEY", "%s", weakness)
+		}
+	}
+
+	// The leaf's own rules
+	leaf := chain[0]
+	if len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 {
+		issue(IssueError, 0, "NET::ERR_CERT_COMMON_NAME_INVALID", "has no subject alternative names; browsers ignore the common name")
+	}
+	if lifetime := leaf.NotAfter.Sub(leaf.NotBefore); !leaf.NotBefore.Before(baselineStartDate) && lifetime > 398*24*time.Hour {
+		issue(IssueError, 0, "NET::ERR_CERT_VALIDITY_TOO_LONG", "valid for %d days (maximum is 398)", int(lifetime.Hours()/24))
+	}
+
+	sort.SliceStable(validation.Issues, func(i, j int) bool {
+		return issueRank[validation.Issues[i].Severity] < issueRank[validation.Issues[j].Severity]
+	})
+	return validation
+}
+
+// issueRank orders issues, errors first
+var issueRank = map[string]int{IssueError: 0, IssueWarning: 1, IssueInfo: 2}
+
+// verifyIssue explains why a chain didn't verify, with the matching Chrome error
+func verifyIssue(err error, chain []*x509.Certificate) (string, string) {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var insecure x509.InsecureAlgorithmError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		last := chain[len(chain)-1]
+		if selfSigned(last) {
+			return "NET::ERR_CERT_AUTHORITY_INVALID", fmt.Sprintf("the chain ends in %s, which is not a trusted root", last.Subject)
+		}
+		message := fmt.Sprintf("the chain is incomplete: %s, which issued the last certificate, is missing", last.Issuer)
+		if len(last.IssuingCertificateURL) > 0 {
+			message += fmt.Sprintf(" (Chrome may download it from %s, but Firefox and most other clients won't)", last.IssuingCertificateURL[0])
+		}
+		return "NET::ERR_CERT_AUTHORITY_INVALID", message
+	case errors.As(err, &hostname):
+		return "NET::ERR_CERT_COMMON_NAME_INVALID", err.Error()
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "NET::ERR_CERT_DATE_INVALID", err.Error()
+	case errors.As(err, &insecure):
+		return "NET::ERR_CERT_WEAK_SIGNATURE_ALGORITHM", err.Error()
+	}
+	return "NET::ERR_CERT_INVALID", err.Error()
+}
+
+// issuedBy reports whether parent signed child
+func issuedBy(child, parent *x509.Certificate) bool {
+	return bytes.Equal(child.RawIssuer, parent.RawSubject) && child.CheckSignatureFrom(parent) == nil
+}
+
+// selfSigned reports whether a certificate signed itself, as roots do
+func selfSigned(cert *x509.Certificate) bool {
+	return issuedBy(cert, cert)
+}
@@ -0,0 +1,194 @@
+package x509info
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// LogListURL is Chrome's list of CT logs and their states, which Apple's list mostly mirrors
+const LogListURL = "https://www.gstatic.com/ct/log_list/v3/log_list.json"
+
+// maxLogListSize caps the log list download; the real one is a few hundred kilobytes
+const maxLogListSize = 8 << 20
+
+// CT log states from the log list
+const (
+	LogPending   = "pending"
+	LogQualified = "qualified"
+	LogUsable    = "usable"
+	LogReadOnly  = "readonly"
+	LogRetired   = "retired"
+	LogRejected  = "rejected"
+)
+
+// SCT is a signed certificate timestamp embedded in a certificate: a log's promise to publish it
+type SCT struct {
+	LogID     string    `json:"logId"` // Base64 SHA-256 of the log's key, as in log lists
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CTLog is one log from the log list
+type CTLog struct {
+	Description string    `json:"description"`
+	Operator    string    `json:"operator"`
+	State       string    `json:"state"` // One of the Log* states
+	StateSince  time.Time `json:"stateSince"`
+}
+
+// LogList is the CT logs browsers know, by log ID
+type LogList struct {
+	Logs map[string]CTLog
+}
+
+// logListJSON is the part of the v3 log list format that's used
+type logListJSON struct {
+	Operators []struct {
+		Name      string    `json:"name"`
+		Logs      []logJSON `json:"logs"`
+		TiledLogs []logJSON `json:"tiled_logs"`
+	} `json:"operators"`
+}
+
+type logJSON struct {
+	Description string `json:"description"`
+	LogID       string `json:"log_id"`
+	State       map[string]struct {
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"state"`
+}
+
+// FetchLogList downloads Chrome's CT log list
+func FetchLogList(ctx context.Context) (*LogList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, LogListURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the CT log list: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the CT log list: %w", ctsearch.RequestError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the CT log list: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLogListSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the CT log list: %w", err)
+	}
+	return ParseLogList(data)
+}
+
+// ParseLogList reads a log list in the v3 format Chrome and Apple publish
+func ParseLogList(data []byte) (*LogList, error) {
+	var parsed logListJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse the CT log list: %w", err)
+	}
+
+	list := &LogList{Logs: make(map[string]CTLog)}
+	for _, operator := range parsed.Operators {
+		for _, log := range append(operator.Logs, operator.TiledLogs...) {
+			ctLog := CTLog{Description: log.Description, Operator: operator.Name}
+			// A log has a single state; a list that names none leaves it pending
+			ctLog.State = LogPending
+			for state, since := range log.State {
+				ctLog.State = state
+				ctLog.StateSince = since.Timestamp
+			}
+			list.Logs[log.LogID] = ctLog
+		}
+	}
+	if len(list.Logs) == 0 {
+		return nil, fmt.Errorf("failed to parse the CT log list: no logs")
+	}
+	return list, nil
+}
+
+// CTCompliance is whether a certificate's embedded SCTs satisfy the browsers' CT policies
+type CTCompliance struct {
+	Compliant bool         `json:"compliant"`
+	Required  int          `json:"required"`  // SCTs from distinct logs required for the certificate's lifetime
+	Counted   int          `json:"counted"`   // Distinct logs whose SCTs count toward that
+	Operators int          `json:"operators"` // Distinct operators among those logs
+	SCTs      []CheckedSCT `json:"scts"`
+	Problems  []string     `json:"problems"`
+}
+
+// CheckedSCT is an embedded SCT with the log that issued it
+type CheckedSCT struct {
+	SCT
+	Log      string `json:"log,omitempty"` // Description, or empty for a log not on the list
+	Operator string `json:"operator,omitempty"`
+	State    string `json:"state,omitempty"`
+	Counts   bool   `json:"counts"` // Whether it counts toward the policy
+}
+
+// CheckCTPolicy checks a TLS certificate's embedded SCTs against the Chrome and Apple CT policies:
+//   - SCTs from at least RequiredSCTs distinct logs that were qualified, usable, read-only or retired
+//     when checked, a retired log's only when issued before it was retired;
+//   - at least one from a log still qualified, usable or read-only;
+//   - from logs of at least two distinct operators.
+//
+// SCTs delivered in the TLS handshake or an OCSP response can also satisfy the policies, which can't be
+// seen from the certificate
+func CheckCTPolicy(info CertificateInfo, notBefore, notAfter time.Time, logs *LogList) CTCompliance {
+	compliance := CTCompliance{
+		Required: RequiredSCTs(notBefore, notAfter),
+		SCTs:     make([]CheckedSCT, 0, len(info.SCTs)),
+		Problems: make([]string, 0),
+	}
+
+	counted := make(map[string]bool)
+	operators := make(map[string]bool)
+	current := false
+	for _, sct := range info.SCTs {
+		checked := CheckedSCT{SCT: sct}
+		log, known := logs.Logs[sct.LogID]
+		if !known {
+			compliance.Problems = append(compliance.Problems, fmt.Sprintf("SCT from log %s, which browsers don't know", sct.LogID))
+			compliance.SCTs = append(compliance.SCTs, checked)
+			continue
+		}
+		checked.Log, checked.Operator, checked.State = log.Description, log.Operator, log.State
+
+		switch log.State {
+		case LogQualified, LogUsable, LogReadOnly:
+			checked.Counts = true
+			current = true
+		case LogRetired:
+			checked.Counts = sct.Timestamp.Before(log.StateSince)
+			if !checked.Counts {
+				compliance.Problems = append(compliance.Problems, fmt.Sprintf("SCT from %s, issued after the log was retired", log.Description))
+			}
+		default:
+			compliance.Problems = append(compliance.Problems, fmt.Sprintf("SCT from %s, which is %s and not trusted yet or at all", log.Description, log.State))
+		}
+		if checked.Counts && !counted[sct.LogID] {
+			counted[sct.LogID] = true
+			operators[log.Operator] = true
+		}
+		compliance.SCTs = append(compliance.SCTs, checked)
+	}
+	compliance.Counted = len(counted)
+	compliance.Operators = len(operators)
+
+	if compliance.Counted < compliance.Required {
+		compliance.Problems = append(compliance.Problems, fmt.Sprintf("%d SCT(s) from logs browsers accept, %d required", compliance.Counted, compliance.Required))
+	}
+	if compliance.Counted > 0 && !current {
+		compliance.Problems = append(compliance.Problems, "no SCT from a log that is still usable")
+	}
+	if compliance.Counted > 0 && compliance.Operators < 2 {
+		compliance.Problems = append(compliance.Problems, "every SCT is from logs of one operator, two are required")
+	}
+	compliance.Compliant = compliance.Counted >= compliance.Required && current && compliance.Operators >= 2
+	return compliance
+}
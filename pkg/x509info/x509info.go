@@ -0,0 +1,348 @@
+// Package x509info downloads certificates from crt.sh and inspects their keys, signatures,
+// embedded SCTs and revocation endpoints
+package x509info
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// fetchWorkers is how many certificates FetchCertificateInfos downloads from crt.sh at once
+const fetchWorkers = 4
+
+// httpClient downloads certificates from crt.sh
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// Extension OIDs from RFC 6962
+var (
+	oidSCTList        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidPrecertPoison  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	baselineStartDate = time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC) // 398-day maximum lifetime applies from here
+)
+
+// Document signing extended key usages, which Go doesn't name
+var documentSigningEKUs = []asn1.ObjectIdentifier{
+	{1, 3, 6, 1, 5, 5, 7, 3, 36},       // id-kp-documentSigning (RFC 9336)
+	{1, 3, 6, 1, 4, 1, 311, 10, 3, 12}, // Microsoft document signing
+	{1, 2, 840, 113583, 1, 1, 5},       // Adobe PDF signing
+}
+
+// CertificateInfo is what we learn from parsing the certificate itself
+// (crt.sh's search results only carry names, dates and the issuer)
+type CertificateInfo struct {
+	ID                    int64    `json:"id"`
+	KeyAlgorithm          string   `json:"keyAlgorithm"` // "RSA", "ECDSA" or "Ed25519"
+	KeySize               int      `json:"keySize"`      // Bits (modulus size for RSA, curve size for ECDSA)
+	Curve                 string   `json:"curve,omitempty"`
+	SignatureAlgorithm    string   `json:"signatureAlgorithm"`
+	Purpose               string   `json:"purpose"` // From the extended key usages, e.g. ctsearch.PurposeTLS
+	IsPrecertificate      bool     `json:"isPrecertificate"`
+	SCTCount              int      `json:"sctCount"` // Embedded SCTs (always 0 for precertificates)
+	SCTs                  []SCT    `json:"scts"`
+	OCSPServers           []string `json:"ocspServers"`
+	CRLDistributionPoints []string `json:"crlDistributionPoints"`
+	Weaknesses            []string `json:"weaknesses"`
+
+	Certificate *x509.Certificate `json:"-"` // The parsed certificate, for further checks
+}
+
+// ErrNotFound is returned when crt.sh has no certificate with the requested ID
+var ErrNotFound = errors.New("no such certificate on crt.sh")
+
+// FetchPEM downloads a single certificate from crt.sh by its ID
+func FetchPEM(ctx context.Context, id int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://crt.sh/?d=%d", id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch certificate %d: %w", id, err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch certificate %d: %w", id, ctsearch.RequestError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("certificate %d: %w", id, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ctsearch.NewStatusError(resp)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// ParseCertificatePEM decodes a PEM (or raw DER) certificate
+func ParseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// InspectCertificate extracts key, signature, CT and revocation details from a parsed certificate
+func InspectCertificate(id int64, cert *x509.Certificate) CertificateInfo {
+	info := CertificateInfo{
+		ID:                    id,
+		SignatureAlgorithm:    cert.SignatureAlgorithm.String(),
+		Purpose:               CertificatePurpose(cert),
+		OCSPServers:           cert.OCSPServer,
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		SCTs:                  make([]SCT, 0),
+		Weaknesses:            make([]string, 0),
+		Certificate:           cert,
+	}
+
+	key := InspectKey(cert.PublicKey, cert.PublicKeyAlgorithm)
+	info.KeyAlgorithm = key.Algorithm
+	info.KeySize = key.Size
+	info.Curve = key.Curve
+	info.Weaknesses = append(info.Weaknesses, key.Weaknesses...)
+
+	if weakness := SignatureWeakness(cert.SignatureAlgorithm); weakness != "" {
+		info.Weaknesses = append(info.Weaknesses, weakness)
+	}
+
+	// The 398-day limit is for TLS server certificates; S/MIME and code signing have their own rules
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if info.Purpose == ctsearch.PurposeTLS && !cert.NotBefore.Before(baselineStartDate) && lifetime > 398*24*time.Hour {
+		info.Weaknesses = append(info.Weaknesses, fmt.Sprintf("valid for %d days (maximum is 398)", int(lifetime.Hours()/24)))
+	}
+
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidPrecertPoison):
+			info.IsPrecertificate = true
+		case ext.Id.Equal(oidSCTList):
+			info.SCTs, _ = parseSCTs(ext.Value)
+			info.SCTCount = len(info.SCTs)
+		}
+	}
+
+	return info
+}
+
+// CertificatePurpose works out what a certificate is for from its extended key usages
+// Server authentication wins, since a certificate that can serve TLS is judged as one; a leaf without
+// any extended key usage is treated as TLS when it names hosts, as browsers accept it
+func CertificatePurpose(cert *x509.Certificate) string {
+	usages := make(map[x509.ExtKeyUsage]bool)
+	for _, usage := range cert.ExtKeyUsage {
+		usages[usage] = true
+	}
+	documentSigning := false
+	for _, oid := range cert.UnknownExtKeyUsage {
+		for _, known := range documentSigningEKUs {
+			if oid.Equal(known) {
+				documentSigning = true
+			}
+		}
+	}
+
+	switch {
+	case usages[x509.ExtKeyUsageServerAuth] || usages[x509.ExtKeyUsageAny]:
+		return ctsearch.PurposeTLS
+	case usages[x509.ExtKeyUsageCodeSigning]:
+		return ctsearch.PurposeCodeSigning
+	case documentSigning:
+		return ctsearch.PurposeDocumentSigning
+	case usages[x509.ExtKeyUsageEmailProtection]:
+		return ctsearch.PurposeEmail
+	case usages[x509.ExtKeyUsageClientAuth]:
+		return ctsearch.PurposeClientAuth
+	case len(usages) == 0 && len(cert.UnknownExtKeyUsage) == 0 && (len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0):
+		return ctsearch.PurposeTLS
+	}
+	return ctsearch.PurposeOther
+}
+
+// KeyInfo describes a public key
+type KeyInfo struct {
+	Algorithm  string   `json:"algorithm"` // "RSA", "ECDSA" or "Ed25519"
+	Size       int      `json:"size"`      // Bits (modulus size for RSA, curve size for ECDSA)
+	Curve      string   `json:"curve,omitempty"`
+	Weaknesses []string `json:"weaknesses"`
+}
+
+// InspectKey describes a public key from a certificate or CSR, flagging keys that are too small
+// fallback names keys of other types
+func InspectKey(key crypto.PublicKey, fallback x509.PublicKeyAlgorithm) KeyInfo {
+	info := KeyInfo{Weaknesses: make([]string, 0)}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		info.Algorithm = "RSA"
+		info.Size = key.N.BitLen()
+		if info.Size < 2048 {
+			info.Weaknesses = append(info.Weaknesses, fmt.Sprintf("RSA key is only %d bits", info.Size))
+		}
+	case *ecdsa.PublicKey:
+		info.Algorithm = "ECDSA"
+		info.Size = key.Curve.Params().BitSize
+		info.Curve = key.Curve.Params().Name
+		if info.Size < 256 {
+			info.Weaknesses = append(info.Weaknesses, fmt.Sprintf("ECDSA curve %s is too small", info.Curve))
+		}
+	case ed25519.PublicKey:
+		info.Algorithm = "Ed25519"
+		info.Size = 256
+	default:
+		info.Algorithm = fallback.String()
+	}
+
+	return info
+}
+
+// SignatureWeakness describes why a signature algorithm is too weak to trust, or returns "" if it's fine
+func SignatureWeakness(algorithm x509.SignatureAlgorithm) string {
+	switch algorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return fmt.Sprintf("signed with %s", algorithm)
+	}
+	return ""
+}
+
+// FetchCertificateInfo downloads, parses and inspects a certificate by crt.sh ID
+func FetchCertificateInfo(ctx context.Context, id int64) (CertificateInfo, error) {
+	data, err := FetchPEM(ctx, id)
+	if err != nil {
+		return CertificateInfo{ID: id}, err
+	}
+
+	cert, err := ParseCertificatePEM(data)
+	if err != nil {
+		return CertificateInfo{ID: id}, err
+	}
+
+	return InspectCertificate(id, cert), nil
+}
+
+// RequiredSCTs returns how many SCTs the Chrome and Apple CT policies require
+// for a certificate with the given lifetime
+func RequiredSCTs(notBefore, notAfter time.Time) int {
+	if notAfter.Sub(notBefore) <= 180*24*time.Hour {
+		return 2
+	}
+	return 3
+}
+
+// parseSCTs reads the entries in an RFC 6962 SignedCertificateTimestampList extension
+// The extension value is an OCTET STRING wrapping a TLS-encoded list of length-prefixed SCTs; the
+// SCTs read before a malformed one are returned with the error
+func parseSCTs(value []byte) ([]SCT, error) {
+	scts := make([]SCT, 0)
+	var list []byte
+	if _, err := asn1.Unmarshal(value, &list); err != nil {
+		return scts, err
+	}
+	if len(list) < 2 {
+		return scts, errors.New("SCT list too short")
+	}
+
+	total := int(binary.BigEndian.Uint16(list))
+	list = list[2:]
+	if total > len(list) {
+		return scts, errors.New("SCT list truncated")
+	}
+	list = list[:total]
+
+	for len(list) >= 2 {
+		size := int(binary.BigEndian.Uint16(list))
+		if 2+size > len(list) {
+			return scts, errors.New("SCT truncated")
+		}
+		sct, err := parseSCT(list[2 : 2+size])
+		if err != nil {
+			return scts, err
+		}
+		scts = append(scts, sct)
+		list = list[2+size:]
+	}
+
+	return scts, nil
+}
+
+// parseSCT reads the log ID and timestamp at the start of a v1 SCT: a version byte, the 32-byte
+// log ID and the timestamp in milliseconds; the extensions and signature after them aren't needed
+func parseSCT(data []byte) (SCT, error) {
+	if len(data) < 1+32+8 {
+		return SCT{}, errors.New("SCT too short")
+	}
+	if data[0] != 0 {
+		return SCT{}, fmt.Errorf("unknown SCT version %d", data[0])
+	}
+	return SCT{
+		LogID:     base64.StdEncoding.EncodeToString(data[1:33]),
+		Timestamp: time.UnixMilli(int64(binary.BigEndian.Uint64(data[33:41]))).UTC(),
+	}, nil
+}
+
+// FetchCertificateInfos inspects several certificates with a small pool of workers
+// Certificates that couldn't be fetched or parsed, or weren't reached before ctx was cancelled, are returned in the error map
+func FetchCertificateInfos(ctx context.Context, ids []int64) (map[int64]CertificateInfo, map[int64]error) {
+	infos := make(map[int64]CertificateInfo)
+	errs := make(map[int64]error)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan int64)
+
+	for i := 0; i < fetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				info, err := FetchCertificateInfo(ctx, id)
+
+				mu.Lock()
+				if err != nil {
+					errs[id] = err
+				} else {
+					infos[id] = info
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+
+	return infos, errs
+}
+
+// PreferredEntry picks the log entry to inspect for a certificate group
+// The final certificate carries the embedded SCTs, so prefer it over the precertificate
+func PreferredEntry(group ctsearch.CertificateGroup) ctsearch.Certificate {
+	for _, entry := range group.Entries {
+		if entry.EntryType == "Leaf Certificate" {
+			return entry
+		}
+	}
+	return group.Entries[0]
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// PQCData holds data to pass to the post-quantum readiness template
+type PQCData struct {
+	Domain  string
+	Report  services.PQCReport
+	Error   string
+	Invalid *services.DomainError
+
+	status int // HTTP status for API responses
+}
+
+// pqcHandler shows a domain's algorithms against post-quantum migration deadlines
+func pqcHandler(w http.ResponseWriter, r *http.Request) {
+	data := runPQCReadiness(r.Context(), r.URL.Query())
+
+	tmpl, err := parseTemplate("pqc.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiPQCHandler returns the post-quantum readiness report as JSON
+func apiPQCHandler(w http.ResponseWriter, r *http.Request) {
+	data := runPQCReadiness(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runPQCReadiness searches CT for the domain, then inspects its active certificates and probes its hosts
+func runPQCReadiness(ctx context.Context, query url.Values) PQCData {
+	search := runSearch(ctx, query)
+	data := PQCData{Domain: search.Domain, Error: search.Error, Invalid: search.Invalid, status: search.status}
+	if data.Error != "" {
+		return data
+	}
+
+	data.Report = services.CheckPQCReadiness(ctx, search.Domain, search.groups, time.Now())
+	return data
+}
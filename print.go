@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// printRecentDays is how far back the print view's summary counts newly issued certificates
+const printRecentDays = 30
+
+// PrintData holds data to pass to the printable results template
+type PrintData struct {
+	SearchData
+	Summary     services.DomainSummary // Active, expiring and recently issued certificates
+	RecentDays  int
+	GeneratedAt time.Time
+}
+
+// printHandler shows every certificate a search finds on one page laid out for printing, without navigation
+// and with a summary up front, for change reviews
+func printHandler(w http.ResponseWriter, r *http.Request) {
+	data := PrintData{SearchData: runSearch(r.Context(), r.URL.Query()), RecentDays: printRecentDays, GeneratedAt: time.Now()}
+	if data.Error == "" {
+		since := data.GeneratedAt.AddDate(0, 0, -printRecentDays)
+		data.Summary = services.SummarizeDomain(data.Domain, data.groups, nil, since, data.GeneratedAt)
+	}
+
+	tmpl, err := parseTemplate("print.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	executeStreamed(w, r, tmpl, data)
+}
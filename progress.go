@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+const (
+	// slowSearchAfter is how long the results page waits silently before showing a search's progress
+	slowSearchAfter = 5 * time.Second
+
+	// progressInterval is how often a slow search's progress is updated
+	progressInterval = 5 * time.Second
+
+	// finishedSearchTTL is how long a slow search's results are kept for the page to pick them up
+	finishedSearchTTL = time.Minute
+)
+
+// maxSearchTimeout is the longest a crt.sh search may take, from -search-timeout
+var maxSearchTimeout = 2 * time.Minute
+
+// finishedSearches holds slow searches' results by token until the progress page sends the browser to them
+var finishedSearches = struct {
+	sync.Mutex
+	results map[string]finishedSearch
+}{results: make(map[string]finishedSearch)}
+
+// finishedSearch is one slow search's results, for the user who ran it
+type finishedSearch struct {
+	username   string
+	data       SearchData
+	finishedAt time.Time
+}
+
+// ProgressData holds data to pass to the progress template while a slow search runs
+type ProgressData struct {
+	Domain    string
+	Elapsed   int // Seconds
+	Remaining int // Seconds until the search gives up
+	Estimate  int // Certificates the last search found, when the domain is large
+	URL       template.URL
+}
+
+// searchTimeout reads a ?timeout= in seconds; empty or more than maxSearchTimeout is maxSearchTimeout
+func searchTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return maxSearchTimeout, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q, use a number of seconds", value)
+	}
+	return min(time.Duration(seconds)*time.Second, maxSearchTimeout), nil
+}
+
+// searchWithProgress runs the search for the results page
+// A search still running after slowSearchAfter gets a progress page that counts up until it finishes,
+// then sends the browser back to /search to show the results; answered is true when that page was sent
+func searchWithProgress(w http.ResponseWriter, r *http.Request) (data SearchData, answered bool) {
+	query := r.URL.Query()
+	if token := query.Get("result"); token != "" {
+		if data, ok := takeFinishedSearch(token, currentUsername(r)); ok {
+			return data, false
+		}
+		// Expired or someone else's; search again
+		query.Del("result")
+	}
+
+	started := time.Now()
+	done := make(chan SearchData, 1)
+	go func() {
+		done <- runSearch(r.Context(), query)
+	}()
+
+	timer := time.NewTimer(slowSearchAfter)
+	defer timer.Stop()
+	select {
+	case data := <-done:
+		return data, false
+	case <-timer.C:
+	}
+
+	// Without streaming there's nothing to show until the search is over
+	flusher, canFlush := w.(http.Flusher)
+	tmpl, err := parseTemplate("progress.html")
+	if !canFlush || err != nil {
+		return <-done, false
+	}
+	tmpl = tmpl.Funcs(pageFuncs(r))
+
+	timeout, _ := searchTimeout(query.Get("timeout"))
+	progress := ProgressData{Domain: strings.TrimSpace(query.Get("domain"))}
+	if domain, err := services.NormalizeDomain(progress.Domain); err == nil {
+		if estimate, ok := services.EstimateResults(domain); ok && estimate.Large {
+			progress.Estimate = estimate.Certificates
+		}
+	}
+	update := func(name string) {
+		progress.Elapsed = int(time.Since(started).Seconds())
+		progress.Remaining = max(int((timeout - time.Since(started)).Seconds()), 0)
+		tmpl.ExecuteTemplate(w, name, progress)
+		flusher.Flush()
+	}
+	update("progressStart")
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-done:
+			query.Set("result", keepFinishedSearch(currentUsername(r), data))
+			progress.URL = pathWithQuery("/search", query)
+			update("progressDone")
+			return data, true
+		case <-ticker.C:
+			update("progressTick")
+		}
+	}
+}
+
+// keepFinishedSearch holds a slow search's results for finishedSearchTTL, returning the token to pick them up with
+func keepFinishedSearch(username string, data SearchData) string {
+	token, err := randomToken()
+	if err != nil {
+		// The page will search again instead
+		return ""
+	}
+
+	finishedSearches.Lock()
+	defer finishedSearches.Unlock()
+	now := time.Now()
+	for old, finished := range finishedSearches.results {
+		if now.Sub(finished.finishedAt) >= finishedSearchTTL {
+			delete(finishedSearches.results, old)
+		}
+	}
+	finishedSearches.results[token] = finishedSearch{username: username, data: data, finishedAt: now}
+	return token
+}
+
+// takeFinishedSearch returns a slow search's results once, to the user who ran it
+func takeFinishedSearch(token, username string) (SearchData, bool) {
+	finishedSearches.Lock()
+	defer finishedSearches.Unlock()
+
+	finished, ok := finishedSearches.results[token]
+	if !ok || finished.username != username || time.Since(finished.finishedAt) >= finishedSearchTTL {
+		return SearchData{}, false
+	}
+	delete(finishedSearches.results, token)
+	return finished.data, true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// proxyAuth trusts usernames from an authenticating reverse proxy, nil unless the server was started with -proxy-user-header
+var proxyAuth *services.ProxyAuth
+
+// proxyLogoutURL is where logging out sends proxy-authenticated users, so the proxy ends its own session
+var proxyLogoutURL string
+
+// proxyUser returns the account for the user a trusted proxy says made the request
+// Accounts are created on first sight and their admin flag follows the proxy's groups, like single sign-on
+func proxyUser(r *http.Request) (services.User, bool) {
+	if proxyAuth == nil {
+		return services.User{}, false
+	}
+	identity, ok := proxyAuth.Identity(r)
+	if !ok {
+		return services.User{}, false
+	}
+
+	existing, known := users.Get(strings.ToLower(identity.Username))
+	user, err := users.UpsertSSO(identity.Username, identity.Admin)
+	if err != nil {
+		log.Printf("proxy auth: %v", err)
+		return services.User{}, false
+	}
+	if !known {
+		auditActorAction(r, user.Username, "user.login", user.Username, fmt.Sprintf("proxy, groups=%s, admin=%t", strings.Join(identity.Groups, ","), identity.Admin))
+	} else if existing.Admin != user.Admin {
+		auditActorAction(r, user.Username, "user.role_change", user.Username, fmt.Sprintf("proxy, groups=%s, admin=%t", strings.Join(identity.Groups, ","), identity.Admin))
+	}
+	return user, true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// requestIDHeader carries a request's ID, from a proxy that set one or made up here, so a visitor's
+// report of a failed page can be matched with the log
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength caps an ID taken from a proxy, which ends up in the log
+const maxRequestIDLength = 64
+
+// panicsRecovered counts handler panics since the server started, for the health endpoint
+var panicsRecovered atomic.Int64
+
+// ErrorPageData holds data to pass to the error template
+type ErrorPageData struct {
+	RequestID string
+}
+
+// recoverPanics turns a panicking handler into a logged stack trace and a friendly 500, rather than
+// a connection closed on a blank page; every response gets an X-Request-Id for the log to be searched by
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+		tracked := &startedWriter{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Meant to drop the connection
+				panic(p)
+			}
+			panicsRecovered.Add(1)
+			log.Printf("panic: %s %s (request %s): %v\n%s", r.Method, r.URL.Path, id, p, debug.Stack())
+			writePanicResponse(tracked, r, id)
+		}()
+		next.ServeHTTP(tracked, r)
+	})
+}
+
+// requestID returns the ID a proxy gave the request, or a new one
+func requestID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get(requestIDHeader)); id != "" && len(id) <= maxRequestIDLength &&
+		!strings.ContainsFunc(id, unicode.IsControl) {
+		return id
+	}
+	id, err := randomToken()
+	if err != nil {
+		return "unknown"
+	}
+	return id[:16]
+}
+
+// writePanicResponse tells the visitor the request failed, as JSON for the API and a page otherwise
+// When the handler had already started its answer, a note is added to the end of a page instead
+func writePanicResponse(w *startedWriter, r *http.Request, id string) {
+	message := tr(r, "Something went wrong on our side, please try again")
+	if w.started {
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			fmt.Fprintf(w, "<p><strong>%s</strong> %s %s</p>", html.EscapeString(message), html.EscapeString(tr(r, "Request ID:")), html.EscapeString(id))
+		}
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": message, "requestId": id})
+		return
+	}
+	tmpl, err := parseTemplate("error.html")
+	if err != nil {
+		http.Error(w, message, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	tmpl.Funcs(pageFuncs(r)).Execute(w, ErrorPageData{RequestID: id})
+}
+
+// startedWriter notes whether a handler has started its answer, which can't be replaced after that
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush passes streamed output, such as the progress page, straight through
+func (w *startedWriter) Flush() {
+	w.started = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *startedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
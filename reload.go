@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// analyzersPath is the -analyzers file, read again on reload
+var analyzersPath string
+
+// rateLimitsPath is the -rate-limits-file file, read again on reload over the -rate-limits and
+// -rate-limit-wait flags kept in rateLimitFlags and rateLimitWait
+var (
+	rateLimitsPath string
+	rateLimitFlags map[string]float64
+	rateLimitWait  time.Duration
+)
+
+// settingsPath is the -settings-file file, read again on reload over -expected-issuers and the credentials
+// from the environment kept in settingsDefaults
+var (
+	settingsPath     string
+	settingsDefaults services.SettingsFile
+)
+
+// reloadMu stops two reloads from running at once
+var reloadMu sync.Mutex
+
+// ReloadResult is what a reload did, returned by /api/v1/admin/reload
+type ReloadResult struct {
+	Reloaded []string `json:"reloaded"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// reloadConfig re-reads the watchlist, teams, saved searches, notification preferences, users, analyzers,
+// rate limits, and the expected issuers and integration credentials
+// Each keeps its current contents if its file can't be read or parsed, and in-flight requests and the
+// monitor carry on with whichever version they see, since the stores are swapped under their own locks
+func reloadConfig() ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	result := ReloadResult{Reloaded: make([]string, 0)}
+	reload := func(name string, fn func() error) {
+		if err := fn(); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		result.Reloaded = append(result.Reloaded, name)
+	}
+
+	reload("watchlist", watchlist.Reload)
+	reload("teams", teams.Reload)
+	reload("saved searches", savedSearches.Reload)
+	reload("notifications", notificationPrefs.Reload)
+	if users != nil {
+		reload("users", users.Reload)
+	}
+	if analyzersPath != "" {
+		reload("analyzers", func() error {
+			configured, err := services.LoadAnalyzers(analyzersPath)
+			if err != nil {
+				return err
+			}
+			services.SetConfiguredAnalyzers(configured)
+			return nil
+		})
+	}
+	if rateLimitsPath != "" {
+		reload("rate limits", applyRateLimits)
+	}
+	if settingsPath != "" {
+		reload("settings", applySettings)
+	}
+
+	return result
+}
+
+// applyRateLimits sets the outbound rate limits from the flags, with -rate-limits-file's on top
+// Nothing changes if the file can't be read or parsed
+func applyRateLimits() error {
+	rates := make(map[string]float64, len(rateLimitFlags))
+	for source, perSecond := range rateLimitFlags {
+		rates[source] = perSecond
+	}
+	wait := rateLimitWait
+	if rateLimitsPath != "" {
+		fileRates, fileWait, err := services.LoadRateLimits(rateLimitsPath)
+		if err != nil {
+			return err
+		}
+		for source, perSecond := range fileRates {
+			rates[source] = perSecond
+		}
+		if fileWait > 0 {
+			wait = fileWait
+		}
+	}
+	services.SetRateLimits(rates, wait)
+	return nil
+}
+
+// loadSettings is the expected issuers and integration credentials from the flags and environment, with
+// -settings-file's on top
+func loadSettings() (services.SettingsFile, error) {
+	settings := settingsDefaults
+	if settingsPath == "" {
+		return settings, nil
+	}
+	file, err := services.LoadSettings(settingsPath)
+	if err != nil {
+		return settings, err
+	}
+	if file.ExpectedIssuers != nil {
+		settings.ExpectedIssuers = file.ExpectedIssuers
+	}
+	if file.SplunkHECToken != "" {
+		settings.SplunkHECToken = file.SplunkHECToken
+	}
+	if file.JiraAPIToken != "" {
+		settings.JiraAPIToken = file.JiraAPIToken
+	}
+	if file.IssuesToken != "" {
+		settings.IssuesToken = file.IssuesToken
+	}
+	if file.SMTPPassword != "" {
+		settings.SMTPPassword = file.SMTPPassword
+	}
+	if file.OIDCClientSecret != "" {
+		settings.OIDCClientSecret = file.OIDCClientSecret
+	}
+	return settings, nil
+}
+
+// applySettings sets the expected issuers and gives the configured sinks, issue trackers, mail and single
+// sign-on the current credentials; deliveries and logins under way finish with the ones they started with
+// Integrations are only set up at startup, so a reload changes their credentials but can't add or remove one
+// Nothing changes if -settings-file can't be read or parsed
+func applySettings() error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	services.SetExpectedIssuers(settings.ExpectedIssuers)
+	for _, sink := range eventSinks {
+		if splunk, ok := sink.(*services.SplunkSink); ok {
+			splunk.SetToken(settings.SplunkHECToken)
+		}
+	}
+	if jiraTracker != nil {
+		jiraTracker.SetToken(settings.JiraAPIToken)
+	}
+	if repoIssues != nil {
+		repoIssues.SetToken(settings.IssuesToken)
+	}
+	if oidcProvider != nil {
+		oidcProvider.SetClientSecret(settings.OIDCClientSecret)
+	}
+	smtpPassword.Lock()
+	smtpPassword.value = settings.SMTPPassword
+	smtpPassword.Unlock()
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process gets SIGHUP (systemctl reload)
+func reloadOnSIGHUP() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		notifySystemd("RELOADING=1")
+		result := reloadConfig()
+		log.Printf("SIGHUP: %s", reloadDetail(result))
+		auditSystemAction("config.reload", "", reloadDetail(result))
+		notifySystemd("READY=1")
+	}
+}
+
+// apiReloadHandler reloads the configuration on POST, for admins
+func apiReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if !requireAdminJSON(w, r) {
+		return
+	}
+
+	result := reloadConfig()
+	log.Printf("%s %s: %s", r.Method, r.URL.Path, reloadDetail(result))
+	auditAction(r, "config.reload", "", reloadDetail(result))
+
+	status := http.StatusOK
+	if len(result.Errors) > 0 {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, result)
+}
+
+// reloadDetail summarizes a reload for the audit log
+func reloadDetail(result ReloadResult) string {
+	detail := "reloaded " + strings.Join(result.Reloaded, ", ")
+	if len(result.Errors) > 0 {
+		detail += "; failed " + strings.Join(result.Errors, "; ")
+	}
+	return detail
+}
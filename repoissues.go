@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// repoIssues files GitHub or GitLab issues for watched domains' expiring certificates; nil when not configured
+var repoIssues *services.RepoIssues
+
+// syncRepoIssues opens and closes a domain's issues in the background after a check, auditing what it does
+func syncRepoIssues(domain string, groups []services.CertificateGroup, now time.Time) {
+	if repoIssues == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		defer cancel()
+		opened, closed, err := repoIssues.Sync(ctx, domain, groups, publicURL, now)
+		for _, issue := range opened {
+			auditSystemAction("issues.opened", issue.URL, fmt.Sprintf("%s: certificate for %s expiring", domain, issue.Hostname))
+		}
+		for _, issue := range closed {
+			auditSystemAction("issues.closed", issue.URL, fmt.Sprintf("%s: certificate for %s renewed", domain, issue.Hostname))
+		}
+		if err != nil {
+			log.Printf("issues: %s: %v", domain, err)
+			auditSystemAction("issues.failed", domain, err.Error())
+		}
+	}()
+}
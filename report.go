@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// pdfCommand converts HTML on stdin to PDF on stdout, e.g. "wkhtmltopdf --quiet - -"
+// PDF reports are disabled when it is empty
+var pdfCommand string
+
+// ReportData holds data to pass to the report template
+type ReportData struct {
+	Domain      string
+	Report      services.DomainReport
+	Error       string
+	StaleSince  *time.Time // When the certificates were found, when crt.sh failed and older ones are used
+	StaleReason string
+}
+
+// reportHandler renders the standalone assessment report (?format=pdf for PDF)
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	search := runSearch(r.Context(), r.URL.Query())
+	data := ReportData{Domain: search.Domain, Error: search.Error, StaleSince: search.StaleSince, StaleReason: search.StaleReason}
+	if data.Error == "" {
+		data.Report = services.BuildDomainReport(r.Context(), search.Domain, search.groups, time.Now())
+	}
+
+	tmpl, err := parseTemplate("report.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	// PDFs are always light
+	if r.URL.Query().Get("format") != "pdf" {
+		tmpl.Funcs(pageFuncs(r))
+	}
+
+	// Render to a buffer so it can be converted to PDF
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, data); err != nil {
+		http.Error(w, tr(r, "Could not render report"), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") != "pdf" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html.Bytes())
+		return
+	}
+
+	if pdfCommand == "" {
+		http.Error(w, tr(r, "PDF reports are not enabled on this server"), http.StatusNotImplemented)
+		return
+	}
+
+	pdf, err := convertToPDF(html.Bytes())
+	if err != nil {
+		log.Printf("report: %v", err)
+		http.Error(w, tr(r, "Could not generate PDF"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+services.BaseDomain(data.Domain)+`-certificates.pdf"`)
+	w.Write(pdf)
+}
+
+// apiReportHandler returns the assessment report as JSON
+func apiReportHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSearch(r.Context(), r.URL.Query())
+	setRetryAfter(w, data.RetryAfter)
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, services.BuildDomainReport(r.Context(), data.Domain, data.groups, time.Now()))
+}
+
+// convertToPDF pipes the HTML through pdfCommand
+func convertToPDF(html []byte) ([]byte, error) {
+	args := strings.Fields(pdfCommand)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(html)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	pdf, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pdf conversion failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return pdf, nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Alert types
+const (
+	AlertNewSubdomain = "new_subdomain"          // A hostname appeared in CT for the first time
+	AlertRateLimit    = "letsencrypt_rate_limit" // A Let's Encrypt rate limit is nearly or entirely used up
+)
+
+// AlertTypes describes each alert type, for people choosing which ones they want
+var AlertTypes = map[string]string{
+	AlertNewSubdomain: "A hostname under a watched domain appears in CT for the first time",
+	AlertRateLimit:    "A watched domain nears a Let's Encrypt rate limit, e.g. automation re-issuing the same certificate",
+}
+
+// Alert is something about a watched domain that someone should look at
+type Alert struct {
+	ID             string     `json:"id,omitempty"` // Given when the watchlist stores it; alerts stored before IDs existed have none
+	Type           string     `json:"type"`
+	Domain         string     `json:"domain"`
+	Subject        string     `json:"subject"`            // What the alert is about, e.g. the new hostname
+	Severity       string     `json:"severity,omitempty"` // Set on alerts that can escalate, e.g. a rate limit going from warning to exceeded
+	Message        string     `json:"message"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	AcknowledgedBy string     `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+}
+
+// DetectNewSubdomains returns an alert for every hostname in the inventory that is not in known
+// known maps hostnames to when we first saw them
+func DetectNewSubdomains(domain string, known map[string]time.Time, inventory SubdomainInventory, now time.Time) []Alert {
+	alerts := make([]Alert, 0)
+
+	for _, subdomain := range inventory.Subdomains {
+		for _, host := range subdomain.Hosts {
+			if _, exists := known[host.Name]; exists {
+				continue
+			}
+			alerts = append(alerts, Alert{
+				Type:      AlertNewSubdomain,
+				Domain:    domain,
+				Subject:   host.Name,
+				Message:   fmt.Sprintf("New hostname %s seen in CT (first certificate issued %s)", host.Name, host.FirstSeen.Format("2006-01-02")),
+				CreatedAt: now,
+			})
+		}
+	}
+
+	// Keep the output stable for logs and the API
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Subject < alerts[j].Subject
+	})
+
+	return alerts
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Analyzer is a custom check on a certificate, for an organization's own rules
+// (naming conventions, approved key types...) on top of the built-in report findings
+type Analyzer interface {
+	// Name identifies the analyzer and is the Check of each finding it returns
+	Name() string
+	// Analyze returns findings for one currently valid certificate; it must be safe to call concurrently
+	Analyze(cert AnalyzedCertificate) []Finding
+}
+
+// AnalyzedCertificate is what an analyzer gets to look at
+type AnalyzedCertificate struct {
+	Domain string           // The domain being reported on
+	Group  CertificateGroup // What crt.sh knows: names, issuer, validity and log entries
+	Names  []string         // Normalized names on the certificate
+	Info   *CertificateInfo // Details from the downloaded certificate, with Info.Certificate parsed; nil when it wasn't inspected
+}
+
+// analyzers are the registered analyzers: compiled-in ones, and ones built from the analyzers file
+var analyzers = struct {
+	mu         sync.RWMutex
+	registered []Analyzer
+	configured []Analyzer
+}{}
+
+// RegisterAnalyzer adds an analyzer to every domain report, usually from an init function
+// It panics if the name is empty or already taken, like http.Handle
+func RegisterAnalyzer(analyzer Analyzer) {
+	analyzers.mu.Lock()
+	defer analyzers.mu.Unlock()
+
+	name := analyzer.Name()
+	if name == "" {
+		panic("services: analyzer has no name")
+	}
+	for _, existing := range analyzers.registered {
+		if existing.Name() == name {
+			panic(fmt.Sprintf("services: analyzer %q registered twice", name))
+		}
+	}
+	analyzers.registered = append(analyzers.registered, analyzer)
+}
+
+// SetConfiguredAnalyzers replaces the analyzers loaded from the analyzers file
+func SetConfiguredAnalyzers(list []Analyzer) {
+	analyzers.mu.Lock()
+	defer analyzers.mu.Unlock()
+
+	analyzers.configured = list
+}
+
+// Analyzers lists every analyzer, compiled-in ones first
+func Analyzers() []Analyzer {
+	analyzers.mu.RLock()
+	defer analyzers.mu.RUnlock()
+
+	list := make([]Analyzer, 0, len(analyzers.registered)+len(analyzers.configured))
+	list = append(list, analyzers.registered...)
+	return append(list, analyzers.configured...)
+}
+
+// runAnalyzers runs every analyzer on a certificate
+// Findings default to the analyzer's name and the certificate, and a panicking analyzer becomes a finding
+// rather than breaking the report
+func runAnalyzers(cert AnalyzedCertificate) []Finding {
+	subject := fmt.Sprintf("%s (serial %s)", cert.Group.CommonName, cert.Group.SerialNumber)
+	findings := make([]Finding, 0)
+	for _, analyzer := range Analyzers() {
+		for _, finding := range analyze(analyzer, cert) {
+			if finding.Check == "" {
+				finding.Check = analyzer.Name()
+			}
+			if finding.Subject == "" {
+				finding.Subject = subject
+			}
+			if _, known := severityRank[finding.Severity]; !known {
+				finding.Severity = SeverityWarning
+			}
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// analyze runs one analyzer, turning a panic into a finding
+func analyze(analyzer Analyzer, cert AnalyzedCertificate) (findings []Finding) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			findings = []Finding{{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("analyzer failed: %v", recovered),
+			}}
+		}
+	}()
+	return analyzer.Analyze(cert)
+}
+
+// AnalyzerConfig describes one analyzer in the analyzers file
+type AnalyzerConfig struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`               // "name-pattern" or "key-type"
+	Severity string   `json:"severity,omitempty"` // Defaults to warning
+	Pattern  string   `json:"pattern,omitempty"`  // name-pattern: regular expression every name must match
+	KeyTypes []string `json:"keyTypes,omitempty"` // key-type: approved keys, e.g. "RSA-2048", "ECDSA-256", "Ed25519"
+	Message  string   `json:"message,omitempty"`  // Replaces the default finding message
+}
+
+// LoadAnalyzers builds the analyzers described by a JSON file holding a list of AnalyzerConfig
+func LoadAnalyzers(path string) ([]Analyzer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analyzers: %w", err)
+	}
+
+	var configs []AnalyzerConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse analyzers: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	list := make([]Analyzer, 0, len(configs))
+	for _, config := range configs {
+		if seen[config.Name] {
+			return nil, fmt.Errorf("analyzer %q is defined twice", config.Name)
+		}
+		seen[config.Name] = true
+
+		analyzer, err := NewConfiguredAnalyzer(config)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, analyzer)
+	}
+	return list, nil
+}
+
+// NewConfiguredAnalyzer builds one of the built-in configurable analyzers
+func NewConfiguredAnalyzer(config AnalyzerConfig) (Analyzer, error) {
+	if config.Name == "" {
+		return nil, errors.New("analyzer needs a name")
+	}
+	if config.Severity == "" {
+		config.Severity = SeverityWarning
+	}
+	if _, known := severityRank[config.Severity]; !known {
+		return nil, fmt.Errorf("analyzer %q: unknown severity %q", config.Name, config.Severity)
+	}
+
+	switch config.Type {
+	case "name-pattern":
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil || config.Pattern == "" {
+			return nil, fmt.Errorf("analyzer %q: invalid pattern %q", config.Name, config.Pattern)
+		}
+		return namePatternAnalyzer{config: config, pattern: pattern}, nil
+	case "key-type":
+		if len(config.KeyTypes) == 0 {
+			return nil, fmt.Errorf("analyzer %q: no approved key types", config.Name)
+		}
+		return keyTypeAnalyzer{config: config}, nil
+	}
+	return nil, fmt.Errorf("analyzer %q: unknown type %q, use name-pattern or key-type", config.Name, config.Type)
+}
+
+// namePatternAnalyzer flags certificates with names that break a naming convention
+type namePatternAnalyzer struct {
+	config  AnalyzerConfig
+	pattern *regexp.Regexp
+}
+
+func (a namePatternAnalyzer) Name() string {
+	return a.config.Name
+}
+
+func (a namePatternAnalyzer) Analyze(cert AnalyzedCertificate) []Finding {
+	bad := make([]string, 0)
+	for _, name := range cert.Names {
+		if !a.pattern.MatchString(name) {
+			bad = append(bad, name)
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	sort.Strings(bad)
+
+	message := a.config.Message
+	if message == "" {
+		message = "names don't follow the naming convention"
+	}
+	return []Finding{{
+		Severity: a.config.Severity,
+		Message:  fmt.Sprintf("%s: %s", message, strings.Join(bad, ", ")),
+	}}
+}
+
+// keyTypeAnalyzer flags inspected certificates whose key isn't on the approved list
+type keyTypeAnalyzer struct {
+	config AnalyzerConfig
+}
+
+func (a keyTypeAnalyzer) Name() string {
+	return a.config.Name
+}
+
+func (a keyTypeAnalyzer) Analyze(cert AnalyzedCertificate) []Finding {
+	if cert.Info == nil {
+		return nil
+	}
+	key := KeyType(*cert.Info)
+	for _, approved := range a.config.KeyTypes {
+		if strings.EqualFold(approved, key) {
+			return nil
+		}
+	}
+
+	message := a.config.Message
+	if message == "" {
+		message = "key type is not approved"
+	}
+	return []Finding{{
+		Severity: a.config.Severity,
+		Message:  fmt.Sprintf("%s: %s", message, key),
+	}}
+}
+
+// KeyType names a certificate's key the way key-type analyzers list them, e.g. "RSA-2048" or "Ed25519"
+func KeyType(info CertificateInfo) string {
+	if info.KeyAlgorithm == "Ed25519" || info.KeySize == 0 {
+		return info.KeyAlgorithm
+	}
+	return fmt.Sprintf("%s-%d", info.KeyAlgorithm, info.KeySize)
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAuditEntries caps how many audit entries are kept in memory for queries
+// The file keeps every entry
+const maxAuditEntries = 50000
+
+// AuditSystem is the actor for actions the server takes on its own, e.g. scheduled checks
+const AuditSystem = "system"
+
+// AuditEntry records one user or system action
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`  // Username, AuditSystem, or "anonymous" when accounts are disabled
+	Action     string    `json:"action"` // e.g. "search", "watchlist.add", "user.login_failed"
+	Target     string    `json:"target,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+}
+
+// AuditQuery filters audit entries; zero fields match everything
+type AuditQuery struct {
+	Actor  string
+	Action string // Matches the action or, ending in ".", every action under it (e.g. "user.")
+	Text   string // Case-insensitive match on the target or detail
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// AuditLog is an append-only record of actions
+// Entries are appended to a JSON lines file as they happen; it is safe for concurrent use
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File // Nil means keep entries in memory only
+	entries []AuditEntry
+}
+
+// OpenAuditLog loads the existing entries from path and opens it for appending
+func OpenAuditLog(path string) (*AuditLog, error) {
+	l := &AuditLog{path: path, entries: make([]AuditEntry, 0)}
+	if path == "" {
+		return l, nil
+	}
+
+	existing, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				l.append(entry)
+			}
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+
+	l.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return l, nil
+}
+
+// Record adds an entry, stamping it with the current time if it has none
+func (l *AuditLog) Record(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.append(entry)
+	if l.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// Query returns the matching entries, newest first
+func (l *AuditLog) Query(query AuditQuery) []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	text := strings.ToLower(query.Text)
+	results := make([]AuditEntry, 0)
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(results) >= query.Limit {
+			break
+		}
+
+		entry := l.entries[i]
+		if !query.matches(entry, text) {
+			continue
+		}
+		results = append(results, entry)
+	}
+
+	return results
+}
+
+// Export passes every matching entry to emit, oldest first, stopping at the first error emit returns
+// Entries are read from the file as they're emitted, so the whole log can be exported, not just the newest
+// maxAuditEntries, without holding it in memory; entries recorded meanwhile aren't included
+func (l *AuditLog) Export(query AuditQuery, emit func(AuditEntry) error) error {
+	text := strings.ToLower(query.Text)
+	emitted := 0
+	send := func(entry AuditEntry) (bool, error) {
+		if !query.matches(entry, text) {
+			return true, nil
+		}
+		if err := emit(entry); err != nil {
+			return false, err
+		}
+		emitted++
+		return query.Limit == 0 || emitted < query.Limit, nil
+	}
+
+	if l.path == "" {
+		l.mu.Lock()
+		entries := append([]AuditEntry(nil), l.entries...)
+		l.mu.Unlock()
+		for _, entry := range entries {
+			if more, err := send(entry); !more {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Only what was written before the export started, so a line being appended isn't read half done
+	l.mu.Lock()
+	file, err := os.Open(l.path)
+	var size int64
+	if err == nil {
+		var info os.FileInfo
+		if info, err = file.Stat(); err == nil {
+			size = info.Size()
+		} else {
+			file.Close()
+		}
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(file, size))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if more, err := send(entry); !more {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether entry passes the query's filters; text is query.Text lowercased
+func (query AuditQuery) matches(entry AuditEntry, text string) bool {
+	if query.Actor != "" && entry.Actor != query.Actor {
+		return false
+	}
+	if query.Action != "" && entry.Action != query.Action &&
+		!(strings.HasSuffix(query.Action, ".") && strings.HasPrefix(entry.Action, query.Action)) {
+		return false
+	}
+	if text != "" && !strings.Contains(strings.ToLower(entry.Target), text) && !strings.Contains(strings.ToLower(entry.Detail), text) {
+		return false
+	}
+	if !query.Since.IsZero() && entry.Time.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !entry.Time.Before(query.Until) {
+		return false
+	}
+	return true
+}
+
+// append keeps entry in memory, dropping the oldest beyond maxAuditEntries
+// Callers must hold l.mu (or own l exclusively while loading)
+func (l *AuditLog) append(entry AuditEntry) {
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > maxAuditEntries {
+		l.entries = l.entries[len(l.entries)-maxAuditEntries:]
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // Requests go through
+	BreakerOpen     = "open"      // Requests fail straight away until the cooldown ends
+	BreakerHalfOpen = "half-open" // One trial request goes through to see whether the source is back
+)
+
+const (
+	// breakerThreshold is how many failures in a row open a breaker
+	breakerThreshold = 5
+
+	// breakerCooldown is how long an open breaker turns requests away before trying the source again
+	breakerCooldown = 30 * time.Second
+)
+
+// ErrSourceDown is returned without trying a source while its breaker is open
+var ErrSourceDown = errors.New("source is failing, giving it a rest")
+
+// BreakerOpenError is ErrSourceDown for one source, with when it will be tried again
+type BreakerOpenError struct {
+	Source     string
+	RetryAfter time.Duration
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("%s failed %d times in a row, trying again in %s", e.Source, breakerThreshold, e.RetryAfter)
+}
+
+// Unwrap makes the error both ErrSourceDown and ErrUpstreamUnavailable
+func (e *BreakerOpenError) Unwrap() []error {
+	return []error{ErrSourceDown, ErrUpstreamUnavailable}
+}
+
+// Breaker stops requests to a data source for breakerCooldown after breakerThreshold failures in a row,
+// rather than adding to the load of a source that's struggling and making every visitor wait for it
+type Breaker struct {
+	name string
+
+	mu        sync.Mutex
+	failures  int       // In a row
+	openUntil time.Time // Zero while closed
+	trial     bool      // A half-open trial request is under way
+	lastError string
+}
+
+// BreakerStatus is a breaker's state, for the health endpoint
+type BreakerStatus struct {
+	Source    string     `json:"source"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"` // In a row
+	OpenUntil *time.Time `json:"openUntil,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// Breakers for each data source
+var (
+	crtshSearchBreaker   = &Breaker{name: "crt.sh search"}
+	crtshDownloadBreaker = &Breaker{name: "crt.sh certificates"}
+)
+
+// Breakers returns the state of every data source's breaker
+func Breakers(now time.Time) []BreakerStatus {
+	return []BreakerStatus{crtshSearchBreaker.Status(now), crtshDownloadBreaker.Status(now)}
+}
+
+// Allow says whether a request may go to the source now
+// Once the cooldown ends one request is let through, and the rest wait on its outcome
+func (b *Breaker) Allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if now.Before(b.openUntil) || b.trial {
+		return &BreakerOpenError{Source: b.name, RetryAfter: max(b.openUntil.Sub(now).Round(time.Second), time.Second)}
+	}
+	b.trial = true
+	return nil
+}
+
+// Record notes how a request to the source went
+// Only the source failing counts; a cancelled or timed out request, a bad query or an answer of "not found" doesn't
+func (b *Breaker) Record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if !sourceFailed(err) {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.failures >= breakerThreshold {
+		b.openUntil = now.Add(breakerCooldown)
+	}
+}
+
+// Status returns the breaker's state
+func (b *Breaker) Status(now time.Time) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{Source: b.name, State: BreakerClosed, Failures: b.failures, LastError: b.lastError}
+	if !b.openUntil.IsZero() {
+		openUntil := b.openUntil
+		status.OpenUntil = &openUntil
+		status.State = BreakerOpen
+		if !now.Before(openUntil) {
+			status.State = BreakerHalfOpen
+		}
+	}
+	return status
+}
+
+// sourceFailed says whether an error means the source itself is failing
+func sourceFailed(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrSourceDown) {
+		return false
+	}
+	return errors.Is(err, ErrUpstreamTimeout) || errors.Is(err, ErrUpstreamMaintenance) ||
+		errors.Is(err, ErrUpstreamUnavailable)
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// failing is an error that counts as the source failing
+var failing = fmt.Errorf("crt.sh answered 503: %w", ErrUpstreamUnavailable)
+
+func TestBreakerTransitions(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		failures  int   // Failures in a row before the cooldown
+		probe     bool  // Whether a half-open probe goes through once the cooldown ends
+		probeErr  error // How the probe went
+		wantState string
+	}{
+		{name: "below threshold stays closed", failures: breakerThreshold - 1, wantState: BreakerClosed},
+		{name: "threshold opens", failures: breakerThreshold, wantState: BreakerOpen},
+		{name: "half-open probe succeeds", failures: breakerThreshold, probe: true, probeErr: nil, wantState: BreakerClosed},
+		{name: "half-open probe fails", failures: breakerThreshold, probe: true, probeErr: failing, wantState: BreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Breaker{name: "test"}
+			for i := 0; i < tt.failures; i++ {
+				b.Record(failing, start)
+			}
+			if err := b.Allow(start); (err != nil) != (tt.failures >= breakerThreshold) {
+				t.Fatalf("Allow() after %d failures = %v", tt.failures, err)
+			}
+
+			now := start
+			if tt.probe {
+				now = start.Add(breakerCooldown)
+				if got := b.Status(now).State; got != BreakerHalfOpen {
+					t.Fatalf("State after the cooldown = %s, want %s", got, BreakerHalfOpen)
+				}
+				if err := b.Allow(now); err != nil {
+					t.Fatalf("Allow() for the half-open probe = %v, want nil", err)
+				}
+				b.Record(tt.probeErr, now)
+			}
+			if got := b.Status(now).State; got != tt.wantState {
+				t.Errorf("State = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenLetsOneProbeThrough(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := &Breaker{name: "test"}
+	for i := 0; i < breakerThreshold; i++ {
+		b.Record(failing, start)
+	}
+
+	now := start.Add(breakerCooldown)
+	if err := b.Allow(now); err != nil {
+		t.Fatalf("first Allow() after the cooldown = %v, want nil", err)
+	}
+	err := b.Allow(now)
+	var openErr *BreakerOpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("second Allow() during the probe = %v, want a *BreakerOpenError", err)
+	}
+	if openErr.RetryAfter != time.Second {
+		t.Errorf("RetryAfter during the probe = %s, want the 1s minimum", openErr.RetryAfter)
+	}
+
+	// A cancelled probe frees the slot without closing or reopening the breaker
+	b.Record(context.Canceled, now)
+	if err := b.Allow(now); err != nil {
+		t.Errorf("Allow() after a cancelled probe = %v, want another probe let through", err)
+	}
+}
+
+func TestBreakerRecord(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantFailures int
+	}{
+		{name: "success resets", err: nil, wantFailures: 0},
+		{name: "source failure counts", err: failing, wantFailures: 3},
+		{name: "timeout counts", err: fmt.Errorf("slow: %w", ErrUpstreamTimeout), wantFailures: 3},
+		{name: "cancelled request ignored", err: context.Canceled, wantFailures: 2},
+		{name: "deadline ignored", err: context.DeadlineExceeded, wantFailures: 2},
+		{name: "bad query resets", err: errors.New("invalid domain"), wantFailures: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			b := &Breaker{name: "test"}
+			b.Record(failing, now)
+			b.Record(failing, now)
+			b.Record(tt.err, now)
+			if got := b.Status(now).Failures; got != tt.wantFailures {
+				t.Errorf("Failures = %d, want %d", got, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestBreakerOpenRetryAfter(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := &Breaker{name: "test"}
+	for i := 0; i < breakerThreshold; i++ {
+		b.Record(failing, start)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{elapsed: 0, want: breakerCooldown},
+		{elapsed: 10*time.Second + 400*time.Millisecond, want: 20 * time.Second},
+		{elapsed: breakerCooldown - 100*time.Millisecond, want: time.Second},
+	}
+	for _, tt := range tests {
+		err := b.Allow(start.Add(tt.elapsed))
+		var openErr *BreakerOpenError
+		if !errors.As(err, &openErr) {
+			t.Fatalf("Allow() %s after opening = %v, want a *BreakerOpenError", tt.elapsed, err)
+		}
+		if openErr.RetryAfter != tt.want {
+			t.Errorf("RetryAfter %s after opening = %s, want %s", tt.elapsed, openErr.RetryAfter, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+const (
+	// MaxImportDomains caps how many domains one import may contain
+	MaxImportDomains = 1000
+
+	// MaxBulkSearchDomains caps how many imported domains are searched in one go
+	MaxBulkSearchDomains = 200
+)
+
+// DomainImport is the result of reading an uploaded domain list
+type DomainImport struct {
+	Domains    []string         `json:"domains"` // Valid, deduplicated, in punycode
+	Invalid    []RejectedDomain `json:"invalid"`
+	Duplicates int              `json:"duplicates"`
+}
+
+// RejectedDomain is an uploaded line that isn't a usable domain
+type RejectedDomain struct {
+	Line  int    `json:"line"`
+	Input string `json:"input"`
+	Error string `json:"error"`
+}
+
+// ParseDomainList reads a CSV or newline-delimited list of domains
+// If the first row has a "domain" column that column is used, otherwise the first column.
+// Blank lines and lines starting with # are skipped.
+func ParseDomainList(r io.Reader) (DomainImport, error) {
+	result := DomainImport{
+		Domains: make([]string, 0),
+		Invalid: make([]RejectedDomain, 0),
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	seen := make(map[string]bool)
+	column := 0
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("could not read domain list: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		// A header row names the column to use
+		if row == 0 {
+			if index := headerColumn(record); index >= 0 {
+				column = index
+				continue
+			}
+		}
+		if column >= len(record) || strings.TrimSpace(record[column]) == "" {
+			continue
+		}
+
+		input := strings.TrimSpace(record[column])
+		domain, err := ValidateDomain(input)
+		switch {
+		case err != nil:
+			result.Invalid = append(result.Invalid, RejectedDomain{Line: line, Input: input, Error: err.Error()})
+		case seen[domain]:
+			result.Duplicates++
+		default:
+			seen[domain] = true
+			result.Domains = append(result.Domains, domain)
+		}
+
+		if len(result.Domains)+len(result.Invalid) > MaxImportDomains {
+			return result, fmt.Errorf("domain list has more than %d entries", MaxImportDomains)
+		}
+	}
+
+	return result, nil
+}
+
+// headerColumn returns the index of a "domain" column, or -1
+func headerColumn(record []string) int {
+	for i, field := range record {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "domain", "domains", "hostname":
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateDomain checks a single domain name and returns it in normalized punycode form
+func ValidateDomain(input string) (string, error) {
+	if strings.ContainsAny(input, "%*") {
+		return "", errors.New("wildcards are not allowed")
+	}
+	domain, err := NormalizeDomain(input)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.Contains(domain, ".") {
+		return "", errors.New("not a fully qualified domain name")
+	}
+	if IsPublicSuffix(domain) {
+		return "", errors.New("is a public suffix, not a domain")
+	}
+
+	return domain, nil
+}
+
+// BulkSearchResult summarizes one domain's certificates in a bulk search
+type BulkSearchResult struct {
+	Domain       string   `json:"domain"`
+	Certificates int      `json:"certificates"`
+	Active       int      `json:"active"`
+	Expiring     int      `json:"expiring"` // Active certificates expiring within expiringSoonDays
+	Issuers      []string `json:"issuers"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// BulkSearch looks up each domain's certificates with a small pool of workers
+func BulkSearch(ctx context.Context, domains []string, now time.Time) []BulkSearchResult {
+	results := make([]BulkSearchResult, 0, len(domains))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+
+	for i := 0; i < sweepWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range queue {
+				result := searchDomain(ctx, domain, now)
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, domain := range domains {
+		queue <- domain
+	}
+	close(queue)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Domain < results[j].Domain
+	})
+	return results
+}
+
+// searchDomain fetches and summarizes a single domain for BulkSearch
+func searchDomain(ctx context.Context, domain string, now time.Time) BulkSearchResult {
+	result := BulkSearchResult{
+		Domain:  domain,
+		Issuers: make([]string, 0),
+	}
+
+	certs, err := FetchCertificates(ctx, domain)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	soon := now.AddDate(0, 0, expiringSoonDays)
+	issuers := make(map[string]bool)
+	for _, group := range GroupCertificates(certs) {
+		result.Certificates++
+		if !isActive(group, now) {
+			continue
+		}
+		result.Active++
+		if group.NotAfterTime.Before(soon) {
+			result.Expiring++
+		}
+		issuers[ctsearch.IssuerDisplayName(group.IssuerName)] = true
+	}
+	for issuer := range issuers {
+		result.Issuers = append(result.Issuers, issuer)
+	}
+	sort.Strings(result.Issuers)
+
+	return result
+}
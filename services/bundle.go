@@ -0,0 +1,132 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MaxBundleCertificates caps how many certificates a ZIP bundle downloads from crt.sh
+const MaxBundleCertificates = 1000
+
+// bundledCertificate is one certificate's download for a bundle
+type bundledCertificate struct {
+	index int
+	id    int64
+	pem   []byte
+	err   error
+}
+
+// WriteCertificateBundle writes a ZIP archive holding each certificate's PEM and a manifest.csv describing them
+// Certificates are downloaded from crt.sh a few at a time and added as they arrive, so the archive streams;
+// ones that couldn't be downloaded are listed in the manifest with the error instead of a file
+func WriteCertificateBundle(ctx context.Context, w io.Writer, groups []CertificateGroup) error {
+	archive := zip.NewWriter(w)
+
+	downloads := make(chan bundledCertificate)
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < sweepWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				id := PreferredEntry(groups[index]).ID
+				pem, err := FetchPEM(ctx, id)
+				downloads <- bundledCertificate{index: index, id: id, pem: pem, err: err}
+			}
+		}()
+	}
+	go func() {
+		for index := range groups {
+			queue <- index
+		}
+		close(queue)
+		wg.Wait()
+		close(downloads)
+	}()
+
+	files := make([]string, len(groups))
+	errs := make([]error, len(groups))
+	var writeErr error
+	for download := range downloads {
+		// Keep draining after a write error so the workers can finish
+		if writeErr != nil {
+			continue
+		}
+		if download.err != nil {
+			errs[download.index] = download.err
+			continue
+		}
+		name := bundleFileName(groups[download.index], download.id)
+		file, err := archive.Create(name)
+		if err == nil {
+			_, err = file.Write(download.pem)
+		}
+		if err != nil {
+			writeErr = fmt.Errorf("failed to write certificate bundle: %w", err)
+			continue
+		}
+		files[download.index] = name
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	manifest, err := archive.Create("manifest.csv")
+	if err != nil {
+		return fmt.Errorf("failed to write certificate bundle: %w", err)
+	}
+	out := csv.NewWriter(manifest)
+	out.Write([]string{"file", "crt_sh_id", "serial_number", "common_name", "issuer", "not_before", "not_after", "names", "error"})
+	for i, group := range groups {
+		errorText := ""
+		if errs[i] != nil {
+			errorText = errs[i].Error()
+		}
+		out.Write([]string{
+			files[i],
+			fmt.Sprint(PreferredEntry(group).ID),
+			CSVSafe(group.SerialNumber),
+			CSVSafe(group.CommonName),
+			CSVSafe(group.IssuerName),
+			group.NotBefore,
+			group.NotAfter,
+			CSVSafe(strings.Join(GroupNames(group), " ")),
+			CSVSafe(errorText),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return fmt.Errorf("failed to write certificate bundle: %w", err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write certificate bundle: %w", err)
+	}
+	return nil
+}
+
+// bundleFileName names a certificate's PEM file after its common name and crt.sh ID, e.g. "www.example.com_1234.pem"
+func bundleFileName(group CertificateGroup, id int64) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		case r == '*':
+			return -1
+		}
+		return '_'
+	}, strings.TrimPrefix(group.CommonName, "*."))
+	if strings.HasPrefix(group.CommonName, "*.") {
+		name = "wildcard." + name
+	}
+	if name == "" {
+		name = "certificate"
+	}
+	return fmt.Sprintf("%s_%d.pem", name, id)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// ComparedCertificate is one side of a certificate comparison
+type ComparedCertificate struct {
+	ID           int64           `json:"id"`
+	Names        []string        `json:"names"`
+	Info         CertificateInfo `json:"info"`
+	SPKISHA256   string          `json:"spkiSha256"` // Identifies the key pair, so a renewal that kept its key shows up
+	LifetimeDays int             `json:"lifetimeDays"`
+}
+
+// FieldChange is one compared field, with both certificates' values
+type FieldChange struct {
+	Field   string `json:"field"`
+	A       string `json:"a"`
+	B       string `json:"b"`
+	Changed bool   `json:"changed"`
+}
+
+// CertificateComparison is what changed between two certificates, typically across a renewal
+type CertificateComparison struct {
+	A            ComparedCertificate `json:"a"`
+	B            ComparedCertificate `json:"b"`
+	AddedNames   []string            `json:"addedNames"`   // On B but not on A
+	RemovedNames []string            `json:"removedNames"` // On A but not on B
+	KeptNames    []string            `json:"keptNames"`
+	Fields       []FieldChange       `json:"fields"`
+}
+
+// Changed lists only the fields that differ
+func (c CertificateComparison) Changed() []FieldChange {
+	changed := make([]FieldChange, 0)
+	for _, field := range c.Fields {
+		if field.Changed {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
+
+// CompareCertificates diffs two inspected certificates, a being the older one in a renewal
+func CompareCertificates(a, b CertificateInfo) CertificateComparison {
+	comparison := CertificateComparison{
+		A:            comparedCertificate(a),
+		B:            comparedCertificate(b),
+		AddedNames:   make([]string, 0),
+		RemovedNames: make([]string, 0),
+		KeptNames:    make([]string, 0),
+	}
+
+	onA := make(map[string]bool)
+	for _, name := range comparison.A.Names {
+		onA[name] = true
+	}
+	for _, name := range comparison.B.Names {
+		if onA[name] {
+			comparison.KeptNames = append(comparison.KeptNames, name)
+			delete(onA, name)
+		} else {
+			comparison.AddedNames = append(comparison.AddedNames, name)
+		}
+	}
+	for name := range onA {
+		comparison.RemovedNames = append(comparison.RemovedNames, name)
+	}
+	sort.Strings(comparison.RemovedNames)
+
+	certA, certB := a.Certificate, b.Certificate
+	key := func(c ComparedCertificate) string {
+		return fmt.Sprintf("%s (SPKI %s)", KeyType(c.Info), c.SPKISHA256[:16])
+	}
+	add := func(field, valueA, valueB string) {
+		comparison.Fields = append(comparison.Fields, FieldChange{Field: field, A: valueA, B: valueB, Changed: valueA != valueB})
+	}
+	add("Subject", certA.Subject.String(), certB.Subject.String())
+	add("Issuer", ctsearch.IssuerDisplayName(crtshName(certA.RawIssuer, certA.Issuer)), ctsearch.IssuerDisplayName(crtshName(certB.RawIssuer, certB.Issuer)))
+	add("Key", key(comparison.A), key(comparison.B))
+	add("Signature algorithm", a.SignatureAlgorithm, b.SignatureAlgorithm)
+	add("Lifetime", fmt.Sprintf("%d days", comparison.A.LifetimeDays), fmt.Sprintf("%d days", comparison.B.LifetimeDays))
+	add("Valid from", certA.NotBefore.UTC().Format("2006-01-02 15:04"), certB.NotBefore.UTC().Format("2006-01-02 15:04"))
+	add("Valid until", certA.NotAfter.UTC().Format("2006-01-02 15:04"), certB.NotAfter.UTC().Format("2006-01-02 15:04"))
+	add("Key usage", keyUsages(certA), keyUsages(certB))
+	add("Extended key usage", extKeyUsages(certA), extKeyUsages(certB))
+	add("Embedded SCTs", fmt.Sprint(a.SCTCount), fmt.Sprint(b.SCTCount))
+	add("OCSP", strings.Join(a.OCSPServers, ", "), strings.Join(b.OCSPServers, ", "))
+	add("CRL", strings.Join(a.CRLDistributionPoints, ", "), strings.Join(b.CRLDistributionPoints, ", "))
+	add("Weaknesses", strings.Join(a.Weaknesses, "; "), strings.Join(b.Weaknesses, "; "))
+
+	return comparison
+}
+
+// comparedCertificate collects the values compared for one certificate
+func comparedCertificate(info CertificateInfo) ComparedCertificate {
+	cert := info.Certificate
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	// Names as crt.sh lists them: the common name and the DNS names
+	group := CertificateGroup{
+		CommonName: cert.Subject.CommonName,
+		Entries:    []Certificate{{NameValue: strings.Join(cert.DNSNames, "\n")}},
+	}
+	names := GroupNames(group)
+	sort.Strings(names)
+
+	return ComparedCertificate{
+		ID:           info.ID,
+		Names:        names,
+		Info:         info,
+		SPKISHA256:   hex.EncodeToString(spki[:]),
+		LifetimeDays: daysBetween(cert.NotBefore, cert.NotAfter),
+	}
+}
+
+// keyUsages names a certificate's key usage bits
+func keyUsages(cert *x509.Certificate) string {
+	usages := make([]string, 0)
+	for i, name := range keyUsageNames {
+		if cert.KeyUsage&(1<<i) != 0 {
+			usages = append(usages, name)
+		}
+	}
+	return strings.Join(usages, ", ")
+}
+
+// extKeyUsages names a certificate's extended key usages
+func extKeyUsages(cert *x509.Certificate) string {
+	usages := make([]string, 0)
+	for _, usage := range cert.ExtKeyUsage {
+		usages = append(usages, extKeyUsageName(usage))
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		usages = append(usages, oid.String())
+	}
+	return strings.Join(usages, ", ")
+}
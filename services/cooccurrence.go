@@ -0,0 +1,149 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CoOccurrenceReport lists the unrelated domains that appear on the same certificates as ours
+// Lots of them usually means shared hosting or a CDN; a few odd ones can mean suspicious bundling
+type CoOccurrenceReport struct {
+	Domain             string         `json:"domain"`
+	SharedCertificates int            `json:"sharedCertificates"` // Certificates naming at least one other domain
+	Domains            []CoOccurrence `json:"domains"`
+}
+
+// CoOccurrence is one other domain found on our certificates
+type CoOccurrence struct {
+	Domain       string    `json:"domain"` // Registrable domain, e.g. "cdn-provider.net"
+	Certificates int       `json:"certificates"`
+	Names        []string  `json:"names"` // The exact names seen
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// AnnotateSharedNames fills in SharedWith on each group with the names outside domain
+func AnnotateSharedNames(domain string, groups []CertificateGroup) {
+	base := BaseDomain(domain)
+	for i := range groups {
+		groups[i].SharedWith = unrelatedNames(base, groups[i])
+	}
+}
+
+// CoOccurringDomains aggregates the unrelated domains sharing certificates with domain
+func CoOccurringDomains(domain string, groups []CertificateGroup) CoOccurrenceReport {
+	base := BaseDomain(domain)
+	report := CoOccurrenceReport{
+		Domain:  base,
+		Domains: make([]CoOccurrence, 0),
+	}
+
+	// Map to collect co-occurrences by registrable domain
+	domainMap := make(map[string]*CoOccurrence)
+
+	for _, group := range groups {
+		names := unrelatedNames(base, group)
+		if len(names) == 0 {
+			continue
+		}
+		report.SharedCertificates++
+
+		// Count each other domain once per certificate
+		counted := make(map[string]bool)
+		for _, name := range names {
+			registrable := RegistrableDomain(name)
+
+			entry, exists := domainMap[registrable]
+			if !exists {
+				entry = &CoOccurrence{
+					Domain:    registrable,
+					FirstSeen: group.NotBeforeTime,
+					LastSeen:  group.NotBeforeTime,
+				}
+				domainMap[registrable] = entry
+			}
+
+			if !counted[registrable] {
+				counted[registrable] = true
+				entry.Certificates++
+				if group.NotBeforeTime.Before(entry.FirstSeen) {
+					entry.FirstSeen = group.NotBeforeTime
+				}
+				if group.NotBeforeTime.After(entry.LastSeen) {
+					entry.LastSeen = group.NotBeforeTime
+				}
+			}
+			if !containsString(entry.Names, name) {
+				entry.Names = append(entry.Names, name)
+			}
+		}
+	}
+
+	for _, entry := range domainMap {
+		sort.Strings(entry.Names)
+		report.Domains = append(report.Domains, *entry)
+	}
+
+	// Most frequent first
+	sort.Slice(report.Domains, func(i, j int) bool {
+		a, b := report.Domains[i], report.Domains[j]
+		if a.Certificates != b.Certificates {
+			return a.Certificates > b.Certificates
+		}
+		return a.Domain < b.Domain
+	})
+
+	return report
+}
+
+// RegistrableDomain returns the registrable domain (eTLD+1) of a name using the Public Suffix List
+// e.g. "*.eu.cdn-provider.net" -> "cdn-provider.net", "www.example.co.uk" -> "example.co.uk"
+// A name that is itself a public suffix is returned unchanged
+func RegistrableDomain(name string) string {
+	name = strings.TrimPrefix(NormalizeName(name), "*.")
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name
+	}
+	return registrable
+}
+
+// IsPublicSuffix reports whether name is a public suffix such as "com", "co.uk" or "github.io"
+func IsPublicSuffix(name string) bool {
+	name = strings.TrimPrefix(NormalizeName(name), "*.")
+	suffix, _ := publicsuffix.PublicSuffix(name)
+	return suffix == name
+}
+
+// unrelatedNames returns the names on the certificate outside base's registrable domain
+// e.g. for shop.example.co.uk, www.example.co.uk is related but example.com is not
+func unrelatedNames(base string, group CertificateGroup) []string {
+	registrable := ""
+	if !IsPublicSuffix(base) {
+		registrable = RegistrableDomain(base)
+	}
+
+	names := make([]string, 0)
+	for _, name := range GroupNames(group) {
+		// A wildcard for the domain itself (*.example.com) is still ours
+		trimmed := strings.TrimPrefix(name, "*.")
+		if inDomain(trimmed, base) || (registrable != "" && RegistrableDomain(trimmed) == registrable) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
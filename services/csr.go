@@ -0,0 +1,279 @@
+package services
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+// Requested extension OIDs we know how to describe
+var (
+	oidSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtKeyUsage      = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidSubjectKeyID     = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidTLSFeature       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+)
+
+// keyUsageNames are the RFC 5280 key usage bits, in bit order
+var keyUsageNames = []string{
+	"Digital Signature", "Content Commitment", "Key Encipherment", "Data Encipherment",
+	"Key Agreement", "Certificate Sign", "CRL Sign", "Encipher Only", "Decipher Only",
+}
+
+// extKeyUsageNames names the common extended key usage OIDs
+var extKeyUsageNames = map[string]string{
+	"1.3.6.1.5.5.7.3.1":      "TLS Web Server Authentication",
+	"1.3.6.1.5.5.7.3.2":      "TLS Web Client Authentication",
+	"1.3.6.1.5.5.7.3.3":      "Code Signing",
+	"1.3.6.1.5.5.7.3.4":      "Email Protection",
+	"1.3.6.1.5.5.7.3.8":      "Time Stamping",
+	"1.3.6.1.5.5.7.3.9":      "OCSP Signing",
+	"2.5.29.37.0":            "Any Extended Key Usage",
+	"1.3.6.1.4.1.311.20.2.2": "Microsoft Smartcard Logon",
+}
+
+// extKeyUsageOIDs maps the extended key usages Go knows to their OIDs, for naming them
+var extKeyUsageOIDs = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "2.5.29.37.0",
+	x509.ExtKeyUsageServerAuth:      "1.3.6.1.5.5.7.3.1",
+	x509.ExtKeyUsageClientAuth:      "1.3.6.1.5.5.7.3.2",
+	x509.ExtKeyUsageCodeSigning:     "1.3.6.1.5.5.7.3.3",
+	x509.ExtKeyUsageEmailProtection: "1.3.6.1.5.5.7.3.4",
+	x509.ExtKeyUsageTimeStamping:    "1.3.6.1.5.5.7.3.8",
+	x509.ExtKeyUsageOCSPSigning:     "1.3.6.1.5.5.7.3.9",
+}
+
+// extKeyUsageName names an extended key usage from a parsed certificate
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	if name, known := extKeyUsageNames[extKeyUsageOIDs[usage]]; known {
+		return name
+	}
+	return fmt.Sprintf("extended key usage %d", usage)
+}
+
+// CSRInfo is what a certificate signing request asks for
+type CSRInfo struct {
+	Subject            string               `json:"subject"`
+	CommonName         string               `json:"commonName,omitempty"`
+	DNSNames           []string             `json:"dnsNames"`
+	IPAddresses        []string             `json:"ipAddresses,omitempty"`
+	EmailAddresses     []string             `json:"emailAddresses,omitempty"`
+	URIs               []string             `json:"uris,omitempty"`
+	Key                x509info.KeyInfo     `json:"key"`
+	SignatureAlgorithm string               `json:"signatureAlgorithm"`
+	SignatureError     string               `json:"signatureError,omitempty"` // Set when the CSR isn't signed by its own key
+	Extensions         []RequestedExtension `json:"extensions"`
+	Weaknesses         []string             `json:"weaknesses"`
+}
+
+// RequestedExtension is one extension a CSR asks the CA to include
+type RequestedExtension struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	Value    string `json:"value"` // Decoded where we know how, otherwise hex
+}
+
+// Names returns the DNS names the CSR covers, including the common name if it looks like one, normalized
+func (c CSRInfo) Names() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(c.DNSNames)+1)
+	add := func(name string) {
+		name = NormalizeName(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if strings.Contains(c.CommonName, ".") && !strings.ContainsAny(c.CommonName, " @") {
+		add(c.CommonName)
+	}
+	for _, name := range c.DNSNames {
+		add(name)
+	}
+	return names
+}
+
+// ParseCSR decodes a PEM (or raw DER) certificate signing request
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("expected a CERTIFICATE REQUEST, got a PEM %s", block.Type)
+		}
+		data = block.Bytes
+	} else if strings.HasPrefix(strings.TrimSpace(string(data)), "-----BEGIN") {
+		return nil, errors.New("failed to decode PEM")
+	}
+
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+	return csr, nil
+}
+
+// InspectCSR describes a parsed certificate signing request
+func InspectCSR(csr *x509.CertificateRequest) CSRInfo {
+	info := CSRInfo{
+		Subject:            csr.Subject.String(),
+		CommonName:         csr.Subject.CommonName,
+		DNSNames:           csr.DNSNames,
+		EmailAddresses:     csr.EmailAddresses,
+		Key:                x509info.InspectKey(csr.PublicKey, csr.PublicKeyAlgorithm),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		Extensions:         make([]RequestedExtension, 0, len(csr.Extensions)),
+	}
+	if info.DNSNames == nil {
+		info.DNSNames = make([]string, 0)
+	}
+	for _, ip := range csr.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	if err := csr.CheckSignature(); err != nil {
+		info.SignatureError = err.Error()
+	}
+
+	info.Weaknesses = append(make([]string, 0), info.Key.Weaknesses...)
+	if weakness := x509info.SignatureWeakness(csr.SignatureAlgorithm); weakness != "" {
+		info.Weaknesses = append(info.Weaknesses, weakness)
+	}
+	if len(info.DNSNames) == 0 && len(info.IPAddresses) == 0 {
+		info.Weaknesses = append(info.Weaknesses, "no subject alternative names; public CAs need at least one")
+	}
+
+	for _, ext := range csr.Extensions {
+		info.Extensions = append(info.Extensions, describeExtension(ext.Id, ext.Critical, ext.Value))
+	}
+	return info
+}
+
+// describeExtension names a requested extension and decodes the ones we know
+func describeExtension(oid asn1.ObjectIdentifier, critical bool, value []byte) RequestedExtension {
+	ext := RequestedExtension{OID: oid.String(), Name: oid.String(), Critical: critical, Value: hex.EncodeToString(value)}
+
+	switch {
+	case oid.Equal(oidSubjectAltName):
+		ext.Name = "Subject Alternative Name"
+		ext.Value = "see names"
+	case oid.Equal(oidKeyUsage):
+		ext.Name = "Key Usage"
+		var bits asn1.BitString
+		if _, err := asn1.Unmarshal(value, &bits); err == nil {
+			usages := make([]string, 0)
+			for i, name := range keyUsageNames {
+				if bits.At(i) == 1 {
+					usages = append(usages, name)
+				}
+			}
+			ext.Value = strings.Join(usages, ", ")
+		}
+	case oid.Equal(oidExtKeyUsage):
+		ext.Name = "Extended Key Usage"
+		var oids []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(value, &oids); err == nil {
+			usages := make([]string, 0, len(oids))
+			for _, usage := range oids {
+				if name, known := extKeyUsageNames[usage.String()]; known {
+					usages = append(usages, name)
+				} else {
+					usages = append(usages, usage.String())
+				}
+			}
+			ext.Value = strings.Join(usages, ", ")
+		}
+	case oid.Equal(oidBasicConstraints):
+		ext.Name = "Basic Constraints"
+		var constraints struct {
+			CA bool `asn1:"optional"`
+		}
+		if _, err := asn1.Unmarshal(value, &constraints); err == nil {
+			ext.Value = fmt.Sprintf("CA: %t", constraints.CA)
+		}
+	case oid.Equal(oidSubjectKeyID):
+		ext.Name = "Subject Key Identifier"
+		var id []byte
+		if _, err := asn1.Unmarshal(value, &id); err == nil {
+			ext.Value = hex.EncodeToString(id)
+		}
+	case oid.Equal(oidTLSFeature):
+		ext.Name = "TLS Feature"
+		var features []int
+		if _, err := asn1.Unmarshal(value, &features); err == nil {
+			ext.Value = fmt.Sprint(features)
+			for _, feature := range features {
+				if feature == 5 {
+					ext.Value = "OCSP Must-Staple"
+				}
+			}
+		}
+	}
+	return ext
+}
+
+// CertificateMatch is a certificate in CT that covers names someone asked about
+type CertificateMatch struct {
+	Group  CertificateGroup `json:"certificate"`
+	Issuer string           `json:"issuer"`
+	Exact  bool             `json:"exact"`  // Covers exactly the requested names, no more
+	Active bool             `json:"active"` // Valid right now
+}
+
+// MatchCertificates finds the certificates that cover every one of names, active ones first, then newest
+func MatchCertificates(names []string, groups []CertificateGroup, now time.Time) []CertificateMatch {
+	matches := make([]CertificateMatch, 0)
+	if len(names) == 0 {
+		return matches
+	}
+
+	for _, group := range groups {
+		certNames := GroupNames(group)
+		if !coversAll(certNames, names) {
+			continue
+		}
+		matches = append(matches, CertificateMatch{
+			Group:  group,
+			Issuer: ctsearch.IssuerDisplayName(group.IssuerName),
+			Exact:  len(certNames) == len(names) && coversAll(names, certNames),
+			Active: isActive(group, now),
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Active != matches[j].Active {
+			return matches[i].Active
+		}
+		return matches[i].Group.NotBeforeTime.After(matches[j].Group.NotBeforeTime)
+	})
+	return matches
+}
+
+// coversAll reports whether every hostname is covered by one of the certificate names
+func coversAll(certNames, hostnames []string) bool {
+	for _, hostname := range hostnames {
+		covered := false
+		for _, certName := range certNames {
+			if NameCovers(certName, hostname) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
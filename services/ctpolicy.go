@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+const (
+	// ctLogListTTL is how long a downloaded CT log list is used before it's fetched again; log states
+	// change over months, not hours
+	ctLogListTTL = 24 * time.Hour
+
+	// ctLogListRetry is how long to wait after a failed download before trying again, so reports
+	// aren't slowed by every one of them waiting on it
+	ctLogListRetry = 10 * time.Minute
+
+	// ctLogListTimeout bounds a download of the log list
+	ctLogListTimeout = 10 * time.Second
+)
+
+// CTCompliance is whether a certificate's embedded SCTs satisfy the browsers' CT policies, see pkg/x509info
+type CTCompliance = x509info.CTCompliance
+
+// ctLogList holds the last CT log list downloaded, which is used past its TTL while a new one can't be had
+var ctLogList = struct {
+	sync.Mutex
+	list      *x509info.LogList
+	fetchedAt time.Time
+	failedAt  time.Time
+	fetching  chan struct{} // Closed when the download under way finishes; nil when none is
+}{}
+
+// CTLogList returns the CT log list, downloading it when it's missing or older than ctLogListTTL
+// The download doesn't run on ctx, so a visitor leaving the page neither fails it for everyone until
+// ctLogListRetry nor leaves the others waiting; a caller whose ctx ends first gets the list it had
+// It returns nil when no list could ever be downloaded
+func CTLogList(ctx context.Context) *x509info.LogList {
+	ctLogList.Lock()
+	now := time.Now()
+	if ctLogList.list != nil && now.Sub(ctLogList.fetchedAt) < ctLogListTTL || now.Sub(ctLogList.failedAt) < ctLogListRetry {
+		list := ctLogList.list
+		ctLogList.Unlock()
+		return list
+	}
+	fetching := ctLogList.fetching
+	if fetching == nil {
+		fetching = make(chan struct{})
+		ctLogList.fetching = fetching
+		go fetchCTLogList(context.WithoutCancel(ctx), fetching)
+	}
+	ctLogList.Unlock()
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+	}
+	ctLogList.Lock()
+	defer ctLogList.Unlock()
+	return ctLogList.list
+}
+
+// fetchCTLogList downloads the CT log list into ctLogList, closing done when it's finished
+func fetchCTLogList(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, ctLogListTimeout)
+	defer cancel()
+	err := waitForCTLogs(ctx)
+	var list *x509info.LogList
+	if err == nil {
+		list, err = x509info.FetchLogList(ctx)
+	}
+
+	ctLogList.Lock()
+	defer ctLogList.Unlock()
+	if err != nil {
+		ctLogList.failedAt = time.Now()
+	} else {
+		ctLogList.list = list
+		ctLogList.fetchedAt = time.Now()
+	}
+	ctLogList.fetching = nil
+	close(done)
+}
+
+// CheckCTPolicy checks an inspected certificate's embedded SCTs against the browsers' CT policies
+// It returns nil when the policies don't apply (not a TLS certificate, or a precertificate, which has no SCTs)
+// or the log list isn't available, leaving callers to fall back on counting SCTs
+func CheckCTPolicy(ctx context.Context, info CertificateInfo, notBefore, notAfter time.Time) *CTCompliance {
+	if info.Purpose != PurposeTLS || info.IsPrecertificate {
+		return nil
+	}
+	logs := CTLogList(ctx)
+	if logs == nil {
+		return nil
+	}
+	compliance := x509info.CheckCTPolicy(info, notBefore, notAfter, logs)
+	return &compliance
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeTLSA is the DNS resource record type for TLSA (RFC 6698)
+const typeTLSA = dnsmessage.Type(52)
+
+// TLSA certificate usages, selectors and matching types (RFC 6698 / RFC 7218)
+var (
+	tlsaUsages        = map[uint8]string{0: "PKIX-TA", 1: "PKIX-EE", 2: "DANE-TA", 3: "DANE-EE"}
+	tlsaSelectors     = map[uint8]string{0: "Cert", 1: "SPKI"}
+	tlsaMatchingTypes = map[uint8]string{0: "Full", 1: "SHA2-256", 2: "SHA2-512"}
+)
+
+// TLSARecord is one TLSA resource record
+type TLSARecord struct {
+	Usage        uint8  `json:"usage"`
+	Selector     uint8  `json:"selector"`
+	MatchingType uint8  `json:"matchingType"`
+	Data         string `json:"data"` // Hex-encoded certificate association data
+}
+
+// String formats the record like a zone file entry, with mnemonics
+func (r TLSARecord) String() string {
+	return fmt.Sprintf("%d %d %d %s (%s %s %s)", r.Usage, r.Selector, r.MatchingType, r.Data,
+		tlsaUsages[r.Usage], tlsaSelectors[r.Selector], tlsaMatchingTypes[r.MatchingType])
+}
+
+// ZoneLine formats the record as a zone file line for owner name
+func (r TLSARecord) ZoneLine(name string) string {
+	return fmt.Sprintf("%s. IN TLSA %d %d %d %s", name, r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// DANEResult is the outcome of checking a service's TLSA records against its served certificates
+type DANEResult struct {
+	Name          string      `json:"name"`          // e.g. "_25._tcp.mail.example.com"
+	Authenticated bool        `json:"authenticated"` // The resolver validated the answer with DNSSEC
+	Checks        []TLSACheck `json:"checks"`
+	Valid         bool        `json:"valid"` // DNSSEC-authenticated and at least one usable record matched
+	Error         string      `json:"error,omitempty"`
+}
+
+// TLSACheck is the result for a single TLSA record
+type TLSACheck struct {
+	Record  TLSARecord `json:"record"`
+	Matched bool       `json:"matched"`
+	Subject string     `json:"subject,omitempty"` // The certificate that matched
+	Message string     `json:"message"`
+}
+
+// TLSAName returns the owner name for a service's TLSA records
+func TLSAName(host string, port int) string {
+	return fmt.Sprintf("_%d._tcp.%s", port, NormalizeName(host))
+}
+
+// CheckDANE looks up the TLSA records for the probed service and verifies them against the served chain
+func CheckDANE(nameserver string, probe ProbeResult) DANEResult {
+	result := DANEResult{
+		Name:   TLSAName(probe.Host, probe.Port),
+		Checks: make([]TLSACheck, 0),
+	}
+
+	records, authenticated, err := LookupTLSA(nameserver, result.Name)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Authenticated = authenticated
+	if len(records) == 0 {
+		result.Error = "no TLSA records published"
+		return result
+	}
+	if probe.Error != "" {
+		result.Error = probe.Error
+		return result
+	}
+	if len(probe.Certificates()) == 0 {
+		result.Error = "no certificate was served"
+		return result
+	}
+
+	for _, record := range records {
+		check := verifyTLSARecord(record, probe)
+		if check.Matched {
+			result.Valid = true
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	// Without DNSSEC an attacker could forge the records, so they prove nothing
+	if !result.Authenticated {
+		result.Valid = false
+		result.Error = "TLSA answer was not DNSSEC-authenticated by the resolver"
+	}
+
+	return result
+}
+
+// verifyTLSARecord checks one record against the appropriate certificates in the chain
+func verifyTLSARecord(record TLSARecord, probe ProbeResult) TLSACheck {
+	check := TLSACheck{Record: record}
+	chain := probe.Certificates()
+
+	// End-entity usages match the leaf, trust-anchor usages match a CA in the chain
+	var candidates []*x509.Certificate
+	switch record.Usage {
+	case 1, 3:
+		candidates = chain[:1]
+	case 0, 2:
+		candidates = chain[1:]
+	default:
+		check.Message = fmt.Sprintf("unknown certificate usage %d", record.Usage)
+		return check
+	}
+
+	for _, cert := range candidates {
+		data, err := TLSAAssociationData(cert, record.Selector, record.MatchingType)
+		if err != nil {
+			check.Message = err.Error()
+			return check
+		}
+		if strings.EqualFold(data, record.Data) {
+			check.Matched = true
+			check.Subject = cert.Subject.String()
+			break
+		}
+	}
+
+	switch {
+	case !check.Matched:
+		check.Message = "does not match any served certificate"
+	case (record.Usage == 0 || record.Usage == 1) && probe.VerifyError != "":
+		// PKIX usages also require the chain to validate normally
+		check.Matched = false
+		check.Message = "matches, but PKIX validation failed: " + probe.VerifyError
+	default:
+		check.Message = "matches " + check.Subject
+	}
+
+	return check
+}
+
+// TLSAAssociationData computes the hex association data for a certificate
+func TLSAAssociationData(cert *x509.Certificate, selector, matchingType uint8) (string, error) {
+	var content []byte
+	switch selector {
+	case 0:
+		content = cert.Raw
+	case 1:
+		content = cert.RawSubjectPublicKeyInfo
+	default:
+		return "", fmt.Errorf("unknown selector %d", selector)
+	}
+
+	switch matchingType {
+	case 0:
+		return hex.EncodeToString(content), nil
+	case 1:
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:]), nil
+	case 2:
+		sum := sha512.Sum512(content)
+		return hex.EncodeToString(sum[:]), nil
+	default:
+		return "", fmt.Errorf("unknown matching type %d", matchingType)
+	}
+}
+
+// GeneratedTLSA is a TLSA record for a certificate, ready to publish
+type GeneratedTLSA struct {
+	Record      TLSARecord `json:"record"`
+	Recommended bool       `json:"recommended"`
+	Note        string     `json:"note"`
+}
+
+// tlsaCombinations are the selector and matching type pairs worth publishing, most recommended first;
+// full certificates (matching type 0) make records too large to be practical
+var tlsaCombinations = []struct {
+	selector, matchingType uint8
+	note                   string
+}{
+	{1, 1, "public key hash: survives renewals that keep the key"},
+	{0, 1, "certificate hash: must be replaced at every renewal"},
+	{1, 2, "public key hash, SHA-512"},
+	{0, 2, "certificate hash, SHA-512"},
+}
+
+// GenerateTLSARecords computes the TLSA records that would match a certificate
+// A CA certificate gets trust-anchor usages (DANE-TA, PKIX-TA), anything else end-entity usages (DANE-EE, PKIX-EE)
+func GenerateTLSARecords(cert *x509.Certificate) []GeneratedTLSA {
+	usages := []uint8{3, 1}
+	if cert.IsCA {
+		usages = []uint8{2, 0}
+	}
+
+	records := make([]GeneratedTLSA, 0, len(usages)*len(tlsaCombinations))
+	for _, usage := range usages {
+		for _, combination := range tlsaCombinations {
+			data, _ := TLSAAssociationData(cert, combination.selector, combination.matchingType)
+			note := fmt.Sprintf("%s %s %s: %s", tlsaUsages[usage], tlsaSelectors[combination.selector], tlsaMatchingTypes[combination.matchingType], combination.note)
+			if usage == 0 || usage == 1 {
+				note += "; also needs the chain to pass PKIX validation"
+			}
+			records = append(records, GeneratedTLSA{
+				Record:      TLSARecord{Usage: usage, Selector: combination.selector, MatchingType: combination.matchingType, Data: data},
+				Recommended: usage == usages[0] && combination.selector == 1 && combination.matchingType == 1,
+				Note:        note,
+			})
+		}
+	}
+	return records
+}
+
+// errTruncated means a UDP answer had the TC bit set, so has to be asked for again over TCP
+var errTruncated = errors.New("truncated DNS response")
+
+// errMismatch means a DNS response doesn't answer the query sent: a late answer to an earlier query, or a
+// spoofed one
+var errMismatch = errors.New("DNS response doesn't match the query")
+
+// LookupTLSA queries nameserver (host:port) for TLSA records
+// The Go resolver can't look up TLSA records, so this sends the query itself, over UDP and again over TCP
+// when the answer is truncated, and reports whether the resolver set the AD (DNSSEC authenticated) bit
+func LookupTLSA(nameserver, name string) ([]TLSARecord, bool, error) {
+	if nameserver == "" {
+		var err error
+		if nameserver, err = SystemNameserver(); err != nil {
+			return nil, false, err
+		}
+	}
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	records, authenticated, err := exchangeTLSA("udp", nameserver, name)
+	if errors.Is(err, errTruncated) {
+		records, authenticated, err = exchangeTLSA("tcp", nameserver, name)
+	}
+	return records, authenticated, err
+}
+
+// exchangeTLSA sends one TLSA query over network ("udp" or "tcp") with a random ID and reads its answer
+// Over UDP, datagrams that don't match the query are ignored until one does or the time is up
+func exchangeTLSA(network, nameserver, name string) ([]TLSARecord, bool, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, false, fmt.Errorf("failed to build DNS query: %w", err)
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, question, err := buildTLSAQuery(name, id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := net.DialTimeout(network, nameserver, dnsTimeout)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reach resolver %s: %w", nameserver, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if network == "tcp" {
+		// Over TCP each message is preceded by its length (RFC 1035 4.2.2)
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, false, fmt.Errorf("failed to query resolver: %w", err)
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, false, fmt.Errorf("no answer from resolver: %w", err)
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, response); err != nil {
+			return nil, false, fmt.Errorf("no answer from resolver: %w", err)
+		}
+		return parseTLSAResponse(response, id, question)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, false, fmt.Errorf("failed to query resolver: %w", err)
+	}
+	response := make([]byte, 4096)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return nil, false, fmt.Errorf("no answer from resolver: %w", err)
+		}
+		records, authenticated, err := parseTLSAResponse(response[:n], id, question)
+		if !errors.Is(err, errMismatch) {
+			return records, authenticated, err
+		}
+	}
+}
+
+// buildTLSAQuery encodes a recursive TLSA query with the AD bit and EDNS0 DO bit set, returning it with
+// its question
+func buildTLSAQuery(name string, id uint16) ([]byte, dnsmessage.Question, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, dnsmessage.Question{}, fmt.Errorf("invalid DNS name %q: %w", name, err)
+	}
+	question := dnsmessage.Question{Name: qname, Type: typeTLSA, Class: dnsmessage.ClassINET}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               id,
+		RecursionDesired: true,
+		AuthenticData:    true,
+	})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, question, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, question, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, question, err
+	}
+
+	// EDNS0 with the DNSSEC OK bit so validating resolvers tell us about DNSSEC
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true); err != nil {
+		return nil, question, err
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, question, err
+	}
+
+	query, err := builder.Finish()
+	return query, question, err
+}
+
+// parseTLSAResponse extracts the TLSA records and the AD bit from the response to the query with id and
+// question; one answering anything else is errMismatch, and a truncated one errTruncated
+func parseTLSAResponse(response []byte, id uint16, question dnsmessage.Question) ([]TLSARecord, bool, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if !header.Response || header.ID != id {
+		return nil, false, errMismatch
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if len(questions) != 1 || questions[0].Type != question.Type || questions[0].Class != question.Class ||
+		!strings.EqualFold(questions[0].Name.String(), question.Name.String()) {
+		return nil, false, errMismatch
+	}
+	if header.Truncated {
+		return nil, false, errTruncated
+	}
+	if header.RCode == dnsmessage.RCodeNameError {
+		return []TLSARecord{}, header.AuthenticData, nil
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, false, fmt.Errorf("resolver returned %s", header.RCode)
+	}
+
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid DNS response: %w", err)
+	}
+
+	records := make([]TLSARecord, 0)
+	for _, answer := range answers {
+		body, ok := answer.Body.(*dnsmessage.UnknownResource)
+		if answer.Header.Type != typeTLSA || !ok || len(body.Data) < 4 {
+			continue
+		}
+		records = append(records, TLSARecord{
+			Usage:        body.Data[0],
+			Selector:     body.Data[1],
+			MatchingType: body.Data[2],
+			Data:         hex.EncodeToString(body.Data[3:]),
+		})
+	}
+
+	return records, header.AuthenticData, nil
+}
+
+// SystemNameserver returns the first nameserver from /etc/resolv.conf
+func SystemNameserver() (string, error) {
+	content, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no resolver configured: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", errors.New("no nameserver in /etc/resolv.conf")
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"   // Not delivered yet, with attempts left
+	DeliveryDelivered = "delivered" // The webhook answered 2xx
+	DeliveryFailed    = "failed"    // Given up on
+)
+
+// maxDeliveries caps how many deliveries the log keeps (oldest are dropped first)
+const maxDeliveries = 500
+
+// Delivery is one payload sent to a user's webhook, however many attempts it took
+type Delivery struct {
+	ID        string            `json:"id"` // Sent as X-Certviewer-Delivery, the same on every attempt so receivers can drop repeats
+	Username  string            `json:"username,omitempty"`
+	URL       string            `json:"url"`
+	Event     string            `json:"event"` // Sent as X-Certviewer-Event, e.g. alerts
+	CreatedAt time.Time         `json:"createdAt"`
+	State     string            `json:"state"`
+	Attempts  []DeliveryAttempt `json:"attempts"`
+	Payload   []byte            `json:"-"` // Kept so a failed delivery can be sent again
+}
+
+// DeliveryAttempt is one try at sending a delivery
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	Status     int       `json:"status,omitempty"` // The webhook's HTTP status; 0 when it didn't answer
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"durationMs,omitempty"` // How long the webhook took to answer; left out when it didn't
+}
+
+// LastAttempt is the delivery's latest attempt, zero before the first
+func (d Delivery) LastAttempt() DeliveryAttempt {
+	if len(d.Attempts) == 0 {
+		return DeliveryAttempt{}
+	}
+	return d.Attempts[len(d.Attempts)-1]
+}
+
+// storedDelivery is a delivery as saved, with the payload a pending one still has to send
+type storedDelivery struct {
+	Delivery
+	Payload []byte `json:"payload"`
+}
+
+// DeliveryLog keeps the latest webhook deliveries and their attempts
+// It is safe for concurrent use and is saved to a JSON file after every change, so deliveries still
+// pending when the server stops can be sent once it starts again
+type DeliveryLog struct {
+	mu         sync.Mutex
+	path       string      // Empty means keep deliveries in memory only
+	deliveries []*Delivery // Oldest first
+}
+
+// LoadDeliveryLog reads the delivery log from path, starting empty if the file doesn't exist yet
+func LoadDeliveryLog(path string) (*DeliveryLog, error) {
+	l := &DeliveryLog{path: path, deliveries: make([]*Delivery, 0)}
+	if path == "" {
+		return l, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+
+	var stored []storedDelivery
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook deliveries: %w", err)
+	}
+	for _, s := range stored {
+		delivery := s.Delivery
+		delivery.Payload = s.Payload
+		l.deliveries = append(l.deliveries, &delivery)
+	}
+	return l, nil
+}
+
+// Start records a new pending delivery of payload to username's webhook
+// The delivery is returned, with its ID set, even if the log couldn't be saved
+func (l *DeliveryLog) Start(username, url, event string, payload []byte) (Delivery, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Delivery{}, fmt.Errorf("failed to start delivery: %w", err)
+	}
+	delivery := &Delivery{
+		ID:        hex.EncodeToString(id),
+		Username:  username,
+		URL:       url,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		State:     DeliveryPending,
+		Attempts:  make([]DeliveryAttempt, 0),
+		Payload:   payload,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.deliveries = append(l.deliveries, delivery)
+	if len(l.deliveries) > maxDeliveries {
+		l.deliveries = l.deliveries[len(l.deliveries)-maxDeliveries:]
+	}
+	return delivery.copy(), l.save()
+}
+
+// Record adds an attempt to a delivery and moves it to state
+func (l *DeliveryLog) Record(id string, attempt DeliveryAttempt, state string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, delivery := range l.deliveries {
+		if delivery.ID == id {
+			delivery.Attempts = append(delivery.Attempts, attempt)
+			delivery.State = state
+			return l.save()
+		}
+	}
+	return nil
+}
+
+// Retry moves a delivery of username's that was given up on back to pending, returning it
+// The delivery is returned even if the log couldn't be saved
+func (l *DeliveryLog) Retry(username, id string) (Delivery, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, delivery := range l.deliveries {
+		if delivery.ID == id && delivery.Username == username && delivery.State == DeliveryFailed {
+			delivery.State = DeliveryPending
+			return delivery.copy(), true, l.save()
+		}
+	}
+	return Delivery{}, false, nil
+}
+
+// Pending returns the deliveries still to be made, oldest first, e.g. those the server stopped in the middle of
+func (l *DeliveryLog) Pending() []Delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pending := make([]Delivery, 0)
+	for _, delivery := range l.deliveries {
+		if delivery.State == DeliveryPending {
+			pending = append(pending, delivery.copy())
+		}
+	}
+	return pending
+}
+
+// List returns up to limit of username's deliveries, newest first
+func (l *DeliveryLog) List(username string, limit int) []Delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]Delivery, 0, limit)
+	for i := len(l.deliveries) - 1; i >= 0 && len(list) < limit; i-- {
+		if l.deliveries[i].Username == username {
+			list = append(list, l.deliveries[i].copy())
+		}
+	}
+	return list
+}
+
+// save writes the log to disk
+// Callers must hold l.mu
+func (l *DeliveryLog) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	stored := make([]storedDelivery, len(l.deliveries))
+	for i, delivery := range l.deliveries {
+		stored[i] = storedDelivery{Delivery: *delivery, Payload: delivery.Payload}
+	}
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook deliveries: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written file
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save webhook deliveries: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("failed to save webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// copy returns the delivery with its own attempts, for handing out of the lock
+func (d *Delivery) copy() Delivery {
+	delivery := *d
+	delivery.Attempts = append([]DeliveryAttempt{}, d.Attempts...)
+	return delivery
+}
+
+// SignWebhook is the X-Certviewer-Signature of a payload sent at timestamp: "sha256=" and the hex HMAC-SHA256,
+// keyed with the shared secret, of the Unix timestamp, a dot and the payload. Signing the timestamp lets
+// receivers turn away old payloads replayed at them
+func SignWebhook(secret string, timestamp time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
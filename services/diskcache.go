@@ -0,0 +1,243 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Eviction policies for a DiskCache that outgrows its size cap
+const (
+	EvictLRU  = "lru"  // Drop what was read or written longest ago
+	EvictFIFO = "fifo" // Drop what was written longest ago, however often it's read
+)
+
+// Buckets in the cache file; a logged certificate never changes, while searches are the last results for a domain
+var (
+	cacheBucketPEMs     = []byte("pems")
+	cacheBucketSearches = []byte("searches")
+)
+
+// DiskCache keeps downloaded certificates and the last search results for each domain in a bbolt file,
+// so they survive restarts without Redis or a database; it is safe for concurrent use
+// Every value is stored behind the 8-byte Unix nanosecond time it was written. Reads are tracked in
+// memory only, so after a restart LRU starts over from write order
+type DiskCache struct {
+	db       *bolt.DB
+	maxBytes int64 // 0 means no cap
+	policy   string
+
+	mu      sync.Mutex
+	entries map[diskCacheKey]*diskCacheEntry
+	size    int64
+}
+
+type diskCacheKey struct {
+	bucket string
+	key    string
+}
+
+// diskCacheEntry is what eviction needs to know about a stored value
+type diskCacheEntry struct {
+	size   int64
+	stored time.Time
+	used   time.Time
+}
+
+// storedSearch is a domain's last search results as kept in the cache file
+type storedSearch struct {
+	Certificates []Certificate `json:"certificates"`
+	FetchedAt    time.Time     `json:"fetchedAt"`
+}
+
+// diskCache is the cache in use, or nil to keep everything in memory
+var diskCache *DiskCache
+
+// SetDiskCache makes downloads and searches go through a disk cache; nil turns it off
+func SetDiskCache(cache *DiskCache) {
+	diskCache = cache
+}
+
+// OpenDiskCache opens or creates the cache file at path, evicting by policy (EvictLRU or EvictFIFO) once
+// its values pass maxBytes; 0 means no cap
+func OpenDiskCache(path string, maxBytes int64, policy string) (*DiskCache, error) {
+	if policy != EvictLRU && policy != EvictFIFO {
+		return nil, fmt.Errorf("unknown cache eviction policy %q, use %s or %s", policy, EvictLRU, EvictFIFO)
+	}
+	if maxBytes < 0 {
+		return nil, errors.New("cache size can't be negative")
+	}
+
+	// Another process holding the file (a second server, say) fails the open rather than hanging it
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache %s: %w", path, err)
+	}
+	c := &DiskCache{db: db, maxBytes: maxBytes, policy: policy, entries: make(map[diskCacheKey]*diskCacheEntry)}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{cacheBucketPEMs, cacheBucketSearches} {
+			bucket, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+			err = bucket.ForEach(func(key, value []byte) error {
+				if len(value) < 8 {
+					return nil
+				}
+				stored := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+				c.entries[diskCacheKey{string(name), string(key)}] = &diskCacheEntry{size: int64(len(value)), stored: stored, used: stored}
+				c.size += int64(len(value))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// The cap may have shrunk since the file was written
+		return c.evict(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Close closes the cache file
+func (c *DiskCache) Close() error {
+	return c.db.Close()
+}
+
+// Size returns how many values are cached and their total size in bytes
+func (c *DiskCache) Size() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+// get returns a cached value
+func (c *DiskCache) get(bucket []byte, key string) ([]byte, bool) {
+	var value []byte
+	c.db.View(func(tx *bolt.Tx) error {
+		if stored := tx.Bucket(bucket).Get([]byte(key)); len(stored) >= 8 {
+			value = append([]byte(nil), stored[8:]...)
+		}
+		return nil
+	})
+	if value == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.entries[diskCacheKey{string(bucket), key}]; ok {
+		entry.used = time.Now()
+	}
+	c.mu.Unlock()
+	return value, true
+}
+
+// put stores a value, evicting others if the cache has grown past its cap
+func (c *DiskCache) put(bucket []byte, key string, value []byte) error {
+	now := time.Now()
+	stored := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(stored, uint64(now.UnixNano()))
+	copy(stored[8:], value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucket).Put([]byte(key), stored); err != nil {
+			return err
+		}
+		id := diskCacheKey{string(bucket), key}
+		if old, ok := c.entries[id]; ok {
+			c.size -= old.size
+		}
+		c.entries[id] = &diskCacheEntry{size: int64(len(stored)), stored: now, used: now}
+		c.size += int64(len(stored))
+		return c.evict(tx)
+	})
+}
+
+// evict deletes values by the cache's policy until they fit under its cap; c.mu must be held
+func (c *DiskCache) evict(tx *bolt.Tx) error {
+	if c.maxBytes == 0 || c.size <= c.maxBytes {
+		return nil
+	}
+
+	keys := make([]diskCacheKey, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	age := func(entry *diskCacheEntry) time.Time {
+		if c.policy == EvictLRU {
+			return entry.used
+		}
+		return entry.stored
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return age(c.entries[keys[i]]).Before(age(c.entries[keys[j]]))
+	})
+
+	for _, key := range keys {
+		if c.size <= c.maxBytes {
+			break
+		}
+		if err := tx.Bucket([]byte(key.bucket)).Delete([]byte(key.key)); err != nil {
+			return err
+		}
+		c.size -= c.entries[key].size
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+// cachedPEM returns a certificate downloaded before, if the disk cache has it
+func cachedPEM(id int64) ([]byte, bool) {
+	if diskCache == nil {
+		return nil, false
+	}
+	return diskCache.get(cacheBucketPEMs, strconv.FormatInt(id, 10))
+}
+
+// cachePEM keeps a downloaded certificate on disk, if there is a disk cache
+func cachePEM(id int64, pemData []byte) {
+	if diskCache != nil {
+		diskCache.put(cacheBucketPEMs, strconv.FormatInt(id, 10), pemData)
+	}
+}
+
+// cachedSearch returns the last results the disk cache has for a domain
+func cachedSearch(domain string) (lastSearch, bool) {
+	if diskCache == nil {
+		return lastSearch{}, false
+	}
+	data, ok := diskCache.get(cacheBucketSearches, domain)
+	if !ok {
+		return lastSearch{}, false
+	}
+	var stored storedSearch
+	if json.Unmarshal(data, &stored) != nil {
+		return lastSearch{}, false
+	}
+	return lastSearch{certs: stored.Certificates, fetchedAt: stored.FetchedAt}, true
+}
+
+// cacheSearch keeps a domain's search results on disk, if there is a disk cache
+func cacheSearch(domain string, search lastSearch) {
+	if diskCache == nil {
+		return
+	}
+	data, err := json.Marshal(storedSearch{Certificates: search.certs, FetchedAt: search.fetchedAt})
+	if err == nil {
+		diskCache.put(cacheBucketSearches, domain, data)
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsTimeout bounds each hostname's lookups
+const dnsTimeout = 5 * time.Second
+
+// DNSRecord is where a hostname currently points
+type DNSRecord struct {
+	Hostname string   `json:"hostname"`
+	CNAME    string   `json:"cname,omitempty"` // Canonical name, if the hostname is an alias
+	A        []string `json:"a"`
+	AAAA     []string `json:"aaaa"`
+	Error    string   `json:"error,omitempty"` // e.g. NXDOMAIN for names that no longer exist
+}
+
+// NewResolver returns a resolver that queries addr (host:port), or the system resolver when addr is empty
+func NewResolver(addr string) *net.Resolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: dnsTimeout}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// ResolveHostnames looks up A, AAAA and CNAME records for each hostname
+// Wildcard names can't be resolved and are skipped
+func ResolveHostnames(ctx context.Context, resolver *net.Resolver, hostnames []string) []DNSRecord {
+	records := make([]DNSRecord, 0, len(hostnames))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+
+	for i := 0; i < sweepWorkers*2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hostname := range queue {
+				record := resolveHostname(ctx, resolver, hostname)
+				mu.Lock()
+				records = append(records, record)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, hostname := range hostnames {
+		if !strings.HasPrefix(hostname, "*.") {
+			queue <- hostname
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Hostname < records[j].Hostname
+	})
+	return records
+}
+
+// resolveHostname looks up a single hostname
+func resolveHostname(ctx context.Context, resolver *net.Resolver, hostname string) DNSRecord {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	record := DNSRecord{
+		Hostname: hostname,
+		A:        make([]string, 0),
+		AAAA:     make([]string, 0),
+	}
+
+	// LookupCNAME returns the name itself when there is no alias
+	if cname, err := resolver.LookupCNAME(ctx, hostname); err == nil {
+		cname = NormalizeName(cname)
+		if cname != hostname {
+			record.CNAME = cname
+		}
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			record.Error = "no such host"
+		} else {
+			record.Error = err.Error()
+		}
+		return record
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			record.A = append(record.A, addr.IP.String())
+		} else {
+			record.AAAA = append(record.AAAA, addr.IP.String())
+		}
+	}
+	return record
+}
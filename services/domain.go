@@ -0,0 +1,116 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// Limits on domain names from RFC 1035
+const (
+	maxDomainLength = 253
+	maxLabelLength  = 63
+)
+
+// Reasons a domain name is rejected, as sentences users can act on and templates can translate
+const (
+	reasonEmpty      = "Please enter a domain name"
+	reasonControl    = "Domain names can't contain control characters"
+	reasonCharacters = "Domain names can only contain letters, digits, hyphens and dots"
+	reasonEmptyLabel = "Domain names can't have an empty part, such as two dots in a row"
+	reasonLongLabel  = "Each part of a domain name can be at most 63 characters"
+	reasonLongDomain = "Domain names can be at most 253 characters"
+	reasonPunycode   = "That isn't a valid internationalized domain name"
+)
+
+// DomainError says why a domain name was rejected; it wraps ErrInvalidDomain
+type DomainError struct {
+	Field  string `json:"field"` // The query parameter or form field it came from
+	Input  string `json:"input"`
+	Reason string `json:"reason"`
+}
+
+func (e *DomainError) Error() string {
+	return fmt.Sprintf("%v %q: %s", ErrInvalidDomain, e.Input, e.Reason)
+}
+
+// Unwrap makes the error ErrInvalidDomain
+func (e *DomainError) Unwrap() error {
+	return ErrInvalidDomain
+}
+
+// NormalizeDomain checks a domain name typed or pasted in, and returns it lowercase in punycode
+// A pasted URL is cut down to its host ("https://Example.com:8443/path" is "example.com"), a trailing
+// dot is dropped, and a leading "%" or "*." (crt.sh wildcards) is kept as-is
+func NormalizeDomain(input string) (string, error) {
+	invalid := func(reason string) error {
+		return &DomainError{Field: "domain", Input: input, Reason: reason}
+	}
+
+	domain := strings.TrimSpace(input)
+	if domain == "" {
+		return "", invalid(reasonEmpty)
+	}
+	if strings.ContainsFunc(domain, unicode.IsControl) {
+		return "", invalid(reasonControl)
+	}
+
+	// Cut a URL down to its host
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+len("://"):]
+		if end := strings.IndexAny(domain, "/?#"); end >= 0 {
+			domain = domain[:end]
+		}
+		if at := strings.LastIndex(domain, "@"); at >= 0 {
+			domain = domain[at+1:]
+		}
+	} else if end := strings.IndexAny(domain, "/?#"); end >= 0 {
+		domain = domain[:end]
+	}
+	if host, port, err := net.SplitHostPort(domain); err == nil && port != "" && strings.Trim(port, "0123456789") == "" {
+		domain = host
+	}
+
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	name := strings.TrimLeft(domain, "%*.")
+	prefix := domain[:len(domain)-len(name)]
+	if name == "" {
+		return "", invalid(reasonEmpty)
+	}
+
+	// Check the labels before idna does, to say which rule was broken
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return "", invalid(reasonEmptyLabel)
+		}
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		if strings.HasPrefix(name, "xn--") || strings.Contains(name, ".xn--") {
+			return "", invalid(reasonPunycode)
+		}
+		return "", invalid(reasonCharacters)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if len(label) > maxLabelLength {
+			return "", invalid(reasonLongLabel)
+		}
+	}
+	if len(ascii) > maxDomainLength {
+		return "", invalid(reasonLongDomain)
+	}
+
+	return prefix + ascii, nil
+}
+
+// NormalizeField is NormalizeDomain for a field other than "domain", such as a host or an origin
+func NormalizeField(field, input string) (string, error) {
+	domain, err := NormalizeDomain(input)
+	if domainErr, ok := err.(*DomainError); ok {
+		domainErr.Field = field
+	}
+	return domain, err
+}
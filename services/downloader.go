@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+// maxConcurrentDownloads caps the certificate downloads in flight to crt.sh across the whole server
+const maxConcurrentDownloads = 6
+
+// downloads is the server-wide certificate downloader: its slots bound concurrency, and inFlight lets a
+// second request for a certificate wait for the first; crt.sh's request budget paces them
+var downloads = struct {
+	slots chan struct{}
+
+	sync.Mutex
+	inFlight map[int64]*download
+}{
+	slots:    make(chan struct{}, maxConcurrentDownloads),
+	inFlight: make(map[int64]*download),
+}
+
+// download is one certificate being fetched; done is closed once cert or err is set
+type download struct {
+	done      chan struct{}
+	cert      *x509.Certificate
+	err       error
+	abandoned bool // The first requester went away, so err says nothing about the certificate
+}
+
+// downloadCertificate returns the certificate crt.sh has under an ID from memory, the disk cache, or
+// a download that shares the server's limits with every other
+func downloadCertificate(ctx context.Context, id int64) (*x509.Certificate, error) {
+	if cert, ok := storedCertificate(id); ok {
+		return cert, nil
+	}
+
+	downloads.Lock()
+	for {
+		pending, ok := downloads.inFlight[id]
+		if !ok {
+			break
+		}
+		downloads.Unlock()
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, ctsearch.RequestError(ctx.Err())
+		}
+		// The download ran on its first requester's context; when that requester went away, the rest
+		// try again themselves rather than fail with it
+		if !pending.abandoned {
+			return pending.cert, pending.err
+		}
+		downloads.Lock()
+	}
+	pending := &download{done: make(chan struct{})}
+	downloads.inFlight[id] = pending
+	downloads.Unlock()
+
+	pending.cert, pending.err = fetchCertificate(ctx, id)
+	pending.abandoned = pending.err != nil && ctx.Err() != nil
+	downloads.Lock()
+	delete(downloads.inFlight, id)
+	downloads.Unlock()
+	close(pending.done)
+	return pending.cert, pending.err
+}
+
+// downloadCertificates returns several certificates by crt.sh ID, downloading those not cached a few at a time
+// Certificates that couldn't be fetched or parsed are returned in the error map
+func downloadCertificates(ctx context.Context, ids []int64) (map[int64]*x509.Certificate, map[int64]error) {
+	certs := make(map[int64]*x509.Certificate, len(ids))
+	errs := make(map[int64]error)
+
+	queue := make(chan int64)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(len(ids), maxConcurrentDownloads) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				cert, err := downloadCertificate(ctx, id)
+				mu.Lock()
+				if err != nil {
+					errs[id] = err
+				} else {
+					certs[id] = cert
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+	return certs, errs
+}
+
+// fetchCertificate downloads and parses a certificate once a download slot is free, then caches it in
+// memory and on disk
+func fetchCertificate(ctx context.Context, id int64) (*x509.Certificate, error) {
+	select {
+	case downloads.slots <- struct{}{}:
+		defer func() { <-downloads.slots }()
+	case <-ctx.Done():
+		return nil, ctsearch.RequestError(ctx.Err())
+	}
+
+	var pemData []byte
+	err := callUpstream(ctx, crtshDownloadBreaker, func() (err error) {
+		pemData, err = x509info.FetchPEM(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, certificateNotFound(err)
+	}
+	cert, err := x509info.ParseCertificatePEM(pemData)
+	if err != nil {
+		return nil, err
+	}
+	rememberCertificate(id, cert)
+	cachePEM(id, pemData)
+	return cert, nil
+}
+
+// storedCertificate returns a certificate downloaded before from memory or the disk cache, without downloading it
+func storedCertificate(id int64) (*x509.Certificate, bool) {
+	if cert, ok := cachedCertificate(id); ok {
+		return cert, true
+	}
+	if pemData, ok := cachedPEM(id); ok {
+		if cert, err := x509info.ParseCertificatePEM(pemData); err == nil {
+			rememberCertificate(id, cert)
+			return cert, true
+		}
+	}
+	return nil, false
+}
+
+// cachedCertificate returns a certificate downloaded before, if it's still held in memory
+func cachedCertificate(id int64) (*x509.Certificate, bool) {
+	certificateCache.Lock()
+	defer certificateCache.Unlock()
+	cert, ok := certificateCache.certs[id]
+	return cert, ok
+}
+
+// rememberCertificate holds a certificate in memory, dropping the oldest to make room
+func rememberCertificate(id int64, cert *x509.Certificate) {
+	certificateCache.Lock()
+	defer certificateCache.Unlock()
+	if _, exists := certificateCache.certs[id]; exists {
+		return
+	}
+	if len(certificateCache.order) >= maxCachedCertificates {
+		delete(certificateCache.certs, certificateCache.order[0])
+		certificateCache.order = certificateCache.order[1:]
+	}
+	certificateCache.certs[id] = cert
+	certificateCache.order = append(certificateCache.order, id)
+}
+
+// encodePEM is a certificate as FetchPEM returns it
+func encodePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Errors that mean something to the person searching; handlers tell them apart with errors.Is
+// to pick a status and a message, rather than showing the error itself
+var (
+	ErrInvalidDomain = errors.New("invalid domain name")
+	ErrInvalidSerial = errors.New("invalid serial number")
+	ErrNoResults     = errors.New("not found on crt.sh")
+	ErrNotSearched   = errors.New("no stored results, the domain hasn't been searched")
+
+	// crt.sh failures, see pkg/ctsearch
+	ErrUpstreamTimeout     = ctsearch.ErrTimeout
+	ErrRateLimited         = ctsearch.ErrRateLimited
+	ErrUpstreamMaintenance = ctsearch.ErrMaintenance
+	ErrUpstreamUnavailable = ctsearch.ErrUnavailable
+)
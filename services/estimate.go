@@ -0,0 +1,35 @@
+package services
+
+import (
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// LargeResultCount is how many certificates make a domain worth warning about before it's searched:
+// crt.sh takes long enough over them that a search may time out
+const LargeResultCount = 5000
+
+// ResultEstimate is how large a search's answer will likely be, going by the last search for the domain
+// crt.sh has no way to count results without sending them, so history is all there is to go on
+type ResultEstimate struct {
+	Certificates int       `json:"certificates"` // Rows the last search found
+	Truncated    bool      `json:"truncated"`    // It stopped at ResultLimit, so the domain has more
+	Large        bool      `json:"large"`        // At least LargeResultCount
+	FetchedAt    time.Time `json:"fetchedAt"`    // When the last search ran
+}
+
+// EstimateResults estimates a search for domain from the last results found for it, in memory or in
+// the disk cache; ok is false when it hasn't been searched
+func EstimateResults(domain string) (ResultEstimate, bool) {
+	last, ok := lastResultsFor(domain)
+	if !ok {
+		return ResultEstimate{}, false
+	}
+	return ResultEstimate{
+		Certificates: len(last.certs),
+		Truncated:    ctsearch.Truncated(len(last.certs)),
+		Large:        len(last.certs) >= LargeResultCount,
+		FetchedAt:    last.fetchedAt,
+	}, true
+}
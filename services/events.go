@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Event types forwarded to event sinks
+const (
+	EventNewCertificate = "new_certificate" // A certificate for a watched domain was logged since its last check
+	EventAlert          = "alert"           // A watched domain raised an alert
+)
+
+// Event is something that happened to a watched domain, forwarded server-wide to SIEMs and other sinks
+// whoever watches the domain
+type Event struct {
+	Type        string            `json:"type"`
+	Domain      string            `json:"domain"`
+	Time        time.Time         `json:"time"`
+	Certificate *EventCertificate `json:"certificate,omitempty"` // For EventNewCertificate
+	Alert       *Alert            `json:"alert,omitempty"`       // For EventAlert
+}
+
+// EventCertificate is a newly logged certificate, flattened for SIEM searches
+type EventCertificate struct {
+	SerialNumber string    `json:"serialNumber"`
+	CommonName   string    `json:"commonName"`
+	Names        []string  `json:"names"`
+	Issuer       string    `json:"issuer"`
+	IssuerName   string    `json:"issuerName"` // The issuer's full distinguished name
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	LoggedAt     time.Time `json:"loggedAt"`
+	CrtshIDs     []int64   `json:"crtshIds"`
+}
+
+// EventSink delivers events somewhere outside the server, e.g. a Splunk HTTP Event Collector
+type EventSink interface {
+	// Name identifies the sink in logs and the audit log
+	Name() string
+	// Send delivers a batch of events, all or nothing as far as the sink allows
+	Send(ctx context.Context, events []Event) error
+}
+
+// CertificateEvents are the events for certificates newly logged for domain
+func CertificateEvents(domain string, groups []CertificateGroup, now time.Time) []Event {
+	events := make([]Event, 0, len(groups))
+	for _, group := range groups {
+		ids := make([]int64, 0, len(group.Entries))
+		for _, entry := range group.Entries {
+			ids = append(ids, entry.ID)
+		}
+		events = append(events, Event{
+			Type:   EventNewCertificate,
+			Domain: domain,
+			Time:   now,
+			Certificate: &EventCertificate{
+				SerialNumber: group.SerialNumber,
+				CommonName:   group.CommonName,
+				Names:        GroupNames(group),
+				Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+				IssuerName:   group.IssuerName,
+				NotBefore:    group.NotBeforeTime,
+				NotAfter:     group.NotAfterTime,
+				LoggedAt:     firstLogged(group),
+				CrtshIDs:     ids,
+			},
+		})
+	}
+	return events
+}
+
+// AlertEvents are the events for alerts raised for watched domains
+func AlertEvents(alerts []Alert) []Event {
+	events := make([]Event, 0, len(alerts))
+	for _, alert := range alerts {
+		events = append(events, Event{Type: EventAlert, Domain: alert.Domain, Time: alert.CreatedAt, Alert: &alert})
+	}
+	return events
+}
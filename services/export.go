@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// CertificateRow is one certificate as a flat export record; CSV and NDJSON exports have the same columns
+type CertificateRow struct {
+	Domain       string     `json:"domain"` // The domain searched
+	SerialNumber string     `json:"serial_number,omitempty"`
+	CommonName   string     `json:"common_name,omitempty"`
+	Names        []string   `json:"names,omitempty"`
+	Issuer       string     `json:"issuer,omitempty"`
+	NotBefore    *time.Time `json:"not_before,omitempty"`
+	NotAfter     *time.Time `json:"not_after,omitempty"`
+	Purpose      string     `json:"purpose,omitempty"`
+	FirstLogged  *time.Time `json:"first_logged,omitempty"`
+	CrtshIDs     []int64    `json:"crtsh_ids,omitempty"`
+	FetchedAt    *time.Time `json:"fetched_at,omitempty"` // When the domain's stored results were found on crt.sh
+	Error        string     `json:"error,omitempty"`      // Set, alone, on a domain with nothing stored
+	Err          error      `json:"-"`                    // Why, for the caller to describe in Error
+}
+
+// CertificateCSVHeader names the columns of a certificate CSV export
+var CertificateCSVHeader = []string{"domain", "serial_number", "common_name", "names", "issuer", "not_before", "not_after", "purpose", "first_logged", "crtsh_ids", "fetched_at", "error"}
+
+// CSV is the row as CSV fields; lists are space separated
+func (r CertificateRow) CSV() []string {
+	ids := make([]string, len(r.CrtshIDs))
+	for i, id := range r.CrtshIDs {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	return []string{
+		CSVSafe(r.Domain),
+		CSVSafe(r.SerialNumber),
+		CSVSafe(r.CommonName),
+		CSVSafe(strings.Join(r.Names, " ")),
+		CSVSafe(r.Issuer),
+		formatRowTime(r.NotBefore),
+		formatRowTime(r.NotAfter),
+		CSVSafe(r.Purpose),
+		formatRowTime(r.FirstLogged),
+		strings.Join(ids, " "),
+		formatRowTime(r.FetchedAt),
+		CSVSafe(r.Error),
+	}
+}
+
+// CSVSafe stops values from certificates or users being run as formulas when an export is opened in a spreadsheet
+// Spreadsheets also treat a leading tab or carriage return as the start of a formula
+func CSVSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formatRowTime writes an export time as RFC 3339, or nothing
+func formatRowTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ExportCertificates hands emit each domain's certificates from its last stored search, in memory or the
+// disk cache, newest first, one domain at a time, so only one domain's certificates are held however many
+// rows the export comes to and crt.sh isn't searched
+// A domain with nothing stored gets one row with Err set to ErrNotSearched; an error from emit stops the export
+func ExportCertificates(ctx context.Context, domains []string, emit func(CertificateRow) error) error {
+	for _, domain := range domains {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		last, ok := lastResultsFor(domain)
+		if !ok {
+			if err := emit(CertificateRow{Domain: domain, Err: ErrNotSearched}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fetchedAt := last.fetchedAt.UTC()
+		groups := GroupCertificates(last.certs)
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i].NotBeforeTime.After(groups[j].NotBeforeTime)
+		})
+		for _, group := range groups {
+			row := certificateRow(domain, group)
+			row.FetchedAt = &fetchedAt
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// certificateRow flattens a certificate for export
+func certificateRow(domain string, group CertificateGroup) CertificateRow {
+	notBefore, notAfter := group.NotBeforeTime.UTC(), group.NotAfterTime.UTC()
+	row := CertificateRow{
+		Domain:       domain,
+		SerialNumber: group.SerialNumber,
+		CommonName:   group.CommonName,
+		Names:        GroupNames(group),
+		Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+		NotBefore:    &notBefore,
+		NotAfter:     &notAfter,
+		Purpose:      group.Purpose,
+		CrtshIDs:     make([]int64, 0, len(group.Entries)),
+	}
+	if logged := firstLogged(group); !logged.IsZero() {
+		logged = logged.UTC()
+		row.FirstLogged = &logged
+	}
+	for _, entry := range group.Entries {
+		row.CrtshIDs = append(row.CrtshIDs, entry.ID)
+	}
+	return row
+}
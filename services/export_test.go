@@ -0,0 +1,61 @@
+package services
+
+import "testing"
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"example.com", "example.com"},
+		{"=HYPERLINK(\"http://evil.example\")", "'=HYPERLINK(\"http://evil.example\")"},
+		{"+1+1", "'+1+1"},
+		{"-1+1", "'-1+1"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		if got := CSVSafe(tt.value); got != tt.want {
+			t.Errorf("CSVSafe(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCertificateRowCSVEscapesFormulas(t *testing.T) {
+	tests := []struct {
+		name string
+		san  string
+	}{
+		{name: "equals", san: "=HYPERLINK(\"http://evil.example\",\"x\")"},
+		{name: "plus", san: "+cmd|' /C calc'!A0"},
+		{name: "minus", san: "-2+3"},
+		{name: "at", san: "@SUM(1+1)"},
+		{name: "tab", san: "\t=1+1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := CertificateRow{
+				Domain:     "example.com",
+				CommonName: tt.san,
+				Names:      []string{tt.san, "www.example.com"},
+				Issuer:     tt.san,
+				Error:      tt.san,
+			}
+			fields := row.CSV()
+			for i, column := range CertificateCSVHeader {
+				switch column {
+				case "common_name", "names", "issuer", "error":
+					if fields[i] == "" || fields[i][0] != '\'' {
+						t.Errorf("%s = %q, want it quoted with a leading '", column, fields[i])
+					}
+				}
+			}
+			if fields[0] != "example.com" {
+				t.Errorf("domain = %q, want it unchanged", fields[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import "sort"
+
+// Finding severities, most serious last
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Finding is a single issue spotted while assessing a domain's certificates
+type Finding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`   // Which check raised it, e.g. "weak-crypto"
+	Subject  string `json:"subject"` // What it is about: a hostname, certificate or issuer
+	Message  string `json:"message"`
+}
+
+// severityRank orders severities for sorting
+var severityRank = map[string]int{
+	SeverityCritical: 0,
+	SeverityWarning:  1,
+	SeverityInfo:     2,
+}
+
+// SortFindings orders findings most serious first, then by check and subject
+func SortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] < severityRank[b.Severity]
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Subject < b.Subject
+	})
+}
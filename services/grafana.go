@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of Grafana datasource target; a target is a kind alone, for every watched domain, or
+// kind:domain for one
+const (
+	GrafanaIssuance = "issuance" // Time series of certificates issued
+	GrafanaExpiring = "expiring" // Table of valid certificates expiring within expiringSoonDays
+)
+
+// maxGrafanaPoints caps the points in one time series, however short the interval Grafana asks for
+const maxGrafanaPoints = 2000
+
+// ExpiringCertificate is a valid certificate of a watched domain that expires soon
+type ExpiringCertificate struct {
+	Domain string
+	CertificateGroup
+	DaysLeft int
+}
+
+// GrafanaTargets lists the targets Grafana can query for domains: each kind for all of them, then for each
+func GrafanaTargets(domains []string) []string {
+	targets := []string{GrafanaIssuance, GrafanaExpiring}
+	for _, domain := range domains {
+		targets = append(targets, GrafanaIssuance+":"+domain, GrafanaExpiring+":"+domain)
+	}
+	return targets
+}
+
+// ParseGrafanaTarget splits a target into its kind and domain, "" for every watched domain
+func ParseGrafanaTarget(target string) (string, string, error) {
+	kind, domain, _ := strings.Cut(strings.TrimSpace(target), ":")
+	if kind != GrafanaIssuance && kind != GrafanaExpiring {
+		return "", "", fmt.Errorf("unknown target %q, use %s or %s, optionally followed by :domain", target, GrafanaIssuance, GrafanaExpiring)
+	}
+	if domain == "" {
+		return kind, "", nil
+	}
+	domain, err := NormalizeDomain(domain)
+	if err != nil {
+		return "", "", err
+	}
+	return kind, domain, nil
+}
+
+// IssuanceSeries counts the certificates issued to domains in each step from from to to, going by the
+// last results found for each, as Grafana datapoints: count, then the step's start in Unix milliseconds
+// Steps are widened when there would be more than maxGrafanaPoints of them
+func IssuanceSeries(domains []string, from, to time.Time, step time.Duration) ([][2]float64, error) {
+	if !from.Before(to) {
+		return nil, errors.New("the time range is empty")
+	}
+	step = max(step, time.Minute, to.Sub(from)/maxGrafanaPoints+1)
+	from = from.Truncate(step)
+
+	counts := make([]int, int(to.Sub(from)/step)+1)
+	for _, domain := range domains {
+		for _, group := range storedGroups(domain) {
+			if group.NotBeforeTime.Before(from) || !group.NotBeforeTime.Before(to) {
+				continue
+			}
+			counts[group.NotBeforeTime.Sub(from)/step]++
+		}
+	}
+
+	points := make([][2]float64, len(counts))
+	for i, count := range counts {
+		points[i] = [2]float64{float64(count), float64(from.Add(time.Duration(i) * step).UnixMilli())}
+	}
+	return points, nil
+}
+
+// ExpiringCertificates lists the certificates of domains, going by the last results found for each,
+// that are valid now and expire within expiringSoonDays, soonest first
+func ExpiringCertificates(domains []string, now time.Time) []ExpiringCertificate {
+	soon := now.AddDate(0, 0, expiringSoonDays)
+	expiring := make([]ExpiringCertificate, 0)
+	for _, domain := range domains {
+		for _, group := range storedGroups(domain) {
+			if isActive(group, now) && group.NotAfterTime.Before(soon) {
+				expiring = append(expiring, ExpiringCertificate{Domain: domain, CertificateGroup: group, DaysLeft: daysBetween(now, group.NotAfterTime)})
+			}
+		}
+	}
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiring[i].NotAfterTime.Before(expiring[j].NotAfterTime)
+	})
+	return expiring
+}
+
+// storedGroups returns a domain's certificates from the last results found for it, without searching crt.sh
+func storedGroups(domain string) []CertificateGroup {
+	last, ok := lastResultsFor(domain)
+	if !ok {
+		return nil
+	}
+	return GroupCertificates(last.certs)
+}
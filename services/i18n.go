@@ -0,0 +1,103 @@
+package services
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Language is one the interface can be shown in
+type Language struct {
+	Code string `json:"code"` // BCP 47, e.g. "de"
+	Name string `json:"name"` // In the language itself, for pickers
+}
+
+// DefaultLanguage is the source language: messages are written in it and used as catalog keys
+const DefaultLanguage = "en"
+
+// Languages lists the supported languages, the default first
+var Languages = []Language{
+	{Code: "en", Name: "English"},
+	{Code: "de", Name: "Deutsch"},
+}
+
+// catalogFiles holds a JSON catalog per language besides English, mapping each English message to its translation
+//
+//go:embed locales/*.json
+var catalogFiles embed.FS
+
+// catalogs are the parsed catalogs by language code
+var catalogs = loadCatalogs()
+
+// languageMatcher picks the closest supported language for a visitor's preferences
+var languageMatcher = language.NewMatcher(languageTags())
+
+func languageTags() []language.Tag {
+	tags := make([]language.Tag, 0, len(Languages))
+	for _, lang := range Languages {
+		tags = append(tags, language.MustParse(lang.Code))
+	}
+	return tags
+}
+
+// loadCatalogs reads the embedded catalogs, failing loudly on a malformed one since they ship with the binary
+func loadCatalogs() map[string]map[string]string {
+	loaded := make(map[string]map[string]string)
+	for _, lang := range Languages[1:] {
+		data, err := catalogFiles.ReadFile(path.Join("locales", lang.Code+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("missing message catalog for %s: %v", lang.Code, err))
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("invalid message catalog for %s: %v", lang.Code, err))
+		}
+		loaded[lang.Code] = messages
+	}
+	return loaded
+}
+
+// SupportedLanguage returns the code if the interface is translated into it, or "" if not
+// Region variants fall back to their language, so "de-AT" is "de"
+func SupportedLanguage(code string) string {
+	tag, err := language.Parse(strings.TrimSpace(code))
+	if err != nil {
+		return ""
+	}
+	base, _ := tag.Base()
+	for _, lang := range Languages {
+		if lang.Code == base.String() {
+			return lang.Code
+		}
+	}
+	return ""
+}
+
+// NegotiateLanguage picks a supported language from an Accept-Language header, the default when none match
+func NegotiateLanguage(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLanguage
+	}
+	_, index, confidence := languageMatcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLanguage
+	}
+	return Languages[index].Code
+}
+
+// Translate returns a message in a language, falling back to the English message when it has no translation
+// With args, the message (and its translation) is a fmt format, e.g. Translate("de", "Found %d certificate(s)", 3)
+func Translate(lang, message string, args ...any) string {
+	if translated, ok := catalogs[lang][message]; ok && translated != "" {
+		message = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// ToASCII converts an internationalized domain to punycode for upstream queries
+// A leading "%" or "*." (crt.sh wildcards) is kept as-is
+func ToASCII(domain string) (string, error) {
+	domain = strings.TrimSpace(domain)
+	name := strings.TrimLeft(domain, "%*.")
+	prefix := domain[:len(domain)-len(name)]
+
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %w", ErrInvalidDomain, domain, err)
+	}
+	return prefix + ascii, nil
+}
+
+// ToUnicode returns the Unicode form of a punycode name, or the name unchanged
+func ToUnicode(name string) string {
+	trimmed := strings.TrimLeft(name, "%*.")
+	unicodeName, err := idna.Display.ToUnicode(trimmed)
+	if err != nil {
+		return name
+	}
+	return name[:len(name)-len(trimmed)] + unicodeName
+}
+
+// DisplayName shows both forms of an internationalized name, e.g. "bücher.example (xn--bcher-kva.example)"
+func DisplayName(name string) string {
+	if unicodeName := ToUnicode(name); unicodeName != name {
+		return fmt.Sprintf("%s (%s)", unicodeName, name)
+	}
+	return name
+}
+
+// ConfusableName is a name whose Unicode form could be mistaken for another
+type ConfusableName struct {
+	Name    string `json:"name"` // Punycode form
+	Unicode string `json:"unicode"`
+	Reason  string `json:"reason"`
+}
+
+// scripts checked when classifying letters, in the order they are reported
+var scripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+	{"Armenian", unicode.Armenian},
+	{"Georgian", unicode.Georgian},
+	{"Cherokee", unicode.Cherokee},
+	{"Hebrew", unicode.Hebrew},
+	{"Arabic", unicode.Arabic},
+	{"Devanagari", unicode.Devanagari},
+	{"Thai", unicode.Thai},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+}
+
+// allowedScriptMixes are combinations used together in normal writing (UTS #39 "highly restrictive")
+var allowedScriptMixes = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// latinLookalikes maps Cyrillic and Greek letters to the Latin letters they are indistinguishable from
+var latinLookalikes = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'ӏ': 'l',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ԝ': 'w', 'х': 'x', 'у': 'y',
+	// Greek
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+}
+
+// FindConfusables checks names for labels that mix scripts, or that are written
+// entirely in Cyrillic or Greek letters that look Latin
+func FindConfusables(names []string) []ConfusableName {
+	found := make([]ConfusableName, 0)
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		name = NormalizeName(name)
+		if seen[name] || !strings.Contains(name, "xn--") {
+			continue
+		}
+		seen[name] = true
+
+		unicodeName := ToUnicode(name)
+		if reason := confusableReason(unicodeName); reason != "" {
+			found = append(found, ConfusableName{Name: name, Unicode: unicodeName, Reason: reason})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Name < found[j].Name
+	})
+	return found
+}
+
+// confusableReason explains why a Unicode name is confusable, or returns ""
+func confusableReason(name string) string {
+	for _, label := range strings.Split(name, ".") {
+		used := labelScripts(label)
+		if len(used) > 1 && !allowedMix(used) {
+			return fmt.Sprintf("label %q mixes %s scripts", label, strings.Join(used, " and "))
+		}
+		if skeleton, ok := latinSkeleton(label); ok {
+			return fmt.Sprintf("label %q is written in non-Latin letters that look like %q", label, skeleton)
+		}
+	}
+	return ""
+}
+
+// labelScripts lists the scripts of the letters in a label (digits and hyphens belong to none)
+func labelScripts(label string) []string {
+	used := make([]string, 0)
+	for _, script := range scripts {
+		for _, r := range label {
+			if unicode.Is(script.table, r) {
+				used = append(used, script.name)
+				break
+			}
+		}
+	}
+	return used
+}
+
+// allowedMix reports whether every script used is part of one allowed combination
+func allowedMix(used []string) bool {
+	for _, mix := range allowedScriptMixes {
+		allowed := true
+		for _, script := range used {
+			if !containsString(mix, script) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// latinSkeleton returns the Latin name a label imitates when all its letters are lookalikes
+func latinSkeleton(label string) (string, bool) {
+	var skeleton strings.Builder
+	letters := 0
+	for _, r := range label {
+		if latin, ok := latinLookalikes[r]; ok {
+			skeleton.WriteRune(latin)
+			letters++
+			continue
+		}
+		if unicode.IsLetter(r) {
+			return "", false
+		}
+		skeleton.WriteRune(r)
+	}
+	return skeleton.String(), letters > 0
+}
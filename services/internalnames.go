@@ -0,0 +1,123 @@
+package services
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Reasons a name on a certificate is internal, as sentences templates can translate
+const (
+	reasonPrivateIP   = "Private network address (RFC 1918 or RFC 4193)"
+	reasonLoopbackIP  = "Loopback address"
+	reasonLinkLocalIP = "Link-local address"
+	reasonSharedIP    = "Carrier-grade NAT address (RFC 6598)"
+	reasonSingleLabel = "Single-label hostname, which only resolves on a local network"
+	reasonReservedTLD = "Top-level domain reserved for local or test use"
+	reasonUnknownTLD  = "Not a public top-level domain, so only resolvable on an internal network"
+)
+
+// reservedTLDs are top-level domains set aside for local networks and testing (RFC 2606, 6761, 6762
+// and ICANN's .internal), plus ones commonly used for them
+var reservedTLDs = map[string]bool{
+	"local": true, "localhost": true, "internal": true, "test": true, "example": true, "invalid": true,
+	"corp": true, "home": true, "lan": true, "intranet": true, "private": true, "localdomain": true,
+}
+
+// sharedAddressSpace is the RFC 6598 range carriers use behind NAT
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// InternalName is a name on a certificate that only means something inside a private network;
+// publicly trusted CAs haven't been allowed to issue for them since 2015, and they leak internal topology
+type InternalName struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// InternalNameCertificate is a certificate with internal names among its SANs
+type InternalNameCertificate struct {
+	CommonName   string         `json:"commonName"`
+	SerialNumber string         `json:"serialNumber"`
+	Issuer       string         `json:"issuer"` // Display name
+	NotBefore    time.Time      `json:"notBefore"`
+	Active       bool           `json:"active"`
+	Names        []InternalName `json:"names"`
+}
+
+// InternalNameReason says why a hostname or IP address is internal, or returns "" for a public one
+func InternalNameReason(name string) string {
+	if ip := net.ParseIP(name); ip != nil {
+		switch {
+		case ip.IsLoopback() || ip.IsUnspecified():
+			return reasonLoopbackIP
+		case ip.IsPrivate():
+			return reasonPrivateIP
+		case ip.IsLinkLocalUnicast():
+			return reasonLinkLocalIP
+		case sharedAddressSpace.Contains(ip):
+			return reasonSharedIP
+		}
+		return ""
+	}
+
+	// Common names that aren't hostnames, like an organization's name, aren't judged
+	name = strings.TrimPrefix(NormalizeName(name), "*.")
+	if name == "" || strings.ContainsAny(name, " @") {
+		return ""
+	}
+	if !strings.Contains(name, ".") {
+		return reasonSingleLabel
+	}
+	tld := name[strings.LastIndex(name, ".")+1:]
+	if reservedTLDs[tld] || strings.HasSuffix(name, ".home.arpa") {
+		return reasonReservedTLD
+	}
+	// The list's private entries (like github.io) all have a dot; a one-label suffix it doesn't
+	// manage is a TLD it has never heard of
+	if suffix, icann := publicsuffix.PublicSuffix(name); !icann && !strings.Contains(suffix, ".") {
+		return reasonUnknownTLD
+	}
+	return ""
+}
+
+// CertificateInternalNames returns a TLS certificate's internal names, for its badge
+func CertificateInternalNames(group CertificateGroup) []InternalName {
+	names := make([]InternalName, 0)
+	if group.Purpose != PurposeTLS {
+		return names
+	}
+	for _, name := range GroupNames(group) {
+		if reason := InternalNameReason(name); reason != "" {
+			names = append(names, InternalName{Name: name, Reason: reason})
+		}
+	}
+	return names
+}
+
+// FindInternalNames lists the certificates naming internal hosts or addresses, newest first
+func FindInternalNames(groups []CertificateGroup, now time.Time) []InternalNameCertificate {
+	certs := make([]InternalNameCertificate, 0)
+	for _, group := range groups {
+		names := CertificateInternalNames(group)
+		if len(names) == 0 {
+			continue
+		}
+		certs = append(certs, InternalNameCertificate{
+			CommonName:   group.CommonName,
+			SerialNumber: group.SerialNumber,
+			Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+			NotBefore:    group.NotBeforeTime,
+			Active:       isActive(group, now),
+			Names:        names,
+		})
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].NotBefore.After(certs[j].NotBefore)
+	})
+	return certs
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// SubdomainInventory is every hostname seen in CT for a domain, grouped by subdomain
+type SubdomainInventory struct {
+	Domain     string           `json:"domain"`
+	Hostnames  int              `json:"hostnames"`
+	Covered    int              `json:"covered"` // Hostnames covered by a currently valid certificate
+	Subdomains []SubdomainGroup `json:"subdomains"`
+}
+
+// SubdomainGroup holds the hostnames under one label of the domain
+// e.g. "vpn" holds vpn.example.com, eu.vpn.example.com and *.vpn.example.com
+type SubdomainGroup struct {
+	Name  string       `json:"name"` // "@" for the domain itself
+	Hosts []HostRecord `json:"hosts"`
+}
+
+// HostRecord describes one hostname seen in certificate SANs or common names
+type HostRecord struct {
+	Name         string    `json:"name"`
+	FirstSeen    time.Time `json:"firstSeen"` // Earliest NotBefore of a certificate naming it
+	LastSeen     time.Time `json:"lastSeen"`  // Latest NotBefore of a certificate naming it
+	Certificates int       `json:"certificates"`
+	Covered      bool      `json:"covered"` // A currently valid certificate covers it (directly or by wildcard)
+}
+
+// BuildSubdomainInventory collects the deduplicated hostnames under domain from the certificate groups
+// domain may be a search pattern like "%.example.com"; the wildcard prefix is ignored
+func BuildSubdomainInventory(domain string, groups []CertificateGroup, now time.Time) SubdomainInventory {
+	base := BaseDomain(domain)
+	inventory := SubdomainInventory{
+		Domain:     base,
+		Subdomains: make([]SubdomainGroup, 0),
+	}
+
+	// Names covered by currently valid certificates, used for the coverage check
+	activeNames := make([]string, 0)
+
+	// Map to collect hosts by name
+	hostMap := make(map[string]*HostRecord)
+
+	for _, group := range groups {
+		names := GroupNames(group)
+		if isActive(group, now) {
+			activeNames = append(activeNames, names...)
+		}
+
+		for _, name := range names {
+			if !inDomain(name, base) {
+				continue
+			}
+
+			host, exists := hostMap[name]
+			if !exists {
+				host = &HostRecord{
+					Name:      name,
+					FirstSeen: group.NotBeforeTime,
+					LastSeen:  group.NotBeforeTime,
+				}
+				hostMap[name] = host
+			}
+
+			host.Certificates++
+			if group.NotBeforeTime.Before(host.FirstSeen) {
+				host.FirstSeen = group.NotBeforeTime
+			}
+			if group.NotBeforeTime.After(host.LastSeen) {
+				host.LastSeen = group.NotBeforeTime
+			}
+		}
+	}
+
+	// Map to collect hosts by subdomain label
+	subdomainMap := make(map[string]*SubdomainGroup)
+
+	for _, host := range hostMap {
+		for _, active := range activeNames {
+			if NameCovers(active, host.Name) {
+				host.Covered = true
+				inventory.Covered++
+				break
+			}
+		}
+
+		label := subdomainLabel(host.Name, base)
+		if _, exists := subdomainMap[label]; !exists {
+			subdomainMap[label] = &SubdomainGroup{Name: label}
+		}
+		subdomainMap[label].Hosts = append(subdomainMap[label].Hosts, *host)
+		inventory.Hostnames++
+	}
+
+	for _, subdomain := range subdomainMap {
+		sort.Slice(subdomain.Hosts, func(i, j int) bool {
+			return subdomain.Hosts[i].Name < subdomain.Hosts[j].Name
+		})
+		inventory.Subdomains = append(inventory.Subdomains, *subdomain)
+	}
+
+	// The domain itself first, then alphabetical
+	sort.Slice(inventory.Subdomains, func(i, j int) bool {
+		a, b := inventory.Subdomains[i].Name, inventory.Subdomains[j].Name
+		if a == "@" || b == "@" {
+			return a == "@" && b != "@"
+		}
+		return a < b
+	})
+
+	return inventory
+}
+
+// GroupNames returns the normalized, deduplicated names from every entry in the group
+// plus the common name
+func GroupNames(group CertificateGroup) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	add := func(name string) {
+		name = NormalizeName(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	add(group.CommonName)
+	for _, entry := range group.Entries {
+		for _, name := range entry.SANs() {
+			add(name)
+		}
+	}
+
+	return names
+}
+
+// NormalizeName lowercases a hostname and strips any trailing dot
+func NormalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// BaseDomain strips crt.sh wildcard prefixes ("%.", "*.") from a search query
+func BaseDomain(domain string) string {
+	return strings.TrimLeft(NormalizeName(domain), "%*.")
+}
+
+// NameCovers reports whether a certificate name (possibly a wildcard) covers hostname
+// A wildcard only covers a single label, so *.example.com covers a.example.com but not a.b.example.com
+func NameCovers(certName, hostname string) bool {
+	if certName == hostname {
+		return true
+	}
+	if !strings.HasPrefix(certName, "*.") {
+		return false
+	}
+
+	// The hostname must have exactly one more label than the wildcard's parent
+	parent := strings.TrimPrefix(certName, "*.")
+	label, rest, found := strings.Cut(hostname, ".")
+	return found && label != "" && label != "*" && rest == parent
+}
+
+// inDomain reports whether name is base or one of its subdomains
+func inDomain(name, base string) bool {
+	if base == "" {
+		return true
+	}
+	return name == base || strings.HasSuffix(name, "."+base)
+}
+
+// subdomainLabel returns the label directly below base that name falls under
+// e.g. ("eu.vpn.example.com", "example.com") -> "vpn"
+// Below a public suffix each registrable domain is its own group: ("www.example.co.uk", "co.uk") -> "example.co.uk"
+func subdomainLabel(name, base string) string {
+	if name == base || base == "" {
+		return "@"
+	}
+	if IsPublicSuffix(base) {
+		return RegistrableDomain(name)
+	}
+	prefix := strings.TrimSuffix(name, "."+base)
+	labels := strings.Split(prefix, ".")
+	return labels[len(labels)-1]
+}
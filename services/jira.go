@@ -0,0 +1,249 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jiraTimeout bounds one request to Jira
+const jiraTimeout = 15 * time.Second
+
+// jiraLabel is put on every issue opened, so they can all be found in Jira
+const jiraLabel = "certviewer"
+
+// JiraConfig is where Jira issues are opened for watched domains' findings, and what they say
+type JiraConfig struct {
+	URL            string // Jira Cloud or Server/Data Center, e.g. https://example.atlassian.net
+	User           string // Jira Cloud account email, used with an API token; empty uses the token as a Server personal access token
+	Token          string
+	Project        string // Project key, e.g. OPS
+	IssueType      string // e.g. Task or Bug
+	ExpiringWithin time.Duration
+	// Fields are set on every new issue, by Jira field ID, e.g. {"priority": {"name": "High"}}; strings
+	// in them may use {domain}, {subject}, {check}, {severity} and {message}. summary and description
+	// replace the defaults
+	Fields map[string]any
+}
+
+// JiraTracker opens Jira issues for expiring certificates and unexpected issuers, one per finding:
+// while a finding's issue is open, checks finding it again leave it be
+type JiraTracker struct {
+	mu     sync.Mutex // Guards config.Token, which SetToken replaces
+	config JiraConfig
+	client *http.Client
+}
+
+// jiraIssue is the part of an issue the tracker reads
+type jiraIssue struct {
+	Key string `json:"key"`
+}
+
+// NewJiraTracker returns a tracker opening issues as config says
+func NewJiraTracker(config JiraConfig) (*JiraTracker, error) {
+	parsed, err := url.Parse(config.URL)
+	if err != nil || parsed.Host == "" || parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return nil, fmt.Errorf("invalid Jira URL %q, use e.g. https://example.atlassian.net", config.URL)
+	}
+	if config.Token == "" {
+		return nil, errors.New("a Jira API token or personal access token is needed")
+	}
+	if config.Project == "" {
+		return nil, errors.New("a Jira project key is needed")
+	}
+	if config.IssueType == "" {
+		return nil, errors.New("a Jira issue type is needed")
+	}
+	config.URL = strings.TrimRight(parsed.String(), "/")
+	return &JiraTracker{config: config, client: &http.Client{Timeout: jiraTimeout}}, nil
+}
+
+// SetToken replaces the API token, e.g. when the settings are reloaded; requests under way keep the old one
+func (j *JiraTracker) SetToken(token string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.config.Token = token
+}
+
+// LoadJiraFields reads the fields to set on new issues from a JSON file of Jira field IDs and values
+func LoadJiraFields(path string) (map[string]any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Jira fields: %w", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse Jira fields: %w", err)
+	}
+	return fields, nil
+}
+
+// Findings are what the tracker opens issues for in a check of domain: TLS certificates whose hostname's
+// newest expires within ExpiringWithin, and valid ones from issuers outside the expected ones
+func (j *JiraTracker) Findings(domain string, groups []CertificateGroup, now time.Time) []Finding {
+	domainMetrics.Lock()
+	issuers := domainMetrics.issuers.Issuers
+	domainMetrics.Unlock()
+
+	policy := Policy{ExpiringWithin: j.config.ExpiringWithin, Issuers: issuers}
+	return CheckPolicy(domain, FilterByPurpose(groups, PurposeFilterTLS), policy, now).Violations
+}
+
+// Open opens an issue for a finding about domain unless one is open already, returning its key and
+// whether it's new
+func (j *JiraTracker) Open(ctx context.Context, domain string, finding Finding) (string, bool, error) {
+	label := jiraFindingLabel(domain, finding)
+	existing, err := j.findOpen(ctx, label)
+	if err != nil {
+		return "", false, err
+	}
+	if existing != "" {
+		return existing, false, nil
+	}
+
+	fields := map[string]any{
+		"project":     map[string]string{"key": j.config.Project},
+		"issuetype":   map[string]string{"name": j.config.IssueType},
+		"summary":     "{domain}: {subject} {message}",
+		"description": "certviewer found a {severity} {check} problem with {domain}'s certificates:\n\n{subject} {message}",
+	}
+	for name, value := range j.config.Fields {
+		fields[name] = value
+	}
+	fields = expandJiraFields(fields, domain, finding).(map[string]any)
+	fields["labels"] = append(jiraLabels(fields["labels"]), jiraLabel, label)
+
+	var created jiraIssue
+	if err := j.call(ctx, http.MethodPost, "/rest/api/2/issue", map[string]any{"fields": fields}, &created); err != nil {
+		return "", false, err
+	}
+	return created.Key, true, nil
+}
+
+// findOpen returns the key of the unresolved issue with label, if there is one
+func (j *JiraTracker) findOpen(ctx context.Context, label string) (string, error) {
+	jql := fmt.Sprintf(`project = "%s" AND labels = "%s" AND statusCategory != Done`, j.config.Project, label)
+	// Jira Cloud has retired /search for /search/jql, which Server and Data Center don't have
+	path := "/rest/api/2/search"
+	if j.config.User != "" {
+		path = "/rest/api/2/search/jql"
+	}
+	query := url.Values{"jql": {jql}, "fields": {"key"}, "maxResults": {"1"}}
+
+	var answer struct {
+		Issues []jiraIssue `json:"issues"`
+	}
+	if err := j.call(ctx, http.MethodGet, path+"?"+query.Encode(), nil, &answer); err != nil {
+		return "", err
+	}
+	if len(answer.Issues) == 0 {
+		return "", nil
+	}
+	return answer.Issues[0].Key, nil
+}
+
+// call makes a Jira REST API request, decoding the answer into result
+func (j *JiraTracker) call(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode Jira request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, j.config.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	token := j.config.Token
+	j.mu.Unlock()
+	if j.config.User != "" {
+		req.SetBasicAuth(j.config.User, token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Jira: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Jira says why, e.g. {"errorMessages":[],"errors":{"customfield_10042":"Field is required"}}
+		var answer struct {
+			ErrorMessages []string          `json:"errorMessages"`
+			Errors        map[string]string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&answer)
+		reasons := answer.ErrorMessages
+		for field, reason := range answer.Errors {
+			reasons = append(reasons, field+": "+reason)
+		}
+		if len(reasons) > 0 {
+			return fmt.Errorf("Jira returned status %d: %s", resp.StatusCode, strings.Join(reasons, "; "))
+		}
+		return fmt.Errorf("Jira returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse Jira's answer: %w", err)
+	}
+	return nil
+}
+
+// jiraFindingLabel is the label identifying a finding's issue: the same check of the same subject of
+// a domain gets the same label however its message changes, e.g. as days remaining count down
+func jiraFindingLabel(domain string, finding Finding) string {
+	sum := sha256.Sum256([]byte(domain + "\n" + finding.Check + "\n" + finding.Subject))
+	return jiraLabel + "-" + hex.EncodeToString(sum[:6])
+}
+
+// expandJiraFields fills the placeholders in a field value's strings
+func expandJiraFields(value any, domain string, finding Finding) any {
+	switch value := value.(type) {
+	case string:
+		return strings.NewReplacer("{domain}", domain, "{subject}", finding.Subject, "{check}", finding.Check,
+			"{severity}", finding.Severity, "{message}", finding.Message).Replace(value)
+	case map[string]any:
+		expanded := make(map[string]any, len(value))
+		for key, item := range value {
+			expanded[key] = expandJiraFields(item, domain, finding)
+		}
+		return expanded
+	case []any:
+		expanded := make([]any, len(value))
+		for i, item := range value {
+			expanded[i] = expandJiraFields(item, domain, finding)
+		}
+		return expanded
+	}
+	return value
+}
+
+// jiraLabels are the labels the configured fields set, if any
+func jiraLabels(value any) []string {
+	labels := make([]string, 0)
+	items, _ := value.([]any)
+	for _, item := range items {
+		if label, ok := item.(string); ok {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
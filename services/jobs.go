@@ -0,0 +1,479 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job types: what is run for each domain
+const (
+	JobSearch = "search" // Certificate counts, expiring certificates and issuers, as in a bulk import
+	JobReport = "report" // The full assessment, downloading each domain's active certificates
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+)
+
+const (
+	// MaxJobDomains caps how many domains one job may scan
+	MaxJobDomains = MaxImportDomains
+
+	// maxActiveJobs caps how many unfinished jobs one user may have
+	maxActiveJobs = 3
+
+	// jobRetention is how long finished jobs and their results are kept
+	jobRetention = 7 * 24 * time.Hour
+
+	// jobCheckpointInterval is how often a running job's progress is saved, so a restart resumes it
+	// rather than starting over
+	jobCheckpointInterval = 5 * time.Second
+)
+
+// Job errors handlers tell apart with errors.Is
+var (
+	ErrJobNotFound = errors.New("job not found") // Doesn't exist or belongs to someone else
+	ErrTooManyJobs = errors.New("too many unfinished jobs")
+)
+
+// Job is a scan of many domains run in the background, one domain at a time per worker
+type Job struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Username   string      `json:"username,omitempty"` // Owner; empty when accounts are disabled
+	Domains    []string    `json:"domains"`
+	Status     string      `json:"status"`
+	Total      int         `json:"total"`
+	Completed  int         `json:"completed"` // Domains scanned, including failures
+	Failed     int         `json:"failed"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+	Results    []JobResult `json:"results,omitempty"` // In the order domains finished; left out of status answers
+
+	savedAt time.Time // When the job was last saved, for checkpointing while it runs
+}
+
+// Finished reports whether the job has stopped for good
+func (j Job) Finished() bool {
+	return j.Status == JobDone || j.Status == JobCancelled
+}
+
+// JobResult is what a job found for one domain; Search or Report is set by the job's type
+type JobResult struct {
+	Domain string            `json:"domain"`
+	Search *BulkSearchResult `json:"search,omitempty"`
+	Report *DomainReport     `json:"report,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// JobStore holds jobs and runs the queued ones
+// It is safe for concurrent use; each job is saved to its own JSON file in a directory
+type JobStore struct {
+	mu      sync.Mutex
+	dir     string // Empty means keep jobs in memory only
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc // Of running jobs
+	wake    chan struct{}
+}
+
+// LoadJobStore reads the jobs saved in dir, creating it if needed
+// Jobs that were queued or running when the server stopped are queued again, keeping the domains they finished
+func LoadJobStore(dir string) (*JobStore, error) {
+	s := &JobStore{
+		dir:     dir,
+		jobs:    make(map[string]*Job),
+		cancels: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+	}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		var job Job
+		if err := json.Unmarshal(content, &job); err != nil {
+			return nil, fmt.Errorf("failed to parse job %s: %w", filepath.Base(path), err)
+		}
+		if job.Status == JobRunning {
+			job.Status = JobQueued
+		}
+		s.jobs[job.ID] = &job
+	}
+	s.prune(time.Now())
+	return s, nil
+}
+
+// Submit queues a job of jobType over domains for username and returns it
+func (s *JobStore) Submit(username, jobType string, domains []string) (Job, error) {
+	if jobType != JobSearch && jobType != JobReport {
+		return Job{}, fmt.Errorf(`unknown job type %q, use "%s" or "%s"`, jobType, JobSearch, JobReport)
+	}
+	if len(domains) == 0 {
+		return Job{}, errors.New("no domains to scan")
+	}
+	if len(domains) > MaxJobDomains {
+		return Job{}, fmt.Errorf("a job can scan at most %d domains", MaxJobDomains)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, fmt.Errorf("failed to create job: %w", err)
+	}
+	job := &Job{
+		ID:        hex.EncodeToString(id),
+		Type:      jobType,
+		Username:  username,
+		Domains:   domains,
+		Status:    JobQueued,
+		Total:     len(domains),
+		CreatedAt: time.Now().UTC(),
+		Results:   make([]JobResult, 0),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	active := 0
+	for _, existing := range s.jobs {
+		if existing.Username == username && !existing.Finished() {
+			active++
+		}
+	}
+	if active >= maxActiveJobs {
+		return Job{}, fmt.Errorf("%w: you already have %d, wait for one to finish or cancel it", ErrTooManyJobs, active)
+	}
+
+	s.jobs[job.ID] = job
+	if err := s.save(job); err != nil {
+		delete(s.jobs, job.ID)
+		return Job{}, err
+	}
+	s.notify()
+	return job.status(), nil
+}
+
+// List returns username's jobs without their results, newest first
+func (s *JobStore) List(username string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Job, 0)
+	for _, job := range s.jobs {
+		if job.Username == username {
+			list = append(list, job.status())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Get returns one of username's jobs without its results
+func (s *JobStore) Get(username, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Username != username {
+		return Job{}, ErrJobNotFound
+	}
+	return job.status(), nil
+}
+
+// Results returns one of username's jobs with the results so far, sorted by domain
+func (s *JobStore) Results(username, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Username != username {
+		return Job{}, ErrJobNotFound
+	}
+	copied := *job
+	copied.Results = append(make([]JobResult, 0, len(job.Results)), job.Results...)
+	sort.Slice(copied.Results, func(i, j int) bool {
+		return copied.Results[i].Domain < copied.Results[j].Domain
+	})
+	return copied, nil
+}
+
+// Cancel stops one of username's unfinished jobs, keeping the results it has
+func (s *JobStore) Cancel(username, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Username != username {
+		return Job{}, ErrJobNotFound
+	}
+	if job.Finished() {
+		return job.status(), nil
+	}
+	job.Status = JobCancelled
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	if cancel, running := s.cancels[id]; running {
+		cancel()
+	}
+	return job.status(), s.save(job)
+}
+
+// Delete removes one of username's finished jobs and its results
+func (s *JobStore) Delete(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Username != username {
+		return ErrJobNotFound
+	}
+	if !job.Finished() {
+		return errors.New("cancel the job before deleting it")
+	}
+	delete(s.jobs, id)
+	return s.remove(id)
+}
+
+// Run works through queued jobs, oldest first, workers at a time, until ctx is done
+func (s *JobStore) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, jobCtx, ok := s.next(ctx)
+				if !ok {
+					return
+				}
+				s.run(jobCtx, job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// next waits for the oldest queued job and marks it running; ok is false once ctx is done
+func (s *JobStore) next(ctx context.Context) (*Job, context.Context, bool) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		var oldest *Job
+		for _, job := range s.jobs {
+			if job.Status == JobQueued && (oldest == nil || job.CreatedAt.Before(oldest.CreatedAt)) {
+				oldest = job
+			}
+		}
+		if oldest != nil {
+			oldest.Status = JobRunning
+			if oldest.StartedAt == nil {
+				started := time.Now().UTC()
+				oldest.StartedAt = &started
+			}
+			jobCtx, cancel := context.WithCancel(ctx)
+			s.cancels[oldest.ID] = cancel
+			s.save(oldest)
+			s.mu.Unlock()
+			return oldest, jobCtx, true
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+		}
+	}
+	return nil, nil, false
+}
+
+// run scans the job's domains that have no result yet, sweepWorkers at a time
+func (s *JobStore) run(ctx context.Context, job *Job) {
+	s.mu.Lock()
+	done := make(map[string]bool, len(job.Results))
+	for _, result := range job.Results {
+		done[result.Domain] = true
+	}
+	remaining := make([]string, 0, len(job.Domains))
+	for _, domain := range job.Domains {
+		if !done[domain] {
+			remaining = append(remaining, domain)
+		}
+	}
+	jobType := job.Type
+	s.mu.Unlock()
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for range min(len(remaining), sweepWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range queue {
+				result := runJobDomain(ctx, jobType, domain, time.Now())
+				// A domain cut short by cancellation is left for a resumed job to scan again
+				if ctx.Err() != nil {
+					continue
+				}
+				s.record(job, result)
+			}
+		}()
+	}
+	for _, domain := range remaining {
+		select {
+		case queue <- domain:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job.Status == JobRunning && ctx.Err() == nil {
+		job.Status = JobDone
+		finished := time.Now().UTC()
+		job.FinishedAt = &finished
+	} else if job.Status == JobRunning {
+		// The server is stopping; the job resumes when it starts again
+		job.Status = JobQueued
+	}
+	s.cancels[job.ID]()
+	delete(s.cancels, job.ID)
+	s.save(job)
+}
+
+// record adds a domain's result to a running job, saving it if the last save was a while ago
+func (s *JobStore) record(job *Job, result JobResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Results = append(job.Results, result)
+	job.Completed++
+	if result.Error != "" {
+		job.Failed++
+	}
+	if time.Since(job.savedAt) >= jobCheckpointInterval {
+		s.save(job)
+	}
+}
+
+// runJobDomain scans one domain for a job of jobType
+func runJobDomain(ctx context.Context, jobType, domain string, now time.Time) JobResult {
+	result := JobResult{Domain: domain}
+	switch jobType {
+	case JobSearch:
+		search := searchDomain(ctx, domain, now)
+		result.Search = &search
+		result.Error = search.Error
+	case JobReport:
+		certs, err := FetchCertificates(ctx, domain)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		report := BuildDomainReport(ctx, domain, GroupCertificates(certs), now)
+		result.Report = &report
+	}
+	return result
+}
+
+// status returns a copy of the job without its results
+func (j *Job) status() Job {
+	copied := *j
+	copied.Results = nil
+	return copied
+}
+
+// notify wakes a worker waiting for a queued job
+func (s *JobStore) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// prune drops jobs that finished more than jobRetention ago
+// Callers must hold s.mu (or own s exclusively while loading)
+func (s *JobStore) prune(now time.Time) {
+	for id, job := range s.jobs {
+		if job.Finished() && job.FinishedAt != nil && now.Sub(*job.FinishedAt) > jobRetention {
+			delete(s.jobs, id)
+			s.remove(id)
+		}
+	}
+}
+
+// save writes a job to its file, unless it has been deleted: a worker finishing a job cancelled and deleted
+// meanwhile would otherwise bring it back
+// Callers must hold s.mu
+func (s *JobStore) save(job *Job) error {
+	if s.jobs[job.ID] != job {
+		return nil
+	}
+	job.savedAt = time.Now()
+	if s.dir == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written file
+	path := s.jobPath(job.ID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a job's file
+// Callers must hold s.mu
+func (s *JobStore) remove(id string) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.Remove(s.jobPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete job: %w", err)
+	}
+	return nil
+}
+
+// jobPath is the file a job is saved in
+func (s *JobStore) jobPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
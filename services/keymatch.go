@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+// ErrPrivateKey is returned for anything that looks like a private key, which is never accepted
+var ErrPrivateKey = errors.New("private keys are never accepted: paste the public key (openssl pkey -pubout) or a CSR instead")
+
+// PublicKeyInput is a public key read from a PEM public key, CSR or certificate
+type PublicKeyInput struct {
+	Source string           `json:"source"` // "public key", "CSR" or "certificate"
+	Key    x509info.KeyInfo `json:"key"`
+	SPKI   string           `json:"spkiSha256"` // SHA-256 of the DER SubjectPublicKeyInfo
+}
+
+// KeyMatch is whether a certificate and a public key belong to the same key pair
+type KeyMatch struct {
+	Match       bool           `json:"match"`
+	Subject     string         `json:"subject"` // The certificate's
+	Certificate PublicKeyInput `json:"certificate"`
+	Key         PublicKeyInput `json:"key"`
+}
+
+// ParsePublicKey reads the public key from a PEM (or DER) public key, CSR or certificate
+// Private keys are refused with ErrPrivateKey before anything else is done with them
+func ParsePublicKey(data []byte) (PublicKeyInput, error) {
+	if bytes.Contains(data, []byte("PRIVATE KEY")) {
+		return PublicKeyInput{}, ErrPrivateKey
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			return PublicKeyInput{}, errors.New("failed to decode PEM")
+		}
+		return parseDERPublicKey(data)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return PublicKeyInput{}, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		return publicKeyInput("public key", key, 0)
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return PublicKeyInput{}, fmt.Errorf("failed to parse RSA public key: %w", err)
+		}
+		return publicKeyInput("public key", key, x509.RSA)
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		csr, err := ParseCSR(pem.EncodeToMemory(block))
+		if err != nil {
+			return PublicKeyInput{}, err
+		}
+		return publicKeyInput("CSR", csr.PublicKey, csr.PublicKeyAlgorithm)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return PublicKeyInput{}, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		return publicKeyInput("certificate", cert.PublicKey, cert.PublicKeyAlgorithm)
+	}
+	return PublicKeyInput{}, fmt.Errorf("expected a PUBLIC KEY, CERTIFICATE REQUEST or CERTIFICATE, got a PEM %s", block.Type)
+}
+
+// parseDERPublicKey works out what a DER blob is, refusing private keys
+func parseDERPublicKey(der []byte) (PublicKeyInput, error) {
+	if _, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return PublicKeyInput{}, ErrPrivateKey
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return PublicKeyInput{}, ErrPrivateKey
+	}
+	if _, err := x509.ParseECPrivateKey(der); err == nil {
+		return PublicKeyInput{}, ErrPrivateKey
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return publicKeyInput("public key", key, 0)
+	}
+	if csr, err := x509.ParseCertificateRequest(der); err == nil {
+		return publicKeyInput("CSR", csr.PublicKey, csr.PublicKeyAlgorithm)
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return publicKeyInput("certificate", cert.PublicKey, cert.PublicKeyAlgorithm)
+	}
+	return PublicKeyInput{}, errors.New("not a public key, CSR or certificate")
+}
+
+// publicKeyInput describes a parsed public key, hashing its SPKI in a canonical encoding
+// so the same key compares equal whichever format it came in
+func publicKeyInput(source string, key crypto.PublicKey, algorithm x509.PublicKeyAlgorithm) (PublicKeyInput, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return PublicKeyInput{}, fmt.Errorf("unsupported public key: %w", err)
+	}
+	spki := sha256.Sum256(der)
+	return PublicKeyInput{
+		Source: source,
+		Key:    x509info.InspectKey(key, algorithm),
+		SPKI:   hex.EncodeToString(spki[:]),
+	}, nil
+}
+
+// MatchKey reports whether a certificate was issued for the given public key
+func MatchKey(cert *x509.Certificate, key PublicKeyInput) (KeyMatch, error) {
+	certKey, err := publicKeyInput("certificate", cert.PublicKey, cert.PublicKeyAlgorithm)
+	if err != nil {
+		return KeyMatch{}, err
+	}
+	return KeyMatch{
+		Match:       certKey.SPKI == key.SPKI,
+		Subject:     cert.Subject.String(),
+		Certificate: certKey,
+		Key:         key,
+	}, nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+const (
+	// maxKeyHistoryCertificates caps how many certificates are downloaded to follow keys, newest first
+	maxKeyHistoryCertificates = 100
+
+	// maxKeyLifetimeDays is how long one key can stay in use across renewals before it's a finding;
+	// audits expect keys to be replaced at least as often as a certificate may last
+	maxKeyLifetimeDays = 398
+)
+
+// KeyRotationReport follows the public keys behind each hostname's certificates, to show whether
+// renewals generate new keys or keep reusing one
+type KeyRotationReport struct {
+	Domain       string     `json:"domain"`
+	CheckedAt    time.Time  `json:"checkedAt"`
+	LongLived    int        `json:"longLived"` // Hosts with a key in use longer than maxKeyLifetimeDays
+	Hosts        []HostKeys `json:"hosts"`
+	NotInspected int        `json:"notInspected"` // Older certificates over maxKeyHistoryCertificates
+	Failed       int        `json:"failed"`       // Certificates that couldn't be downloaded
+}
+
+// HostKeys is the key history of one hostname
+type HostKeys struct {
+	Name         string   `json:"name"`
+	Certificates int      `json:"certificates"` // Inspected
+	Renewals     int      `json:"renewals"`     // Certificates following an earlier one with the same key type
+	Rotated      int      `json:"rotated"`      // Of those, how many came with a new key
+	Keys         []KeyUse `json:"keys"`         // Most recently issued first
+	LongLived    bool     `json:"longLived"`
+}
+
+// KeyUse is one key pair and the certificates a hostname had for it
+type KeyUse struct {
+	SPKISHA256   string    `json:"spkiSha256"`
+	KeyType      string    `json:"keyType"` // E.g. "RSA-2048" or "ECDSA-256"
+	Certificates int       `json:"certificates"`
+	FirstIssued  time.Time `json:"firstIssued"`
+	LastIssued   time.Time `json:"lastIssued"`
+	LastExpires  time.Time `json:"lastExpires"`
+	Days         int       `json:"days"` // From the first certificate's issuance to the last one's expiry
+}
+
+// keyedCertificate is a certificate group with the key it was downloaded to find
+type keyedCertificate struct {
+	group   CertificateGroup
+	spki    string
+	keyType string
+}
+
+// AnalyzeKeyRotation downloads a domain's newest certificates from crt.sh and follows each hostname's keys
+// by SPKI hash; renewals are compared with the previous certificate of the same key type, so a host
+// with both RSA and ECDSA certificates isn't counted as rotating on every renewal
+func AnalyzeKeyRotation(ctx context.Context, domain string, groups []CertificateGroup, now time.Time) KeyRotationReport {
+	base := BaseDomain(domain)
+	report := KeyRotationReport{Domain: base, CheckedAt: now.UTC(), Hosts: make([]HostKeys, 0)}
+
+	// The newest certificates naming a host in the domain
+	named := make([]CertificateGroup, 0)
+	for _, group := range groups {
+		if group.NotBeforeTime.IsZero() || len(group.Entries) == 0 {
+			continue
+		}
+		for _, name := range GroupNames(group) {
+			if inDomain(name, base) {
+				named = append(named, group)
+				break
+			}
+		}
+	}
+	sort.Slice(named, func(i, j int) bool {
+		return named[i].NotBeforeTime.After(named[j].NotBeforeTime)
+	})
+	if len(named) > maxKeyHistoryCertificates {
+		report.NotInspected = len(named) - maxKeyHistoryCertificates
+		named = named[:maxKeyHistoryCertificates]
+	}
+
+	ids := make([]int64, 0, len(named))
+	for _, group := range named {
+		ids = append(ids, PreferredEntry(group).ID)
+	}
+	infos, errs := FetchCertificateInfos(ctx, ids)
+	report.Failed = len(errs)
+
+	hostCerts := make(map[string][]keyedCertificate)
+	for i, group := range named {
+		info, ok := infos[ids[i]]
+		if !ok {
+			continue
+		}
+		spki := sha256.Sum256(info.Certificate.RawSubjectPublicKeyInfo)
+		cert := keyedCertificate{group: group, spki: hex.EncodeToString(spki[:]), keyType: KeyType(info)}
+		for _, name := range GroupNames(group) {
+			if inDomain(name, base) {
+				hostCerts[name] = append(hostCerts[name], cert)
+			}
+		}
+	}
+
+	for name, certs := range hostCerts {
+		host := analyzeHostKeys(name, certs)
+		if host.LongLived {
+			report.LongLived++
+		}
+		report.Hosts = append(report.Hosts, host)
+	}
+
+	// Hosts reusing keys first, then alphabetical
+	sort.Slice(report.Hosts, func(i, j int) bool {
+		a, b := report.Hosts[i], report.Hosts[j]
+		if a.LongLived != b.LongLived {
+			return a.LongLived
+		}
+		return a.Name < b.Name
+	})
+
+	return report
+}
+
+// analyzeHostKeys walks one hostname's certificates in issuance order, grouping them by key
+func analyzeHostKeys(name string, certs []keyedCertificate) HostKeys {
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].group.NotBeforeTime.Before(certs[j].group.NotBeforeTime)
+	})
+
+	host := HostKeys{Name: name, Certificates: len(certs), Keys: make([]KeyUse, 0)}
+	previous := make(map[string]string) // SPKI of the last certificate of each key type
+	keys := make(map[string]*KeyUse)
+	for _, cert := range certs {
+		if last, seen := previous[cert.keyType]; seen {
+			host.Renewals++
+			if last != cert.spki {
+				host.Rotated++
+			}
+		}
+		previous[cert.keyType] = cert.spki
+
+		key, seen := keys[cert.spki]
+		if !seen {
+			key = &KeyUse{SPKISHA256: cert.spki, KeyType: cert.keyType, FirstIssued: cert.group.NotBeforeTime}
+			keys[cert.spki] = key
+		}
+		key.Certificates++
+		key.LastIssued = cert.group.NotBeforeTime
+		if cert.group.NotAfterTime.After(key.LastExpires) {
+			key.LastExpires = cert.group.NotAfterTime
+		}
+	}
+
+	for _, key := range keys {
+		key.Days = daysBetween(key.FirstIssued, key.LastExpires)
+		if key.Certificates > 1 && key.Days > maxKeyLifetimeDays {
+			host.LongLived = true
+		}
+		host.Keys = append(host.Keys, *key)
+	}
+	sort.Slice(host.Keys, func(i, j int) bool {
+		return host.Keys[i].LastIssued.After(host.Keys[j].LastIssued)
+	})
+
+	return host
+}
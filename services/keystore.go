@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Keystore formats
+const (
+	KeystorePKCS12 = "PKCS#12"
+	KeystoreJKS    = "JKS"
+)
+
+// ErrKeystorePassword is returned when a keystore's integrity check fails, which almost always means a wrong password
+var ErrKeystorePassword = errors.New("incorrect keystore password")
+
+// Keystore is the certificates in a PKCS#12 or JKS file; private keys are noted but never kept
+type Keystore struct {
+	Format    string          `json:"format"`
+	Verified  bool            `json:"verified"` // The password checked out; a JKS opened without one isn't verified
+	Entries   []KeystoreEntry `json:"entries"`
+	Truncated bool            `json:"truncated,omitempty"` // More than maxKeystoreCertificates certificates; the rest were skipped
+}
+
+// KeystoreEntry is a private key with its certificate chain, or a trusted certificate
+type KeystoreEntry struct {
+	Alias      string              `json:"alias,omitempty"` // JKS only
+	PrivateKey bool                `json:"privateKey"`
+	KeyMatches *bool               `json:"keyMatches,omitempty"` // Whether the key belongs to the first certificate; nil when it couldn't be read (JKS keys stay encrypted)
+	Chain      []*x509.Certificate `json:"-"`                    // Leaf first for key entries
+}
+
+// maxKeystoreCertificates caps how many certificates are read from one keystore, since each may be looked up in CT
+const maxKeystoreCertificates = 50
+
+// jksMagic starts every JKS file; JCEKS files start with 0xCECECECE instead
+const (
+	jksMagic   = 0xFEEDFEED
+	jceksMagic = 0xCECECECE
+)
+
+// ParseKeystore reads the certificates from a PKCS#12 (.p12, .pfx) or JKS file
+func ParseKeystore(data []byte, password string) (Keystore, error) {
+	if len(data) >= 4 {
+		switch binary.BigEndian.Uint32(data) {
+		case jksMagic:
+			return parseJKS(data, password)
+		case jceksMagic:
+			return Keystore{}, errors.New("JCEKS keystores aren't supported: convert with keytool -importkeystore -deststoretype pkcs12")
+		}
+	}
+	return parsePKCS12(data, password)
+}
+
+// parsePKCS12 reads a key with its chain, or failing that a Java trust store of certificates only
+func parsePKCS12(data []byte, password string) (Keystore, error) {
+	keystore := Keystore{Format: KeystorePKCS12, Verified: true}
+
+	key, leaf, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err == nil {
+		entry := KeystoreEntry{PrivateKey: true, Chain: append([]*x509.Certificate{leaf}, caCerts...)}
+		if signer, ok := key.(crypto.Signer); ok {
+			public, comparable := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+			matches := comparable && public.Equal(leaf.PublicKey)
+			entry.KeyMatches = &matches
+		}
+		keystore.Entries = []KeystoreEntry{entry}
+		keystore.truncate()
+		return keystore, nil
+	}
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return Keystore{}, ErrKeystorePassword
+	}
+
+	certs, trustErr := pkcs12.DecodeTrustStore(data, password)
+	if trustErr != nil {
+		if errors.Is(trustErr, pkcs12.ErrIncorrectPassword) {
+			return Keystore{}, ErrKeystorePassword
+		}
+		return Keystore{}, fmt.Errorf("failed to read PKCS#12 as a key with its chain (%v) or as a trust store (%v)", err, trustErr)
+	}
+	for _, cert := range certs {
+		keystore.Entries = append(keystore.Entries, KeystoreEntry{Chain: []*x509.Certificate{cert}})
+	}
+	keystore.truncate()
+	return keystore, nil
+}
+
+// parseJKS reads a Java KeyStore: private key entries (whose chains are stored in the clear) and trusted certificates
+// The file ends with a SHA-1 over the password, a fixed salt and the contents, checked when a password is given
+func parseJKS(data []byte, password string) (Keystore, error) {
+	keystore := Keystore{Format: KeystoreJKS}
+	if len(data) < sha1.Size {
+		return Keystore{}, errors.New("JKS file is truncated")
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if password != "" {
+		if !bytes.Equal(jksDigest(body, password), digest) {
+			return Keystore{}, ErrKeystorePassword
+		}
+		keystore.Verified = true
+	}
+
+	r := jksReader{r: bytes.NewReader(body)}
+	r.uint32() // Magic, already checked
+	version := r.uint32()
+	if r.err == nil && version != 1 && version != 2 {
+		return Keystore{}, fmt.Errorf("unsupported JKS version %d", version)
+	}
+	count := r.uint32()
+
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		tag := r.uint32()
+		entry := KeystoreEntry{Alias: r.utf()}
+		r.read(8) // Creation time, in milliseconds
+
+		switch tag {
+		case 1: // Private key, encrypted with Sun's own scheme, then its chain
+			r.bytes()
+			entry.PrivateKey = true
+			for certs := r.uint32(); certs > 0 && r.err == nil; certs-- {
+				entry.Chain = append(entry.Chain, r.certificate(version))
+			}
+		case 2: // Trusted certificate
+			entry.Chain = []*x509.Certificate{r.certificate(version)}
+		default:
+			return Keystore{}, fmt.Errorf("unsupported JKS entry type %d for %q", tag, entry.Alias)
+		}
+		if r.err == nil {
+			keystore.Entries = append(keystore.Entries, entry)
+		}
+	}
+	if r.err != nil {
+		return Keystore{}, fmt.Errorf("failed to read JKS: %w", r.err)
+	}
+
+	keystore.truncate()
+	return keystore, nil
+}
+
+// jksDigest is the integrity hash keytool writes at the end of a JKS file
+func jksDigest(body []byte, password string) []byte {
+	hash := sha1.New()
+	for _, unit := range utf16.Encode([]rune(password)) {
+		hash.Write([]byte{byte(unit >> 8), byte(unit)})
+	}
+	hash.Write([]byte("Mighty Aphrodite"))
+	hash.Write(body)
+	return hash.Sum(nil)
+}
+
+// jksReader reads JKS's big-endian fields, remembering the first error so callers check once
+type jksReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (j *jksReader) uint32() uint32 {
+	var value uint32
+	if j.err == nil {
+		j.err = binary.Read(j.r, binary.BigEndian, &value)
+	}
+	return value
+}
+
+// utf reads a Java modified UTF-8 string, which is plain UTF-8 for anything keytool writes
+func (j *jksReader) utf() string {
+	var length uint16
+	if j.err == nil {
+		j.err = binary.Read(j.r, binary.BigEndian, &length)
+	}
+	return string(j.read(int(length)))
+}
+
+// bytes reads a length-prefixed block
+func (j *jksReader) bytes() []byte {
+	length := j.uint32()
+	if j.err == nil && int64(length) > int64(j.r.Len()) {
+		j.err = io.ErrUnexpectedEOF
+	}
+	return j.read(int(length))
+}
+
+func (j *jksReader) read(n int) []byte {
+	if j.err != nil {
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(j.r, buf); err != nil {
+		j.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return buf
+}
+
+// certificate reads one certificate; version 2 files name its type first
+func (j *jksReader) certificate(version uint32) *x509.Certificate {
+	if version == 2 {
+		if certType := j.utf(); j.err == nil && certType != "X.509" {
+			j.err = fmt.Errorf("unsupported certificate type %q", certType)
+		}
+	}
+	der := j.bytes()
+	if j.err != nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		j.err = fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert
+}
+
+// truncate drops certificates past maxKeystoreCertificates
+func (k *Keystore) truncate() {
+	seen := 0
+	for i := range k.Entries {
+		if seen+len(k.Entries[i].Chain) > maxKeystoreCertificates {
+			k.Truncated = true
+			if seen == maxKeystoreCertificates {
+				k.Entries = k.Entries[:i]
+				return
+			}
+			k.Entries[i].Chain = k.Entries[i].Chain[:maxKeystoreCertificates-seen]
+			k.Entries = k.Entries[:i+1]
+			return
+		}
+		seen += len(k.Entries[i].Chain)
+	}
+}
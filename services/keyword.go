@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// minKeywordLength keeps keyword searches specific enough for crt.sh to answer
+const minKeywordLength = 4
+
+// keywordPattern is what a keyword may contain: a single label's worth of characters
+var keywordPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// KeywordReport lists the domains with certificates naming a keyword, e.g. a brand name
+type KeywordReport struct {
+	Keyword      string          `json:"keyword"`
+	Excluded     []string        `json:"excluded"`     // Domains the caller owns, left out of the results
+	Certificates int             `json:"certificates"` // Matching certificates outside the excluded domains
+	Domains      []KeywordDomain `json:"domains"`
+}
+
+// KeywordDomain is one registrable domain with certificates naming the keyword
+type KeywordDomain struct {
+	Domain       string    `json:"domain"`
+	Certificates int       `json:"certificates"`
+	Active       int       `json:"active"`
+	Names        []string  `json:"names"` // Names containing the keyword
+	Issuers      []string  `json:"issuers"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// NormalizeKeyword checks a keyword and returns it lowercased
+func NormalizeKeyword(keyword string) (string, error) {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	if len(keyword) < minKeywordLength {
+		return "", fmt.Errorf("keyword must be at least %d characters", minKeywordLength)
+	}
+	if !keywordPattern.MatchString(keyword) {
+		return "", errors.New("keyword may only contain letters, digits and hyphens")
+	}
+	return keyword, nil
+}
+
+// SearchKeyword finds certificates with the keyword anywhere in their names, across all domains
+// Names under the excluded domains (the brand's own) are ignored
+func SearchKeyword(ctx context.Context, keyword string, excluded []string, now time.Time) (KeywordReport, error) {
+	report := KeywordReport{
+		Keyword:  keyword,
+		Excluded: make([]string, 0),
+		Domains:  make([]KeywordDomain, 0),
+	}
+	for _, domain := range excluded {
+		if domain = BaseDomain(domain); domain != "" {
+			report.Excluded = append(report.Excluded, domain)
+		}
+	}
+
+	certs, err := FetchCertificates(ctx, "%"+keyword+"%")
+	if err != nil {
+		return report, err
+	}
+
+	// Map to collect matches by registrable domain
+	domainMap := make(map[string]*KeywordDomain)
+	issuers := make(map[string]map[string]bool)
+
+	for _, group := range GroupCertificates(certs) {
+		counted := make(map[string]bool)
+		for _, name := range GroupNames(group) {
+			if !strings.Contains(name, keyword) || excludedName(name, report.Excluded) {
+				continue
+			}
+			registrable := RegistrableDomain(name)
+
+			entry, exists := domainMap[registrable]
+			if !exists {
+				entry = &KeywordDomain{
+					Domain:    registrable,
+					Names:     make([]string, 0),
+					FirstSeen: group.NotBeforeTime,
+					LastSeen:  group.NotBeforeTime,
+				}
+				domainMap[registrable] = entry
+				issuers[registrable] = make(map[string]bool)
+			}
+
+			if !counted[registrable] {
+				counted[registrable] = true
+				entry.Certificates++
+				if isActive(group, now) {
+					entry.Active++
+				}
+				if group.NotBeforeTime.Before(entry.FirstSeen) {
+					entry.FirstSeen = group.NotBeforeTime
+				}
+				if group.NotBeforeTime.After(entry.LastSeen) {
+					entry.LastSeen = group.NotBeforeTime
+				}
+				issuers[registrable][ctsearch.IssuerDisplayName(group.IssuerName)] = true
+			}
+			if !containsString(entry.Names, name) {
+				entry.Names = append(entry.Names, name)
+			}
+		}
+		if len(counted) > 0 {
+			report.Certificates++
+		}
+	}
+
+	for registrable, entry := range domainMap {
+		sort.Strings(entry.Names)
+		for issuer := range issuers[registrable] {
+			entry.Issuers = append(entry.Issuers, issuer)
+		}
+		sort.Strings(entry.Issuers)
+		report.Domains = append(report.Domains, *entry)
+	}
+
+	// Live certificates first, then the most recently issued
+	sort.Slice(report.Domains, func(i, j int) bool {
+		a, b := report.Domains[i], report.Domains[j]
+		if (a.Active > 0) != (b.Active > 0) {
+			return a.Active > 0
+		}
+		if !a.LastSeen.Equal(b.LastSeen) {
+			return a.LastSeen.After(b.LastSeen)
+		}
+		return a.Domain < b.Domain
+	})
+
+	return report, nil
+}
+
+// excludedName reports whether name is one of the excluded domains or under one
+func excludedName(name string, excluded []string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	for _, domain := range excluded {
+		if inDomain(name, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Let's Encrypt's issuance limits that CT shows usage of, see https://letsencrypt.org/docs/rate-limits/
+const (
+	// leCertificatesPerDomain is how many new certificates a registered domain may get per window;
+	// renewals, with the same names as an earlier certificate, don't count
+	leCertificatesPerDomain = 50
+
+	// leDuplicateCertificates is how many certificates one exact set of names may get per window
+	leDuplicateCertificates = 5
+
+	// leWindow is the window both limits count over
+	leWindow = 7 * 24 * time.Hour
+
+	// leWarnPercent is how much of a limit used raises a warning
+	leWarnPercent = 80
+)
+
+// Let's Encrypt limits, as RateLimitUsage names them
+const (
+	LimitCertificatesPerDomain = "certificates_per_registered_domain"
+	LimitDuplicateCertificate  = "duplicate_certificate"
+)
+
+// Levels of rate limit usage
+const (
+	UsageOK       = "ok"
+	UsageWarning  = "warning"  // leWarnPercent of the limit or more used
+	UsageExceeded = "exceeded" // The limit is used up, so Let's Encrypt turns requests away
+)
+
+// RateLimitUsage is how much of one Let's Encrypt limit CT shows used in the last window
+type RateLimitUsage struct {
+	Limit string `json:"limit"`
+	Key   string `json:"key"` // The registered domain, or the set of names
+	Used  int    `json:"used"`
+	Max   int    `json:"max"`
+	// Renewals are certificates in the window that renewed an earlier one's names, so don't count
+	// against the registered domain's limit
+	Renewals int    `json:"renewals,omitempty"`
+	Level    string `json:"level"`
+	// FreesAt is when the oldest certificate counted leaves the window, for a limit at warning or worse
+	FreesAt *time.Time `json:"freesAt,omitempty"`
+}
+
+// LetsEncryptUsage estimates a domain's use of Let's Encrypt's rate limits from its certificates in CT
+// Let's Encrypt's limits refill gradually rather than all at once a week on, so counting the last 7 days
+// is an upper bound
+type LetsEncryptUsage struct {
+	Domain           string           `json:"domain"`
+	RegisteredDomain RateLimitUsage   `json:"registeredDomain"`
+	Duplicates       []RateLimitUsage `json:"duplicates"` // Sets of names issued more than once in the window, most first
+	Issued           int              `json:"issued"`     // Let's Encrypt certificates issued in the window
+	CheckedAt        time.Time        `json:"checkedAt"`
+	Findings         []Finding        `json:"findings"` // Limits at warning or exceeded
+}
+
+// leIssuance is one Let's Encrypt certificate, by when it was issued and the set of names it's for
+type leIssuance struct {
+	at    time.Time
+	names string
+}
+
+// EstimateLetsEncryptUsage counts domain's Let's Encrypt certificates issued in the last week against the
+// limits on new certificates per registered domain and per exact set of names
+// groups should come from a search of the registered domain and its subdomains, or counts come out low
+func EstimateLetsEncryptUsage(domain string, groups []CertificateGroup, now time.Time) LetsEncryptUsage {
+	registered := RegistrableDomain(BaseDomain(domain))
+	usage := LetsEncryptUsage{
+		Domain:     BaseDomain(domain),
+		Duplicates: make([]RateLimitUsage, 0),
+		CheckedAt:  now,
+		Findings:   make([]Finding, 0),
+	}
+
+	issuances := make([]leIssuance, 0)
+	for _, group := range groups {
+		if !strings.Contains(strings.ToLower(group.IssuerName), "let's encrypt") {
+			continue
+		}
+		names := GroupNames(group)
+		inRegistered := false
+		for _, name := range names {
+			inRegistered = inRegistered || RegistrableDomain(name) == registered
+		}
+		if !inRegistered {
+			continue
+		}
+		sort.Strings(names)
+		at := firstLogged(group)
+		if at.IsZero() {
+			at = group.NotBeforeTime
+		}
+		issuances = append(issuances, leIssuance{at: at, names: strings.Join(names, ",")})
+	}
+	sort.Slice(issuances, func(i, j int) bool {
+		return issuances[i].at.Before(issuances[j].at)
+	})
+
+	// A certificate renews the names of any earlier one; the rest are new and count against the domain
+	since := now.Add(-leWindow)
+	seen := make(map[string]bool)
+	var fresh []time.Time
+	perSet := make(map[string][]time.Time)
+	renewals := 0
+	for _, issuance := range issuances {
+		if !issuance.at.Before(since) && !issuance.at.After(now) {
+			usage.Issued++
+			perSet[issuance.names] = append(perSet[issuance.names], issuance.at)
+			if seen[issuance.names] {
+				renewals++
+			} else {
+				fresh = append(fresh, issuance.at)
+			}
+		}
+		seen[issuance.names] = true
+	}
+
+	usage.RegisteredDomain = rateLimitUsage(LimitCertificatesPerDomain, registered, fresh, leCertificatesPerDomain)
+	usage.RegisteredDomain.Renewals = renewals
+	if finding, ok := usage.RegisteredDomain.finding(); ok {
+		usage.Findings = append(usage.Findings, finding)
+	}
+
+	for names, issued := range perSet {
+		if len(issued) < 2 {
+			continue
+		}
+		duplicate := rateLimitUsage(LimitDuplicateCertificate, names, issued, leDuplicateCertificates)
+		usage.Duplicates = append(usage.Duplicates, duplicate)
+		if finding, ok := duplicate.finding(); ok {
+			usage.Findings = append(usage.Findings, finding)
+		}
+	}
+	sort.Slice(usage.Duplicates, func(i, j int) bool {
+		a, b := usage.Duplicates[i], usage.Duplicates[j]
+		if a.Used != b.Used {
+			return a.Used > b.Used
+		}
+		return a.Key < b.Key
+	})
+	SortFindings(usage.Findings)
+	return usage
+}
+
+// rateLimitUsage rates the certificates issued in the window against a limit; issued is oldest first
+func rateLimitUsage(limit, key string, issued []time.Time, allowed int) RateLimitUsage {
+	usage := RateLimitUsage{Limit: limit, Key: key, Used: len(issued), Max: allowed, Level: UsageOK}
+	switch {
+	case usage.Used >= allowed:
+		usage.Level = UsageExceeded
+	case usage.Used*100 >= allowed*leWarnPercent:
+		usage.Level = UsageWarning
+	}
+	if usage.Level != UsageOK {
+		frees := issued[0].Add(leWindow)
+		usage.FreesAt = &frees
+	}
+	return usage
+}
+
+// finding is the warning for a limit at warning or exceeded
+func (u RateLimitUsage) finding() (Finding, bool) {
+	if u.Level == UsageOK {
+		return Finding{}, false
+	}
+	severity := SeverityWarning
+	if u.Level == UsageExceeded {
+		severity = SeverityCritical
+	}
+	frees := u.FreesAt.UTC().Format("2006-01-02 15:04 UTC")
+	if u.Limit == LimitDuplicateCertificate {
+		return Finding{
+			Severity: severity,
+			Check:    "letsencrypt-rate-limit",
+			Subject:  u.Key,
+			Message: fmt.Sprintf("issued %d times in the last 7 days, Let's Encrypt allows %d (frees up from %s); automation re-issuing rather than reusing its certificate?",
+				u.Used, u.Max, frees),
+		}, true
+	}
+	return Finding{
+		Severity: severity,
+		Check:    "letsencrypt-rate-limit",
+		Subject:  u.Key,
+		Message:  fmt.Sprintf("%d new Let's Encrypt certificates in the last 7 days, of %d allowed per registered domain (frees up from %s)", u.Used, u.Max, frees),
+	}, true
+}
+
+// RateLimitAlerts are the alerts for a watched domain's Let's Encrypt limits at warning or exceeded
+func RateLimitAlerts(domain string, usage LetsEncryptUsage, now time.Time) []Alert {
+	alerts := make([]Alert, 0, len(usage.Findings))
+	for _, finding := range usage.Findings {
+		alerts = append(alerts, Alert{
+			Type:      AlertRateLimit,
+			Domain:    domain,
+			Subject:   finding.Subject,
+			Severity:  finding.Severity,
+			Message:   fmt.Sprintf("%s: %s", finding.Subject, finding.Message),
+			CreatedAt: now,
+		})
+	}
+	return alerts
+}
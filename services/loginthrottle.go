@@ -0,0 +1,86 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// loginUserFailures is how many wrong passwords in a row lock a username out
+	loginUserFailures = 5
+
+	// loginAddrFailures is how many failed logins lock an address out, whatever usernames they tried;
+	// higher than per user, since many people can share an address behind NAT
+	loginAddrFailures = 20
+
+	// loginLockout is how long a lockout lasts after the last failure, and how long failures are remembered
+	loginLockout = 15 * time.Minute
+
+	// maxLoginThrottleKeys caps how many usernames and addresses are tracked before expired ones are swept
+	maxLoginThrottleKeys = 10000
+)
+
+// LoginThrottle refuses password logins for a username or from an address after too many failures, so
+// passwords can't be guessed at the speed of the network. It is safe for concurrent use
+type LoginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures // By "user:" username or "addr:" address
+}
+
+// loginFailures is a run of failed logins
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// NewLoginThrottle returns a throttle with no failures recorded
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{failures: make(map[string]*loginFailures)}
+}
+
+// Allow reports whether username may try a password from addr (a host, without the port) now
+func (t *LoginThrottle) Allow(username, addr string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return !t.locked("user:"+username, loginUserFailures, now) && !t.locked("addr:"+addr, loginAddrFailures, now)
+}
+
+// Failed records a wrong password for username from addr
+func (t *LoginThrottle) Failed(username, addr string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.failures) >= maxLoginThrottleKeys {
+		for key, failures := range t.failures {
+			if now.Sub(failures.last) >= loginLockout {
+				delete(t.failures, key)
+			}
+		}
+	}
+	for _, key := range []string{"user:" + username, "addr:" + addr} {
+		failures, exists := t.failures[key]
+		if !exists || now.Sub(failures.last) >= loginLockout {
+			failures = &loginFailures{}
+			t.failures[key] = failures
+		}
+		failures.count++
+		failures.last = now
+	}
+}
+
+// Succeeded clears username's failures
+// The address's are kept, so one account someone knows the password of can't reset guesses at others
+func (t *LoginThrottle) Succeeded(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, "user:"+username)
+}
+
+// locked reports whether key has failed limit times with the last failure within loginLockout
+// Callers must hold t.mu
+func (t *LoginThrottle) locked(key string, limit int, now time.Time) bool {
+	failures, exists := t.failures[key]
+	return exists && failures.count >= limit && now.Sub(failures.last) < loginLockout
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// sweepWorkers is how many lookalike domains are queried against crt.sh at once
+const sweepWorkers = 4
+
+// PermutationEngine generates lookalike variants of a domain's first label
+// e.g. the homoglyph engine turns "example" into "examp1e" and "exarnple"
+type PermutationEngine struct {
+	Name        string
+	Description string
+	Generate    func(label, suffix string) []string // Returns full domain names
+}
+
+// PermutationEngines are the engines available to SweepLookalikes, keyed by name
+var PermutationEngines = map[string]PermutationEngine{
+	"homoglyph": {
+		Name:        "homoglyph",
+		Description: "Swap characters for ones that look alike (o→0, l→1, m→rn)",
+		Generate:    homoglyphPermutations,
+	},
+	"hyphenation": {
+		Name:        "hyphenation",
+		Description: "Insert or remove hyphens (example → ex-ample)",
+		Generate:    hyphenationPermutations,
+	},
+	"tld": {
+		Name:        "tld",
+		Description: "Same name under a different TLD (example.com → example.net)",
+		Generate:    tldPermutations,
+	},
+	"omission": {
+		Name:        "omission",
+		Description: "Drop a single character (example → exmple)",
+		Generate:    omissionPermutations,
+	},
+	"repetition": {
+		Name:        "repetition",
+		Description: "Double a single character (example → exammple)",
+		Generate:    repetitionPermutations,
+	},
+	"transposition": {
+		Name:        "transposition",
+		Description: "Swap two neighbouring characters (example → exmaple)",
+		Generate:    transpositionPermutations,
+	},
+}
+
+// DefaultPermutationEngines are used when the caller doesn't choose any
+var DefaultPermutationEngines = []string{"homoglyph", "hyphenation", "tld"}
+
+// LookalikeReport lists lookalike domains that have certificates in CT
+type LookalikeReport struct {
+	Domain  string         `json:"domain"`
+	Engines []string       `json:"engines"`
+	Checked int            `json:"checked"` // Permutations queried
+	Skipped int            `json:"skipped"` // Permutations over the limit that weren't queried
+	Failed  int            `json:"failed"`  // Permutations whose lookup failed
+	Hits    []LookalikeHit `json:"hits"`
+}
+
+// LookalikeHit is a lookalike domain with at least one certificate
+type LookalikeHit struct {
+	Domain       string    `json:"domain"`
+	Engine       string    `json:"engine"`
+	Certificates int       `json:"certificates"`
+	Active       int       `json:"active"` // Currently valid certificates - live phishing infrastructure is most urgent
+	Issuers      []string  `json:"issuers"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// LookalikeCandidate is one permutation and the engine that produced it
+type LookalikeCandidate struct {
+	Domain string
+	Engine string
+}
+
+// GenerateLookalikes returns the unique permutations of domain produced by the named engines
+func GenerateLookalikes(domain string, engines []string) ([]LookalikeCandidate, error) {
+	// Permute the registrable domain: for www.example.co.uk that's "example" under "co.uk"
+	domain = RegistrableDomain(BaseDomain(domain))
+	label, suffix, found := strings.Cut(domain, ".")
+	if !found || label == "" {
+		return nil, fmt.Errorf("%q is not a domain name", domain)
+	}
+
+	seen := map[string]bool{domain: true}
+	candidates := make([]LookalikeCandidate, 0)
+
+	for _, name := range engines {
+		engine, exists := PermutationEngines[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown permutation engine: %q", name)
+		}
+
+		for _, permutation := range engine.Generate(label, suffix) {
+			if !seen[permutation] && isValidPermutation(permutation) {
+				seen[permutation] = true
+				candidates = append(candidates, LookalikeCandidate{Domain: permutation, Engine: name})
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// SweepLookalikes generates lookalike domains and checks CT for certificates issued to them
+// At most limit permutations are queried so a sweep can't flood crt.sh
+func SweepLookalikes(ctx context.Context, domain string, engines []string, limit int, now time.Time) (LookalikeReport, error) {
+	if len(engines) == 0 {
+		engines = DefaultPermutationEngines
+	}
+
+	report := LookalikeReport{
+		Domain:  RegistrableDomain(BaseDomain(domain)),
+		Engines: engines,
+		Hits:    make([]LookalikeHit, 0),
+	}
+
+	candidates, err := GenerateLookalikes(domain, engines)
+	if err != nil {
+		return report, err
+	}
+	if len(candidates) > limit {
+		report.Skipped = len(candidates) - limit
+		candidates = candidates[:limit]
+	}
+	report.Checked = len(candidates)
+
+	// Query the candidates with a small pool of workers
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan LookalikeCandidate)
+
+	for i := 0; i < sweepWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for candidate := range queue {
+				certs, err := FetchCertificates(ctx, candidate.Domain)
+
+				mu.Lock()
+				if err != nil {
+					report.Failed++
+				} else if len(certs) > 0 {
+					report.Hits = append(report.Hits, summarizeLookalike(candidate, certs, now))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, candidate := range candidates {
+		queue <- candidate
+	}
+	close(queue)
+	wg.Wait()
+	// A sweep cut short would look like one that found nothing
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	// Live certificates first, then most certificates
+	sort.Slice(report.Hits, func(i, j int) bool {
+		a, b := report.Hits[i], report.Hits[j]
+		if a.Active != b.Active {
+			return a.Active > b.Active
+		}
+		if a.Certificates != b.Certificates {
+			return a.Certificates > b.Certificates
+		}
+		return a.Domain < b.Domain
+	})
+
+	return report, nil
+}
+
+// summarizeLookalike builds the report row for a lookalike domain that has certificates
+func summarizeLookalike(candidate LookalikeCandidate, certs []Certificate, now time.Time) LookalikeHit {
+	hit := LookalikeHit{
+		Domain:  candidate.Domain,
+		Engine:  candidate.Engine,
+		Issuers: make([]string, 0),
+	}
+
+	issuers := make(map[string]bool)
+	for _, group := range GroupCertificates(certs) {
+		hit.Certificates++
+		if isActive(group, now) {
+			hit.Active++
+		}
+
+		issuer := ctsearch.IssuerDisplayName(group.IssuerName)
+		if !issuers[issuer] {
+			issuers[issuer] = true
+			hit.Issuers = append(hit.Issuers, issuer)
+		}
+
+		if hit.FirstSeen.IsZero() || group.NotBeforeTime.Before(hit.FirstSeen) {
+			hit.FirstSeen = group.NotBeforeTime
+		}
+		if group.NotBeforeTime.After(hit.LastSeen) {
+			hit.LastSeen = group.NotBeforeTime
+		}
+	}
+	sort.Strings(hit.Issuers)
+
+	return hit
+}
+
+// isValidPermutation rejects names that can't be registered (empty labels, edge hyphens)
+func isValidPermutation(domain string) bool {
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+// homoglyphs maps characters (or pairs) to strings that look like them in most fonts
+var homoglyphs = map[string][]string{
+	"o":  {"0"},
+	"0":  {"o"},
+	"l":  {"1", "i"},
+	"i":  {"1", "l"},
+	"1":  {"l", "i"},
+	"e":  {"3"},
+	"a":  {"4"},
+	"s":  {"5"},
+	"g":  {"9", "q"},
+	"b":  {"6"},
+	"m":  {"rn", "nn"},
+	"rn": {"m"},
+	"w":  {"vv"},
+	"vv": {"w"},
+	"d":  {"cl"},
+	"cl": {"d"},
+}
+
+// homoglyphPermutations replaces one character (or pair) at a time with a lookalike
+func homoglyphPermutations(label, suffix string) []string {
+	results := make([]string, 0)
+	for i := range label {
+		for size := 1; size <= 2 && i+size <= len(label); size++ {
+			for _, replacement := range homoglyphs[label[i:i+size]] {
+				results = append(results, label[:i]+replacement+label[i+size:]+"."+suffix)
+			}
+		}
+	}
+	return results
+}
+
+// hyphenationPermutations inserts a hyphen at each position, or removes existing ones
+func hyphenationPermutations(label, suffix string) []string {
+	results := make([]string, 0)
+	for i := 1; i < len(label); i++ {
+		results = append(results, label[:i]+"-"+label[i:]+"."+suffix)
+	}
+	if strings.Contains(label, "-") {
+		results = append(results, strings.ReplaceAll(label, "-", "")+"."+suffix)
+	}
+	return results
+}
+
+// swapTLDs are the suffixes most often used for lookalike registrations
+var swapTLDs = []string{"com", "net", "org", "co", "io", "info", "biz", "xyz", "online", "site", "app", "dev", "shop", "top"}
+
+// tldPermutations keeps the label but swaps the suffix
+func tldPermutations(label, suffix string) []string {
+	results := make([]string, 0)
+	for _, tld := range swapTLDs {
+		if tld != suffix {
+			results = append(results, label+"."+tld)
+		}
+	}
+	return results
+}
+
+// omissionPermutations drops one character at a time
+func omissionPermutations(label, suffix string) []string {
+	results := make([]string, 0)
+	for i := range label {
+		results = append(results, label[:i]+label[i+1:]+"."+suffix)
+	}
+	return results
+}
+
+// repetitionPermutations doubles one character at a time
+func repetitionPermutations(label, suffix string) []string {
+	results := make([]string, 0)
+	for i := range label {
+		results = append(results, label[:i+1]+label[i:]+"."+suffix)
+	}
+	return results
+}
+
+// transpositionPermutations swaps each pair of neighbouring characters
+func transpositionPermutations(label, suffix string) []string {
+	results := make([]string, 0)
+	for i := 0; i+1 < len(label); i++ {
+		if label[i] == label[i+1] {
+			continue
+		}
+		results = append(results, label[:i]+string(label[i+1])+string(label[i])+label[i+2:]+"."+suffix)
+	}
+	return results
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// DomainMetrics is what the last check of a watched domain found, for Prometheus and Zabbix
+type DomainMetrics struct {
+	Domain    string
+	CheckedAt time.Time
+	// NotAfter is when the first of the domain's hostnames runs out of valid certificates, going by each
+	// hostname's newest; zero when none has a valid certificate
+	NotAfter           time.Time
+	Hostname           string // The hostname that runs out first
+	Issuer             string // Display name of the issuer of its newest certificate
+	ActiveCertificates int
+	UnexpectedIssuer   int // Certificates ever logged by an issuer outside the expected ones
+}
+
+// DaysRemaining is how many whole days are left until NotAfter, negative once it has passed
+func (m DomainMetrics) DaysRemaining(now time.Time) int {
+	return int(math.Floor(m.NotAfter.Sub(now).Hours() / 24))
+}
+
+// domainMetrics holds the last metrics of every domain checked, and the issuers certificates are expected from
+var domainMetrics = struct {
+	sync.Mutex
+	domains map[string]DomainMetrics
+	issuers Policy // Only its Issuers are used; empty expects any
+}{domains: make(map[string]DomainMetrics)}
+
+// SetExpectedIssuers sets the issuers watched domains' certificates should come from, matched
+// case-insensitively against the issuer name like a policy's; empty expects any
+func SetExpectedIssuers(issuers []string) {
+	domainMetrics.Lock()
+	defer domainMetrics.Unlock()
+	domainMetrics.issuers = Policy{Issuers: issuers}
+}
+
+// ExpectingIssuers reports whether expected issuers are set, so certificates from others can be counted
+func ExpectingIssuers() bool {
+	domainMetrics.Lock()
+	defer domainMetrics.Unlock()
+	return len(domainMetrics.issuers.Issuers) > 0
+}
+
+// RecordDomainMetrics keeps what a check of domain found, replacing the last check's
+func RecordDomainMetrics(domain string, groups []CertificateGroup, now time.Time) DomainMetrics {
+	domainMetrics.Lock()
+	defer domainMetrics.Unlock()
+	metrics := measureDomain(domain, groups, domainMetrics.issuers, now)
+	domainMetrics.domains[domain] = metrics
+	return metrics
+}
+
+// MetricsFor returns the metrics of each of domains that has been checked, in the same order
+// A domain not checked since the server started is measured from its last results, e.g. in the disk cache
+func MetricsFor(domains []string) []DomainMetrics {
+	list := make([]DomainMetrics, 0, len(domains))
+	for _, domain := range domains {
+		domainMetrics.Lock()
+		metrics, ok := domainMetrics.domains[domain]
+		domainMetrics.Unlock()
+		if !ok {
+			last, cached := lastResultsFor(domain)
+			if !cached {
+				continue
+			}
+			metrics = RecordDomainMetrics(domain, GroupCertificates(last.certs), last.fetchedAt)
+		}
+		list = append(list, metrics)
+	}
+	return list
+}
+
+// measureDomain works out a domain's metrics from its certificates as of now
+// Hostnames are judged by their newest certificate like CheckPolicy, so a renewed one about to expire doesn't count
+func measureDomain(domain string, groups []CertificateGroup, expected Policy, now time.Time) DomainMetrics {
+	metrics := DomainMetrics{Domain: domain, CheckedAt: now}
+	for _, group := range groups {
+		if !expected.allowsIssuer(group.IssuerName) {
+			metrics.UnexpectedIssuer++
+		}
+		if isActive(group, now) {
+			metrics.ActiveCertificates++
+		}
+	}
+	for name, group := range newestByHostname(groups, now) {
+		if metrics.NotAfter.IsZero() || group.NotAfterTime.Before(metrics.NotAfter) ||
+			group.NotAfterTime.Equal(metrics.NotAfter) && name < metrics.Hostname {
+			metrics.NotAfter, metrics.Hostname = group.NotAfterTime, name
+			metrics.Issuer = ctsearch.IssuerDisplayName(group.IssuerName)
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TeamsMessage is what a Microsoft Teams incoming webhook or Workflows "post to a channel when a webhook
+// request is received" trigger takes: a message with an Adaptive Card attached
+type TeamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []TeamsAttachment `json:"attachments"`
+}
+
+// TeamsAttachment is a card attached to a Teams message
+type TeamsAttachment struct {
+	ContentType string         `json:"contentType"`
+	ContentURL  *string        `json:"contentUrl"` // Always null, but Workflows wants it there
+	Content     map[string]any `json:"content"`
+}
+
+// ValidateTeamsWebhook checks a Teams webhook URL is usable
+func ValidateTeamsWebhook(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("Teams webhook URL must be an https URL")
+	}
+	return nil
+}
+
+// TeamsAlertMessage is a card listing alerts, each with its domain and when it was raised
+// Given baseURL, this server's address, each also gets buttons to view the certificates it's about and
+// to acknowledge it on the dashboard; cards can only link, so acknowledging takes a click there
+func TeamsAlertMessage(alerts []Alert, baseURL string) TeamsMessage {
+	title := fmt.Sprintf("Certificate alert: %s", alerts[0].Message)
+	if len(alerts) > 1 {
+		title = fmt.Sprintf("%d certificate alerts", len(alerts))
+	}
+	body := []any{
+		map[string]any{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "wrap": true},
+	}
+
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	for _, alert := range alerts {
+		items := []any{
+			map[string]any{"type": "TextBlock", "text": alert.Message, "wrap": true},
+			map[string]any{"type": "FactSet", "facts": []any{
+				map[string]string{"title": "Domain", "value": alert.Domain},
+				map[string]string{"title": "Type", "value": alert.Type},
+				map[string]string{"title": "Raised", "value": alert.CreatedAt.UTC().Format("2006-01-02 15:04 UTC")},
+			}},
+		}
+		if baseURL != "" {
+			actions := []any{
+				map[string]string{"type": "Action.OpenUrl", "title": "View certificates", "url": baseURL + "/search?domain=" + url.QueryEscape(alert.Subject)},
+			}
+			if alert.ID != "" {
+				actions = append(actions, map[string]string{"type": "Action.OpenUrl", "title": "Acknowledge", "url": baseURL + "/dashboard#alert-" + alert.ID})
+			}
+			items = append(items, map[string]any{"type": "ActionSet", "actions": actions})
+		}
+		body = append(body, map[string]any{"type": "Container", "separator": true, "items": items})
+	}
+
+	return TeamsMessage{
+		Type: "message",
+		Attachments: []TeamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+				"msteams": map[string]string{"width": "Full"},
+			},
+		}},
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPolicySize is the most we read of an MTA-STS policy file (RFC 8461 suggests 64KB)
+const maxPolicySize = 64 * 1024
+
+// MTASTSPolicy is a parsed MTA-STS policy file (RFC 8461)
+type MTASTSPolicy struct {
+	Version string   `json:"version"`
+	Mode    string   `json:"mode"` // enforce, testing or none
+	MX      []string `json:"mx"`   // Allowed MX host patterns, e.g. "*.mail.example.com"
+	MaxAge  int      `json:"maxAge"`
+}
+
+// Allows reports whether the policy permits host as an MX, wildcards covering one label
+func (p MTASTSPolicy) Allows(host string) bool {
+	for _, pattern := range p.MX {
+		if NameCovers(NormalizeName(pattern), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// MailTransportReport summarizes a domain's email transport security
+type MailTransportReport struct {
+	Domain       string        `json:"domain"`
+	STSRecord    string        `json:"stsRecord"` // TXT at _mta-sts.domain
+	Policy       *MTASTSPolicy `json:"policy,omitempty"`
+	PolicyError  string        `json:"policyError,omitempty"`
+	TLSRPTRecord string        `json:"tlsrptRecord"` // TXT at _smtp._tls.domain
+	ReportURIs   []string      `json:"reportUris"`
+	MXHosts      []MXCheck     `json:"mxHosts"`
+	Findings     []Finding     `json:"findings"`
+}
+
+// MXCheck is an MX host with the certificate it presents over STARTTLS
+type MXCheck struct {
+	Host            string      `json:"host"`
+	Preference      uint16      `json:"preference"`
+	AllowedByPolicy bool        `json:"allowedByPolicy"`
+	Probe           ProbeResult `json:"probe"`
+}
+
+// CheckMailTransport fetches the domain's MTA-STS policy and TLS-RPT record,
+// probes each MX host and reports where they disagree
+func CheckMailTransport(ctx context.Context, resolver *net.Resolver, domain string) MailTransportReport {
+	report := MailTransportReport{
+		Domain:     BaseDomain(domain),
+		ReportURIs: make([]string, 0),
+		MXHosts:    make([]MXCheck, 0),
+		Findings:   make([]Finding, 0),
+	}
+
+	report.STSRecord = lookupTagRecord(ctx, resolver, "_mta-sts."+report.Domain, "v=STSv1")
+	report.TLSRPTRecord = lookupTagRecord(ctx, resolver, "_smtp._tls."+report.Domain, "v=TLSRPTv1")
+	if report.TLSRPTRecord != "" {
+		report.ReportURIs = tagValues(report.TLSRPTRecord, "rua")
+	}
+
+	policy, err := FetchMTASTSPolicy(ctx, report.Domain)
+	if err != nil {
+		report.PolicyError = err.Error()
+	} else {
+		report.Policy = &policy
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	mxs, err := resolver.LookupMX(lookupCtx, report.Domain)
+	cancel()
+	if err != nil {
+		report.Findings = append(report.Findings, Finding{
+			Severity: SeverityWarning,
+			Check:    "mx",
+			Subject:  report.Domain,
+			Message:  "could not look up MX records: " + err.Error(),
+		})
+	}
+	sort.Slice(mxs, func(i, j int) bool {
+		return mxs[i].Pref < mxs[j].Pref
+	})
+
+	// Probe the MX hosts in parallel, keeping preference order
+	report.MXHosts = make([]MXCheck, len(mxs))
+	var wg sync.WaitGroup
+	for i, mx := range mxs {
+		wg.Add(1)
+		go func(i int, mx *net.MX) {
+			defer wg.Done()
+			host := NormalizeName(mx.Host)
+			check := MXCheck{
+				Host:       host,
+				Preference: mx.Pref,
+				Probe:      ProbeTLS(ctx, host, 25),
+			}
+			if report.Policy != nil {
+				check.AllowedByPolicy = report.Policy.Allows(host)
+			}
+			report.MXHosts[i] = check
+		}(i, mx)
+	}
+	wg.Wait()
+
+	report.Findings = append(report.Findings, mailTransportFindings(report)...)
+	SortFindings(report.Findings)
+	return report
+}
+
+// mailTransportFindings lists the discrepancies between the records, policy and MX hosts
+func mailTransportFindings(report MailTransportReport) []Finding {
+	findings := make([]Finding, 0)
+	add := func(severity, check, subject, message string) {
+		findings = append(findings, Finding{Severity: severity, Check: check, Subject: subject, Message: message})
+	}
+
+	switch {
+	case report.STSRecord == "" && report.Policy == nil:
+		add(SeverityInfo, "mta-sts", report.Domain, "MTA-STS is not deployed")
+	case report.STSRecord == "":
+		add(SeverityWarning, "mta-sts", report.Domain, "a policy is published but there is no _mta-sts TXT record, so senders will not fetch it")
+	case report.Policy == nil:
+		add(SeverityCritical, "mta-sts", report.Domain, "_mta-sts TXT record exists but the policy could not be fetched: "+report.PolicyError)
+	case report.Policy.Mode == "testing":
+		add(SeverityInfo, "mta-sts", report.Domain, "policy is in testing mode, so failures are reported but not enforced")
+	case report.Policy.Mode == "none":
+		add(SeverityWarning, "mta-sts", report.Domain, "policy mode is none, which withdraws MTA-STS protection")
+	}
+
+	if report.TLSRPTRecord == "" {
+		add(SeverityInfo, "tls-rpt", report.Domain, "no TLS-RPT record, so TLS delivery failures are not reported to the domain")
+	} else if len(report.ReportURIs) == 0 {
+		add(SeverityWarning, "tls-rpt", report.Domain, "TLS-RPT record has no rua reporting address")
+	}
+
+	enforced := report.Policy != nil && report.Policy.Mode == "enforce"
+	for _, mx := range report.MXHosts {
+		severity := SeverityWarning
+		if enforced {
+			// Senders honouring the policy will refuse to deliver to this host
+			severity = SeverityCritical
+		}
+
+		if report.Policy != nil && report.Policy.Mode != "none" && !mx.AllowedByPolicy {
+			add(severity, "mta-sts", mx.Host, "MX host is not listed in the MTA-STS policy")
+		}
+		switch {
+		case mx.Probe.Error != "":
+			add(severity, "mx-tls", mx.Host, mx.Probe.Error)
+		case mx.Probe.VerifyError != "":
+			add(severity, "mx-tls", mx.Host, "certificate is not valid for the MX hostname: "+mx.Probe.VerifyError)
+		}
+	}
+
+	return findings
+}
+
+// FetchMTASTSPolicy downloads and parses https://mta-sts.<domain>/.well-known/mta-sts.txt
+func FetchMTASTSPolicy(ctx context.Context, domain string) (MTASTSPolicy, error) {
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+		// RFC 8461 forbids following redirects for the policy
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://mta-sts."+domain+"/.well-known/mta-sts.txt", nil)
+	if err != nil {
+		return MTASTSPolicy{}, fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return MTASTSPolicy{}, fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MTASTSPolicy{}, fmt.Errorf("policy host returned status %d", resp.StatusCode)
+	}
+
+	return ParseMTASTSPolicy(io.LimitReader(resp.Body, maxPolicySize))
+}
+
+// ParseMTASTSPolicy parses the "key: value" lines of a policy file
+func ParseMTASTSPolicy(r io.Reader) (MTASTSPolicy, error) {
+	policy := MTASTSPolicy{MX: make([]string, 0)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "version":
+			policy.Version = value
+		case "mode":
+			policy.Mode = value
+		case "mx":
+			policy.MX = append(policy.MX, value)
+		case "max_age":
+			policy.MaxAge, _ = strconv.Atoi(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return policy, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	if policy.Version != "STSv1" {
+		return policy, fmt.Errorf("policy version is %q, expected STSv1", policy.Version)
+	}
+	switch policy.Mode {
+	case "enforce", "testing", "none":
+	default:
+		return policy, fmt.Errorf("policy mode %q is not enforce, testing or none", policy.Mode)
+	}
+	if policy.Mode != "none" && len(policy.MX) == 0 {
+		return policy, fmt.Errorf("policy lists no mx hosts")
+	}
+
+	return policy, nil
+}
+
+// lookupTagRecord returns the TXT record at name starting with prefix, e.g. "v=STSv1"
+func lookupTagRecord(ctx context.Context, resolver *net.Resolver, name, prefix string) string {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return ""
+	}
+	for _, record := range records {
+		if strings.HasPrefix(record, prefix) {
+			return record
+		}
+	}
+	return ""
+}
+
+// tagValues splits the comma-separated value of a tag in a "k=v; k=v" record
+func tagValues(record, tag string) []string {
+	values := make([]string, 0)
+	for _, field := range strings.Split(record, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found || strings.TrimSpace(key) != tag {
+			continue
+		}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
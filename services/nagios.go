@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Nagios plugin states; each is also the exit status a plugin returns with it
+const (
+	NagiosOK       = 0
+	NagiosWarning  = 1
+	NagiosCritical = 2
+	NagiosUnknown  = 3
+)
+
+// nagiosStateNames are the states as plugin output names them, by state
+var nagiosStateNames = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosSeverity ranks the states by state, for combining several problems into one
+var nagiosSeverity = [...]int{NagiosOK: 0, NagiosWarning: 2, NagiosCritical: 3, NagiosUnknown: 1}
+
+// NagiosThresholds are when a domain's check turns warning or critical
+type NagiosThresholds struct {
+	Warning  time.Duration // A hostname's newest certificate expires within this
+	Critical time.Duration
+	Issuers  []string // Allowed issuers, matched like a policy's; a valid certificate from any other is critical, empty allows any
+}
+
+// NagiosResult is a domain check in the shape of Nagios plugin output, which Icinga reads too
+type NagiosResult struct {
+	State    int      `json:"state"`
+	Summary  string   `json:"summary"`  // The first line of output
+	Details  []string `json:"details"`  // Long output, a line each
+	Perfdata []string `json:"perfdata"` // label=value;warn;crit;min;max
+}
+
+// StateName is the state as plugin output names it, e.g. WARNING
+func (r NagiosResult) StateName() string {
+	return nagiosStateNames[r.State]
+}
+
+// Output is the result as a plugin prints it: status and summary, performance data after a pipe, then
+// the long output
+func (r NagiosResult) Output() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "CERT %s - %s", r.StateName(), r.Summary)
+	if len(r.Perfdata) > 0 {
+		fmt.Fprintf(&out, " | %s", strings.Join(r.Perfdata, " "))
+	}
+	out.WriteString("\n")
+	for _, line := range r.Details {
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
+
+// NagiosFailure is the UNKNOWN result of a check that couldn't be made, e.g. because crt.sh failed
+func NagiosFailure(domain string, err error) NagiosResult {
+	return NagiosResult{State: NagiosUnknown, Summary: fmt.Sprintf("%s: %v", domain, err), Details: make([]string, 0), Perfdata: make([]string, 0)}
+}
+
+// CheckNagios checks a domain's certificates for a Nagios or Icinga service: the hostname whose newest
+// certificate expires first sets the expiry state, and any valid certificate from an unexpected issuer is
+// critical. A domain with certificates in CT but none valid is critical, and one with none at all unknown
+// Only TLS server certificates count, so S/MIME and code signing certificates don't page anyone
+func CheckNagios(domain string, groups []CertificateGroup, thresholds NagiosThresholds, now time.Time) NagiosResult {
+	groups = FilterByPurpose(groups, PurposeFilterTLS)
+	result := NagiosResult{State: NagiosOK, Details: make([]string, 0), Perfdata: make([]string, 0)}
+	policy := Policy{Issuers: thresholds.Issuers}
+
+	active := 0
+	unexpected := make([]CertificateGroup, 0)
+	for _, group := range groups {
+		if !isActive(group, now) {
+			continue
+		}
+		active++
+		if !policy.allowsIssuer(group.IssuerName) {
+			unexpected = append(unexpected, group)
+		}
+	}
+
+	parts := make([]string, 0)
+	if len(unexpected) > 0 {
+		result.raise(NagiosCritical)
+		parts = append(parts, fmt.Sprintf("%d valid certificate(s) from unexpected issuers", len(unexpected)))
+		for _, group := range unexpected {
+			finding := unexpectedIssuerFinding(group)
+			result.Details = append(result.Details, fmt.Sprintf("%s %s", finding.Subject, finding.Message))
+		}
+	}
+
+	newest := newestByHostname(groups, now)
+	hostnames := make([]string, 0, len(newest))
+	for name := range newest {
+		hostnames = append(hostnames, name)
+	}
+	sort.Slice(hostnames, func(i, j int) bool {
+		a, b := newest[hostnames[i]], newest[hostnames[j]]
+		if !a.NotAfterTime.Equal(b.NotAfterTime) {
+			return a.NotAfterTime.Before(b.NotAfterTime)
+		}
+		return hostnames[i] < hostnames[j]
+	})
+
+	switch {
+	case len(groups) == 0:
+		result.raise(NagiosUnknown)
+		parts = append(parts, "no certificates logged in CT")
+	case len(hostnames) == 0:
+		result.raise(NagiosCritical)
+		last := groups[0]
+		for _, group := range groups[1:] {
+			if group.NotAfterTime.After(last.NotAfterTime) {
+				last = group
+			}
+		}
+		parts = append(parts, fmt.Sprintf("no valid certificate, the last expired on %s", last.NotAfterTime.Format("2006-01-02")))
+	default:
+		first := newest[hostnames[0]]
+		remaining := first.NotAfterTime.Sub(now)
+		switch {
+		case remaining <= thresholds.Critical:
+			result.raise(NagiosCritical)
+		case remaining <= thresholds.Warning:
+			result.raise(NagiosWarning)
+		}
+		parts = append(parts, fmt.Sprintf("certificate for %s expires in %d day(s) on %s (%s)", hostnames[0],
+			daysBetween(now, first.NotAfterTime), first.NotAfterTime.Format("2006-01-02"), ctsearch.IssuerDisplayName(first.IssuerName)))
+		for _, name := range hostnames {
+			group := newest[name]
+			if group.NotAfterTime.Sub(now) > thresholds.Warning {
+				break
+			}
+			result.Details = append(result.Details, fmt.Sprintf("%s expires in %d day(s) on %s", name, daysBetween(now, group.NotAfterTime), group.NotAfterTime.Format("2006-01-02")))
+		}
+		result.Perfdata = append(result.Perfdata, fmt.Sprintf("days_remaining=%d;%d;%d;;", daysBetween(now, first.NotAfterTime),
+			int(thresholds.Warning/(24*time.Hour)), int(thresholds.Critical/(24*time.Hour))))
+	}
+
+	result.Summary = fmt.Sprintf("%s: %s", domain, strings.Join(parts, "; "))
+	result.Perfdata = append(result.Perfdata, fmt.Sprintf("active_certificates=%d;;;0;", active))
+	if len(policy.Issuers) > 0 {
+		// A critical range of 0 alerts on anything above it
+		result.Perfdata = append(result.Perfdata, fmt.Sprintf("unexpected_issuers=%d;;0;0;", len(unexpected)))
+	}
+	return result
+}
+
+// raise moves the result to state if that's worse: CRITICAL, then WARNING, then UNKNOWN
+func (r *NagiosResult) raise(state int) {
+	if nagiosSeverity[state] > nagiosSeverity[r.State] {
+		r.State = state
+	}
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NotificationPrefs is how one user wants to hear about alerts
+type NotificationPrefs struct {
+	Username   string   `json:"username"` // Empty when accounts are disabled
+	Types      []string `json:"types"`    // Alert types to send; empty sends none
+	Email      string   `json:"email,omitempty"`
+	WebhookURL string   `json:"webhookUrl,omitempty"` // Gets a JSON POST per batch of alerts
+	// WebhookSecret signs what's POSTed to WebhookURL (X-Certviewer-Signature), so the receiver can tell it's from us
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	// TeamsWebhookURL is a Microsoft Teams incoming webhook or Workflows URL, which gets an Adaptive Card
+	// per batch of alerts
+	TeamsWebhookURL string `json:"teamsWebhookUrl,omitempty"`
+	QuietStart      string `json:"quietStart,omitempty"` // "22:00"; alerts wait until quiet hours end
+	QuietEnd        string `json:"quietEnd,omitempty"`   // "07:00"
+	Timezone        string `json:"timezone,omitempty"`   // IANA name for quiet hours, e.g. "Europe/London"; UTC when empty
+}
+
+// Wants reports whether the user wants alerts of this type on any channel
+func (p NotificationPrefs) Wants(alert Alert) bool {
+	return (p.Email != "" || p.WebhookURL != "" || p.TeamsWebhookURL != "") && containsString(p.Types, alert.Type)
+}
+
+// QuietUntil returns when the quiet hours covering now end, or the zero time if now isn't in quiet hours
+// Quiet hours may run past midnight (22:00 to 07:00)
+func (p NotificationPrefs) QuietUntil(now time.Time) time.Time {
+	if p.QuietStart == "" || p.QuietEnd == "" {
+		return time.Time{}
+	}
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	start, errStart := time.Parse("15:04", p.QuietStart)
+	end, errEnd := time.Parse("15:04", p.QuietEnd)
+	if errStart != nil || errEnd != nil || p.QuietStart == p.QuietEnd {
+		return time.Time{}
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+
+	if startMinute < endMinute {
+		if minute >= startMinute && minute < endMinute {
+			return endToday
+		}
+		return time.Time{}
+	}
+	// Overnight: quiet from start until midnight, and from midnight until end
+	if minute >= startMinute {
+		return endToday.AddDate(0, 0, 1)
+	}
+	if minute < endMinute {
+		return endToday
+	}
+	return time.Time{}
+}
+
+// Validate checks the preferences are usable, normalizing the email address
+func (p *NotificationPrefs) Validate() error {
+	for _, alertType := range p.Types {
+		if _, known := AlertTypes[alertType]; !known {
+			return fmt.Errorf("unknown alert type %q", alertType)
+		}
+	}
+	if p.Email != "" {
+		address, err := mail.ParseAddress(p.Email)
+		if err != nil {
+			return fmt.Errorf("invalid email address %q", p.Email)
+		}
+		p.Email = address.Address
+	}
+	if p.WebhookURL != "" {
+		parsed, err := url.Parse(p.WebhookURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return errors.New("webhook URL must be an http or https URL")
+		}
+		if err := CheckWebhookURL(p.WebhookURL); err != nil {
+			return err
+		}
+	}
+	if p.TeamsWebhookURL != "" {
+		if err := ValidateTeamsWebhook(p.TeamsWebhookURL); err != nil {
+			return err
+		}
+		if err := CheckWebhookURL(p.TeamsWebhookURL); err != nil {
+			return err
+		}
+	}
+	if (p.QuietStart == "") != (p.QuietEnd == "") {
+		return errors.New("set both the start and end of quiet hours, or neither")
+	}
+	for _, clock := range []string{p.QuietStart, p.QuietEnd} {
+		if _, err := time.Parse("15:04", clock); clock != "" && err != nil {
+			return fmt.Errorf("invalid time %q, use HH:MM", clock)
+		}
+	}
+	if _, err := LoadTimezone(p.Timezone); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NotificationStore holds everyone's notification preferences
+// It is safe for concurrent use and is saved to a JSON file after every change
+type NotificationStore struct {
+	mu    sync.Mutex
+	path  string // Empty means keep everything in memory only
+	prefs map[string]NotificationPrefs
+}
+
+// LoadNotificationStore reads the preferences from path, starting empty if the file doesn't exist yet
+func LoadNotificationStore(path string) (*NotificationStore, error) {
+	s := &NotificationStore{
+		path:  path,
+		prefs: make(map[string]NotificationPrefs),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification preferences: %w", err)
+	}
+
+	var prefs []NotificationPrefs
+	if err := json.Unmarshal(content, &prefs); err != nil {
+		return nil, fmt.Errorf("failed to parse notification preferences: %w", err)
+	}
+	for _, p := range prefs {
+		s.prefs[p.Username] = p
+	}
+
+	return s, nil
+}
+
+// Reload re-reads the notification preferences file, e.g. after it was edited by hand
+// Nothing changes if the file can't be read or parsed
+func (s *NotificationStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+	fresh, err := LoadNotificationStore(s.path)
+	if err != nil {
+		return err
+	}
+	s.prefs = fresh.prefs
+	return nil
+}
+
+// Get returns username's preferences, defaulting to every alert type and no channels
+func (s *NotificationStore) Get(username string) NotificationPrefs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, exists := s.prefs[username]; exists {
+		p.Types = append([]string{}, p.Types...)
+		return p
+	}
+
+	p := NotificationPrefs{Username: username, Types: make([]string, 0, len(AlertTypes))}
+	for alertType := range AlertTypes {
+		p.Types = append(p.Types, alertType)
+	}
+	sort.Strings(p.Types)
+	return p
+}
+
+// Set validates and saves a user's preferences
+func (s *NotificationStore) Set(prefs NotificationPrefs) error {
+	if err := prefs.Validate(); err != nil {
+		return err
+	}
+	sort.Strings(prefs.Types)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prefs[prefs.Username] = prefs
+	return s.save()
+}
+
+// Delete removes username's preferences, e.g. when their account is deleted
+func (s *NotificationStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.prefs[username]; !exists {
+		return nil
+	}
+	delete(s.prefs, username)
+	return s.save()
+}
+
+// List returns everyone's saved preferences
+func (s *NotificationStore) List() []NotificationPrefs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]NotificationPrefs, 0, len(s.prefs))
+	for _, p := range s.prefs {
+		p.Types = append([]string{}, p.Types...)
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+
+	return list
+}
+
+// save writes the preferences to disk
+// Callers must hold s.mu
+func (s *NotificationStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	prefs := make([]NotificationPrefs, 0, len(s.prefs))
+	for _, p := range s.prefs {
+		prefs = append(prefs, p)
+	}
+	sort.Slice(prefs, func(i, j int) bool {
+		return prefs[i].Username < prefs[j].Username
+	})
+
+	content, err := json.MarshalIndent(prefs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode notification preferences: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written file
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+
+	return nil
+}
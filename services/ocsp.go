@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+const (
+	// ocspTimeout bounds each request to a responder, and each issuer certificate download
+	ocspTimeout = 10 * time.Second
+
+	// ocspSlowLatency is when a responder counts as slow; browsers and servers that fetch OCSP
+	// during a handshake make the client wait this long
+	ocspSlowLatency = time.Second
+
+	// maxOCSPIssuers caps how many issuers' responders are checked for one domain
+	maxOCSPIssuers = 20
+)
+
+// OCSP responder health
+const (
+	OCSPHealthy   = "healthy"
+	OCSPSlow      = "slow"
+	OCSPFailing   = "failing"
+	OCSPNone      = "none"      // The issuer's certificates name no responder, so revocation is by CRL only
+	OCSPUnchecked = "unchecked" // The certificate couldn't be downloaded from crt.sh to find its responder
+)
+
+// OCSPHealthReport is how the OCSP responders behind a domain's certificates answer from this server
+type OCSPHealthReport struct {
+	Domain     string                `json:"domain"`
+	CheckedAt  time.Time             `json:"checkedAt"`
+	Responders []OCSPResponderHealth `json:"responders"`
+	Skipped    int                   `json:"skipped,omitempty"` // Issuers over maxOCSPIssuers, not checked
+}
+
+// OCSPResponderHealth is one issuer's responder, asked about one of its currently valid certificates
+type OCSPResponderHealth struct {
+	Issuer        string `json:"issuer"`
+	CertificateID int64  `json:"certificateId"` // crt.sh ID of the certificate asked about
+	URL           string `json:"url,omitempty"`
+	Health        string `json:"health"`
+	LatencyMS     int64  `json:"latencyMs,omitempty"`
+	HTTPStatus    int    `json:"httpStatus,omitempty"`
+	CertStatus    string `json:"certStatus,omitempty"` // "good", "revoked" or "unknown" from a signed response
+	Error         string `json:"error,omitempty"`
+}
+
+// ocspClient asks responders and downloads issuer certificates
+var ocspClient = &http.Client{
+	Timeout: ocspTimeout,
+}
+
+// CheckOCSPResponders asks each issuer's OCSP responder about its newest valid certificate for the domain
+// The issuer certificate is downloaded from the certificate's AIA URL to build a real request and check the
+// signed answer; when it can't be, the responder is only checked for reachability
+func CheckOCSPResponders(ctx context.Context, domain string, groups []CertificateGroup, now time.Time) OCSPHealthReport {
+	report := OCSPHealthReport{Domain: BaseDomain(domain), CheckedAt: now.UTC(), Responders: make([]OCSPResponderHealth, 0)}
+
+	// The newest currently valid certificate from each issuer
+	newest := make(map[string]CertificateGroup)
+	for _, group := range groups {
+		if !isActive(group, now) {
+			continue
+		}
+		if current, seen := newest[group.IssuerName]; !seen || group.NotBeforeTime.After(current.NotBeforeTime) {
+			newest[group.IssuerName] = group
+		}
+	}
+	issuers := make([]string, 0, len(newest))
+	for issuer := range newest {
+		issuers = append(issuers, issuer)
+	}
+	sort.Slice(issuers, func(i, j int) bool {
+		return ctsearch.IssuerDisplayName(issuers[i]) < ctsearch.IssuerDisplayName(issuers[j])
+	})
+	if len(issuers) > maxOCSPIssuers {
+		report.Skipped = len(issuers) - maxOCSPIssuers
+		issuers = issuers[:maxOCSPIssuers]
+	}
+
+	ids := make([]int64, 0, len(issuers))
+	for _, issuer := range issuers {
+		ids = append(ids, PreferredEntry(newest[issuer]).ID)
+	}
+	infos, errs := FetchCertificateInfos(ctx, ids)
+
+	for i, issuer := range issuers {
+		base := OCSPResponderHealth{Issuer: ctsearch.IssuerDisplayName(issuer), CertificateID: ids[i]}
+		if err, failed := errs[ids[i]]; failed {
+			base.Health = OCSPUnchecked
+			base.Error = "could not download a certificate to find the responder: " + err.Error()
+			report.Responders = append(report.Responders, base)
+			continue
+		}
+		info := infos[ids[i]]
+		if len(info.OCSPServers) == 0 {
+			base.Health = OCSPNone
+			report.Responders = append(report.Responders, base)
+			continue
+		}
+		for _, url := range info.OCSPServers {
+			responder := base
+			responder.URL = url
+			report.Responders = append(report.Responders, responder)
+		}
+	}
+
+	// Ask every issuer's responders at once, downloading each issuer certificate once
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		info, ok := infos[id]
+		if !ok || len(info.OCSPServers) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			issuer := fetchIssuerCertificate(ctx, info.Certificate)
+			for i := range report.Responders {
+				if report.Responders[i].CertificateID == id && report.Responders[i].URL != "" {
+					checkOCSPResponder(ctx, &report.Responders[i], info.Certificate, issuer)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+// checkOCSPResponder times one request to a responder: a real OCSP request when the issuer is known,
+// otherwise a plain GET to see whether it answers at all
+func checkOCSPResponder(ctx context.Context, responder *OCSPResponderHealth, cert, issuer *x509.Certificate) {
+	var req *http.Request
+	var err error
+	if issuer != nil {
+		var body []byte
+		body, err = ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+		if err == nil {
+			req, err = http.NewRequestWithContext(ctx, http.MethodPost, responder.URL, bytes.NewReader(body))
+		}
+		if req != nil {
+			req.Header.Set("Content-Type", "application/ocsp-request")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, responder.URL, nil)
+	}
+	if err != nil {
+		responder.Health = OCSPFailing
+		responder.Error = err.Error()
+		return
+	}
+
+	if err := waitForHost(ctx, responder.URL); err != nil {
+		responder.Health = OCSPFailing
+		responder.Error = "not checked: " + err.Error()
+		return
+	}
+	start := time.Now()
+	resp, err := ocspClient.Do(req)
+	if err != nil {
+		responder.Health = OCSPFailing
+		responder.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	responder.LatencyMS = time.Since(start).Milliseconds()
+	responder.HTTPStatus = resp.StatusCode
+
+	responder.Health = OCSPHealthy
+	if time.Duration(responder.LatencyMS)*time.Millisecond >= ocspSlowLatency {
+		responder.Health = OCSPSlow
+	}
+	switch {
+	case err != nil:
+		responder.Health = OCSPFailing
+		responder.Error = "failed to read response: " + err.Error()
+	case issuer == nil:
+		responder.Error = "issuer certificate unavailable, so only reachability was checked"
+	case resp.StatusCode != http.StatusOK:
+		responder.Health = OCSPFailing
+		responder.Error = fmt.Sprintf("responder returned HTTP %d", resp.StatusCode)
+	default:
+		parsed, err := ocsp.ParseResponseForCert(body, cert, issuer)
+		if err != nil {
+			responder.Health = OCSPFailing
+			responder.Error = "invalid OCSP response: " + err.Error()
+			return
+		}
+		responder.CertStatus = ocspStatusName(parsed.Status)
+	}
+}
+
+// ocspStatusName names a certificate status from an OCSP response
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// fetchIssuerCertificate downloads a certificate's issuer from its AIA URL, or returns nil
+func fetchIssuerCertificate(ctx context.Context, cert *x509.Certificate) *x509.Certificate {
+	for _, url := range cert.IssuingCertificateURL {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil || waitForHost(ctx, url) != nil {
+			continue
+		}
+		resp, err := ocspClient.Do(req)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if issuer, err := x509info.ParseCertificatePEM(data); err == nil && cert.CheckSignatureFrom(issuer) == nil {
+			return issuer
+		}
+	}
+	return nil
+}
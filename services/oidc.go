@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig describes an OpenID Connect identity provider (Okta, Azure AD, Keycloak...)
+type OIDCConfig struct {
+	Issuer        string // Discovery is done from Issuer + /.well-known/openid-configuration
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string   // Must point at /login/oidc/callback and be registered with the provider
+	UsernameClaim string   // ID token claim used as the username, e.g. "preferred_username" or "email"
+	GroupsClaim   string   // ID token claim listing the user's groups
+	AdminGroups   []string // Members of any of these groups are admins
+	AllowedGroups []string // If set, only members of these groups (or AdminGroups) may log in
+}
+
+// OIDCIdentity is who the identity provider (or an authenticating proxy) says logged in
+type OIDCIdentity struct {
+	Username string
+	Groups   []string
+	Admin    bool
+}
+
+// OIDCProvider runs the authorization code flow against one identity provider
+type OIDCProvider struct {
+	config   OIDCConfig
+	mu       sync.Mutex // Guards oauth2, whose client secret SetClientSecret replaces
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider fetches the provider's discovery document and signing keys endpoint
+func NewOIDCProvider(ctx context.Context, config OIDCConfig) (*OIDCProvider, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OIDC needs a client ID and redirect URL")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	return &OIDCProvider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// AuthCodeURL returns where to send the browser to log in
+// state and nonce tie the callback to this login; verifier is the PKCE secret kept for Exchange
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	p.mu.Lock()
+	config := p.oauth2
+	p.mu.Unlock()
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// SetClientSecret replaces the client secret, e.g. when the settings are reloaded; logins under way use the new one
+func (p *OIDCProvider) SetClientSecret(secret string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.oauth2.ClientSecret = secret
+}
+
+// Exchange trades the callback's code for an ID token, verifies it and maps the user's groups to a role
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (OIDCIdentity, error) {
+	p.mu.Lock()
+	config := p.oauth2
+	p.mu.Unlock()
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("failed to exchange OIDC code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return OIDCIdentity{}, errors.New("OIDC provider did not return an ID token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return OIDCIdentity{}, errors.New("ID token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("failed to read ID token claims: %w", err)
+	}
+
+	return p.identity(claims)
+}
+
+// identity picks the username and groups out of the ID token claims and applies the group rules
+func (p *OIDCProvider) identity(claims map[string]interface{}) (OIDCIdentity, error) {
+	username, _ := claims[p.config.UsernameClaim].(string)
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" {
+		return OIDCIdentity{}, fmt.Errorf("ID token has no %q claim", p.config.UsernameClaim)
+	}
+
+	identity := OIDCIdentity{Username: username, Groups: claimStrings(claims[p.config.GroupsClaim])}
+	identity.Admin = anyStringIn(identity.Groups, p.config.AdminGroups)
+
+	if len(p.config.AllowedGroups) > 0 && !identity.Admin && !anyStringIn(identity.Groups, p.config.AllowedGroups) {
+		return identity, fmt.Errorf("%s is not in a group allowed to use this site", username)
+	}
+
+	return identity, nil
+}
+
+// claimStrings reads a claim that is either a list of strings or a single string
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// anyStringIn reports whether any of values is in set
+func anyStringIn(values, set []string) bool {
+	for _, value := range values {
+		if containsString(set, value) {
+			return true
+		}
+	}
+	return false
+}
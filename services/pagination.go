@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pageWindow is how many page numbers are linked either side of the current page
+const pageWindow = 2
+
+// Page is where one page of results sits in the whole list
+type Page struct {
+	Number int // 1-based
+	Pages  int
+	Size   int
+	Total  int // Certificates across every page
+	First  int // 1-based position of the first certificate on the page, 0 when there are none
+	Last   int
+}
+
+// PageLink is one entry in a row of page number links; a gap stands for the pages skipped between two numbers
+type PageLink struct {
+	Number  int
+	Current bool
+	Gap     bool
+}
+
+// ParsePage reads a page parameter, defaulting to the first page
+func ParsePage(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(value)
+	if err != nil || page < 1 {
+		return 0, fmt.Errorf("invalid page %q, use a number from 1", value)
+	}
+	return page, nil
+}
+
+// PaginateIssuers keeps the certificates on one page of the issuer sections, in order
+// An issuer split across pages appears on each with its part of the certificates; pages past the end show the last
+func PaginateIssuers(issuers []IssuerGroup, number, size int) ([]IssuerGroup, Page) {
+	page := Page{Size: size}
+	for _, issuer := range issuers {
+		page.Total += len(issuer.Certificates)
+	}
+	page.Pages = max(1, (page.Total+size-1)/size)
+	page.Number = min(max(number, 1), page.Pages)
+	if page.Total == 0 {
+		return issuers, page
+	}
+	page.First = (page.Number-1)*size + 1
+	page.Last = min(page.Number*size, page.Total)
+
+	paged := make([]IssuerGroup, 0)
+	position := 0 // Certificates before the current issuer
+	for _, issuer := range issuers {
+		start := max(page.First-1-position, 0)
+		end := min(page.Last-position, len(issuer.Certificates))
+		position += len(issuer.Certificates)
+		if start >= end {
+			continue
+		}
+		issuer.Certificates = issuer.Certificates[start:end]
+		paged = append(paged, issuer)
+	}
+	return paged, page
+}
+
+// Previous is the page before this one, or 0 on the first page
+func (p Page) Previous() int {
+	if p.Number > 1 {
+		return p.Number - 1
+	}
+	return 0
+}
+
+// Next is the page after this one, or 0 on the last page
+func (p Page) Next() int {
+	if p.Number < p.Pages {
+		return p.Number + 1
+	}
+	return 0
+}
+
+// Links lists the first and last pages and those around the current one, with gaps between
+func (p Page) Links() []PageLink {
+	links := make([]PageLink, 0)
+	for number := 1; number <= p.Pages; number++ {
+		if number != 1 && number != p.Pages && (number < p.Number-pageWindow || number > p.Number+pageWindow) {
+			if last := len(links) - 1; last < 0 || !links[last].Gap {
+				links = append(links, PageLink{Gap: true})
+			}
+			continue
+		}
+		links = append(links, PageLink{Number: number, Current: number == p.Number})
+	}
+	return links
+}
+
+// SearchQuery builds the query string for a search with its filters, leaving out empty values and the default sort
+// so the same search always has the same URL
+func SearchQuery(domain, notBefore, san string, sanRegex bool, purpose, sort string) url.Values {
+	query := url.Values{"domain": {domain}}
+	if notBefore != "" {
+		query.Set("notBefore", notBefore)
+	}
+	if san != "" {
+		query.Set("san", san)
+	}
+	if sanRegex {
+		query.Set("sanRegex", "1")
+	}
+	if purpose != "" {
+		query.Set("purpose", purpose)
+	}
+	if sort != "" && sort != DefaultSortOrder.String() {
+		query.Set("sort", sort)
+	}
+	return query
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+// Where a pasted certificate was found in CT
+const (
+	CTLoggedExact          = "logged"         // These exact bytes are in CT
+	CTLoggedPrecertificate = "precertificate" // Only its precertificate is in CT
+	CTSerialOnly           = "serial-only"    // crt.sh has the serial number, but its entries couldn't be downloaded to compare
+	CTNotLogged            = "not-logged"
+)
+
+// PastedCertificate is a certificate that didn't come from a CT search, analyzed like a report's certificates
+type PastedCertificate struct {
+	Subject     string            `json:"subject"`
+	Names       []string          `json:"names"`
+	SHA256      string            `json:"sha256"`
+	Active      bool              `json:"active"`
+	Certificate ReportCertificate `json:"certificate"`
+	Findings    []Finding         `json:"findings"`
+
+	Group CertificateGroup `json:"-"` // The certificate as crt.sh would have described it
+}
+
+// CTPresence is what crt.sh knows about a pasted certificate
+type CTPresence struct {
+	Status      string            `json:"status"`
+	Certificate *CertificateGroup `json:"certificate,omitempty"` // crt.sh's entries with the same serial number
+	Entry       *Certificate      `json:"entry,omitempty"`       // The entry with these exact bytes, or the precertificate
+	Error       string            `json:"error,omitempty"`
+}
+
+// ParseCertificate decodes a PEM (or raw DER) certificate
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("expected a CERTIFICATE, got a PEM %s", block.Type)
+	}
+	return x509info.ParseCertificatePEM(data)
+}
+
+// InspectPastedCertificate checks a certificate's crypto, CT policy, expiry and custom analyzers,
+// with the same findings a domain report gives each active certificate
+func InspectPastedCertificate(ctx context.Context, cert *x509.Certificate, now time.Time) PastedCertificate {
+	group := certificateGroup(cert)
+	fingerprint := sha256.Sum256(cert.Raw)
+	info := x509info.InspectCertificate(0, cert)
+
+	pasted := PastedCertificate{
+		Subject: cert.Subject.String(),
+		Names:   GroupNames(group),
+		SHA256:  hex.EncodeToString(fingerprint[:]),
+		Active:  isActive(group, now),
+		Certificate: ReportCertificate{
+			CommonName:   group.CommonName,
+			SerialNumber: group.SerialNumber,
+			Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+			NotBefore:    group.NotBeforeTime,
+			NotAfter:     group.NotAfterTime,
+			RequiredSCTs: RequiredSCTs(group.NotBeforeTime, group.NotAfterTime),
+			Info:         &info,
+			CTPolicy:     CheckCTPolicy(ctx, info, group.NotBeforeTime, group.NotAfterTime),
+		},
+		Group: group,
+	}
+
+	pasted.Findings = certificateFindings(pasted.Certificate)
+	switch {
+	case !now.Before(group.NotAfterTime):
+		pasted.Findings = append(pasted.Findings, Finding{
+			Severity: SeverityCritical,
+			Check:    "expiry",
+			Subject:  group.CommonName,
+			Message:  fmt.Sprintf("expired %d day(s) ago on %s", daysBetween(group.NotAfterTime, now), group.NotAfterTime.Format("2006-01-02")),
+		})
+	case now.Before(group.NotBeforeTime):
+		pasted.Findings = append(pasted.Findings, Finding{
+			Severity: SeverityWarning,
+			Check:    "expiry",
+			Subject:  group.CommonName,
+			Message:  fmt.Sprintf("not valid until %s", group.NotBeforeTime.Format("2006-01-02")),
+		})
+	case group.NotAfterTime.Before(now.AddDate(0, 0, expiringSoonDays)):
+		pasted.Findings = append(pasted.Findings, expiryFinding(group, now))
+	}
+
+	domain := ""
+	if len(pasted.Names) > 0 {
+		domain = BaseDomain(pasted.Names[0])
+	}
+	pasted.Findings = append(pasted.Findings, runAnalyzers(AnalyzedCertificate{Domain: domain, Group: group, Names: pasted.Names, Info: &info})...)
+
+	SortFindings(pasted.Findings)
+	return pasted
+}
+
+// certificateGroup describes a parsed certificate the way crt.sh's search results would
+func certificateGroup(cert *x509.Certificate) CertificateGroup {
+	const crtshTime = "2006-01-02T15:04:05"
+	notBefore, notAfter := cert.NotBefore.UTC(), cert.NotAfter.UTC()
+	entry := Certificate{
+		IssuerName:   crtshName(cert.RawIssuer, cert.Issuer),
+		CommonName:   cert.Subject.CommonName,
+		NameValue:    strings.Join(cert.DNSNames, "\n"),
+		NotBefore:    notBefore.Format(crtshTime),
+		NotAfter:     notAfter.Format(crtshTime),
+		SerialNumber: cert.SerialNumber.Text(16),
+	}
+	return CertificateGroup{
+		SerialNumber:  entry.SerialNumber,
+		CommonName:    entry.CommonName,
+		IssuerName:    entry.IssuerName,
+		NotBefore:     entry.NotBefore,
+		NotAfter:      entry.NotAfter,
+		NotBeforeTime: notBefore,
+		NotAfterTime:  notAfter,
+		Entries:       []Certificate{entry},
+		Purpose:       x509info.CertificatePurpose(cert),
+		PurposeKnown:  true,
+	}
+}
+
+// crtshName writes a distinguished name the way crt.sh does, in certificate order: "C=US, O=Let's Encrypt, CN=R3"
+func crtshName(raw []byte, name pkix.Name) string {
+	var sequence pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(raw, &sequence); err != nil || len(rest) > 0 {
+		sequence = name.ToRDNSequence()
+	}
+	parts := make([]string, 0, len(sequence))
+	for _, rdn := range sequence {
+		parts = append(parts, pkix.RDNSequence{rdn}.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// LocateInCT looks for a certificate among crt.sh's search results
+// Entries with its serial number are downloaded and compared byte for byte, since crt.sh's results
+// don't say whether an entry is the certificate itself or only its precertificate
+func LocateInCT(ctx context.Context, cert *x509.Certificate, groups []CertificateGroup) CTPresence {
+	presence := CTPresence{Status: CTNotLogged}
+	serial := normalizeSerial(cert.SerialNumber.Text(16))
+
+	for i := range groups {
+		if normalizeSerial(groups[i].SerialNumber) != serial {
+			continue
+		}
+		presence.Certificate = &groups[i]
+
+		// The group's entries are downloaded together, rather than one after another
+		ids := make([]int64, 0, len(groups[i].Entries))
+		for _, entry := range groups[i].Entries {
+			ids = append(ids, entry.ID)
+		}
+		downloaded, errs := downloadCertificates(ctx, ids)
+
+		for j, entry := range groups[i].Entries {
+			logged, ok := downloaded[entry.ID]
+			if !ok {
+				presence.Error = errs[entry.ID].Error()
+				continue
+			}
+			if bytes.Equal(logged.Raw, cert.Raw) {
+				return CTPresence{Status: CTLoggedExact, Certificate: &groups[i], Entry: &groups[i].Entries[j]}
+			}
+			if x509info.InspectCertificate(entry.ID, logged).IsPrecertificate {
+				presence.Status = CTLoggedPrecertificate
+				presence.Entry = &groups[i].Entries[j]
+			}
+		}
+	}
+
+	if presence.Status == CTNotLogged && presence.Error != "" {
+		presence.Status = CTSerialOnly
+	}
+	return presence
+}
+
+// normalizeSerial makes hex serial numbers comparable, whatever their case and leading zeros
+func normalizeSerial(serial string) string {
+	return strings.TrimLeft(strings.ToLower(strings.ReplaceAll(serial, ":", "")), "0")
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// ErrCertificateNotFound is returned when crt.sh has no certificate for a permalink or ID
+var ErrCertificateNotFound = fmt.Errorf("certificate %w", ErrNoResults)
+
+// maxCachedCertificates caps the certificates kept in memory; a logged certificate never changes, so
+// entries are only dropped to make room, oldest first
+const maxCachedCertificates = 1000
+
+// certificateCache holds certificates downloaded from crt.sh by ID, and which ID each issuer and serial resolved to
+var certificateCache = struct {
+	sync.Mutex
+	certs   map[int64]*x509.Certificate
+	order   []int64 // Oldest first
+	serials map[string]int64
+}{
+	certs:   make(map[int64]*x509.Certificate),
+	serials: make(map[string]int64),
+}
+
+// LoggedCertificate returns the certificate crt.sh has under an ID, downloading it if it isn't cached
+func LoggedCertificate(ctx context.Context, id int64) (*x509.Certificate, error) {
+	return downloadCertificate(ctx, id)
+}
+
+// ResolveSerial finds the crt.sh ID of the certificate an issuing CA (by crt.sh CA ID) gave a hex serial number
+// The final certificate is preferred over its precertificate when both are logged
+func ResolveSerial(ctx context.Context, issuerCAID int64, serial string) (int64, error) {
+	serial = normalizeSerial(serial)
+	if serial == "" || strings.Trim(serial, "0123456789abcdef") != "" {
+		return 0, fmt.Errorf("%w %q, use hex digits", ErrInvalidSerial, serial)
+	}
+	key := fmt.Sprintf("%d/%s", issuerCAID, serial)
+
+	certificateCache.Lock()
+	id, cached := certificateCache.serials[key]
+	certificateCache.Unlock()
+	if cached {
+		return id, nil
+	}
+
+	var certs []Certificate
+	err := callUpstream(ctx, crtshSearchBreaker, func() (err error) {
+		certs, err = ctsearch.FetchBySerial(ctx, serial)
+		return err
+	})
+	certs, _, err = partialResults(certs, err)
+	if err != nil {
+		return 0, err
+	}
+	matching := make([]Certificate, 0)
+	for _, cert := range certs {
+		if cert.IssuerCAID == issuerCAID && normalizeSerial(cert.SerialNumber) == serial {
+			matching = append(matching, cert)
+		}
+	}
+	groups := GroupCertificates(matching)
+	if len(groups) == 0 {
+		return 0, ErrCertificateNotFound
+	}
+	id = PreferredEntry(groups[0]).ID
+
+	certificateCache.Lock()
+	defer certificateCache.Unlock()
+	if len(certificateCache.serials) >= maxCachedCertificates {
+		clear(certificateCache.serials)
+	}
+	certificateCache.serials[key] = id
+	return id, nil
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Policy is what a domain's currently valid certificates must satisfy, e.g. in a cron job or CI check
+type Policy struct {
+	ExpiringWithin time.Duration // A hostname fails when its newest certificate expires sooner than this
+	MaxAge         time.Duration // A hostname fails when its newest certificate was issued longer ago than this; 0 means no limit
+	Issuers        []string      // Allowed issuers, matched case-insensitively against the issuer name; empty allows any
+}
+
+// PolicyResult is the outcome of checking one domain against a policy
+type PolicyResult struct {
+	Domain             string    `json:"domain"`
+	CheckedAt          time.Time `json:"checkedAt"`
+	Hostnames          int       `json:"hostnames"`          // Hostnames with at least one valid certificate
+	ActiveCertificates int       `json:"activeCertificates"` // Certificates valid right now
+	Violations         []Finding `json:"violations"`
+}
+
+// Passed reports whether the domain had no violations
+func (r PolicyResult) Passed() bool {
+	return len(r.Violations) == 0
+}
+
+// CheckPolicy checks a domain's currently valid certificates against a policy
+// Hostnames are judged by their newest certificate, since that's the one a renewal put in place;
+// names only on expired certificates are history and aren't checked
+func CheckPolicy(domain string, groups []CertificateGroup, policy Policy, now time.Time) PolicyResult {
+	result := PolicyResult{Domain: domain, CheckedAt: now, Violations: make([]Finding, 0)}
+
+	for _, group := range groups {
+		if !isActive(group, now) {
+			continue
+		}
+		result.ActiveCertificates++
+
+		if !policy.allowsIssuer(group.IssuerName) {
+			result.Violations = append(result.Violations, unexpectedIssuerFinding(group))
+		}
+	}
+	newest := newestByHostname(groups, now)
+	result.Hostnames = len(newest)
+
+	names := make([]string, 0, len(newest))
+	for name := range newest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		group := newest[name]
+		if group.NotAfterTime.Sub(now) < policy.ExpiringWithin {
+			finding := expiryFinding(group, now)
+			finding.Subject = name
+			result.Violations = append(result.Violations, finding)
+		}
+		if policy.MaxAge > 0 && now.Sub(group.NotBeforeTime) > policy.MaxAge {
+			result.Violations = append(result.Violations, Finding{
+				Severity: SeverityWarning,
+				Check:    "max-age",
+				Subject:  name,
+				Message:  fmt.Sprintf("newest certificate was issued %d day(s) ago on %s", daysBetween(group.NotBeforeTime, now), group.NotBeforeTime.Format("2006-01-02")),
+			})
+		}
+	}
+
+	SortFindings(result.Violations)
+	return result
+}
+
+// newestByHostname maps each hostname on an active certificate to its newest one, the one a renewal put in place
+func newestByHostname(groups []CertificateGroup, now time.Time) map[string]CertificateGroup {
+	newest := make(map[string]CertificateGroup)
+	for _, group := range groups {
+		if !isActive(group, now) {
+			continue
+		}
+		for _, name := range GroupNames(group) {
+			if current, seen := newest[name]; !seen || group.NotAfterTime.After(current.NotAfterTime) {
+				newest[name] = group
+			}
+		}
+	}
+	return newest
+}
+
+// unexpectedIssuerFinding is the violation for an active certificate from an issuer the policy doesn't allow
+func unexpectedIssuerFinding(group CertificateGroup) Finding {
+	return Finding{
+		Severity: SeverityCritical,
+		Check:    "issuer",
+		Subject:  fmt.Sprintf("%s (serial %s)", group.CommonName, group.SerialNumber),
+		Message:  fmt.Sprintf("issued by unexpected issuer %s", ctsearch.IssuerDisplayName(group.IssuerName)),
+	}
+}
+
+// allowsIssuer reports whether the policy accepts certificates from an issuer
+func (p Policy) allowsIssuer(issuerName string) bool {
+	if len(p.Issuers) == 0 {
+		return true
+	}
+	issuerName = strings.ToLower(issuerName)
+	for _, allowed := range p.Issuers {
+		if strings.Contains(issuerName, strings.ToLower(allowed)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+const (
+	// maxPQCCertificates caps how many active certificates are downloaded to tally algorithms, newest first
+	maxPQCCertificates = 100
+
+	// maxPQCEndpoints caps how many hostnames are probed on port 443
+	maxPQCEndpoints = 10
+)
+
+// PQCMilestone is an announced date after which quantum-vulnerable keys stop being acceptable
+type PQCMilestone struct {
+	Name         string    `json:"name"`
+	Date         time.Time `json:"date"`
+	Below128Bits bool      `json:"below128Bits"` // Only applies to keys under 128-bit classical security, like RSA-2048
+}
+
+// pqcMilestones are the published migration timelines certificates are compared with
+var pqcMilestones = []PQCMilestone{
+	{Name: "NIST IR 8547: 112-bit RSA and ECC deprecated", Date: time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), Below128Bits: true},
+	{Name: "CNSA 2.0: web servers and browsers quantum-resistant", Date: time.Date(2033, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{Name: "NIST IR 8547: RSA and ECC disallowed", Date: time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+// classicalKeyAlgorithms are the public key algorithms a quantum computer breaks
+var classicalKeyAlgorithms = map[string]bool{"RSA": true, "ECDSA": true, "Ed25519": true}
+
+// PQCReport summarizes how ready a domain is for post-quantum cryptography: the key and signature
+// algorithms of its active certificates, which of them are valid past a migration deadline, and whether
+// its servers already negotiate a hybrid post-quantum key exchange
+type PQCReport struct {
+	Domain              string           `json:"domain"`
+	CheckedAt           time.Time        `json:"checkedAt"`
+	Milestones          []PQCMilestone   `json:"milestones"`
+	Certificates        int              `json:"certificates"` // Active certificates inspected
+	Classical           int              `json:"classical"`    // Of those, with quantum-vulnerable keys
+	KeyAlgorithms       []AlgorithmCount `json:"keyAlgorithms"`
+	SignatureAlgorithms []AlgorithmCount `json:"signatureAlgorithms"`
+	AtRisk              []PQCCertificate `json:"atRisk"`       // Classical certificates valid past a milestone, latest expiry first
+	NotInspected        int              `json:"notInspected"` // Older active certificates over maxPQCCertificates
+	Failed              int              `json:"failed"`       // Certificates that couldn't be downloaded
+
+	Endpoints       []PQCEndpoint `json:"endpoints"`
+	HybridEndpoints int           `json:"hybridEndpoints"` // Endpoints negotiating X25519MLKEM768
+	NotProbed       int           `json:"notProbed"`       // Hostnames over maxPQCEndpoints
+}
+
+// AlgorithmCount is how many certificates use one algorithm
+type AlgorithmCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// PQCCertificate is a certificate with a quantum-vulnerable key that is still valid after a migration milestone
+type PQCCertificate struct {
+	CommonName         string    `json:"commonName"`
+	SerialNumber       string    `json:"serialNumber"`
+	Issuer             string    `json:"issuer"` // Display name
+	KeyType            string    `json:"keyType"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	Days               int       `json:"days"`       // Validity period
+	Milestones         []string  `json:"milestones"` // Names of the milestones it outlives
+}
+
+// PQCEndpoint is what one server negotiates
+type PQCEndpoint struct {
+	Host               string `json:"host"`
+	TLSVersion         string `json:"tlsVersion,omitempty"`
+	CipherSuite        string `json:"cipherSuite,omitempty"`
+	KeyType            string `json:"keyType,omitempty"` // Of the served leaf certificate
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+	HybridKeyExchange  bool   `json:"hybridKeyExchange"` // Negotiates X25519MLKEM768
+	Error              string `json:"error,omitempty"`
+}
+
+// CheckPQCReadiness downloads a domain's active certificates to tally their algorithms and compare their
+// validity with pqcMilestones, then probes its hostnames for a hybrid post-quantum key exchange
+func CheckPQCReadiness(ctx context.Context, domain string, groups []CertificateGroup, now time.Time) PQCReport {
+	base := BaseDomain(domain)
+	report := PQCReport{
+		Domain:              base,
+		CheckedAt:           now.UTC(),
+		Milestones:          pqcMilestones,
+		KeyAlgorithms:       make([]AlgorithmCount, 0),
+		SignatureAlgorithms: make([]AlgorithmCount, 0),
+		AtRisk:              make([]PQCCertificate, 0),
+		Endpoints:           make([]PQCEndpoint, 0),
+	}
+
+	// The newest active certificates, and the hostnames they cover
+	active := make([]CertificateGroup, 0)
+	hosts := make(map[string]bool)
+	for _, group := range groups {
+		if !isActive(group, now) || len(group.Entries) == 0 {
+			continue
+		}
+		active = append(active, group)
+		if group.Purpose != PurposeTLS {
+			continue
+		}
+		for _, name := range GroupNames(group) {
+			if inDomain(name, base) && !strings.HasPrefix(name, "*.") {
+				hosts[name] = true
+			}
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].NotBeforeTime.After(active[j].NotBeforeTime)
+	})
+	if len(active) > maxPQCCertificates {
+		report.NotInspected = len(active) - maxPQCCertificates
+		active = active[:maxPQCCertificates]
+	}
+
+	ids := make([]int64, 0, len(active))
+	for _, group := range active {
+		ids = append(ids, PreferredEntry(group).ID)
+	}
+	infos, errs := FetchCertificateInfos(ctx, ids)
+	report.Failed = len(errs)
+
+	keyTypes := make(map[string]int)
+	signatures := make(map[string]int)
+	for i, group := range active {
+		info, ok := infos[ids[i]]
+		if !ok {
+			continue
+		}
+		report.Certificates++
+		keyTypes[KeyType(info)]++
+		signatures[info.SignatureAlgorithm]++
+		if !classicalKeyAlgorithms[info.KeyAlgorithm] {
+			continue
+		}
+		report.Classical++
+
+		outlived := make([]string, 0)
+		for _, milestone := range pqcMilestones {
+			if group.NotAfterTime.After(milestone.Date) && (!milestone.Below128Bits || below128Bits(info)) {
+				outlived = append(outlived, milestone.Name)
+			}
+		}
+		if len(outlived) == 0 {
+			continue
+		}
+		report.AtRisk = append(report.AtRisk, PQCCertificate{
+			CommonName:         group.CommonName,
+			SerialNumber:       group.SerialNumber,
+			Issuer:             ctsearch.IssuerDisplayName(group.IssuerName),
+			KeyType:            KeyType(info),
+			SignatureAlgorithm: info.SignatureAlgorithm,
+			NotBefore:          group.NotBeforeTime,
+			NotAfter:           group.NotAfterTime,
+			Days:               daysBetween(group.NotBeforeTime, group.NotAfterTime),
+			Milestones:         outlived,
+		})
+	}
+	report.KeyAlgorithms = algorithmCounts(keyTypes)
+	report.SignatureAlgorithms = algorithmCounts(signatures)
+	sort.Slice(report.AtRisk, func(i, j int) bool {
+		return report.AtRisk[i].NotAfter.After(report.AtRisk[j].NotAfter)
+	})
+
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxPQCEndpoints {
+		report.NotProbed = len(names) - maxPQCEndpoints
+		names = names[:maxPQCEndpoints]
+	}
+
+	// Probe every hostname at once
+	report.Endpoints = make([]PQCEndpoint, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Endpoints[i] = probePQCEndpoint(ctx, name)
+		}()
+	}
+	wg.Wait()
+	for _, endpoint := range report.Endpoints {
+		if endpoint.HybridKeyExchange {
+			report.HybridEndpoints++
+		}
+	}
+
+	return report
+}
+
+// probePQCEndpoint records what a hostname serves on port 443 and whether it speaks X25519MLKEM768
+func probePQCEndpoint(ctx context.Context, host string) PQCEndpoint {
+	endpoint := PQCEndpoint{Host: host}
+	result := ProbeTLS(ctx, host, 443)
+	if result.Error != "" {
+		endpoint.Error = result.Error
+		return endpoint
+	}
+	endpoint.TLSVersion = result.TLSVersion
+	endpoint.CipherSuite = result.CipherSuite
+	if chain := result.Certificates(); len(chain) > 0 {
+		info := x509info.InspectCertificate(0, chain[0])
+		endpoint.KeyType = KeyType(info)
+		endpoint.SignatureAlgorithm = info.SignatureAlgorithm
+	}
+
+	hybrid, err := HybridKeyExchange(ctx, host, 443)
+	endpoint.HybridKeyExchange = hybrid
+	if err != nil {
+		endpoint.Error = "hybrid key exchange check failed: " + err.Error()
+	}
+	return endpoint
+}
+
+// below128Bits reports whether a classical key gives less than 128-bit security, as NIST counts it
+func below128Bits(info CertificateInfo) bool {
+	return info.KeyAlgorithm == "RSA" && info.KeySize < 3072 || info.KeyAlgorithm == "ECDSA" && info.KeySize < 256
+}
+
+// algorithmCounts sorts a tally by count, most used first
+func algorithmCounts(counts map[string]int) []AlgorithmCount {
+	sorted := make([]AlgorithmCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, AlgorithmCount{Name: name, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/probe"
+)
+
+// ProbeResult is what a server presented during a TLS handshake, see pkg/probe
+type ProbeResult = probe.Result
+
+// ProbedCertificate summarizes one certificate from a served chain
+type ProbedCertificate = probe.Certificate
+
+// ProbeTLS connects to host:port and records the served certificate chain
+// SMTP ports (25, 587) are probed with STARTTLS
+func ProbeTLS(ctx context.Context, host string, port int) ProbeResult {
+	return probe.TLS(ctx, host, port)
+}
+
+// HybridKeyExchange reports whether host:port negotiates the hybrid X25519MLKEM768 post-quantum key exchange
+func HybridKeyExchange(ctx context.Context, host string, port int) (bool, error) {
+	return probe.HybridKeyExchange(ctx, host, port)
+}
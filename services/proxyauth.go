@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ProxyAuthConfig describes an authenticating reverse proxy in front of the server (oauth2-proxy, Authelia...)
+type ProxyAuthConfig struct {
+	UserHeader     string   // Header holding the authenticated username, e.g. "X-Remote-User"
+	GroupsHeader   string   // Optional comma-separated groups header, e.g. "X-Remote-Groups"
+	AdminGroups    []string // Members of any of these groups are admins
+	TrustedProxies []string // CIDRs (or single addresses) allowed to set the headers
+}
+
+// ProxyAuth reads identities from headers set by a trusted reverse proxy
+// Headers from any other address are ignored, so clients can't log themselves in by sending them
+type ProxyAuth struct {
+	config  ProxyAuthConfig
+	trusted []netip.Prefix
+}
+
+// NewProxyAuth parses the trusted proxy list
+func NewProxyAuth(config ProxyAuthConfig) (*ProxyAuth, error) {
+	if config.UserHeader == "" {
+		return nil, errors.New("proxy auth needs a username header")
+	}
+	if len(config.TrustedProxies) == 0 {
+		return nil, errors.New("proxy auth needs at least one trusted proxy address")
+	}
+
+	p := &ProxyAuth{config: config}
+	for _, proxy := range config.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+
+	return p, nil
+}
+
+// Trusted reports whether a request's peer address (host:port) is one of the trusted proxies
+func (p *ProxyAuth) Trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns who the proxy says made the request
+// ok is false when the request didn't come from a trusted proxy or has no username header
+func (p *ProxyAuth) Identity(r *http.Request) (identity OIDCIdentity, ok bool) {
+	if !p.Trusted(r.RemoteAddr) {
+		return identity, false
+	}
+	identity.Username = strings.TrimSpace(r.Header.Get(p.config.UserHeader))
+	if identity.Username == "" {
+		return identity, false
+	}
+
+	if p.config.GroupsHeader != "" {
+		for _, value := range r.Header.Values(p.config.GroupsHeader) {
+			for _, group := range strings.Split(value, ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					identity.Groups = append(identity.Groups, group)
+				}
+			}
+		}
+	}
+	identity.Admin = anyStringIn(identity.Groups, p.config.AdminGroups)
+
+	return identity, true
+}
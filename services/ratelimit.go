@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Upstream sources with their own outbound request budget
+const (
+	SourceCrtsh  = "crtsh"  // crt.sh searches and certificate downloads
+	SourceCTLogs = "ctlogs" // The CT log list
+	SourceOCSP   = "ocsp"   // Each OCSP responder and issuer certificate host, separately
+)
+
+// maxLimitedHosts caps how many OCSP hosts have a limiter at once; past it they all start afresh
+const maxLimitedHosts = 1000
+
+// defaultRateLimits are each source's requests per second unless -rate-limits says otherwise
+var defaultRateLimits = map[string]float64{
+	SourceCrtsh:  4,
+	SourceCTLogs: 1,
+	SourceOCSP:   5,
+}
+
+// ErrBudgetExhausted is returned without sending a request when its source's budget is used up for longer
+// than a request may queue; the source never saw it, but to callers it's as good as being rate limited
+var ErrBudgetExhausted = errors.New("outbound request budget used up")
+
+// BudgetError is ErrBudgetExhausted for one source, with when its next request could go out
+type BudgetError struct {
+	Source     string
+	RetryAfter time.Duration
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s request budget used up, next request in %s", e.Source, e.RetryAfter)
+}
+
+// Unwrap makes the error both ErrBudgetExhausted and ErrRateLimited
+func (e *BudgetError) Unwrap() []error {
+	return []error{ErrBudgetExhausted, ErrRateLimited}
+}
+
+// RateLimiter is a token bucket for the requests sent to one upstream host: rate requests a second, in
+// bursts of up to two seconds' worth. A request with no token left queues for its turn, up to maxWait,
+// and is turned away if it would wait longer; it is safe for concurrent use
+type RateLimiter struct {
+	source  string
+	rate    float64 // 0 means unlimited
+	burst   float64
+	maxWait time.Duration
+
+	mu       sync.Mutex
+	tokens   float64 // Below 0 when requests are queued for tokens not yet refilled
+	last     time.Time
+	waiting  int
+	rejected int64
+}
+
+// RateLimiterStatus is a limiter's state, for the health endpoint
+type RateLimiterStatus struct {
+	Source    string  `json:"source"`
+	Rate      float64 `json:"rate"`      // Requests per second, 0 for unlimited
+	Available int     `json:"available"` // Requests that could go out straight away
+	Waiting   int     `json:"waiting"`   // Requests queued for their turn
+	Rejected  int64   `json:"rejected"`  // Requests turned away since the server started
+}
+
+// newRateLimiter returns a full bucket for source
+func newRateLimiter(source string, rate float64, maxWait time.Duration) *RateLimiter {
+	burst := max(math.Ceil(rate*2), 1)
+	return &RateLimiter{source: source, rate: rate, burst: burst, maxWait: maxWait, tokens: burst, last: time.Now()}
+}
+
+// Wait takes a token, waiting for one if none is left, or fails with a *BudgetError if the wait would be longer than maxWait
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	if wait > l.maxWait {
+		l.tokens++
+		l.rejected++
+		l.mu.Unlock()
+		// Rounded up to whole seconds, as Retry-After has them
+		return &BudgetError{Source: l.source, RetryAfter: (wait + time.Second - 1).Truncate(time.Second)}
+	}
+	if wait <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.waiting++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		// The token goes back for the requests queued behind
+		l.mu.Lock()
+		l.waiting--
+		l.tokens++
+		l.mu.Unlock()
+		return ctsearch.RequestError(ctx.Err())
+	}
+}
+
+// Status returns the limiter's state
+func (l *RateLimiter) Status(now time.Time) RateLimiterStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	available := 0
+	if l.rate > 0 {
+		available = int(max(min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst), 0))
+	}
+	return RateLimiterStatus{Source: l.source, Rate: l.rate, Available: available, Waiting: l.waiting, Rejected: l.rejected}
+}
+
+// rateLimits holds the limiters in use: one each for crt.sh and the CT log list, and one per OCSP host
+var rateLimits = struct {
+	sync.Mutex
+	rates   map[string]float64
+	maxWait time.Duration
+	crtsh   *RateLimiter
+	ctLogs  *RateLimiter
+	hosts   map[string]*RateLimiter // OCSP responders and issuer certificate hosts
+}{
+	rates:   defaultRateLimits,
+	maxWait: maxRetryWait,
+	crtsh:   newRateLimiter("crt.sh", defaultRateLimits[SourceCrtsh], maxRetryWait),
+	ctLogs:  newRateLimiter("CT log list", defaultRateLimits[SourceCTLogs], maxRetryWait),
+	hosts:   make(map[string]*RateLimiter),
+}
+
+// SetRateLimits replaces the outbound limits: requests per second by source (missing sources keep their
+// defaults, 0 is unlimited) and how long a request may queue for its turn
+func SetRateLimits(rates map[string]float64, maxWait time.Duration) {
+	merged := make(map[string]float64, len(defaultRateLimits))
+	for source, rate := range defaultRateLimits {
+		merged[source] = rate
+	}
+	for source, rate := range rates {
+		merged[source] = rate
+	}
+
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+	rateLimits.rates = merged
+	rateLimits.maxWait = maxWait
+	rateLimits.crtsh = newRateLimiter("crt.sh", merged[SourceCrtsh], maxWait)
+	rateLimits.ctLogs = newRateLimiter("CT log list", merged[SourceCTLogs], maxWait)
+	rateLimits.hosts = make(map[string]*RateLimiter)
+}
+
+// ParseRateLimits reads -rate-limits, comma-separated source=requests per second, e.g. "crtsh=2,ocsp=10"
+func ParseRateLimits(value string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		source, rate, ok := strings.Cut(part, "=")
+		source = strings.ToLower(strings.TrimSpace(source))
+		if _, known := defaultRateLimits[source]; !ok || !known {
+			return nil, fmt.Errorf("invalid rate limit %q, use source=requests per second with source %s, %s or %s", part, SourceCrtsh, SourceCTLogs, SourceOCSP)
+		}
+		perSecond, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || !validRate(perSecond) {
+			return nil, fmt.Errorf("invalid rate limit %q, the rate must be a number of requests per second", part)
+		}
+		rates[source] = perSecond
+	}
+	return rates, nil
+}
+
+// RateLimitFile is the -rate-limits-file format, e.g. {"limits": {"crtsh": 2, "ocsp": 10}, "wait": "5s"}
+type RateLimitFile struct {
+	Limits map[string]float64 `json:"limits"` // Requests per second by source, as in -rate-limits
+	Wait   string             `json:"wait"`   // How long a request may queue, as in -rate-limit-wait; empty keeps it
+}
+
+// LoadRateLimits reads a rate limit file, returning its limits by source and its wait (0 when it sets none)
+func LoadRateLimits(path string) (map[string]float64, time.Duration, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read rate limits: %w", err)
+	}
+	var file RateLimitFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse rate limits: %w", err)
+	}
+
+	rates := make(map[string]float64, len(file.Limits))
+	for source, perSecond := range file.Limits {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if _, known := defaultRateLimits[source]; !known {
+			return nil, 0, fmt.Errorf("unknown rate limit source %q, use %s, %s or %s", source, SourceCrtsh, SourceCTLogs, SourceOCSP)
+		}
+		if !validRate(perSecond) {
+			return nil, 0, fmt.Errorf("invalid rate limit for %s, the rate must be a number of requests per second", source)
+		}
+		rates[source] = perSecond
+	}
+	var wait time.Duration
+	if file.Wait != "" {
+		if wait, err = time.ParseDuration(file.Wait); err != nil || wait <= 0 {
+			return nil, 0, fmt.Errorf("invalid rate limit wait %q, use a duration like 10s", file.Wait)
+		}
+	}
+	return rates, wait, nil
+}
+
+// validRate reports whether a requests-per-second rate is usable; 0 is unlimited
+func validRate(perSecond float64) bool {
+	return perSecond >= 0 && !math.IsInf(perSecond, 0) && !math.IsNaN(perSecond)
+}
+
+// RateLimiters returns the state of every outbound limiter, OCSP hosts by name
+func RateLimiters(now time.Time) []RateLimiterStatus {
+	rateLimits.Lock()
+	limiters := []*RateLimiter{rateLimits.crtsh, rateLimits.ctLogs}
+	hosts := make([]*RateLimiter, 0, len(rateLimits.hosts))
+	for _, limiter := range rateLimits.hosts {
+		hosts = append(hosts, limiter)
+	}
+	rateLimits.Unlock()
+
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].source < hosts[j].source
+	})
+	statuses := make([]RateLimiterStatus, 0, len(limiters)+len(hosts))
+	for _, limiter := range append(limiters, hosts...) {
+		statuses = append(statuses, limiter.Status(now))
+	}
+	return statuses
+}
+
+// waitForCrtsh takes a turn in crt.sh's budget
+func waitForCrtsh(ctx context.Context) error {
+	rateLimits.Lock()
+	limiter := rateLimits.crtsh
+	rateLimits.Unlock()
+	return limiter.Wait(ctx)
+}
+
+// waitForCTLogs takes a turn in the CT log list's budget
+func waitForCTLogs(ctx context.Context) error {
+	rateLimits.Lock()
+	limiter := rateLimits.ctLogs
+	rateLimits.Unlock()
+	return limiter.Wait(ctx)
+}
+
+// waitForHost takes a turn in the budget of the OCSP responder or issuer certificate host rawURL points at
+func waitForHost(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	rateLimits.Lock()
+	limiter, ok := rateLimits.hosts[host]
+	if !ok {
+		// Hosts come from certificates, so there's no telling how many there will be
+		if len(rateLimits.hosts) >= maxLimitedHosts {
+			clear(rateLimits.hosts)
+		}
+		limiter = newRateLimiter(host, rateLimits.rates[SourceOCSP], rateLimits.maxWait)
+		rateLimits.hosts[host] = limiter
+	}
+	rateLimits.Unlock()
+	return limiter.Wait(ctx)
+}
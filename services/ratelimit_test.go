@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		tokens    float64
+		maxWait   time.Duration
+		wantErr   bool
+		wantRetry time.Duration
+	}{
+		{name: "unlimited", rate: 0, tokens: 0, maxWait: 0},
+		{name: "token left", rate: 1, tokens: 1, maxWait: 0},
+		{name: "empty bucket, no queueing", rate: 1, tokens: 0, maxWait: 0, wantErr: true, wantRetry: time.Second},
+		{name: "empty bucket, short queue", rate: 50, tokens: 0, maxWait: time.Second},
+		{name: "queue too long", rate: 1, tokens: -2, maxWait: time.Second, wantErr: true, wantRetry: 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter("test", tt.rate, tt.maxWait)
+			limiter.tokens = tt.tokens
+			limiter.last = time.Now()
+
+			err := limiter.Wait(context.Background())
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Wait() = %v, want nil", err)
+				}
+				return
+			}
+			var budgetErr *BudgetError
+			if !errors.As(err, &budgetErr) {
+				t.Fatalf("Wait() = %v, want a *BudgetError", err)
+			}
+			if !errors.Is(err, ErrBudgetExhausted) || !errors.Is(err, ErrRateLimited) {
+				t.Errorf("Wait() = %v, want it to be ErrBudgetExhausted and ErrRateLimited", err)
+			}
+			if budgetErr.RetryAfter != tt.wantRetry {
+				t.Errorf("RetryAfter = %s, want %s", budgetErr.RetryAfter, tt.wantRetry)
+			}
+			if limiter.rejected != 1 {
+				t.Errorf("rejected = %d, want 1", limiter.rejected)
+			}
+			if limiter.tokens < tt.tokens {
+				t.Errorf("tokens = %v after a rejection, want the token handed back (at least %v)", limiter.tokens, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter := newRateLimiter("test", 1, 0)
+	if limiter.burst != 2 {
+		t.Fatalf("burst = %v, want 2", limiter.burst)
+	}
+	for i := 0; i < 2; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait() %d = %v, want nil", i+1, err)
+		}
+	}
+	if err := limiter.Wait(context.Background()); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("Wait() past the burst = %v, want ErrBudgetExhausted", err)
+	}
+}
+
+func TestRateLimiterCancelReturnsToken(t *testing.T) {
+	limiter := newRateLimiter("test", 1, time.Minute)
+	limiter.tokens = 0
+	limiter.last = time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() = %v, want context.Canceled", err)
+	}
+	if limiter.waiting != 0 {
+		t.Errorf("waiting = %d, want 0", limiter.waiting)
+	}
+	if limiter.tokens < 0 {
+		t.Errorf("tokens = %v, want the cancelled request's token back", limiter.tokens)
+	}
+}
+
+func TestRateLimiterStatus(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		rate   float64
+		tokens float64
+		since  time.Duration
+		want   int
+	}{
+		{name: "unlimited", rate: 0, tokens: 5, want: 0},
+		{name: "full", rate: 2, tokens: 4, want: 4},
+		{name: "queued", rate: 2, tokens: -3, want: 0},
+		{name: "refilled", rate: 2, tokens: 0, since: time.Second, want: 2},
+		{name: "refill capped at burst", rate: 2, tokens: 0, since: time.Hour, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter("test", tt.rate, 0)
+			limiter.tokens = tt.tokens
+			limiter.last = now.Add(-tt.since)
+			if got := limiter.Status(now).Available; got != tt.want {
+				t.Errorf("Available = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{value: "", want: map[string]float64{}},
+		{value: "crtsh=2, OCSP=10", want: map[string]float64{SourceCrtsh: 2, SourceOCSP: 10}},
+		{value: "ctlogs=0", want: map[string]float64{SourceCTLogs: 0}},
+		{value: "crtsh", wantErr: true},
+		{value: "other=1", wantErr: true},
+		{value: "crtsh=-1", wantErr: true},
+		{value: "crtsh=NaN", wantErr: true},
+		{value: "crtsh=Inf", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRateLimits(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRateLimits(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseRateLimits(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			for source, rate := range tt.want {
+				if got[source] != rate {
+					t.Errorf("ParseRateLimits(%q)[%s] = %v, want %v", tt.value, source, got[source], rate)
+				}
+			}
+		})
+	}
+}
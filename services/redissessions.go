@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds each call to Redis so a stuck server can't hang every request
+const redisTimeout = 3 * time.Second
+
+// RedisSessionStore keeps sessions in Redis, so they survive restarts and are shared between servers
+// Each session is a JSON value that Redis expires at its idle or absolute timeout, whichever comes first,
+// and each user has a set of their session IDs for listing and logging out everywhere
+type RedisSessionStore struct {
+	client   *redis.Client
+	prefix   string
+	timeouts SessionTimeouts
+}
+
+// NewRedisSessionStore connects to Redis at a redis:// or rediss:// URL and checks it answers
+func NewRedisSessionStore(redisURL string, timeouts SessionTimeouts) (*RedisSessionStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	s := &RedisSessionStore{
+		client:   redis.NewClient(options),
+		prefix:   "certificate-viewer:",
+		timeouts: timeouts,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return s, nil
+}
+
+// Create starts a session for username with a random token
+func (s *RedisSessionStore) Create(username, userAgent, remoteAddr string) (Session, error) {
+	session, err := newSession(username, userAgent, remoteAddr, s.timeouts)
+	if err != nil {
+		return Session{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := s.put(ctx, session); err != nil {
+		return Session{}, err
+	}
+	if err := s.client.SAdd(ctx, s.userKey(username), session.ID).Err(); err != nil {
+		return Session{}, fmt.Errorf("failed to save session: %w", err)
+	}
+	// The newest session always outlives the older ones, so the set can expire with it
+	s.client.Expire(ctx, s.userKey(username), s.timeouts.Absolute)
+
+	return session, nil
+}
+
+// Get returns the session for a token, unless it doesn't exist or has timed out
+func (s *RedisSessionStore) Get(token string) (Session, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	session, ok, err := s.load(ctx, sessionID(token))
+	if !ok || err != nil {
+		return Session{}, ok, err
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeen) > sessionTouchInterval {
+		session.LastSeen = now
+		if err := s.put(ctx, session); err != nil {
+			return Session{}, false, err
+		}
+	}
+	return session, true, nil
+}
+
+// Delete ends a session
+func (s *RedisSessionStore) Delete(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	id := sessionID(token)
+	session, ok, err := s.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	return s.remove(ctx, session.Username, id)
+}
+
+// DeleteID ends one of username's sessions by ID
+func (s *RedisSessionStore) DeleteID(username, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	session, ok, err := s.load(ctx, id)
+	if err != nil || !ok || session.Username != username {
+		return false, err
+	}
+	return true, s.remove(ctx, username, id)
+}
+
+// DeleteUser ends every session for username
+func (s *RedisSessionStore) DeleteUser(username string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ids, err := s.client.SMembers(ctx, s.userKey(username)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	keys := []string{s.userKey(username)}
+	for _, id := range ids {
+		keys = append(keys, s.sessionKey(id))
+	}
+	if err := s.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	return nil
+}
+
+// List returns username's live sessions, forgetting ones Redis has expired
+func (s *RedisSessionStore) List(username string) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ids, err := s.client.SMembers(ctx, s.userKey(username)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	list := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		session, ok, err := s.load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			s.client.SRem(ctx, s.userKey(username), id)
+			continue
+		}
+		list = append(list, session)
+	}
+	sortSessions(list)
+	return list, nil
+}
+
+// load reads a session by ID, reporting false if Redis has expired it or it has timed out
+func (s *RedisSessionStore) load(ctx context.Context, id string) (Session, bool, error) {
+	content, err := s.client.Get(ctx, s.sessionKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(content, &session); err != nil {
+		return Session{}, false, fmt.Errorf("failed to parse session: %w", err)
+	}
+	session.ID = id
+	if session.expired(time.Now(), s.timeouts) {
+		return Session{}, false, nil
+	}
+	return session, true, nil
+}
+
+// put writes a session, expiring it at whichever timeout comes first
+func (s *RedisSessionStore) put(ctx context.Context, session Session) error {
+	ttl := time.Until(session.ExpiresAt)
+	if s.timeouts.Idle > 0 && s.timeouts.Idle < ttl {
+		ttl = s.timeouts.Idle
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	session.Token = ""
+	content, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := s.client.Set(ctx, s.sessionKey(session.ID), content, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a session and drops it from its user's set
+func (s *RedisSessionStore) remove(ctx context.Context, username, id string) error {
+	if err := s.client.Del(ctx, s.sessionKey(id)).Err(); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	if err := s.client.SRem(ctx, s.userKey(username), id).Err(); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	return nil
+}
+
+// sessionKey is the Redis key holding a session
+func (s *RedisSessionStore) sessionKey(id string) string {
+	return s.prefix + "session:" + id
+}
+
+// userKey is the Redis key holding a user's session IDs
+func (s *RedisSessionStore) userKey(username string) string {
+	return s.prefix + "user-sessions:" + username
+}
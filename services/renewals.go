@@ -0,0 +1,193 @@
+package services
+
+import (
+	"sort"
+	"time"
+)
+
+// lastMinuteDays is how close to expiry a renewal has to be to count as last-minute
+const lastMinuteDays = 3
+
+// Most certificates a hostname normally has valid at once: an RSA and an ECDSA certificate, each
+// overlapping its renewal, from a primary and a backup CA. More usually means two pieces of automation
+// issuing for the same name, or someone else issuing for it
+const (
+	maxNormalConcurrent        = 4
+	maxNormalConcurrentIssuers = 2
+)
+
+// RenewalAnalysis looks at how each hostname's certificates follow on from each other
+type RenewalAnalysis struct {
+	Domain          string         `json:"domain"`
+	AtRisk          int            `json:"atRisk"`          // Hosts with coverage gaps or repeated last-minute renewals
+	UnusualOverlaps int            `json:"unusualOverlaps"` // Hosts with more certificates valid at once than normal
+	Hosts           []HostRenewals `json:"hosts"`
+}
+
+// HostRenewals is the renewal history of one hostname
+type HostRenewals struct {
+	Name               string        `json:"name"`
+	Certificates       int           `json:"certificates"`
+	MedianIntervalDays int           `json:"medianIntervalDays"` // Typical days between successive issuances
+	LastMinuteRenewals int           `json:"lastMinuteRenewals"` // Renewals issued within lastMinuteDays of the previous expiry
+	Renewals           []Renewal     `json:"renewals"`
+	Gaps               []CoverageGap `json:"gaps"`
+	AtRisk             bool          `json:"atRisk"`
+	Concurrency        Concurrency   `json:"concurrency"`
+}
+
+// Concurrency is the most certificates a hostname had valid at the same moment
+type Concurrency struct {
+	Peak    int       `json:"peak"`
+	At      time.Time `json:"at"`      // When the peak was first reached
+	Issuers int       `json:"issuers"` // Distinct issuers among the certificates valid then
+	Serials []string  `json:"serials"` // Serial numbers of those certificates
+	Unusual bool      `json:"unusual"` // More certificates or issuers than maxNormalConcurrent(Issuers)
+}
+
+// Renewal is one certificate replacing the previous one
+type Renewal struct {
+	IssuedAt     time.Time `json:"issuedAt"`
+	IntervalDays int       `json:"intervalDays"` // Days since the previous certificate was issued
+	LeadDays     int       `json:"leadDays"`     // Days before the previous certificate expired (negative = after)
+}
+
+// CoverageGap is a window in which no certificate for the hostname was valid
+type CoverageGap struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Days  int       `json:"days"`
+}
+
+// AnalyzeRenewals computes renewal intervals and coverage gaps for every hostname under domain
+func AnalyzeRenewals(domain string, groups []CertificateGroup) RenewalAnalysis {
+	base := BaseDomain(domain)
+	analysis := RenewalAnalysis{
+		Domain: base,
+		Hosts:  make([]HostRenewals, 0),
+	}
+
+	// Map to collect each hostname's certificates
+	hostCerts := make(map[string][]CertificateGroup)
+	for _, group := range groups {
+		if group.NotBeforeTime.IsZero() || group.NotAfterTime.IsZero() {
+			continue
+		}
+		for _, name := range GroupNames(group) {
+			if inDomain(name, base) {
+				hostCerts[name] = append(hostCerts[name], group)
+			}
+		}
+	}
+
+	for name, certs := range hostCerts {
+		host := analyzeHostRenewals(name, certs)
+		if host.AtRisk {
+			analysis.AtRisk++
+		}
+		if host.Concurrency.Unusual {
+			analysis.UnusualOverlaps++
+		}
+		analysis.Hosts = append(analysis.Hosts, host)
+	}
+
+	// At-risk hosts first, then unusual overlaps, then alphabetical
+	sort.Slice(analysis.Hosts, func(i, j int) bool {
+		a, b := analysis.Hosts[i], analysis.Hosts[j]
+		if a.AtRisk != b.AtRisk {
+			return a.AtRisk
+		}
+		if a.Concurrency.Unusual != b.Concurrency.Unusual {
+			return a.Concurrency.Unusual
+		}
+		return a.Name < b.Name
+	})
+
+	return analysis
+}
+
+// analyzeHostRenewals walks one hostname's certificates in issuance order
+func analyzeHostRenewals(name string, certs []CertificateGroup) HostRenewals {
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].NotBeforeTime.Before(certs[j].NotBeforeTime)
+	})
+
+	host := HostRenewals{
+		Name:         name,
+		Certificates: len(certs),
+		Renewals:     make([]Renewal, 0),
+		Gaps:         make([]CoverageGap, 0),
+	}
+
+	intervals := make([]int, 0)
+	coveredUntil := certs[0].NotAfterTime
+
+	for i := 1; i < len(certs); i++ {
+		previous, current := certs[i-1], certs[i]
+
+		renewal := Renewal{
+			IssuedAt:     current.NotBeforeTime,
+			IntervalDays: daysBetween(previous.NotBeforeTime, current.NotBeforeTime),
+			LeadDays:     daysBetween(current.NotBeforeTime, previous.NotAfterTime),
+		}
+		host.Renewals = append(host.Renewals, renewal)
+		intervals = append(intervals, renewal.IntervalDays)
+
+		if current.NotBeforeTime.Before(previous.NotAfterTime) && renewal.LeadDays < lastMinuteDays {
+			host.LastMinuteRenewals++
+		}
+
+		// A gap is time after every earlier certificate expired and before this one started
+		if current.NotBeforeTime.After(coveredUntil) {
+			host.Gaps = append(host.Gaps, CoverageGap{
+				Start: coveredUntil,
+				End:   current.NotBeforeTime,
+				Days:  daysBetween(coveredUntil, current.NotBeforeTime),
+			})
+		}
+		if current.NotAfterTime.After(coveredUntil) {
+			coveredUntil = current.NotAfterTime
+		}
+	}
+
+	if len(intervals) > 0 {
+		sort.Ints(intervals)
+		host.MedianIntervalDays = intervals[len(intervals)/2]
+	}
+	host.AtRisk = len(host.Gaps) > 0 || host.LastMinuteRenewals >= 2
+	host.Concurrency = peakConcurrency(certs)
+
+	return host
+}
+
+// peakConcurrency finds the moment most of a hostname's certificates were valid at once
+// The count only goes up when a certificate starts, so the peak is at one of the start times
+func peakConcurrency(certs []CertificateGroup) Concurrency {
+	peak := Concurrency{Serials: make([]string, 0)}
+	for _, start := range certs {
+		valid := make([]CertificateGroup, 0)
+		for _, cert := range certs {
+			if !cert.NotBeforeTime.After(start.NotBeforeTime) && cert.NotAfterTime.After(start.NotBeforeTime) {
+				valid = append(valid, cert)
+			}
+		}
+		if len(valid) <= peak.Peak {
+			continue
+		}
+
+		issuers := make(map[string]bool)
+		peak = Concurrency{Peak: len(valid), At: start.NotBeforeTime, Serials: make([]string, 0, len(valid))}
+		for _, cert := range valid {
+			issuers[cert.IssuerName] = true
+			peak.Serials = append(peak.Serials, cert.SerialNumber)
+		}
+		peak.Issuers = len(issuers)
+	}
+	peak.Unusual = peak.Peak > maxNormalConcurrent || peak.Issuers > maxNormalConcurrentIssuers
+	return peak
+}
+
+// daysBetween returns the whole days from a to b (negative if b is before a)
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Where RepoIssues files issues
+const (
+	IssuesGitHub = "github"
+	IssuesGitLab = "gitlab"
+)
+
+const (
+	// repoIssuesTimeout bounds one request to GitHub or GitLab
+	repoIssuesTimeout = 15 * time.Second
+
+	// repoIssuesLabel is put on every issue filed, and is how open ones are found again
+	repoIssuesLabel = "certviewer"
+
+	// repoIssuesPages caps how many pages of open issues are read, at 100 a page
+	repoIssuesPages = 10
+)
+
+// repoIssuesProviders are the providers' names for messages
+var repoIssuesProviders = map[string]string{IssuesGitHub: "GitHub", IssuesGitLab: "GitLab"}
+
+// repoIssueMarker is hidden in an issue's body to say which domain and hostname it's about
+var repoIssueMarker = regexp.MustCompile(`<!-- certviewer: (\S+) (\S+) -->`)
+
+// RepoIssuesConfig is the repository issues are filed in for watched domains' expiring certificates
+type RepoIssuesConfig struct {
+	Provider       string // IssuesGitHub or IssuesGitLab
+	Repository     string // owner/repo on GitHub, group/project (or its numeric ID) on GitLab
+	APIURL         string // e.g. https://github.example.com/api/v3; empty uses github.com's or gitlab.com's
+	Token          string
+	Labels         []string // Put on new issues besides certviewer
+	ExpiringWithin time.Duration
+}
+
+// RepoIssues files an issue when a watched domain's hostname's newest certificate enters the expiry window,
+// and closes it once CT shows a renewal that takes the hostname out of it
+type RepoIssues struct {
+	mu     sync.Mutex // Guards config.Token, which SetToken replaces
+	config RepoIssuesConfig
+	client *http.Client
+}
+
+// RepoIssue is an issue RepoIssues filed
+type RepoIssue struct {
+	Number   int    // The issue's number on GitHub, IID on GitLab
+	URL      string // Its web page
+	Domain   string
+	Hostname string
+	body     string
+}
+
+// NewRepoIssues returns a RepoIssues filing issues as config says
+func NewRepoIssues(config RepoIssuesConfig) (*RepoIssues, error) {
+	switch config.Provider {
+	case IssuesGitHub:
+		if config.APIURL == "" {
+			config.APIURL = "https://api.github.com"
+		}
+		if strings.Count(config.Repository, "/") != 1 {
+			return nil, fmt.Errorf("invalid GitHub repository %q, use owner/repo", config.Repository)
+		}
+	case IssuesGitLab:
+		if config.APIURL == "" {
+			config.APIURL = "https://gitlab.com/api/v4"
+		}
+		if config.Repository == "" {
+			return nil, errors.New("a GitLab project is needed, e.g. group/project")
+		}
+	default:
+		return nil, fmt.Errorf("invalid issue provider %q, use %s or %s", config.Provider, IssuesGitHub, IssuesGitLab)
+	}
+	parsed, err := url.Parse(config.APIURL)
+	if err != nil || parsed.Host == "" || parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return nil, fmt.Errorf("invalid API URL %q", config.APIURL)
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("a %s token is needed", repoIssuesProviders[config.Provider])
+	}
+	config.APIURL = strings.TrimRight(config.APIURL, "/")
+	return &RepoIssues{config: config, client: &http.Client{Timeout: repoIssuesTimeout}}, nil
+}
+
+// SetToken replaces the API token, e.g. when the settings are reloaded; requests under way keep the old one
+func (r *RepoIssues) SetToken(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config.Token = token
+}
+
+// Sync brings a domain's issues in line with a check of it: hostnames whose newest TLS certificate expires
+// within the window get an issue unless one is open, and open issues for hostnames whose newest now expires
+// after it are closed with a comment on the renewal. A hostname left with no valid certificate keeps its issue
+// baseURL, this server's address, links issues to the hostname's certificates; empty leaves the link out
+func (r *RepoIssues) Sync(ctx context.Context, domain string, groups []CertificateGroup, baseURL string, now time.Time) (opened, closed []RepoIssue, err error) {
+	newest := newestByHostname(FilterByPurpose(groups, PurposeFilterTLS), now)
+	open, err := r.listOpen(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	filed := make(map[string]RepoIssue)
+	for _, issue := range open {
+		if issue.Domain == domain {
+			filed[issue.Hostname] = issue
+		}
+	}
+
+	hostnames := make([]string, 0, len(newest))
+	for name := range newest {
+		hostnames = append(hostnames, name)
+	}
+	sort.Strings(hostnames)
+
+	for _, name := range hostnames {
+		group := newest[name]
+		expiring := group.NotAfterTime.Sub(now) < r.config.ExpiringWithin
+		issue, isFiled := filed[name]
+		switch {
+		case expiring && !isFiled:
+			issue, err := r.create(ctx, repoIssueTitle(name, group), repoIssueBody(domain, name, group, baseURL, now))
+			if err != nil {
+				return opened, closed, err
+			}
+			opened = append(opened, issue)
+		case !expiring && isFiled:
+			comment := fmt.Sprintf("Renewed: CT shows a new certificate for %s (serial %s, issued by %s), valid until %s. Closing.",
+				name, group.SerialNumber, ctsearch.IssuerDisplayName(group.IssuerName), group.NotAfterTime.Format("2006-01-02"))
+			if err := r.close(ctx, issue, comment); err != nil {
+				return opened, closed, err
+			}
+			closed = append(closed, issue)
+		}
+	}
+	return opened, closed, nil
+}
+
+// repoIssueTitle is the title of a hostname's issue
+func repoIssueTitle(hostname string, group CertificateGroup) string {
+	return fmt.Sprintf("Certificate for %s expires on %s", hostname, group.NotAfterTime.Format("2006-01-02"))
+}
+
+// repoIssueBody is the Markdown body of a hostname's issue, ending with the marker that finds it again
+func repoIssueBody(domain, hostname string, group CertificateGroup, baseURL string, now time.Time) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "The newest certificate for `%s`, on the watched domain `%s`, expires in %d day(s).\n\n", hostname, domain, daysBetween(now, group.NotAfterTime))
+	fmt.Fprintf(&body, "| | |\n|---|---|\n")
+	fmt.Fprintf(&body, "| Common name | `%s` |\n", group.CommonName)
+	fmt.Fprintf(&body, "| Serial number | `%s` |\n", group.SerialNumber)
+	fmt.Fprintf(&body, "| Issuer | %s |\n", ctsearch.IssuerDisplayName(group.IssuerName))
+	fmt.Fprintf(&body, "| Not after | %s |\n", group.NotAfterTime.UTC().Format("2006-01-02 15:04 UTC"))
+	if baseURL != "" {
+		fmt.Fprintf(&body, "\n[View certificates for %s](%s/search?domain=%s)\n", hostname, strings.TrimSuffix(baseURL, "/"), url.QueryEscape(hostname))
+	}
+	fmt.Fprintf(&body, "\nThis issue closes itself once a renewed certificate shows up in CT.\n\n<!-- certviewer: %s %s -->\n", domain, hostname)
+	return body.String()
+}
+
+// listOpen returns the open issues RepoIssues filed, as far as their markers say
+func (r *RepoIssues) listOpen(ctx context.Context) ([]RepoIssue, error) {
+	issues := make([]RepoIssue, 0)
+	for page := 1; page <= repoIssuesPages; page++ {
+		query := url.Values{"labels": {repoIssuesLabel}, "per_page": {"100"}, "page": {strconv.Itoa(page)}}
+		var batch []RepoIssue
+		var err error
+		if r.config.Provider == IssuesGitHub {
+			query.Set("state", "open")
+			var answer []struct {
+				Number  int    `json:"number"`
+				HTMLURL string `json:"html_url"`
+				Body    string `json:"body"`
+			}
+			err = r.call(ctx, http.MethodGet, "/issues?"+query.Encode(), nil, &answer)
+			for _, issue := range answer {
+				batch = append(batch, RepoIssue{Number: issue.Number, URL: issue.HTMLURL, body: issue.Body})
+			}
+		} else {
+			query.Set("state", "opened")
+			var answer []struct {
+				IID         int    `json:"iid"`
+				WebURL      string `json:"web_url"`
+				Description string `json:"description"`
+			}
+			err = r.call(ctx, http.MethodGet, "/issues?"+query.Encode(), nil, &answer)
+			for _, issue := range answer {
+				batch = append(batch, RepoIssue{Number: issue.IID, URL: issue.WebURL, body: issue.Description})
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range batch {
+			if match := repoIssueMarker.FindStringSubmatch(issue.body); match != nil {
+				issue.Domain, issue.Hostname = match[1], match[2]
+				issues = append(issues, issue)
+			}
+		}
+		if len(batch) < 100 {
+			break
+		}
+	}
+	return issues, nil
+}
+
+// create files an issue
+func (r *RepoIssues) create(ctx context.Context, title, body string) (RepoIssue, error) {
+	labels := append([]string{repoIssuesLabel}, r.config.Labels...)
+	issue := RepoIssue{body: body}
+	if r.config.Provider == IssuesGitHub {
+		var answer struct {
+			Number  int    `json:"number"`
+			HTMLURL string `json:"html_url"`
+		}
+		if err := r.call(ctx, http.MethodPost, "/issues", map[string]any{"title": title, "body": body, "labels": labels}, &answer); err != nil {
+			return RepoIssue{}, err
+		}
+		issue.Number, issue.URL = answer.Number, answer.HTMLURL
+	} else {
+		var answer struct {
+			IID    int    `json:"iid"`
+			WebURL string `json:"web_url"`
+		}
+		if err := r.call(ctx, http.MethodPost, "/issues", map[string]any{"title": title, "description": body, "labels": strings.Join(labels, ",")}, &answer); err != nil {
+			return RepoIssue{}, err
+		}
+		issue.Number, issue.URL = answer.IID, answer.WebURL
+	}
+	match := repoIssueMarker.FindStringSubmatch(body)
+	issue.Domain, issue.Hostname = match[1], match[2]
+	return issue, nil
+}
+
+// close comments on an issue and closes it
+func (r *RepoIssues) close(ctx context.Context, issue RepoIssue, comment string) error {
+	path := "/issues/" + strconv.Itoa(issue.Number)
+	if r.config.Provider == IssuesGitHub {
+		if err := r.call(ctx, http.MethodPost, path+"/comments", map[string]string{"body": comment}, nil); err != nil {
+			return err
+		}
+		return r.call(ctx, http.MethodPatch, path, map[string]string{"state": "closed", "state_reason": "completed"}, nil)
+	}
+	if err := r.call(ctx, http.MethodPost, path+"/notes", map[string]string{"body": comment}, nil); err != nil {
+		return err
+	}
+	return r.call(ctx, http.MethodPut, path, map[string]string{"state_event": "close"}, nil)
+}
+
+// call makes a request to the repository's part of the API, decoding the answer into result unless it's nil
+func (r *RepoIssues) call(ctx context.Context, method, path string, body, result any) error {
+	provider := repoIssuesProviders[r.config.Provider]
+	base := r.config.APIURL + "/repos/" + r.config.Repository
+	if r.config.Provider == IssuesGitLab {
+		base = r.config.APIURL + "/projects/" + url.PathEscape(r.config.Repository)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", provider, err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, reader)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	token := r.config.Token
+	r.mu.Unlock()
+	if r.config.Provider == IssuesGitHub {
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	} else {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Both say why, GitHub as {"message": ...} and GitLab as {"message": ...} or {"error": ...}
+		var answer struct {
+			Message any    `json:"message"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&answer)
+		reason := answer.Error
+		if answer.Message != nil {
+			reason = fmt.Sprint(answer.Message)
+		}
+		if reason != "" {
+			return fmt.Errorf("%s returned status %d: %s", provider, resp.StatusCode, reason)
+		}
+		return fmt.Errorf("%s returned status %d", provider, resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse %s's answer: %w", provider, err)
+	}
+	return nil
+}
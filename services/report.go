@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+const (
+	// expiringSoonDays is how far ahead the report looks for expiring certificates
+	expiringSoonDays = 30
+
+	// maxReportDetails caps how many active certificates are downloaded for inspection
+	maxReportDetails = 25
+)
+
+// DomainReport is a complete assessment of a domain's certificates, suitable for auditors
+type DomainReport struct {
+	Domain             string              `json:"domain"`
+	GeneratedAt        time.Time           `json:"generatedAt"`
+	TotalCertificates  int                 `json:"totalCertificates"`
+	ActiveCertificates int                 `json:"activeCertificates"`
+	Findings           []Finding           `json:"findings"`
+	Expiring           []CertificateGroup  `json:"expiring"` // Active certificates expiring within expiringSoonDays
+	Issuers            IssuerDistribution  `json:"issuers"`
+	Lifetimes          LifetimeHistogram   `json:"lifetimes"`
+	Certificates       []ReportCertificate `json:"certificates"` // Active certificates inspected in detail
+	NotInspected       int                 `json:"notInspected"` // Active certificates over maxReportDetails
+	Renewals           RenewalAnalysis     `json:"renewals"`
+	Inventory          SubdomainInventory  `json:"inventory"`
+}
+
+// ReportCertificate is an active certificate with the details parsed from the certificate itself
+type ReportCertificate struct {
+	ID           int64             `json:"id"`
+	CommonName   string            `json:"commonName"`
+	SerialNumber string            `json:"serialNumber"`
+	Issuer       string            `json:"issuer"`
+	NotBefore    time.Time         `json:"notBefore"`
+	NotAfter     time.Time         `json:"notAfter"`
+	RequiredSCTs int               `json:"requiredSCTs"`
+	Info         *CertificateInfo  `json:"info,omitempty"`
+	CTPolicy     *CTCompliance     `json:"ctPolicy,omitempty"` // Missing when it doesn't apply or the log list couldn't be had
+	Revocation   *RevocationStatus `json:"revocation,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// BuildDomainReport assembles the full assessment for a domain
+// It downloads up to maxReportDetails active certificates from crt.sh to check crypto and CT policy, and asks
+// their CAs whether they were revoked
+func BuildDomainReport(ctx context.Context, domain string, groups []CertificateGroup, now time.Time) DomainReport {
+	report := DomainReport{
+		Domain:            BaseDomain(domain),
+		GeneratedAt:       now.UTC(),
+		TotalCertificates: len(groups),
+		Findings:          make([]Finding, 0),
+		Expiring:          make([]CertificateGroup, 0),
+		Issuers:           IssuerDistributionStats(groups, now),
+		Lifetimes:         LifetimeHistogramStats(groups),
+		Certificates:      make([]ReportCertificate, 0),
+		Renewals:          AnalyzeRenewals(domain, groups),
+		Inventory:         BuildSubdomainInventory(domain, groups, now),
+	}
+
+	// Collect the active certificates, newest first
+	active := make([]CertificateGroup, 0)
+	for _, group := range groups {
+		if isActive(group, now) {
+			active = append(active, group)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].NotBeforeTime.After(active[j].NotBeforeTime)
+	})
+	report.ActiveCertificates = len(active)
+	analyzed := active
+
+	// Expirations
+	soon := now.AddDate(0, 0, expiringSoonDays)
+	for _, group := range active {
+		if group.NotAfterTime.Before(soon) {
+			report.Expiring = append(report.Expiring, group)
+			report.Findings = append(report.Findings, expiryFinding(group, now))
+		}
+	}
+	sort.Slice(report.Expiring, func(i, j int) bool {
+		return report.Expiring[i].NotAfterTime.Before(report.Expiring[j].NotAfterTime)
+	})
+
+	// Renewal risks
+	for _, host := range report.Renewals.Hosts {
+		if host.AtRisk {
+			report.Findings = append(report.Findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "renewal",
+				Subject:  host.Name,
+				Message:  fmt.Sprintf("%d coverage gap(s) and %d last-minute renewal(s)", len(host.Gaps), host.LastMinuteRenewals),
+			})
+		}
+	}
+
+	// Hostnames with more certificates valid at once than renewals explain
+	for _, host := range report.Renewals.Hosts {
+		if host.Concurrency.Unusual {
+			report.Findings = append(report.Findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "concurrent-certificates",
+				Subject:  host.Name,
+				Message: fmt.Sprintf("%d certificates from %d issuer(s) were valid at once on %s, which often means duplicated automation or a compromise",
+					host.Concurrency.Peak, host.Concurrency.Issuers, host.Concurrency.At.Format("2006-01-02")),
+			})
+		}
+	}
+
+	// Certificates lasting far longer or shorter than the rest were probably issued outside the usual process
+	for _, outlier := range report.Lifetimes.Outliers {
+		severity := SeverityInfo
+		if !now.Before(outlier.NotBefore) && now.Before(outlier.NotAfter) {
+			severity = SeverityWarning
+		}
+		report.Findings = append(report.Findings, Finding{
+			Severity: severity,
+			Check:    "lifetime-outlier",
+			Subject:  fmt.Sprintf("%s (serial %s)", outlier.CommonName, outlier.SerialNumber),
+			Message: fmt.Sprintf("valid for %d days from %s, while the domain's certificates typically last %d days, which suggests it bypassed the usual issuance",
+				outlier.LifetimeDays, outlier.Issuer, outlier.TypicalDays),
+		})
+	}
+
+	// Names that only mean something on a private network
+	for _, cert := range FindInternalNames(groups, now) {
+		severity := SeverityInfo
+		if cert.Active {
+			severity = SeverityWarning
+		}
+		names := make([]string, 0, len(cert.Names))
+		for _, name := range cert.Names {
+			names = append(names, fmt.Sprintf("%s: %s", name.Name, name.Reason))
+		}
+		report.Findings = append(report.Findings, Finding{
+			Severity: severity,
+			Check:    "internal-name",
+			Subject:  fmt.Sprintf("%s (serial %s)", cert.CommonName, cert.SerialNumber),
+			Message:  fmt.Sprintf("names internal hosts or addresses, which leaks internal topology; %s", strings.Join(names, "; ")),
+		})
+	}
+
+	// Serial numbers without the randomness CAs must put in them
+	for _, issue := range CheckSerialEntropy(groups) {
+		report.Findings = append(report.Findings, Finding{
+			Severity: SeverityWarning,
+			Check:    "serial-entropy",
+			Subject:  fmt.Sprintf("%s (serial %s)", issue.CommonName, issue.SerialNumber),
+			Message:  fmt.Sprintf("issued by %s: %s", issue.Issuer, issue.Reason),
+		})
+	}
+
+	// One serial under several issuers is a parsing or issuance irregularity
+	for _, duplicate := range FindDuplicateSerials(groups) {
+		issuers := make([]string, 0, len(duplicate.Certificates))
+		for _, holder := range duplicate.Certificates {
+			issuers = append(issuers, fmt.Sprintf("%s (%s, %s)", holder.Issuer, holder.CommonName, holder.NotBefore.Format("2006-01-02")))
+		}
+		report.Findings = append(report.Findings, Finding{
+			Severity: SeverityWarning,
+			Check:    "duplicate-serial",
+			Subject:  "serial " + duplicate.SerialNumber,
+			Message:  fmt.Sprintf("appears under %d issuers: %s", len(duplicate.Certificates), strings.Join(issuers, "; ")),
+		})
+	}
+
+	// Issuers browsers no longer trust; their active certificates fail now
+	for _, issuer := range DistrustedIssuers(groups, now) {
+		severity := SeverityInfo
+		if issuer.Active > 0 {
+			severity = SeverityCritical
+		}
+		report.Findings = append(report.Findings, Finding{
+			Severity: severity,
+			Check:    "distrusted-issuer",
+			Subject:  issuer.Issuer,
+			Message: fmt.Sprintf("%d certificate(s), %d still valid, from %s, which browsers no longer trust: %s",
+				issuer.Certificates, issuer.Active, issuer.Distrust.CA, issuer.Distrust.Reason),
+		})
+	}
+
+	// Certificates issued after their issuer's distrust cutoff never worked in browsers
+	for _, anomaly := range DistrustAnomalies(groups, now) {
+		severity := SeverityWarning
+		if anomaly.Active {
+			severity = SeverityCritical
+		}
+		report.Findings = append(report.Findings, Finding{
+			Severity: severity,
+			Check:    "issued-after-distrust",
+			Subject:  anomaly.CommonName,
+			Message: fmt.Sprintf("Serial %s was issued by %s on %s, after browsers stopped accepting %s certificates issued after %s, so browsers reject it",
+				anomaly.SerialNumber, anomaly.Issuer, anomaly.NotBefore.Format("2006-01-02"), anomaly.Distrust.CA, anomaly.Distrust.Cutoff().Format("2006-01-02")),
+		})
+	}
+
+	// Inspect the newest active certificates in detail
+	if len(active) > maxReportDetails {
+		report.NotInspected = len(active) - maxReportDetails
+		active = active[:maxReportDetails]
+	}
+	ids := make([]int64, 0, len(active))
+	for _, group := range active {
+		ids = append(ids, PreferredEntry(group).ID)
+	}
+	infos, errs := FetchCertificateInfos(ctx, ids)
+
+	// Ask every inspected certificate's CA about it at once
+	revocations := make(map[int64]RevocationStatus, len(infos))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checker := NewRevocationChecker()
+	for id, info := range infos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := checker.Check(ctx, info.Certificate)
+			mu.Lock()
+			revocations[id] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for i, group := range active {
+		cert := ReportCertificate{
+			ID:           ids[i],
+			CommonName:   group.CommonName,
+			SerialNumber: group.SerialNumber,
+			Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+			NotBefore:    group.NotBeforeTime,
+			NotAfter:     group.NotAfterTime,
+			RequiredSCTs: RequiredSCTs(group.NotBeforeTime, group.NotAfterTime),
+		}
+		if err, failed := errs[cert.ID]; failed {
+			cert.Error = err.Error()
+		} else {
+			info := infos[cert.ID]
+			cert.Info = &info
+			cert.CTPolicy = CheckCTPolicy(ctx, info, group.NotBeforeTime, group.NotAfterTime)
+			revocation := revocations[cert.ID]
+			cert.Revocation = &revocation
+			report.Findings = append(report.Findings, certificateFindings(cert)...)
+		}
+		report.Certificates = append(report.Certificates, cert)
+	}
+
+	// Custom analyzers see every active certificate, with its details when it was inspected
+	for i, group := range analyzed {
+		cert := AnalyzedCertificate{Domain: report.Domain, Group: group, Names: GroupNames(group)}
+		if i < len(report.Certificates) {
+			cert.Info = report.Certificates[i].Info
+		}
+		report.Findings = append(report.Findings, runAnalyzers(cert)...)
+	}
+
+	SortFindings(report.Findings)
+	return report
+}
+
+// expiryFinding describes an active certificate that is about to expire
+func expiryFinding(group CertificateGroup, now time.Time) Finding {
+	days := daysBetween(now, group.NotAfterTime)
+	severity := SeverityWarning
+	if days < 7 {
+		severity = SeverityCritical
+	}
+	return Finding{
+		Severity: severity,
+		Check:    "expiry",
+		Subject:  group.CommonName,
+		Message:  fmt.Sprintf("expires in %d day(s) on %s", days, group.NotAfterTime.Format("2006-01-02")),
+	}
+}
+
+// certificateFindings checks an inspected certificate's crypto, revocation status and CT policy compliance
+func certificateFindings(cert ReportCertificate) []Finding {
+	findings := make([]Finding, 0)
+	subject := fmt.Sprintf("%s (serial %s)", cert.CommonName, cert.SerialNumber)
+
+	for _, weakness := range cert.Info.Weaknesses {
+		findings = append(findings, Finding{
+			Severity: SeverityCritical,
+			Check:    "weak-crypto",
+			Subject:  subject,
+			Message:  weakness,
+		})
+	}
+
+	if revocation := cert.Revocation; revocation != nil {
+		switch revocation.Status {
+		case RevocationRevoked:
+			message := "revoked"
+			if revocation.RevokedAt != nil {
+				message += " on " + revocation.RevokedAt.Format("2006-01-02")
+			}
+			if revocation.Reason != "" {
+				message += " (" + revocation.Reason + ")"
+			}
+			findings = append(findings, Finding{
+				Severity: SeverityCritical,
+				Check:    "revocation",
+				Subject:  subject,
+				Message:  fmt.Sprintf("%s according to %s, so clients checking revocation reject it", message, revocation.Source),
+			})
+		case RevocationUnknown:
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "revocation",
+				Subject:  subject,
+				Message:  fmt.Sprintf("revocation status is unknown: %s doesn't know the certificate", revocation.Source),
+			})
+		case RevocationUnreachable:
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "revocation",
+				Subject:  subject,
+				Message:  "revocation status could not be checked: " + revocation.Error,
+			})
+		}
+	}
+
+	// Browsers' CT policies only cover TLS server certificates
+	switch {
+	case cert.Info.Purpose != PurposeTLS:
+	case cert.Info.IsPrecertificate:
+		findings = append(findings, Finding{
+			Severity: SeverityInfo,
+			Check:    "ct-policy",
+			Subject:  subject,
+			Message:  "only the precertificate is logged, so embedded SCTs could not be checked",
+		})
+	case cert.CTPolicy != nil:
+		if !cert.CTPolicy.Compliant {
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    "ct-policy",
+				Subject:  subject,
+				Message:  fmt.Sprintf("embedded SCTs don't meet CT policy (%s), browsers show a CT error unless SCTs are delivered via TLS or OCSP", strings.Join(cert.CTPolicy.Problems, "; ")),
+			})
+		}
+	case cert.Info.SCTCount < cert.RequiredSCTs:
+		findings = append(findings, Finding{
+			Severity: SeverityWarning,
+			Check:    "ct-policy",
+			Subject:  subject,
+			Message:  fmt.Sprintf("has %d embedded SCT(s), CT policy requires %d unless they are delivered via TLS or OCSP", cert.Info.SCTCount, cert.RequiredSCTs),
+		})
+	}
+
+	return findings
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// crlTimeout bounds each CRL download; CRLs can run to megabytes
+	crlTimeout = 30 * time.Second
+
+	// maxCRLSize caps how much of a CRL is read
+	maxCRLSize = 32 << 20
+)
+
+// Revocation statuses
+const (
+	RevocationGood        = "good"
+	RevocationRevoked     = "revoked"
+	RevocationUnknown     = "unknown"     // The responder doesn't know the certificate
+	RevocationUnreachable = "unreachable" // No OCSP responder or CRL could be asked
+)
+
+// crlClient downloads CRLs
+var crlClient = &http.Client{
+	Timeout: crlTimeout,
+}
+
+// RevocationStatus is whether a certificate's CA says it was revoked, asked over OCSP or, when the
+// certificate names no responder or it doesn't answer, from its CRL
+type RevocationStatus struct {
+	Status    string     `json:"status"`
+	Source    string     `json:"source,omitempty"` // The OCSP responder or CRL URL that answered
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	Reason    string     `json:"reason,omitempty"` // Why it was revoked, when the CA said
+	Error     string     `json:"error,omitempty"`  // Why the status couldn't be had
+}
+
+// RevocationChecker checks certificates' revocation status, downloading each issuer certificate and CRL
+// only once however many certificates need it. It is safe for concurrent use
+type RevocationChecker struct {
+	mu      sync.Mutex
+	issuers map[string]*revocationFetch // By the issuer's AIA URLs
+	crls    map[string]*revocationFetch // By URL
+}
+
+// revocationFetch is one download, shared by every certificate that needs it
+type revocationFetch struct {
+	done   chan struct{}
+	issuer *x509.Certificate
+	crl    *x509.RevocationList
+	err    error
+}
+
+// NewRevocationChecker returns a checker with nothing downloaded yet
+func NewRevocationChecker() *RevocationChecker {
+	return &RevocationChecker{
+		issuers: make(map[string]*revocationFetch),
+		crls:    make(map[string]*revocationFetch),
+	}
+}
+
+// Check asks cert's OCSP responders, then its CRLs, whether it has been revoked
+func (c *RevocationChecker) Check(ctx context.Context, cert *x509.Certificate) RevocationStatus {
+	if len(cert.OCSPServer) == 0 && len(cert.CRLDistributionPoints) == 0 {
+		return RevocationStatus{Status: RevocationUnreachable, Error: "the certificate names no OCSP responder or CRL"}
+	}
+	issuer := c.issuer(ctx, cert)
+	if issuer == nil {
+		return RevocationStatus{Status: RevocationUnreachable, Error: "could not download the issuer certificate to check the answer's signature"}
+	}
+
+	var problems []error
+	for _, url := range cert.OCSPServer {
+		response, err := queryOCSP(ctx, url, cert, issuer)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("OCSP %s: %w", url, err))
+			continue
+		}
+		status := RevocationStatus{Status: ocspStatusName(response.Status), Source: url}
+		if response.Status == ocsp.Revoked {
+			revokedAt := response.RevokedAt.UTC()
+			status.RevokedAt = &revokedAt
+			status.Reason = revocationReasonName(response.RevocationReason)
+		}
+		return status
+	}
+	for _, url := range cert.CRLDistributionPoints {
+		list, err := c.crl(ctx, url, issuer)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("CRL %s: %w", url, err))
+			continue
+		}
+		status := RevocationStatus{Status: RevocationGood, Source: url}
+		for _, entry := range list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				revokedAt := entry.RevocationTime.UTC()
+				status.Status = RevocationRevoked
+				status.RevokedAt = &revokedAt
+				status.Reason = revocationReasonName(entry.ReasonCode)
+				break
+			}
+		}
+		return status
+	}
+	return RevocationStatus{Status: RevocationUnreachable, Error: errors.Join(problems...).Error()}
+}
+
+// issuer downloads cert's issuer from its AIA URL, once per URL set, or returns nil
+func (c *RevocationChecker) issuer(ctx context.Context, cert *x509.Certificate) *x509.Certificate {
+	key := fmt.Sprint(cert.IssuingCertificateURL)
+	fetch, first := c.start(c.issuers, key)
+	if first {
+		fetch.issuer = fetchIssuerCertificate(ctx, cert)
+		close(fetch.done)
+	}
+	select {
+	case <-fetch.done:
+	case <-ctx.Done():
+		return nil
+	}
+	if fetch.issuer == nil || cert.CheckSignatureFrom(fetch.issuer) != nil {
+		return nil
+	}
+	return fetch.issuer
+}
+
+// crl downloads the CRL at url, once, checking issuer signed it
+func (c *RevocationChecker) crl(ctx context.Context, url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	fetch, first := c.start(c.crls, url)
+	if first {
+		fetch.crl, fetch.err = downloadCRL(ctx, url)
+		close(fetch.done)
+	}
+	select {
+	case <-fetch.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if fetch.err != nil {
+		return nil, fetch.err
+	}
+	if err := fetch.crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("not signed by the certificate's issuer: %w", err)
+	}
+	return fetch.crl, nil
+}
+
+// start returns the download for key, and whether the caller is first so has to run it
+func (c *RevocationChecker) start(fetches map[string]*revocationFetch, key string) (*revocationFetch, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if fetch, exists := fetches[key]; exists {
+		return fetch, false
+	}
+	fetch := &revocationFetch{done: make(chan struct{})}
+	fetches[key] = fetch
+	return fetch, true
+}
+
+// queryOCSP asks the responder at url about cert and returns its signed answer
+func queryOCSP(ctx context.Context, url string, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	body, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	if err := waitForHost(ctx, url); err != nil {
+		return nil, err
+	}
+	resp, err := ocspClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("responder returned HTTP %d", resp.StatusCode)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	response, err := ocsp.ParseResponseForCert(answer, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	return response, nil
+}
+
+// downloadCRL fetches and parses the CRL at url
+func downloadCRL(ctx context.Context, url string) (*x509.RevocationList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := waitForHost(ctx, url); err != nil {
+		return nil, err
+	}
+	resp, err := crlClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+	if len(data) > maxCRLSize {
+		return nil, fmt.Errorf("CRL is over %d MB", maxCRLSize>>20)
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL: %w", err)
+	}
+	return list, nil
+}
+
+// revocationReasonName names an RFC 5280 CRLReason code
+func revocationReasonName(code int) string {
+	switch code {
+	case ocsp.KeyCompromise:
+		return "key compromise"
+	case ocsp.CACompromise:
+		return "CA compromise"
+	case ocsp.AffiliationChanged:
+		return "affiliation changed"
+	case ocsp.Superseded:
+		return "superseded"
+	case ocsp.CessationOfOperation:
+		return "cessation of operation"
+	case ocsp.CertificateHold:
+		return "certificate hold"
+	case ocsp.PrivilegeWithdrawn:
+		return "privilege withdrawn"
+	case ocsp.AACompromise:
+		return "AA compromise"
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SavedSearch is a named domain search with its filters
+type SavedSearch struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username,omitempty"` // Owner; empty when accounts are disabled
+	Name      string    `json:"name"`
+	Domain    string    `json:"domain"`
+	NotBefore string    `json:"notBefore,omitempty"`
+	SAN       string    `json:"san,omitempty"`
+	SANRegex  bool      `json:"sanRegex,omitempty"`
+	Purpose   string    `json:"purpose,omitempty"`
+	Sort      string    `json:"sort,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// URL returns the /search link that runs the saved search
+func (s SavedSearch) URL() string {
+	query := SearchQuery(s.Domain, s.NotBefore, s.SAN, s.SANRegex, s.Purpose, s.Sort)
+	return "/search?" + query.Encode()
+}
+
+// SavedSearchStore holds everyone's saved searches
+// It is safe for concurrent use and is saved to a JSON file after every change
+type SavedSearchStore struct {
+	mu       sync.Mutex
+	path     string // Empty means keep everything in memory only
+	searches []SavedSearch
+}
+
+// LoadSavedSearches reads the saved searches from path, starting empty if the file doesn't exist yet
+func LoadSavedSearches(path string) (*SavedSearchStore, error) {
+	s := &SavedSearchStore{
+		path:     path,
+		searches: make([]SavedSearch, 0),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved searches: %w", err)
+	}
+	if err := json.Unmarshal(content, &s.searches); err != nil {
+		return nil, fmt.Errorf("failed to parse saved searches: %w", err)
+	}
+
+	return s, nil
+}
+
+// Reload re-reads the saved searches file, e.g. after it was edited by hand
+// Nothing changes if the file can't be read or parsed
+func (s *SavedSearchStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+	fresh, err := LoadSavedSearches(s.path)
+	if err != nil {
+		return err
+	}
+	s.searches = fresh.searches
+	return nil
+}
+
+// Add saves a search for its owner and returns it with its new ID
+func (s *SavedSearchStore) Add(search SavedSearch) (SavedSearch, error) {
+	search.Name = strings.TrimSpace(search.Name)
+	search.Domain = NormalizeName(search.Domain)
+	if search.Domain == "" {
+		return search, errors.New("please enter a domain name")
+	}
+	if search.Name == "" {
+		search.Name = search.Domain
+	}
+	if err := CheckPurposeFilter(search.Purpose); err != nil {
+		return search, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return search, fmt.Errorf("failed to save search: %w", err)
+	}
+	search.ID = hex.EncodeToString(id)
+	search.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.searches = append(s.searches, search)
+	return search, s.save()
+}
+
+// List returns username's saved searches by name
+func (s *SavedSearchStore) List(username string) []SavedSearch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]SavedSearch, 0)
+	for _, search := range s.searches {
+		if search.Username == username {
+			list = append(list, search)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+
+	return list
+}
+
+// Delete removes one of username's saved searches, reporting whether it existed
+func (s *SavedSearchStore) Delete(username, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, search := range s.searches {
+		if search.ID == id && search.Username == username {
+			s.searches = append(s.searches[:i], s.searches[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// save writes the saved searches to disk
+// Callers must hold s.mu
+func (s *SavedSearchStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(s.searches, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode saved searches: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written file
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save searches: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to save searches: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+const (
+	// minSerialBits is the shortest serial that plausibly holds the 64 random bits the Baseline Requirements
+	// have required since September 30, 2016; random leading zero bits make a 64-bit serial shorter, but
+	// only one in 256 is shorter than this, while counters and timestamps are
+	minSerialBits = 56
+
+	// maxSerialRun is the longest run of one hex digit a random serial plausibly has; a run this long
+	// turns up in a random serial about once in a hundred million
+	maxSerialRun = 8
+
+	// sequentialSerialGap is how close two serials from one issuer can be before they look counted out
+	// rather than drawn at random; random 64-bit serials land this close about once in four billion
+	sequentialSerialGap = 1 << 32
+)
+
+// serialEntropyDate is when the Baseline Requirements started requiring random serials
+var serialEntropyDate = time.Date(2016, 9, 30, 0, 0, 0, 0, time.UTC)
+
+// Reasons a serial number looks like it lacks the required randomness, as sentences templates can translate
+const (
+	reasonShortSerial      = "Serial number is too short to hold the 64 random bits the Baseline Requirements require"
+	reasonRepeatedSerial   = "Serial number has a long run of one digit, which random serials almost never do"
+	reasonSequentialSerial = "Serial number is close to another from the same issuer, as if they were counted out rather than random"
+)
+
+// SerialIssue is a certificate whose serial number looks like it wasn't drawn from a CSPRNG
+// These are heuristics: a short or patterned serial can't hold the required entropy, but a long one
+// could still come from a weak generator
+type SerialIssue struct {
+	CommonName   string    `json:"commonName"`
+	SerialNumber string    `json:"serialNumber"`
+	Issuer       string    `json:"issuer"` // Display name
+	IssuerName   string    `json:"-"`
+	NotBefore    time.Time `json:"notBefore"`
+	Reason       string    `json:"reason"`
+}
+
+// CheckSerialEntropy flags certificates issued under the randomness requirement whose serial numbers
+// are too short, patterned or close to another from the same issuer, newest first
+func CheckSerialEntropy(groups []CertificateGroup) []SerialIssue {
+	issues := make([]SerialIssue, 0)
+	issue := func(group CertificateGroup, reason string) {
+		issues = append(issues, SerialIssue{
+			CommonName:   group.CommonName,
+			SerialNumber: group.SerialNumber,
+			Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+			IssuerName:   group.IssuerName,
+			NotBefore:    group.NotBeforeTime,
+			Reason:       reason,
+		})
+	}
+
+	type numbered struct {
+		group  CertificateGroup
+		serial *big.Int
+	}
+	byIssuer := make(map[string][]numbered)
+	for _, group := range groups {
+		if group.NotBeforeTime.Before(serialEntropyDate) {
+			continue
+		}
+		hexSerial := normalizeSerial(group.SerialNumber)
+		serial, ok := new(big.Int).SetString(hexSerial, 16)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case serial.BitLen() < minSerialBits:
+			issue(group, reasonShortSerial)
+		case longestRun(hexSerial) >= maxSerialRun:
+			issue(group, reasonRepeatedSerial)
+		default:
+			byIssuer[group.IssuerName] = append(byIssuer[group.IssuerName], numbered{group, serial})
+		}
+	}
+
+	// Neighbouring serials from one issuer, in numeric order, mark both certificates
+	gap := big.NewInt(sequentialSerialGap)
+	for _, certs := range byIssuer {
+		sort.Slice(certs, func(i, j int) bool {
+			return certs[i].serial.Cmp(certs[j].serial) < 0
+		})
+		flagged := make([]bool, len(certs))
+		for i := 1; i < len(certs); i++ {
+			if new(big.Int).Sub(certs[i].serial, certs[i-1].serial).Cmp(gap) < 0 {
+				flagged[i-1], flagged[i] = true, true
+			}
+		}
+		for i, cert := range certs {
+			if flagged[i] {
+				issue(cert.group, reasonSequentialSerial)
+			}
+		}
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].NotBefore.After(issues[j].NotBefore)
+	})
+	return issues
+}
+
+// longestRun returns the length of the longest run of one character in s
+func longestRun(s string) int {
+	longest, run := 0, 0
+	for i := range s {
+		if i > 0 && s[i] == s[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+// minDuplicateSerialBits is the shortest serial whose reuse by another issuer is worth a look; old CAs
+// counting up from 1 reuse each other's short serials all the time
+const minDuplicateSerialBits = 32
+
+// DuplicateSerial is a serial number more than one issuer put on a domain's certificates
+// Random serials from different CAs never collide, so this is a parsing or issuance irregularity:
+// a CA renamed mid-stream, a misconfigured intermediate, or a certificate copied under another issuer
+type DuplicateSerial struct {
+	SerialNumber string                  `json:"serialNumber"`
+	Certificates []DuplicateSerialHolder `json:"certificates"`
+}
+
+// DuplicateSerialHolder is one issuer's certificate with a duplicated serial
+type DuplicateSerialHolder struct {
+	CommonName string    `json:"commonName"`
+	Issuer     string    `json:"issuer"` // Display name
+	IssuerName string    `json:"issuerName"`
+	NotBefore  time.Time `json:"notBefore"`
+	NotAfter   time.Time `json:"notAfter"`
+	IDs        []int64   `json:"ids"` // crt.sh IDs
+}
+
+// FindDuplicateSerials finds serial numbers that appear under more than one issuer
+// A precertificate signed by a dedicated precertificate signing CA (RFC 6962) names that CA as its
+// issuer, so two entries with the same names and validity are taken to be one certificate and skipped
+func FindDuplicateSerials(groups []CertificateGroup) []DuplicateSerial {
+	bySerial := make(map[string][]CertificateGroup)
+	for _, group := range groups {
+		serial := normalizeSerial(group.SerialNumber)
+		if number, ok := new(big.Int).SetString(serial, 16); ok && number.BitLen() >= minDuplicateSerialBits {
+			bySerial[serial] = append(bySerial[serial], group)
+		}
+	}
+
+	duplicates := make([]DuplicateSerial, 0)
+	for _, holders := range bySerial {
+		if len(holders) < 2 || len(holders) == 2 && samePrecertificatePair(holders[0], holders[1]) {
+			continue
+		}
+		duplicate := DuplicateSerial{SerialNumber: holders[0].SerialNumber, Certificates: make([]DuplicateSerialHolder, 0, len(holders))}
+		for _, group := range holders {
+			holder := DuplicateSerialHolder{
+				CommonName: group.CommonName,
+				Issuer:     ctsearch.IssuerDisplayName(group.IssuerName),
+				IssuerName: group.IssuerName,
+				NotBefore:  group.NotBeforeTime,
+				NotAfter:   group.NotAfterTime,
+				IDs:        make([]int64, 0, len(group.Entries)),
+			}
+			for _, entry := range group.Entries {
+				holder.IDs = append(holder.IDs, entry.ID)
+			}
+			duplicate.Certificates = append(duplicate.Certificates, holder)
+		}
+		sort.Slice(duplicate.Certificates, func(i, j int) bool {
+			return duplicate.Certificates[i].NotBefore.Before(duplicate.Certificates[j].NotBefore)
+		})
+		duplicates = append(duplicates, duplicate)
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Certificates[0].NotBefore.After(duplicates[j].Certificates[0].NotBefore)
+	})
+	return duplicates
+}
+
+// samePrecertificatePair reports whether two entries with one serial are a precertificate and its
+// certificate, logged under different issuer names
+func samePrecertificatePair(a, b CertificateGroup) bool {
+	return len(a.Entries) == 1 && len(b.Entries) == 1 && a.CommonName == b.CommonName &&
+		a.NotBeforeTime.Equal(b.NotBeforeTime) && a.NotAfterTime.Equal(b.NotAfterTime)
+}
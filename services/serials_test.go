@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// afterEntropyRule is a notBefore date the random serial requirement covers
+var afterEntropyRule = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func TestCheckSerialEntropy(t *testing.T) {
+	tests := []struct {
+		name    string
+		serials []string // From one issuer
+		before  bool     // Issued before the requirement
+		want    []string // Reasons, in the order of serials flagged
+	}{
+		{name: "random", serials: []string{"7c:3a:91:5e:02:d4:b8:6f:13"}, want: nil},
+		{name: "empty", serials: []string{""}, want: nil},
+		{name: "not hex", serials: []string{"xyz"}, want: nil},
+		{name: "short", serials: []string{"01:00:00:00:00"}, want: []string{reasonShortSerial}},
+		{name: "leading zeros don't count", serials: []string{"00:00:00:00:01:02:03:04:05"}, want: []string{reasonShortSerial}},
+		{name: "just long enough", serials: []string{"80:3a:91:5e:02:d4:b8"}, want: nil},
+		{name: "one bit short", serials: []string{"7f:3a:91:5e:02:d4:b8"}, want: []string{reasonShortSerial}},
+		{name: "short before the requirement", serials: []string{"01"}, before: true, want: nil},
+		{name: "run of one digit", serials: []string{"7c:3a:00:00:00:00:b8:6f:13"}, want: []string{reasonRepeatedSerial}},
+		{name: "run just under the limit", serials: []string{"7c:3a:10:00:00:0b:b8:6f:13"}, want: nil},
+		{name: "sequential", serials: []string{"7c:3a:91:5e:02:d4:b8:6f:13", "7c:3a:91:5e:02:d4:b8:6f:14"},
+			want: []string{reasonSequentialSerial, reasonSequentialSerial}},
+		{name: "just far enough apart", serials: []string{"7c:3a:91:5e:00:00:00:01", "7c:3a:91:5f:00:00:00:01"}, want: nil},
+		{name: "just too close", serials: []string{"7c:3a:91:5e:00:00:00:01", "7c:3a:91:5e:fe:ff:ff:ff"},
+			want: []string{reasonSequentialSerial, reasonSequentialSerial}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notBefore := afterEntropyRule
+			if tt.before {
+				notBefore = serialEntropyDate.AddDate(0, 0, -1)
+			}
+			groups := make([]CertificateGroup, 0, len(tt.serials))
+			for _, serial := range tt.serials {
+				groups = append(groups, CertificateGroup{SerialNumber: serial, CommonName: "example.com", IssuerName: "CN=Test CA", NotBeforeTime: notBefore})
+			}
+
+			issues := CheckSerialEntropy(groups)
+			if len(issues) != len(tt.want) {
+				t.Fatalf("CheckSerialEntropy(%v) = %d issue(s) %v, want %d", tt.serials, len(issues), issues, len(tt.want))
+			}
+			for i, reason := range tt.want {
+				if issues[i].Reason != reason {
+					t.Errorf("issue %d reason = %q, want %q", i, issues[i].Reason, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSerialEntropySequentialPerIssuer(t *testing.T) {
+	groups := []CertificateGroup{
+		{SerialNumber: "7c:3a:91:5e:02:d4:b8:6f:13", IssuerName: "CN=First CA", NotBeforeTime: afterEntropyRule},
+		{SerialNumber: "7c:3a:91:5e:02:d4:b8:6f:14", IssuerName: "CN=Second CA", NotBeforeTime: afterEntropyRule},
+	}
+	if issues := CheckSerialEntropy(groups); len(issues) != 0 {
+		t.Errorf("CheckSerialEntropy() = %v, want close serials from different issuers left alone", issues)
+	}
+}
+
+func TestLongestRun(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"a", 1},
+		{"abc", 1},
+		{"aab", 2},
+		{"abbb", 3},
+		{"aabbbaaaa", 4},
+	}
+	for _, tt := range tests {
+		if got := longestRun(tt.s); got != tt.want {
+			t.Errorf("longestRun(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sessionTouchInterval is how stale a session's last-seen time may get before it's updated
+// It saves a store write on every request
+const sessionTouchInterval = time.Minute
+
+// Session is a logged-in browser
+type Session struct {
+	Token      string    `json:"-"`  // Only known when the session is created; stores keep its hash
+	ID         string    `json:"id"` // Hash of the token, safe to show and use to end the session
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeen   time.Time `json:"lastSeen"`
+	ExpiresAt  time.Time `json:"expiresAt"` // Absolute limit, however active the session is
+	UserAgent  string    `json:"userAgent,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+}
+
+// SessionTimeouts say how long sessions last
+type SessionTimeouts struct {
+	Idle     time.Duration // Sessions unused for this long end; 0 means never
+	Absolute time.Duration // Sessions end this long after login, however active
+}
+
+// SessionStore keeps sessions on the server; browsers only hold the token
+type SessionStore interface {
+	// Create starts a session for username with a random token
+	Create(username, userAgent, remoteAddr string) (Session, error)
+	// Get returns the session for a token, reporting false if it doesn't exist or has timed out
+	// It counts as activity for the idle timeout
+	Get(token string) (Session, bool, error)
+	// Delete ends the session for a token
+	Delete(token string) error
+	// DeleteID ends one of username's sessions by ID, reporting whether it existed
+	DeleteID(username, id string) (bool, error)
+	// DeleteUser ends every session for username, e.g. after a password change
+	DeleteUser(username string) error
+	// List returns username's live sessions, most recently used first
+	List(username string) ([]Session, error)
+}
+
+// newSession returns a session for username with a fresh random token
+func newSession(username, userAgent, remoteAddr string, timeouts SessionTimeouts) (Session, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return Session{}, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	now := time.Now()
+	session := Session{
+		Token:      hex.EncodeToString(token),
+		Username:   username,
+		CreatedAt:  now,
+		LastSeen:   now,
+		ExpiresAt:  now.Add(timeouts.Absolute),
+		UserAgent:  userAgent,
+		RemoteAddr: remoteAddr,
+	}
+	session.ID = sessionID(session.Token)
+	return session, nil
+}
+
+// sessionID hashes a token, so a leaked store can't be used to log in
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// expired reports whether the session has passed its absolute or idle timeout
+func (s Session) expired(now time.Time, timeouts SessionTimeouts) bool {
+	if now.After(s.ExpiresAt) {
+		return true
+	}
+	return timeouts.Idle > 0 && now.Sub(s.LastSeen) > timeouts.Idle
+}
+
+// sortSessions orders sessions most recently used first
+func sortSessions(list []Session) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+}
+
+// MemorySessionStore keeps sessions in memory, so restarting the server logs everyone out
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	timeouts SessionTimeouts
+	sessions map[string]*Session // By ID
+}
+
+// NewMemorySessionStore returns an empty in-memory store
+func NewMemorySessionStore(timeouts SessionTimeouts) *MemorySessionStore {
+	return &MemorySessionStore{
+		timeouts: timeouts,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create starts a session for username with a random token
+func (s *MemorySessionStore) Create(username, userAgent, remoteAddr string) (Session, error) {
+	session, err := newSession(username, userAgent, remoteAddr, s.timeouts)
+	if err != nil {
+		return Session{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop timed-out sessions nobody came back for
+	now := time.Now()
+	for id, existing := range s.sessions {
+		if existing.expired(now, s.timeouts) {
+			delete(s.sessions, id)
+		}
+	}
+
+	stored := session
+	stored.Token = ""
+	s.sessions[session.ID] = &stored
+	return session, nil
+}
+
+// Get returns the session for a token, unless it doesn't exist or has timed out
+func (s *MemorySessionStore) Get(token string) (Session, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := sessionID(token)
+	session, exists := s.sessions[id]
+	if !exists {
+		return Session{}, false, nil
+	}
+	now := time.Now()
+	if session.expired(now, s.timeouts) {
+		delete(s.sessions, id)
+		return Session{}, false, nil
+	}
+	session.LastSeen = now
+	return *session, true, nil
+}
+
+// Delete ends a session
+func (s *MemorySessionStore) Delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionID(token))
+	return nil
+}
+
+// DeleteID ends one of username's sessions by ID
+func (s *MemorySessionStore) DeleteID(username, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists || session.Username != username {
+		return false, nil
+	}
+	delete(s.sessions, id)
+	return true, nil
+}
+
+// DeleteUser ends every session for username
+func (s *MemorySessionStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// List returns username's live sessions
+func (s *MemorySessionStore) List(username string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	list := make([]Session, 0)
+	for _, session := range s.sessions {
+		if session.Username == username && !session.expired(now, s.timeouts) {
+			list = append(list, *session)
+		}
+	}
+	sortSessions(list)
+	return list, nil
+}
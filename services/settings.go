@@ -0,0 +1,42 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SettingsFile is the -settings-file format, the expected issuers and integration credentials that can change
+// without a restart, e.g. {"expected_issuers": ["Let's Encrypt"], "jira_api_token": "..."}
+// A setting left out or empty keeps the flag's or environment variable's value
+type SettingsFile struct {
+	ExpectedIssuers  []string `json:"expected_issuers,omitempty"` // As in -expected-issuers; [] expects any
+	SplunkHECToken   string   `json:"splunk_hec_token,omitempty"`
+	JiraAPIToken     string   `json:"jira_api_token,omitempty"`
+	IssuesToken      string   `json:"issues_token,omitempty"`
+	SMTPPassword     string   `json:"smtp_password,omitempty"`
+	OIDCClientSecret string   `json:"oidc_client_secret,omitempty"`
+}
+
+// LoadSettings reads a settings file
+func LoadSettings(path string) (SettingsFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return SettingsFile{}, fmt.Errorf("failed to read settings: %w", err)
+	}
+	var file SettingsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return SettingsFile{}, fmt.Errorf("failed to parse settings: %w", err)
+	}
+	if file.ExpectedIssuers != nil {
+		issuers := make([]string, 0, len(file.ExpectedIssuers))
+		for _, issuer := range file.ExpectedIssuers {
+			if issuer = strings.TrimSpace(issuer); issuer != "" {
+				issuers = append(issuers, issuer)
+			}
+		}
+		file.ExpectedIssuers = issuers
+	}
+	return file, nil
+}
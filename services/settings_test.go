@@ -0,0 +1,48 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantIssuers []string // nil when the file leaves them out
+		wantToken   string
+		wantErr     bool
+	}{
+		{name: "issuers", content: `{"expected_issuers": [" Let's Encrypt ", "", "DigiCert"]}`, wantIssuers: []string{"Let's Encrypt", "DigiCert"}},
+		{name: "any issuer", content: `{"expected_issuers": []}`, wantIssuers: []string{}},
+		{name: "issuers left out", content: `{"jira_api_token": "secret"}`, wantToken: "secret"},
+		{name: "invalid", content: `{"expected_issuers": "Let's Encrypt"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "settings.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadSettings(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadSettings() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got.ExpectedIssuers == nil) != (tt.wantIssuers == nil) || len(got.ExpectedIssuers) != len(tt.wantIssuers) {
+				t.Fatalf("ExpectedIssuers = %#v, want %#v", got.ExpectedIssuers, tt.wantIssuers)
+			}
+			for i, issuer := range tt.wantIssuers {
+				if got.ExpectedIssuers[i] != issuer {
+					t.Errorf("ExpectedIssuers[%d] = %q, want %q", i, got.ExpectedIssuers[i], issuer)
+				}
+			}
+			if got.JiraAPIToken != tt.wantToken {
+				t.Errorf("JiraAPIToken = %q, want %q", got.JiraAPIToken, tt.wantToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// SMIMEReport lists the S/MIME certificates logged for an email address, or for every address at a domain
+type SMIMEReport struct {
+	Query        string             `json:"query"` // "user@example.com", or "@example.com" for the whole domain
+	Active       int                `json:"active"`
+	Certificates []SMIMECertificate `json:"certificates"`
+	Addresses    []SMIMEAddress     `json:"addresses"`
+}
+
+// SMIMECertificate is one certificate naming a matching address
+type SMIMECertificate struct {
+	ID           int64     `json:"id"` // crt.sh ID of the newest entry
+	SerialNumber string    `json:"serialNumber"`
+	CommonName   string    `json:"commonName"` // Usually the person's name rather than an address
+	Issuer       string    `json:"issuer"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	Active       bool      `json:"active"`
+	Addresses    []string  `json:"addresses"`            // Email addresses on the certificate, matching or not
+	OtherNames   []string  `json:"otherNames,omitempty"` // Anything else crt.sh lists, such as DNS names
+}
+
+// SMIMEAddress summarizes the certificates for one matching address
+type SMIMEAddress struct {
+	Address      string    `json:"address"`
+	Certificates int       `json:"certificates"`
+	Active       int       `json:"active"`
+	LastExpiry   time.Time `json:"lastExpiry"` // When its latest certificate expires
+	Issuers      []string  `json:"issuers"`
+}
+
+// NormalizeEmailQuery checks an email address, or "@domain" for every address at a domain, and returns it
+// lowercased with the domain in punycode
+func NormalizeEmailQuery(query string) (string, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	local, domain, found := strings.Cut(query, "@")
+	if !found || strings.Contains(domain, "@") {
+		return "", errors.New("enter an email address, or @domain for every address at a domain")
+	}
+	if strings.ContainsAny(local, " %\"<>") {
+		return "", errors.New("invalid email address")
+	}
+	domain, err := NormalizeDomain(domain)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "%*") {
+		return "", errors.New("email domain must be a fully qualified domain name")
+	}
+	return local + "@" + domain, nil
+}
+
+// SearchSMIME finds the certificates naming a normalized email query
+// crt.sh's identity search also returns near matches, so only certificates with a matching address are kept
+func SearchSMIME(ctx context.Context, query string, now time.Time) (SMIMEReport, error) {
+	report := SMIMEReport{
+		Query:        query,
+		Certificates: make([]SMIMECertificate, 0),
+		Addresses:    make([]SMIMEAddress, 0),
+	}
+
+	search := query
+	if strings.HasPrefix(query, "@") {
+		search = "%" + query
+	}
+	certs, err := FetchCertificates(ctx, search)
+	if err != nil {
+		return report, err
+	}
+
+	addresses := make(map[string]*SMIMEAddress)
+	issuers := make(map[string]map[string]bool)
+	for _, group := range GroupCertificates(certs) {
+		cert := SMIMECertificate{
+			SerialNumber: group.SerialNumber,
+			CommonName:   group.CommonName,
+			Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+			NotBefore:    group.NotBeforeTime,
+			NotAfter:     group.NotAfterTime,
+			Active:       isActive(group, now),
+			Addresses:    make([]string, 0),
+		}
+		for _, entry := range group.Entries {
+			if entry.ID > cert.ID {
+				cert.ID = entry.ID
+			}
+		}
+
+		matching := make([]string, 0)
+		for _, name := range GroupNames(group) {
+			if !strings.Contains(name, "@") {
+				if name != NormalizeName(group.CommonName) {
+					cert.OtherNames = append(cert.OtherNames, name)
+				}
+				continue
+			}
+			cert.Addresses = append(cert.Addresses, name)
+			if emailMatches(name, query) {
+				matching = append(matching, name)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+
+		report.Certificates = append(report.Certificates, cert)
+		if cert.Active {
+			report.Active++
+		}
+		for _, address := range matching {
+			summary, exists := addresses[address]
+			if !exists {
+				summary = &SMIMEAddress{Address: address}
+				addresses[address] = summary
+				issuers[address] = make(map[string]bool)
+			}
+			summary.Certificates++
+			if cert.Active {
+				summary.Active++
+			}
+			if cert.NotAfter.After(summary.LastExpiry) {
+				summary.LastExpiry = cert.NotAfter
+			}
+			issuers[address][cert.Issuer] = true
+		}
+	}
+
+	for address, summary := range addresses {
+		for issuer := range issuers[address] {
+			summary.Issuers = append(summary.Issuers, issuer)
+		}
+		sort.Strings(summary.Issuers)
+		report.Addresses = append(report.Addresses, *summary)
+	}
+	sort.Slice(report.Addresses, func(i, j int) bool {
+		return report.Addresses[i].Address < report.Addresses[j].Address
+	})
+
+	// Valid certificates first, then the most recently issued
+	sort.SliceStable(report.Certificates, func(i, j int) bool {
+		a, b := report.Certificates[i], report.Certificates[j]
+		if a.Active != b.Active {
+			return a.Active
+		}
+		return a.NotBefore.After(b.NotBefore)
+	})
+
+	return report, nil
+}
+
+// emailMatches reports whether an address is the queried one, or at the queried "@domain"
+func emailMatches(address, query string) bool {
+	if strings.HasPrefix(query, "@") {
+		return strings.HasSuffix(address, query) && !strings.HasPrefix(address, "@")
+	}
+	return address == query
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// splunkTimeout bounds one delivery to the HTTP Event Collector
+const splunkTimeout = 15 * time.Second
+
+// SplunkSink forwards events to a Splunk HTTP Event Collector
+type SplunkSink struct {
+	url        string // The collector's event endpoint
+	mu         sync.Mutex
+	token      string // Replaced by SetToken
+	index      string // Empty uses the token's default index
+	sourceType string
+	host       string
+	client     *http.Client
+}
+
+// splunkEvent is one event in the collector's envelope
+type splunkEvent struct {
+	Time       float64 `json:"time"` // Unix seconds
+	Host       string  `json:"host,omitempty"`
+	Source     string  `json:"source"`
+	SourceType string  `json:"sourcetype"`
+	Index      string  `json:"index,omitempty"`
+	Event      Event   `json:"event"`
+}
+
+// NewSplunkSink returns a sink posting to the collector at rawURL, e.g. https://splunk.example.com:8088;
+// a URL with no path gets the collector's /services/collector/event
+func NewSplunkSink(rawURL, token, index, sourceType string) (*SplunkSink, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return nil, fmt.Errorf("invalid Splunk HEC URL %q, use e.g. https://splunk.example.com:8088", rawURL)
+	}
+	if token == "" {
+		return nil, errors.New("a Splunk HEC token is needed")
+	}
+	if strings.Trim(parsed.Path, "/") == "" {
+		parsed.Path = "/services/collector/event"
+	}
+	host, _ := os.Hostname()
+	return &SplunkSink{
+		url:        parsed.String(),
+		token:      token,
+		index:      index,
+		sourceType: sourceType,
+		host:       host,
+		client:     &http.Client{Timeout: splunkTimeout},
+	}, nil
+}
+
+// SetToken replaces the collector token, e.g. when the settings are reloaded; sends under way keep the old one
+func (s *SplunkSink) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Name identifies the sink
+func (s *SplunkSink) Name() string {
+	return "splunk"
+}
+
+// Send posts events to the collector in one request, as concatenated JSON envelopes
+func (s *SplunkSink) Send(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, event := range events {
+		envelope := splunkEvent{
+			Time:       float64(event.Time.UnixMilli()) / 1000,
+			Host:       s.host,
+			Source:     "tsl-certificate-work",
+			SourceType: s.sourceType,
+			Index:      s.index,
+			Event:      event,
+		}
+		if err := encoder.Encode(envelope); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	req.Header.Set("Authorization", "Splunk "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Splunk: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The collector says why, e.g. {"text":"Invalid token","code":4}
+		var answer struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&answer)
+		if answer.Text != "" {
+			return fmt.Errorf("Splunk returned status %d: %s", resp.StatusCode, answer.Text)
+		}
+		return fmt.Errorf("Splunk returned status %d", resp.StatusCode)
+	}
+	return nil
+}
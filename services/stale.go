@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxStaleSearches caps the domains whose last results are kept for when crt.sh can't be searched
+const maxStaleSearches = 100
+
+// lastResults holds the certificates each recent search found, oldest search first in order
+var lastResults = struct {
+	sync.Mutex
+	searches map[string]lastSearch
+	order    []string
+}{searches: make(map[string]lastSearch)}
+
+// lastSearch is the certificates a search found, and when
+type lastSearch struct {
+	certs     []Certificate
+	fetchedAt time.Time
+}
+
+// StaleResultsError is returned along with the certificates the last search for a domain found, when
+// crt.sh can't be searched now; the results are usable, but may be out of date
+type StaleResultsError struct {
+	FetchedAt time.Time
+	Err       error // Why crt.sh couldn't be searched
+}
+
+func (e *StaleResultsError) Error() string {
+	return fmt.Sprintf("results from %s: %v", e.FetchedAt.Format(time.RFC3339), e.Err)
+}
+
+// Unwrap returns why crt.sh couldn't be searched, so callers that don't look for stale results see the failure
+func (e *StaleResultsError) Unwrap() error {
+	return e.Err
+}
+
+// rememberResults keeps a search's certificates, dropping the oldest search to make room
+// With a disk cache they're written there too, so they outlast a restart and the in-memory limit
+func rememberResults(domain string, certs []Certificate, now time.Time) {
+	cacheSearch(domain, lastSearch{certs: certs, fetchedAt: now})
+
+	lastResults.Lock()
+	defer lastResults.Unlock()
+
+	if _, exists := lastResults.searches[domain]; exists {
+		for i, old := range lastResults.order {
+			if old == domain {
+				lastResults.order = append(lastResults.order[:i], lastResults.order[i+1:]...)
+				break
+			}
+		}
+	} else if len(lastResults.order) >= maxStaleSearches {
+		delete(lastResults.searches, lastResults.order[0])
+		lastResults.order = lastResults.order[1:]
+	}
+	lastResults.searches[domain] = lastSearch{certs: certs, fetchedAt: now}
+	lastResults.order = append(lastResults.order, domain)
+}
+
+// staleResults returns the last certificates found for a domain in place of a failed search, if crt.sh
+// failing is why it failed and there are some
+func staleResults(domain string, err error) ([]Certificate, error) {
+	if errors.Is(err, context.Canceled) || !errors.Is(err, ErrUpstreamTimeout) && !errors.Is(err, ErrRateLimited) &&
+		!errors.Is(err, ErrUpstreamMaintenance) && !errors.Is(err, ErrUpstreamUnavailable) {
+		return nil, err
+	}
+
+	last, ok := lastResultsFor(domain)
+	if !ok {
+		return nil, err
+	}
+	return last.certs, &StaleResultsError{FetchedAt: last.fetchedAt, Err: err}
+}
+
+// LastSearchedAt returns when the last results kept for a domain, in memory or the disk cache, were found
+func LastSearchedAt(domain string) (time.Time, bool) {
+	last, ok := lastResultsFor(domain)
+	return last.fetchedAt, ok
+}
+
+// lastResultsFor returns the last certificates found for a domain, from memory or the disk cache
+func lastResultsFor(domain string) (lastSearch, bool) {
+	lastResults.Lock()
+	last, ok := lastResults.searches[domain]
+	lastResults.Unlock()
+	if !ok {
+		last, ok = cachedSearch(domain)
+	}
+	return last, ok
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// trendMonths is how many calendar months of issuance history the stats cover
+const trendMonths = 12
+
+// Lifetime outliers are certificates lasting outlierFactor times longer or shorter than the typical
+// certificate of the same purpose, in a lifetime bucket holding under maxOutlierShare of them; fewer than
+// minOutlierSample certificates of a purpose don't show a norm
+const (
+	outlierFactor    = 3
+	maxOutlierShare  = 0.1
+	minOutlierSample = 10
+)
+
+// IssuerDistribution summarizes which CAs issue certificates for a domain
+type IssuerDistribution struct {
+	Months      []string      `json:"months"` // "2006-01" labels for each IssuerStats.Monthly count, oldest first
+	TotalActive int           `json:"totalActive"`
+	Issuers     []IssuerStats `json:"issuers"`
+}
+
+// IssuerStats holds the numbers for a single CA
+type IssuerStats struct {
+	IssuerName  string  `json:"issuerName"`
+	DisplayName string  `json:"displayName"`
+	Active      int     `json:"active"` // Certificates valid right now
+	Total       int     `json:"total"`  // All certificates in the result set
+	Share       float64 `json:"share"`  // Percentage of all active certificates
+	Monthly     []int   `json:"monthly"`
+}
+
+// IssuerDistributionStats counts active certificates per issuer, their share of
+// the domain's active certificates, and how many were issued in each of the last 12 months
+func IssuerDistributionStats(groups []CertificateGroup, now time.Time) IssuerDistribution {
+	// Work out the first day of each month in the trend window
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	firstMonth := thisMonth.AddDate(0, -(trendMonths - 1), 0)
+
+	dist := IssuerDistribution{
+		Months:  make([]string, trendMonths),
+		Issuers: make([]IssuerStats, 0),
+	}
+	for i := range dist.Months {
+		dist.Months[i] = firstMonth.AddDate(0, i, 0).Format("2006-01")
+	}
+
+	// Map to collect stats by issuer
+	statsMap := make(map[string]*IssuerStats)
+
+	for _, group := range groups {
+		stats, exists := statsMap[group.IssuerName]
+		if !exists {
+			stats = &IssuerStats{
+				IssuerName:  group.IssuerName,
+				DisplayName: ctsearch.IssuerDisplayName(group.IssuerName),
+				Monthly:     make([]int, trendMonths),
+			}
+			statsMap[group.IssuerName] = stats
+		}
+
+		stats.Total++
+		if isActive(group, now) {
+			stats.Active++
+			dist.TotalActive++
+		}
+
+		// Count the issuance month if it falls inside the trend window
+		if !group.NotBeforeTime.Before(firstMonth) {
+			index := monthsBetween(firstMonth, group.NotBeforeTime)
+			if index < trendMonths {
+				stats.Monthly[index]++
+			}
+		}
+	}
+
+	for _, stats := range statsMap {
+		if dist.TotalActive > 0 {
+			stats.Share = float64(stats.Active) * 100 / float64(dist.TotalActive)
+		}
+		dist.Issuers = append(dist.Issuers, *stats)
+	}
+
+	// Most active issuers first
+	sort.Slice(dist.Issuers, func(i, j int) bool {
+		a, b := dist.Issuers[i], dist.Issuers[j]
+		if a.Active != b.Active {
+			return a.Active > b.Active
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.DisplayName < b.DisplayName
+	})
+
+	return dist
+}
+
+// Sparkline renders the monthly trend as unicode bars for compact display
+func (s IssuerStats) Sparkline() string {
+	bars := []rune("▁▂▃▄▅▆▇█")
+
+	max := 0
+	for _, count := range s.Monthly {
+		if count > max {
+			max = count
+		}
+	}
+
+	var sb strings.Builder
+	for _, count := range s.Monthly {
+		if max == 0 {
+			sb.WriteRune(bars[0])
+			continue
+		}
+		sb.WriteRune(bars[count*(len(bars)-1)/max])
+	}
+	return sb.String()
+}
+
+// isActive reports whether the certificate is valid at the given time
+func isActive(group CertificateGroup, now time.Time) bool {
+	return !now.Before(group.NotBeforeTime) && now.Before(group.NotAfterTime)
+}
+
+// monthsBetween returns how many calendar months t is after start
+func monthsBetween(start, t time.Time) int {
+	return (t.Year()-start.Year())*12 + int(t.Month()) - int(start.Month())
+}
+
+// lifetimeBuckets are the validity lengths we group certificates into
+// The upper bounds follow the common issuance policies (ACME 90-day, 398-day, legacy 825-day)
+var lifetimeBuckets = []struct {
+	Label   string
+	MaxDays int
+}{
+	{"≤ 47 days", 47},
+	{"48-100 days (90-day)", 100},
+	{"101-200 days", 200},
+	{"201-398 days (1-year)", 398},
+	{"399-825 days (legacy 2-year)", 825},
+	{"> 825 days (legacy 3-year+)", -1},
+}
+
+// LifetimeHistogram is a chart-friendly distribution of certificate validity lengths
+type LifetimeHistogram struct {
+	Buckets []string       `json:"buckets"` // Labels, in the same order as every Counts slice
+	Counts  []int          `json:"counts"`  // Totals across the whole result set
+	Years   []LifetimeYear `json:"years"`   // Per issuance year, oldest first
+
+	Outliers []LifetimeOutlier `json:"outliers"` // Certificates whose lifetime is far from the norm, newest first
+}
+
+// LifetimeOutlier is a certificate whose validity period differs sharply from the domain's other
+// certificates, which usually means it was issued outside the standard process (e.g. a 3-year internal CA
+// certificate among 90-day ACME ones)
+type LifetimeOutlier struct {
+	CommonName   string    `json:"commonName"`
+	SerialNumber string    `json:"serialNumber"`
+	Issuer       string    `json:"issuer"` // Display name
+	Purpose      string    `json:"purpose"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	LifetimeDays int       `json:"lifetimeDays"`
+	TypicalDays  int       `json:"typicalDays"` // Median lifetime of the domain's certificates with the same purpose
+}
+
+// LifetimeYear holds the bucket counts for certificates issued in one year
+type LifetimeYear struct {
+	Year   int   `json:"year"`
+	Counts []int `json:"counts"`
+}
+
+// LifetimeHistogramStats buckets certificates by validity length, overall and per issuance year
+func LifetimeHistogramStats(groups []CertificateGroup) LifetimeHistogram {
+	hist := LifetimeHistogram{
+		Buckets: make([]string, len(lifetimeBuckets)),
+		Counts:  make([]int, len(lifetimeBuckets)),
+		Years:   make([]LifetimeYear, 0),
+	}
+	for i, bucket := range lifetimeBuckets {
+		hist.Buckets[i] = bucket.Label
+	}
+
+	// Map to collect counts by issuance year
+	yearMap := make(map[int][]int)
+
+	for _, group := range groups {
+		// Skip certificates with dates we couldn't parse
+		if group.NotBeforeTime.IsZero() || group.NotAfterTime.IsZero() {
+			continue
+		}
+
+		index := lifetimeBucket(LifetimeDays(group))
+		hist.Counts[index]++
+
+		year := group.NotBeforeTime.Year()
+		if _, exists := yearMap[year]; !exists {
+			yearMap[year] = make([]int, len(lifetimeBuckets))
+		}
+		yearMap[year][index]++
+	}
+
+	for year, counts := range yearMap {
+		hist.Years = append(hist.Years, LifetimeYear{Year: year, Counts: counts})
+	}
+	sort.Slice(hist.Years, func(i, j int) bool {
+		return hist.Years[i].Year < hist.Years[j].Year
+	})
+	hist.Outliers = LifetimeOutliers(groups)
+
+	return hist
+}
+
+// LifetimeOutliers finds certificates lasting far longer or shorter than the domain's norm for their purpose
+// The norm is the median, so a few outliers don't move it, and a lifetime shared by a tenth of the
+// certificates or more is a second norm (say, commercial 1-year certificates beside ACME ones), not an outlier
+func LifetimeOutliers(groups []CertificateGroup) []LifetimeOutlier {
+	outliers := make([]LifetimeOutlier, 0)
+
+	byPurpose := make(map[string][]CertificateGroup)
+	for _, group := range groups {
+		if !group.NotBeforeTime.IsZero() && !group.NotAfterTime.IsZero() {
+			byPurpose[group.Purpose] = append(byPurpose[group.Purpose], group)
+		}
+	}
+
+	for _, certs := range byPurpose {
+		if len(certs) < minOutlierSample {
+			continue
+		}
+		lifetimes := make([]int, len(certs))
+		buckets := make([]int, len(lifetimeBuckets))
+		for i, group := range certs {
+			lifetimes[i] = LifetimeDays(group)
+			buckets[lifetimeBucket(lifetimes[i])]++
+		}
+		sort.Ints(lifetimes)
+		typical := lifetimes[len(lifetimes)/2]
+		if typical < 1 {
+			typical = 1
+		}
+
+		for _, group := range certs {
+			days := LifetimeDays(group)
+			if days < typical*outlierFactor && days*outlierFactor > typical {
+				continue
+			}
+			if float64(buckets[lifetimeBucket(days)]) >= maxOutlierShare*float64(len(certs)) {
+				continue
+			}
+			outliers = append(outliers, LifetimeOutlier{
+				CommonName:   group.CommonName,
+				SerialNumber: group.SerialNumber,
+				Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+				Purpose:      group.Purpose,
+				NotBefore:    group.NotBeforeTime,
+				NotAfter:     group.NotAfterTime,
+				LifetimeDays: days,
+				TypicalDays:  typical,
+			})
+		}
+	}
+
+	sort.Slice(outliers, func(i, j int) bool {
+		return outliers[i].NotBefore.After(outliers[j].NotBefore)
+	})
+	return outliers
+}
+
+// LifetimeDays returns the certificate's validity period in whole days
+func LifetimeDays(group CertificateGroup) int {
+	return int(group.NotAfterTime.Sub(group.NotBeforeTime).Hours() / 24)
+}
+
+// Bars pairs each bucket with its overall count and its width relative to the largest bucket
+func (h LifetimeHistogram) Bars() []HistogramBar {
+	max := 0
+	for _, count := range h.Counts {
+		if count > max {
+			max = count
+		}
+	}
+
+	bars := make([]HistogramBar, len(h.Buckets))
+	for i, label := range h.Buckets {
+		bars[i] = HistogramBar{Label: label, Count: h.Counts[i]}
+		if max > 0 {
+			bars[i].Percent = h.Counts[i] * 100 / max
+		}
+	}
+	return bars
+}
+
+// HistogramBar is one row of a rendered histogram
+type HistogramBar struct {
+	Label   string
+	Count   int
+	Percent int
+}
+
+// lifetimeBucket returns the index of the bucket a validity length falls into
+func lifetimeBucket(days int) int {
+	for i, bucket := range lifetimeBuckets {
+		if bucket.MaxDays < 0 || days <= bucket.MaxDays {
+			return i
+		}
+	}
+	return len(lifetimeBuckets) - 1
+}
+
+// TimelinePoint is one month of a domain's issuance history
+type TimelinePoint struct {
+	Month  string `json:"month"`  // "2006-01"
+	Issued int    `json:"issued"` // Certificates with a NotBefore in this month
+	Active int    `json:"active"` // Certificates valid at some point during this month
+	Gap    bool   `json:"gap"`    // No certificate was valid at all this month
+}
+
+// IssuanceTimeline buckets certificates by issuance month, from the first issuance up to now,
+// and counts how many were active in each month so spikes and coverage gaps stand out
+func IssuanceTimeline(groups []CertificateGroup, now time.Time) []TimelinePoint {
+	timeline := make([]TimelinePoint, 0)
+
+	// Find the first issuance month
+	var first time.Time
+	for _, group := range groups {
+		if group.NotBeforeTime.IsZero() {
+			continue
+		}
+		if first.IsZero() || group.NotBeforeTime.Before(first) {
+			first = group.NotBeforeTime
+		}
+	}
+	if first.IsZero() {
+		return timeline
+	}
+
+	start := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		next := month.AddDate(0, 1, 0)
+		point := TimelinePoint{Month: month.Format("2006-01")}
+
+		for _, group := range groups {
+			if group.NotBeforeTime.IsZero() {
+				continue
+			}
+			if !group.NotBeforeTime.Before(month) && group.NotBeforeTime.Before(next) {
+				point.Issued++
+			}
+			// Valid at some point in [month, next)
+			if group.NotBeforeTime.Before(next) && group.NotAfterTime.After(month) {
+				point.Active++
+			}
+		}
+
+		point.Gap = point.Active == 0
+		timeline = append(timeline, point)
+	}
+
+	return timeline
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"sort"
+	"time"
+)
+
+// WatchlistSummary is the periodic digest of what happened to the watched domains
+type WatchlistSummary struct {
+	Team    string          `json:"team,omitempty"` // Team name, for a team's summary
+	Since   time.Time       `json:"since"`
+	Until   time.Time       `json:"until"`
+	Domains []DomainSummary `json:"domains"`
+}
+
+// DomainSummary is one watched domain's part of the digest
+type DomainSummary struct {
+	Domain      string             `json:"domain"`
+	Active      int                `json:"active"`
+	Expiring    []CertificateGroup `json:"expiring"`    // Active certificates expiring within expiringSoonDays
+	NewlyIssued []CertificateGroup `json:"newlyIssued"` // Issued during the period
+	Alerts      []Alert            `json:"alerts"`      // Raised during the period
+	Error       string             `json:"error,omitempty"`
+}
+
+// SummarizeDomain builds a domain's digest entry from its certificates and the alerts raised since since
+func SummarizeDomain(domain string, groups []CertificateGroup, alerts []Alert, since, now time.Time) DomainSummary {
+	summary := DomainSummary{
+		Domain:      domain,
+		Expiring:    make([]CertificateGroup, 0),
+		NewlyIssued: make([]CertificateGroup, 0),
+		Alerts:      make([]Alert, 0),
+	}
+
+	soon := now.AddDate(0, 0, expiringSoonDays)
+	for _, group := range groups {
+		if isActive(group, now) {
+			summary.Active++
+			if group.NotAfterTime.Before(soon) {
+				summary.Expiring = append(summary.Expiring, group)
+			}
+		}
+		if !group.NotBeforeTime.Before(since) {
+			summary.NewlyIssued = append(summary.NewlyIssued, group)
+		}
+	}
+
+	sort.Slice(summary.Expiring, func(i, j int) bool {
+		return summary.Expiring[i].NotAfterTime.Before(summary.Expiring[j].NotAfterTime)
+	})
+	sort.Slice(summary.NewlyIssued, func(i, j int) bool {
+		return summary.NewlyIssued[i].NotBeforeTime.After(summary.NewlyIssued[j].NotBeforeTime)
+	})
+
+	for _, alert := range alerts {
+		if alert.Domain == domain {
+			summary.Alerts = append(summary.Alerts, alert)
+		}
+	}
+
+	return summary
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the syslog sink's messages
+const (
+	SyslogCEF  = "cef"  // ArcSight Common Event Format
+	SyslogLEEF = "leef" // QRadar Log Event Extended Format
+)
+
+const (
+	// syslogTimeout bounds connecting to the syslog server when the caller sets no deadline
+	syslogTimeout = 10 * time.Second
+
+	// syslogFacility is local0, which SIEM collectors commonly route to a log source by
+	syslogFacility = 16
+
+	// CEF identifies the product in every message's header
+	cefVendor  = "jonisgett"
+	cefProduct = "tsl-certificate-work"
+	cefVersion = "1"
+)
+
+// SyslogSink sends events to a syslog server as RFC 5424 messages carrying CEF or LEEF, so SIEMs can
+// ingest them without a custom parser
+// It keeps one connection open, dialling again after a failure
+type SyslogSink struct {
+	network  string // udp, tcp or tls
+	address  string
+	format   string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// eventField is one key and value of a CEF or LEEF message
+type eventField struct {
+	key, value string
+}
+
+// NewSyslogSink returns a sink for the syslog server at rawURL, udp://host[:514], tcp://host[:514] or
+// tls://host[:6514], formatting messages as format (SyslogCEF or SyslogLEEF)
+func NewSyslogSink(rawURL, format string) (*SyslogSink, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid syslog address %q, use e.g. udp://siem.example.com:514, tcp://... or tls://...", rawURL)
+	}
+	port := parsed.Port()
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		if port == "" {
+			port = "514"
+		}
+	case "tls":
+		if port == "" {
+			port = "6514"
+		}
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q, use udp://, tcp:// or tls://", rawURL)
+	}
+	if format != SyslogCEF && format != SyslogLEEF {
+		return nil, fmt.Errorf("invalid syslog format %q, use %s or %s", format, SyslogCEF, SyslogLEEF)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogSink{
+		network:  parsed.Scheme,
+		address:  net.JoinHostPort(parsed.Hostname(), port),
+		format:   format,
+		hostname: hostname,
+	}, nil
+}
+
+// Name identifies the sink
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+// Send writes one syslog message per event; over TCP and TLS each is framed by its length (RFC 6587)
+func (s *SyslogSink) Send(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := s.dial(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to reach syslog server: %w", err)
+		}
+		s.conn = conn
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(syslogTimeout)
+	}
+	s.conn.SetWriteDeadline(deadline)
+
+	for _, event := range events {
+		message := s.message(event)
+		if s.network != "udp" {
+			message = strconv.Itoa(len(message)) + " " + message
+		}
+		if _, err := s.conn.Write([]byte(message)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to write to syslog server: %w", err)
+		}
+	}
+	return nil
+}
+
+// dial connects to the syslog server
+func (s *SyslogSink) dial(ctx context.Context) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, syslogTimeout)
+		defer cancel()
+	}
+	if s.network == "tls" {
+		dialer := tls.Dialer{Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+		return dialer.DialContext(ctx, "tcp", s.address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, s.network, s.address)
+}
+
+// message is an event as an RFC 5424 message: header, no structured data, then CEF or LEEF
+func (s *SyslogSink) message(event Event) string {
+	severity, cefSeverity := 6, 3 // Informational
+	name := "New certificate logged"
+	if event.Type == EventAlert {
+		severity, cefSeverity = 4, 7 // Warning
+		name = "Watched domain alert"
+	}
+	header := fmt.Sprintf("<%d>1 %s %s certviewer %d %s - ", syslogFacility*8+severity,
+		event.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, os.Getpid(), event.Type)
+
+	fields := eventFields(event)
+	if s.format == SyslogLEEF {
+		return header + formatLEEF(event, cefSeverity, fields)
+	}
+	return header + formatCEF(event, name, cefSeverity, fields)
+}
+
+// eventFields lists what an event says as keys and values, CEF's custom fields labelled
+func eventFields(event Event) []eventField {
+	fields := []eventField{{"cs5Label", "watchedDomain"}, {"cs5", event.Domain}}
+	switch {
+	case event.Certificate != nil:
+		cert := event.Certificate
+		ids := make([]string, 0, len(cert.CrtshIDs))
+		for _, id := range cert.CrtshIDs {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+		fields = append(fields,
+			eventField{"dhost", cert.CommonName},
+			eventField{"cs1Label", "serialNumber"}, eventField{"cs1", cert.SerialNumber},
+			eventField{"cs2Label", "issuer"}, eventField{"cs2", cert.Issuer},
+			eventField{"cs3Label", "names"}, eventField{"cs3", strings.Join(cert.Names, " ")},
+			eventField{"cs4Label", "crtshIds"}, eventField{"cs4", strings.Join(ids, ",")},
+			eventField{"deviceCustomDate1Label", "notBefore"}, eventField{"deviceCustomDate1", strconv.FormatInt(cert.NotBefore.UnixMilli(), 10)},
+			eventField{"deviceCustomDate2Label", "notAfter"}, eventField{"deviceCustomDate2", strconv.FormatInt(cert.NotAfter.UnixMilli(), 10)},
+		)
+	case event.Alert != nil:
+		fields = append(fields,
+			eventField{"dhost", event.Alert.Subject},
+			eventField{"cs1Label", "alertType"}, eventField{"cs1", event.Alert.Type},
+			eventField{"msg", event.Alert.Message},
+		)
+	}
+	return fields
+}
+
+// cefHeader and cefValue escape a CEF header field and extension value
+var (
+	cefHeader = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValue  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// formatCEF writes an event as CEF: version, product, event class and severity, then key=value pairs
+func formatCEF(event Event, name string, severity int, fields []eventField) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "CEF:0|%s|%s|%s|%s|%s|%d|rt=%d", cefHeader.Replace(cefVendor), cefHeader.Replace(cefProduct), cefVersion,
+		cefHeader.Replace(event.Type), cefHeader.Replace(name), severity, event.Time.UnixMilli())
+	for _, field := range fields {
+		fmt.Fprintf(&out, " %s=%s", field.key, cefValue.Replace(field.value))
+	}
+	return out.String()
+}
+
+// leefValue keeps tabs and line breaks out of a LEEF value, since a tab separates attributes
+var leefValue = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// formatLEEF writes an event as LEEF 1.0: product and event ID, then tab-separated attributes
+// CEF's labelled custom fields become plainly named attributes
+func formatLEEF(event Event, severity int, fields []eventField) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "LEEF:1.0|%s|%s|%s|%s|", cefVendor, cefProduct, cefVersion, event.Type)
+	fmt.Fprintf(&out, "devTime=%s\tdevTimeFormat=MMM dd yyyy HH:mm:ss.SSS z\tcat=%s\tsev=%d",
+		event.Time.UTC().Format("Jan 02 2006 15:04:05.000 MST"), event.Type, severity)
+
+	labels := make(map[string]string)
+	for _, field := range fields {
+		if key, ok := strings.CutSuffix(field.key, "Label"); ok {
+			labels[key] = field.value
+		}
+	}
+	for _, field := range fields {
+		if strings.HasSuffix(field.key, "Label") {
+			continue
+		}
+		key := field.key
+		if label, ok := labels[key]; ok {
+			key = label
+		}
+		fmt.Fprintf(&out, "\t%s=%s", key, leefValue.Replace(field.value))
+	}
+	return out.String()
+}
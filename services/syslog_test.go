@@ -0,0 +1,132 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCEFEscaping(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{name: "plain", header: "Watched domain alert", value: "example.com", want: "|Watched domain alert|7|rt=0 cs5Label=watchedDomain cs5=example.com"},
+		{name: "pipe", header: "a|b", value: "a|b", want: `|a\|b|7|rt=0 cs5Label=watchedDomain cs5=a|b`},
+		{name: "equals", header: "a=b", value: "a=b", want: `|a=b|7|rt=0 cs5Label=watchedDomain cs5=a\=b`},
+		{name: "backslash", header: `a\b`, value: `a\b`, want: `|a\\b|7|rt=0 cs5Label=watchedDomain cs5=a\\b`},
+		{name: "escaped pipe", header: `a\|b`, value: `a\=b`, want: `|a\\\|b|7|rt=0 cs5Label=watchedDomain cs5=a\\\=b`},
+		{name: "line breaks", header: "a\r\nb", value: "a\r\nb", want: `|a  b|7|rt=0 cs5Label=watchedDomain cs5=a\r\nb`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := Event{Type: EventAlert, Time: time.UnixMilli(0)}
+			fields := []eventField{{"cs5Label", "watchedDomain"}, {"cs5", tt.value}}
+			got := formatCEF(event, tt.header, 7, fields)
+			if !strings.HasPrefix(got, "CEF:0|jonisgett|tsl-certificate-work|1|alert|") {
+				t.Fatalf("formatCEF() = %q, want the CEF header first", got)
+			}
+			if !strings.HasSuffix(got, tt.want) {
+				t.Errorf("formatCEF() = %q, want it to end %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLEEFEscaping(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "plain", value: "example.com", want: "\twatchedDomain=example.com"},
+		{name: "tab", value: "a\tb", want: "\twatchedDomain=a b"},
+		{name: "line breaks", value: "a\r\nb", want: "\twatchedDomain=a  b"},
+		{name: "pipe, equals and backslash kept", value: `a|b=c\d`, want: "\twatchedDomain=a|b=c\\d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := Event{Type: EventAlert, Time: time.UnixMilli(0)}
+			fields := []eventField{{"cs5Label", "watchedDomain"}, {"cs5", tt.value}}
+			got := formatLEEF(event, 7, fields)
+			if !strings.HasPrefix(got, "LEEF:1.0|jonisgett|tsl-certificate-work|1|alert|") {
+				t.Fatalf("formatLEEF() = %q, want the LEEF header first", got)
+			}
+			if !strings.HasSuffix(got, tt.want) {
+				t.Errorf("formatLEEF() = %q, want it to end %q", got, tt.want)
+			}
+			if strings.Contains(got, "cs5") {
+				t.Errorf("formatLEEF() = %q, want CEF's custom field named by its label", got)
+			}
+		})
+	}
+}
+
+func TestSyslogMessage(t *testing.T) {
+	when := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	alert := Event{Type: EventAlert, Domain: "example.com", Time: when,
+		Alert: &Alert{Type: "new_subdomain", Subject: "new.example.com", Message: "New subdomain=new.example.com"}}
+	cert := Event{Type: EventNewCertificate, Domain: "example.com", Time: when,
+		Certificate: &EventCertificate{SerialNumber: "0a1b", CommonName: "example.com", Names: []string{"example.com", "www.example.com"}}}
+
+	tests := []struct {
+		name       string
+		format     string
+		event      Event
+		wantPrefix string
+		wantParts  []string
+	}{
+		{name: "cef alert", format: SyslogCEF, event: alert, wantPrefix: "<132>1 2026-03-01T09:30:00.000000Z host certviewer ",
+			wantParts: []string{"|Watched domain alert|7|", " dhost=new.example.com", ` msg=New subdomain\=new.example.com`}},
+		{name: "cef certificate", format: SyslogCEF, event: cert, wantPrefix: "<134>1 2026-03-01T09:30:00.000000Z host certviewer ",
+			wantParts: []string{"|New certificate logged|3|", " cs1=0a1b", " cs3=example.com www.example.com"}},
+		{name: "leef alert", format: SyslogLEEF, event: alert, wantPrefix: "<132>1 ",
+			wantParts: []string{"\tsev=7", "\talertType=new_subdomain", "\tmsg=New subdomain=new.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &SyslogSink{network: "udp", format: tt.format, hostname: "host"}
+			got := sink.message(tt.event)
+			if !strings.HasPrefix(got, tt.wantPrefix) {
+				t.Errorf("message() = %q, want it to start %q", got, tt.wantPrefix)
+			}
+			for _, part := range tt.wantParts {
+				if !strings.Contains(got, part) {
+					t.Errorf("message() = %q, want it to contain %q", got, part)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSyslogSink(t *testing.T) {
+	tests := []struct {
+		url, format string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{url: "udp://siem.example.com", format: SyslogCEF, wantNetwork: "udp", wantAddress: "siem.example.com:514"},
+		{url: "tcp://siem.example.com:1514", format: SyslogLEEF, wantNetwork: "tcp", wantAddress: "siem.example.com:1514"},
+		{url: "tls://siem.example.com", format: SyslogCEF, wantNetwork: "tls", wantAddress: "siem.example.com:6514"},
+		{url: "http://siem.example.com", format: SyslogCEF, wantErr: true},
+		{url: "udp://", format: SyslogCEF, wantErr: true},
+		{url: "udp://siem.example.com", format: "json", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url+" "+tt.format, func(t *testing.T) {
+			sink, err := NewSyslogSink(tt.url, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSyslogSink(%q, %q) error = %v, want error %v", tt.url, tt.format, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if sink.network != tt.wantNetwork || sink.address != tt.wantAddress {
+				t.Errorf("NewSyslogSink(%q) = %s %s, want %s %s", tt.url, sink.network, sink.address, tt.wantNetwork, tt.wantAddress)
+			}
+		})
+	}
+}
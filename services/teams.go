@@ -0,0 +1,347 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Team roles, from least to most privileged
+const (
+	RoleViewer = "viewer" // Sees the team's domains, alerts and reports
+	RoleEditor = "editor" // Also adds and removes the team's domains
+	RoleOwner  = "owner"  // Also manages members and alert channels
+)
+
+// roleRanks orders the roles so permissions can be compared
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// teamSlugPattern is what a team's short name may look like
+var teamSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+// Team is a workspace whose members share domains, alerts and reports
+// The team's domains are kept on the watchlist
+type Team struct {
+	Slug        string       `json:"slug"`
+	Name        string       `json:"name"`
+	Members     []TeamMember `json:"members"`
+	AlertEmails []string     `json:"alertEmails,omitempty"` // Where the team's summary emails go
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// TeamMember is a user's role in a team
+type TeamMember struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// Role returns username's role in the team, or "" if they aren't a member
+func (t Team) Role(username string) string {
+	for _, member := range t.Members {
+		if member.Username == username {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// RoleAtLeast reports whether role grants at least the permissions of minimum
+func RoleAtLeast(role, minimum string) bool {
+	return roleRanks[role] >= roleRanks[minimum]
+}
+
+// ValidRole reports whether role is one of the team roles
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// TeamStore holds the teams
+// It is safe for concurrent use and is saved to a JSON file after every change
+type TeamStore struct {
+	mu    sync.Mutex
+	path  string // Empty means keep everything in memory only
+	teams map[string]*Team
+}
+
+// LoadTeamStore reads the teams from path, starting empty if the file doesn't exist yet
+func LoadTeamStore(path string) (*TeamStore, error) {
+	s := &TeamStore{
+		path:  path,
+		teams: make(map[string]*Team),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read teams: %w", err)
+	}
+
+	var teams []*Team
+	if err := json.Unmarshal(content, &teams); err != nil {
+		return nil, fmt.Errorf("failed to parse teams: %w", err)
+	}
+	for _, team := range teams {
+		s.teams[team.Slug] = team
+	}
+
+	return s, nil
+}
+
+// Reload re-reads the teams file, e.g. after it was edited by hand
+// Nothing changes if the file can't be read or parsed
+func (s *TeamStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+	fresh, err := LoadTeamStore(s.path)
+	if err != nil {
+		return err
+	}
+	s.teams = fresh.teams
+	return nil
+}
+
+// Create adds a team with owner as its first member ("" when accounts are disabled)
+func (s *TeamStore) Create(slug, name, owner string) (Team, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	name = strings.TrimSpace(name)
+	if !teamSlugPattern.MatchString(slug) {
+		return Team{}, errors.New("team short name must be 2-32 lowercase letters, digits or hyphens")
+	}
+	if name == "" {
+		name = slug
+	}
+
+	team := &Team{
+		Slug:      slug,
+		Name:      name,
+		Members:   make([]TeamMember, 0),
+		CreatedAt: time.Now().UTC(),
+	}
+	if owner != "" {
+		team.Members = append(team.Members, TeamMember{Username: owner, Role: RoleOwner})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.teams[slug]; exists {
+		return Team{}, fmt.Errorf("team %q already exists", slug)
+	}
+	s.teams[slug] = team
+
+	return copyTeam(team), s.save()
+}
+
+// Get returns a team by slug
+func (s *TeamStore) Get(slug string) (Team, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	team, exists := s.teams[slug]
+	if !exists {
+		return Team{}, false
+	}
+	return copyTeam(team), true
+}
+
+// List returns the teams username belongs to by name, or every team when username is ""
+func (s *TeamStore) List(username string) []Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Team, 0)
+	for _, team := range s.teams {
+		if username == "" || team.Role(username) != "" {
+			list = append(list, copyTeam(team))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+
+	return list
+}
+
+// SetMember adds username to a team or changes their role
+// A team always keeps at least one owner
+func (s *TeamStore) SetMember(slug, username, role string) error {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" {
+		return errors.New("please enter a username")
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	team, exists := s.teams[slug]
+	if !exists {
+		return fmt.Errorf("team %q does not exist", slug)
+	}
+
+	for i, member := range team.Members {
+		if member.Username == username {
+			if member.Role == RoleOwner && role != RoleOwner && team.owners() == 1 {
+				return errors.New("a team needs at least one owner")
+			}
+			team.Members[i].Role = role
+			return s.save()
+		}
+	}
+	team.Members = append(team.Members, TeamMember{Username: username, Role: role})
+	sort.Slice(team.Members, func(i, j int) bool {
+		return team.Members[i].Username < team.Members[j].Username
+	})
+
+	return s.save()
+}
+
+// RemoveMember takes username out of a team, reporting whether they were in it
+func (s *TeamStore) RemoveMember(slug, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	team, exists := s.teams[slug]
+	if !exists {
+		return false, nil
+	}
+	for i, member := range team.Members {
+		if member.Username == username {
+			if member.Role == RoleOwner && team.owners() == 1 {
+				return false, errors.New("a team needs at least one owner")
+			}
+			team.Members = append(team.Members[:i], team.Members[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// RemoveUser takes username out of every team, e.g. when their account is deleted
+// Teams they solely owned keep going without an owner until an admin adds one
+func (s *TeamStore) RemoveUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, team := range s.teams {
+		for i, member := range team.Members {
+			if member.Username == username {
+				team.Members = append(team.Members[:i], team.Members[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// SetAlertEmails replaces the addresses that get the team's summary emails
+func (s *TeamStore) SetAlertEmails(slug string, emails []string) error {
+	valid := make([]string, 0, len(emails))
+	for _, email := range emails {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			return fmt.Errorf("invalid email address %q", email)
+		}
+		valid = append(valid, address.Address)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	team, exists := s.teams[slug]
+	if !exists {
+		return fmt.Errorf("team %q does not exist", slug)
+	}
+	team.AlertEmails = valid
+
+	return s.save()
+}
+
+// Delete removes a team, reporting whether it existed
+// The caller should also release the team's domains from the watchlist
+func (s *TeamStore) Delete(slug string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.teams[slug]; !exists {
+		return false, nil
+	}
+	delete(s.teams, slug)
+
+	return true, s.save()
+}
+
+// owners counts the team's owners
+func (t *Team) owners() int {
+	count := 0
+	for _, member := range t.Members {
+		if member.Role == RoleOwner {
+			count++
+		}
+	}
+	return count
+}
+
+// copyTeam returns a copy of team that callers can use without holding the lock
+func copyTeam(team *Team) Team {
+	copied := *team
+	copied.Members = append([]TeamMember{}, team.Members...)
+	copied.AlertEmails = append([]string(nil), team.AlertEmails...)
+	return copied
+}
+
+// save writes the teams to disk
+// Callers must hold s.mu
+func (s *TeamStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	teams := make([]*Team, 0, len(s.teams))
+	for _, team := range s.teams {
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Slug < teams[j].Slug
+	})
+
+	content, err := json.MarshalIndent(teams, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode teams: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written file
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save teams: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to save teams: %w", err)
+	}
+
+	return nil
+}
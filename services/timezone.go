@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateTimeLayout is how pages show a time, with the zone it's shown in
+const DateTimeLayout = "2006-01-02 15:04 MST"
+
+// CommonTimezones are suggested when picking a timezone; any IANA name works
+var CommonTimezones = []string{
+	"UTC",
+	"America/Los_Angeles", "America/Denver", "America/Chicago", "America/New_York", "America/Sao_Paulo",
+	"Europe/London", "Europe/Paris", "Europe/Berlin", "Europe/Helsinki", "Europe/Moscow",
+	"Asia/Dubai", "Asia/Kolkata", "Asia/Singapore", "Asia/Shanghai", "Asia/Tokyo",
+	"Australia/Sydney", "Pacific/Auckland",
+}
+
+// LoadTimezone returns the location for an IANA zone name such as "Europe/Berlin"; empty is UTC
+// The server's own zone ("Local") is refused, since it says nothing about the visitor
+func LoadTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return location, nil
+}
+
+// relativeUnits are the units relative times are counted in, each used for spans shorter than upTo
+// Messages are singular then plural, in the default language for Translate
+var relativeUnits = []struct {
+	upTo   time.Duration
+	size   time.Duration
+	future [2]string
+	past   [2]string
+}{
+	{time.Hour, time.Minute, [2]string{"in 1 minute", "in %d minutes"}, [2]string{"1 minute ago", "%d minutes ago"}},
+	{48 * time.Hour, time.Hour, [2]string{"in 1 hour", "in %d hours"}, [2]string{"1 hour ago", "%d hours ago"}},
+	{60 * 24 * time.Hour, 24 * time.Hour, [2]string{"in 1 day", "in %d days"}, [2]string{"1 day ago", "%d days ago"}},
+	{2 * 365 * 24 * time.Hour, 30 * 24 * time.Hour, [2]string{"in 1 month", "in %d months"}, [2]string{"1 month ago", "%d months ago"}},
+	{0, 365 * 24 * time.Hour, [2]string{"in 1 year", "in %d years"}, [2]string{"1 year ago", "%d years ago"}},
+}
+
+// RelativeTime says when t is from now in a language, e.g. "in 23 days" or "3 hours ago"
+// Counts are rounded down, so a certificate expiring in 23 days and 20 hours is "in 23 days"
+func RelativeTime(lang string, t, now time.Time) string {
+	d := t.Sub(now)
+	future := d > 0
+	if !future {
+		d = -d
+	}
+	if d < time.Minute {
+		return Translate(lang, "just now")
+	}
+
+	for _, unit := range relativeUnits {
+		if unit.upTo != 0 && d >= unit.upTo {
+			continue
+		}
+		messages := unit.past
+		if future {
+			messages = unit.future
+		}
+		count := int(d / unit.size)
+		if count == 1 {
+			return Translate(lang, messages[0])
+		}
+		return Translate(lang, messages[1], count)
+	}
+	return ""
+}
+
+// RelativeExpiry says when something expires or expired in a language, e.g. "expires in 23 days"
+func RelativeExpiry(lang string, expiry, now time.Time) string {
+	if expiry.After(now) {
+		return Translate(lang, "expires %s", RelativeTime(lang, expiry, now))
+	}
+	return Translate(lang, "expired %s", RelativeTime(lang, expiry, now))
+}
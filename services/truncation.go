@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// ResultLimit is the most rows crt.sh sends for one search, see pkg/ctsearch
+const ResultLimit = ctsearch.ResultLimit
+
+// FillCurrentCertificates adds the domain's unexpired certificates missing from a truncated search's results
+// crt.sh can't split a search by date, but its unexpired certificates alone are a much smaller answer; any
+// that the full answer lacked also confirm it was cut short. It returns the results and how many were added
+func FillCurrentCertificates(ctx context.Context, domain string, certs []Certificate) ([]Certificate, int, error) {
+	var current []Certificate
+	err := callUpstream(ctx, crtshSearchBreaker, func() (err error) {
+		current, err = ctsearch.FetchCurrentCertificates(ctx, domain)
+		return err
+	})
+	current, _, err = partialResults(current, err)
+	if err != nil {
+		return certs, 0, err
+	}
+
+	seen := make(map[int64]bool, len(certs))
+	for _, cert := range certs {
+		seen[cert.ID] = true
+	}
+	added := 0
+	for _, cert := range current {
+		if !seen[cert.ID] {
+			seen[cert.ID] = true
+			certs = append(certs, cert)
+			added++
+		}
+	}
+	return certs, added, nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Distrust is a decision by the browser root programs to stop trusting a CA's TLS certificates
+type Distrust struct {
+	CA            string    `json:"ca"`
+	Organizations []string  `json:"-"`                     // Issuer organizations (O=) on its certificates
+	IssuedAfter   time.Time `json:"issuedAfter,omitempty"` // Only certificates issued after this are distrusted; zero for all
+	Since         time.Time `json:"since"`                 // When browsers started rejecting them
+	Reason        string    `json:"reason"`
+}
+
+// distrusts are the root program decisions certificates are checked against, as announced by
+// Chrome, Mozilla and Apple; certificates a CA issued under other names before or after aren't covered
+var distrusts = []Distrust{
+	{
+		CA:            "DigiNotar",
+		Organizations: []string{"DigiNotar"},
+		Since:         date(2011, 9, 1),
+		Reason:        "Removed from every root store after it was breached and issued fraudulent certificates",
+	},
+	{
+		CA:            "CNNIC",
+		Organizations: []string{"China Internet Network Information Center", "CNNIC"},
+		IssuedAfter:   date(2015, 4, 1),
+		Since:         date(2015, 4, 1),
+		Reason:        "Distrusted after an intermediate it issued was used to impersonate Google",
+	},
+	{
+		CA:            "WoSign and StartCom",
+		Organizations: []string{"WoSign CA Limited", "StartCom Ltd.", "StartCom Ltd"},
+		Since:         date(2017, 9, 5),
+		Reason:        "Distrusted for backdating certificates and concealing the acquisition of StartCom",
+	},
+	{
+		CA:            "Symantec (legacy PKI: Symantec, VeriSign, GeoTrust, Thawte, RapidSSL)",
+		Organizations: []string{"Symantec Corporation", "VeriSign, Inc.", "GeoTrust Inc.", "GeoTrust, Inc.", "thawte, Inc."},
+		Since:         date(2018, 10, 16),
+		Reason:        "Distrusted for repeated misissuance; DigiCert took over and reissued from its own roots",
+	},
+	{
+		CA:            "Camerfirma",
+		Organizations: []string{"AC Camerfirma S.A."},
+		Since:         date(2021, 4, 13),
+		Reason:        "Distrusted for a long series of compliance failures",
+	},
+	{
+		CA:            "TrustCor",
+		Organizations: []string{"TrustCor Systems S. de R.L."},
+		Since:         date(2022, 11, 30),
+		Reason:        "Removed over its ties to a company distributing spyware",
+	},
+	{
+		CA:            "Entrust",
+		Organizations: []string{"Entrust, Inc.", "Entrust Limited", "AffirmTrust"},
+		IssuedAfter:   date(2024, 11, 11),
+		Since:         date(2024, 11, 12),
+		Reason:        "Distrusted for a pattern of compliance failures; certificates issued before the cutoff stay trusted until they expire",
+	},
+	{
+		CA:            "Chunghwa Telecom",
+		Organizations: []string{"Chunghwa Telecom Co., Ltd."},
+		IssuedAfter:   date(2025, 7, 31),
+		Since:         date(2025, 8, 1),
+		Reason:        "Distrusted for a pattern of compliance failures; certificates issued before the cutoff stay trusted until they expire",
+	},
+	{
+		CA:            "NetLock",
+		Organizations: []string{"NetLock Kft.", "NETLOCK Ltd."},
+		IssuedAfter:   date(2025, 7, 31),
+		Since:         date(2025, 8, 1),
+		Reason:        "Distrusted for a pattern of compliance failures; certificates issued before the cutoff stay trusted until they expire",
+	},
+}
+
+// Cutoff is when browsers stopped accepting new certificates from the CA: IssuedAfter, or Since when
+// every certificate was distrusted
+func (d Distrust) Cutoff() time.Time {
+	if !d.IssuedAfter.IsZero() {
+		return d.IssuedAfter
+	}
+	return d.Since
+}
+
+// date is midnight UTC on a day, for the distrust table
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// IssuerTrust is an issuer of some of a domain's certificates that browsers no longer trust
+type IssuerTrust struct {
+	Issuer       string   `json:"issuer"` // Display name
+	Distrust     Distrust `json:"distrust"`
+	Certificates int      `json:"certificates"` // The domain's certificates it covers
+	Active       int      `json:"active"`       // Of those, how many haven't expired, and so fail in browsers now
+	IssuedAfter  int      `json:"issuedAfter"`  // Of those, how many were issued after the cutoff
+}
+
+// DistrustAnomaly is a certificate issued after browsers stopped accepting its issuer's new certificates,
+// so it never worked in them; a CA still issuing then, or a site still buying from it, is worth a look
+type DistrustAnomaly struct {
+	CommonName   string    `json:"commonName"`
+	SerialNumber string    `json:"serialNumber"`
+	Issuer       string    `json:"issuer"` // Display name
+	NotBefore    time.Time `json:"notBefore"`
+	Active       bool      `json:"active"`
+	Distrust     Distrust  `json:"distrust"`
+}
+
+// IssuerDistrust returns the distrust covering a certificate from an issuer issued at notBefore, or nil
+func IssuerDistrust(issuerName string, notBefore time.Time) *Distrust {
+	org := issuerOrganization(issuerName)
+	if org == "" {
+		return nil
+	}
+	for i, distrust := range distrusts {
+		if !distrust.IssuedAfter.IsZero() && !notBefore.After(distrust.IssuedAfter) {
+			continue
+		}
+		for _, name := range distrust.Organizations {
+			if strings.EqualFold(org, name) {
+				return &distrusts[i]
+			}
+		}
+	}
+	return nil
+}
+
+// IssuedAfterDistrust returns the distrust a certificate from an issuer issued at notBefore came after, or nil
+func IssuedAfterDistrust(issuerName string, notBefore time.Time) *Distrust {
+	distrust := IssuerDistrust(issuerName, notBefore)
+	if distrust == nil || !notBefore.After(distrust.Cutoff()) {
+		return nil
+	}
+	return distrust
+}
+
+// DistrustAnomalies lists a domain's certificates issued after their issuer's distrust cutoff, newest first
+func DistrustAnomalies(groups []CertificateGroup, now time.Time) []DistrustAnomaly {
+	anomalies := make([]DistrustAnomaly, 0)
+	for _, group := range groups {
+		distrust := IssuedAfterDistrust(group.IssuerName, group.NotBeforeTime)
+		if distrust == nil {
+			continue
+		}
+		anomalies = append(anomalies, DistrustAnomaly{
+			CommonName:   group.CommonName,
+			SerialNumber: group.SerialNumber,
+			Issuer:       ctsearch.IssuerDisplayName(group.IssuerName),
+			NotBefore:    group.NotBeforeTime,
+			Active:       isActive(group, now),
+			Distrust:     *distrust,
+		})
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		return anomalies[i].NotBefore.After(anomalies[j].NotBefore)
+	})
+	return anomalies
+}
+
+// DistrustedIssuers lists the issuers of a domain's certificates that browsers no longer trust, with how many
+// certificates each covers, those with active certificates first
+func DistrustedIssuers(groups []CertificateGroup, now time.Time) []IssuerTrust {
+	byIssuer := make(map[string]*IssuerTrust)
+	for _, group := range groups {
+		distrust := IssuerDistrust(group.IssuerName, group.NotBeforeTime)
+		if distrust == nil {
+			continue
+		}
+		issuer := ctsearch.IssuerDisplayName(group.IssuerName)
+		trust, ok := byIssuer[issuer]
+		if !ok {
+			trust = &IssuerTrust{Issuer: issuer, Distrust: *distrust}
+			byIssuer[issuer] = trust
+		}
+		trust.Certificates++
+		if isActive(group, now) {
+			trust.Active++
+		}
+		if group.NotBeforeTime.After(distrust.Cutoff()) {
+			trust.IssuedAfter++
+		}
+	}
+
+	issuers := make([]IssuerTrust, 0, len(byIssuer))
+	for _, trust := range byIssuer {
+		issuers = append(issuers, *trust)
+	}
+	sort.Slice(issuers, func(i, j int) bool {
+		if issuers[i].Active != issuers[j].Active {
+			return issuers[i].Active > issuers[j].Active
+		}
+		return issuers[i].Issuer < issuers[j].Issuer
+	})
+	return issuers
+}
+
+// issuerOrganization returns the O= value of a distinguished name as crt.sh writes it, where values
+// containing commas are quoted, e.g. `C=US, O="thawte, Inc.", CN=thawte SSL CA - G2`
+func issuerOrganization(dn string) string {
+	for rest := dn; rest != ""; {
+		rest = strings.TrimLeft(rest, ", ")
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			return ""
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return ""
+			}
+			rest = value[end+2:]
+			value = value[1 : end+1]
+		} else if comma := strings.Index(value, ", "); comma >= 0 {
+			rest = value[comma:]
+			value = value[:comma]
+		} else {
+			rest = ""
+		}
+		if strings.TrimSpace(key) == "O" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// maxRetryWait is the longest a request waits for crt.sh to take requests again; a longer Retry-After
+// is passed on to the user, whose page retries by itself
+const maxRetryWait = 10 * time.Second
+
+// upstreamBackoff holds crt.sh's last rate limit or maintenance answer until its Retry-After has passed,
+// so requests made meanwhile wait (or fail) without adding to the load
+var upstreamBackoff struct {
+	sync.Mutex
+	until  time.Time
+	answer ctsearch.StatusError
+}
+
+// callUpstream runs a crt.sh request once crt.sh will take it and the source's breaker allows, and
+// retries it once when the answer is a rate limit or maintenance with a Retry-After short enough to wait out
+func callUpstream(ctx context.Context, breaker *Breaker, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := waitForUpstream(ctx); err != nil {
+			return err
+		}
+		// Before the breaker, so a half-open trial isn't held up queuing
+		if err := waitForCrtsh(ctx); err != nil {
+			return err
+		}
+		if err := breaker.Allow(time.Now()); err != nil {
+			return err
+		}
+		err := request()
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded) && !callerShortened(ctx):
+			// Out of the longest time a search may take, so crt.sh hung
+			breaker.Record(fmt.Errorf("%w: %w", ErrUpstreamTimeout, ctx.Err()), time.Now())
+		case ctx.Err() != nil:
+			// The caller gave up, or asked for less time than a search may take, which says nothing about the source
+			breaker.Record(ctx.Err(), time.Now())
+		default:
+			breaker.Record(err, time.Now())
+		}
+		var status *ctsearch.StatusError
+		if !errors.As(err, &status) || status.RetryAfter <= 0 ||
+			!errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrUpstreamMaintenance) {
+			return err
+		}
+		deferUpstream(*status)
+		if attempt > 0 || status.RetryAfter > maxRetryWait {
+			return err
+		}
+	}
+}
+
+// shortenedTimeoutKey marks a context whose deadline the caller chose, shorter than a search may take
+type shortenedTimeoutKey struct{}
+
+// WithSearchTimeout gives ctx a search's timeout; shortened says the caller asked for less than the server
+// allows, so running out of time is the caller giving up rather than crt.sh failing and doesn't count
+// against its breaker
+func WithSearchTimeout(ctx context.Context, timeout time.Duration, shortened bool) (context.Context, context.CancelFunc) {
+	if shortened {
+		ctx = context.WithValue(ctx, shortenedTimeoutKey{}, true)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// callerShortened reports whether ctx's deadline came from a timeout the caller shortened
+func callerShortened(ctx context.Context) bool {
+	shortened, _ := ctx.Value(shortenedTimeoutKey{}).(bool)
+	return shortened
+}
+
+// deferUpstream holds back requests until crt.sh's Retry-After has passed
+func deferUpstream(answer ctsearch.StatusError) {
+	upstreamBackoff.Lock()
+	defer upstreamBackoff.Unlock()
+
+	if until := time.Now().Add(answer.RetryAfter); until.After(upstreamBackoff.until) {
+		upstreamBackoff.until = until
+		upstreamBackoff.answer = answer
+	}
+}
+
+// waitForUpstream waits out a Retry-After crt.sh gave, or fails straight away with crt.sh's answer
+// when it's longer than maxRetryWait
+func waitForUpstream(ctx context.Context) error {
+	upstreamBackoff.Lock()
+	wait := time.Until(upstreamBackoff.until)
+	answer := upstreamBackoff.answer
+	upstreamBackoff.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRetryWait {
+		answer.RetryAfter = wait.Round(time.Second)
+		return fmt.Errorf("not sent, waiting for crt.sh: %w", &answer)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctsearch.RequestError(ctx.Err())
+	}
+}
+
+// RetryAfter is how long crt.sh asked us to wait before trying again, or its breaker or request budget
+// will keep it from being tried; zero if none
+func RetryAfter(err error) time.Duration {
+	var status *ctsearch.StatusError
+	var open *BreakerOpenError
+	var budget *BudgetError
+	switch {
+	case errors.As(err, &status):
+		return status.RetryAfter
+	case errors.As(err, &open):
+		return open.RetryAfter
+	case errors.As(err, &budget):
+		return budget.RetryAfter
+	}
+	return 0
+}
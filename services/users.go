@@ -0,0 +1,292 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength is the shortest password accepted for an account
+const minPasswordLength = 10
+
+// usernamePattern is what a username may look like
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,31}$`)
+
+// dummyHash is compared against when a username doesn't exist, so failed logins take the same time either way
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// User is a local account, or one created on first single sign-on login
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"` // bcrypt; empty for SSO accounts, which can't log in with a password
+	Admin        bool      `json:"admin"`        // Can manage other accounts
+	SSO          bool      `json:"sso,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// UserStore holds the local accounts
+// It is safe for concurrent use and is saved to a JSON file after every change
+type UserStore struct {
+	mu    sync.Mutex
+	path  string // Empty means keep everything in memory only
+	users map[string]*User
+}
+
+// LoadUserStore reads the accounts from path, starting empty if the file doesn't exist yet
+func LoadUserStore(path string) (*UserStore, error) {
+	s := &UserStore{
+		path:  path,
+		users: make(map[string]*User),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %w", err)
+	}
+
+	var users []*User
+	if err := json.Unmarshal(content, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %w", err)
+	}
+	for _, user := range users {
+		s.users[user.Username] = user
+	}
+
+	return s, nil
+}
+
+// Reload re-reads the users file, e.g. after it was edited by hand
+// Nothing changes if the file can't be read or parsed
+func (s *UserStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return nil
+	}
+	fresh, err := LoadUserStore(s.path)
+	if err != nil {
+		return err
+	}
+	s.users = fresh.users
+	return nil
+}
+
+// Count returns how many accounts exist
+func (s *UserStore) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.users)
+}
+
+// Create adds an account
+func (s *UserStore) Create(username, password string, admin bool) error {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username must be 2-32 lowercase letters, digits, dots, hyphens or underscores")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[username]; exists {
+		return fmt.Errorf("user %q already exists", username)
+	}
+	s.users[username] = &User{
+		Username:     username,
+		PasswordHash: hash,
+		Admin:        admin,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	return s.save()
+}
+
+// UpsertSSO creates or updates the account for someone who logged in through single sign-on
+// The admin flag follows the identity provider's groups on every login
+// A local account with the same name is never taken over
+func (s *UserStore) UpsertSSO(username string, admin bool) (User, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" || len(username) > 254 {
+		return User{}, errors.New("invalid single sign-on username")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[username]
+	if exists && !user.SSO {
+		return User{}, fmt.Errorf("a local account named %q already exists", username)
+	}
+	if exists && user.Admin == admin {
+		return *user, nil
+	}
+	if !exists {
+		user = &User{
+			Username:  username,
+			SSO:       true,
+			CreatedAt: time.Now().UTC(),
+		}
+		s.users[username] = user
+	}
+	user.Admin = admin
+
+	return *user, s.save()
+}
+
+// HasLocalUsers reports whether any account can log in with a password
+func (s *UserStore) HasLocalUsers() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if !user.SSO {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate checks a username and password, returning the account if they match
+func (s *UserStore) Authenticate(username, password string) (User, bool) {
+	username = strings.ToLower(strings.TrimSpace(username))
+
+	s.mu.Lock()
+	user, exists := s.users[username]
+	hash := dummyHash
+	if exists {
+		hash = []byte(user.PasswordHash)
+	}
+	s.mu.Unlock()
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !exists || user.SSO {
+		return User{}, false
+	}
+	return *user, true
+}
+
+// Get returns an account by username
+func (s *UserStore) Get(username string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return User{}, false
+	}
+	return *user, true
+}
+
+// SetPassword replaces an account's password
+func (s *UserStore) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("user %q does not exist", username)
+	}
+	if user.SSO {
+		return errors.New("single sign-on accounts don't have a password here")
+	}
+	user.PasswordHash = hash
+
+	return s.save()
+}
+
+// Delete removes an account, reporting whether it existed
+func (s *UserStore) Delete(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[username]; !exists {
+		return false, nil
+	}
+	delete(s.users, username)
+
+	return true, s.save()
+}
+
+// List returns the accounts sorted by username
+func (s *UserStore) List() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		list = append(list, *user)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+
+	return list
+}
+
+// hashPassword checks the password policy and hashes it with bcrypt
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// save writes the accounts to disk
+// Callers must hold s.mu
+func (s *UserStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	content, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode users: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written file
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save users: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to save users: %w", err)
+	}
+
+	return nil
+}
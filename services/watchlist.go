@@ -0,0 +1,615 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxStoredAlerts caps how many alerts the watchlist keeps (oldest are dropped first)
+const maxStoredAlerts = 1000
+
+// ErrAlertNotFound means there is no such alert, or it's for a domain the user doesn't watch
+var ErrAlertNotFound = errors.New("alert not found")
+
+// ErrSharedDomain means a user tried to stop watching a domain that's shared with everyone
+var ErrSharedDomain = errors.New("this domain is shared with everyone, so can only be removed with accounts disabled")
+
+// WatchedDomain is a domain we keep checking for new CT activity
+type WatchedDomain struct {
+	Domain      string               `json:"domain"`
+	AddedAt     time.Time            `json:"addedAt"`
+	LastChecked time.Time            `json:"lastChecked"`
+	LatestEntry time.Time            `json:"latestEntry"`     // When the newest certificate seen was logged, so later ones are new
+	KnownHosts  map[string]time.Time `json:"knownHosts"`      // Hostname -> when we first saw it
+	Users       []string             `json:"users,omitempty"` // Accounts watching it
+	Teams       []string             `json:"teams,omitempty"` // Teams it belongs to
+	// Shared domains are watched by everyone, whoever else watches them, e.g. those watched before accounts
+	Shared bool `json:"shared,omitempty"`
+}
+
+// WatchedBy reports whether username sees this domain on their own watchlist
+// Without accounts (username "") every domain is visible, and shared domains are visible to everyone
+func (d WatchedDomain) WatchedBy(username string) bool {
+	return username == "" || d.Shared || containsString(d.Users, username)
+}
+
+// unwatched reports whether nobody watches the domain any more, so it can be dropped
+func (d WatchedDomain) unwatched() bool {
+	return !d.Shared && len(d.Users) == 0 && len(d.Teams) == 0
+}
+
+// watch adds username to the domain's watchers; everyone already watches a shared domain
+func (d *WatchedDomain) watch(username string) bool {
+	if username == "" || d.Shared || containsString(d.Users, username) {
+		return false
+	}
+	d.Users = append(d.Users, username)
+	sort.Strings(d.Users)
+	return true
+}
+
+// Watchlist holds the watched domains and the alerts raised for them
+// It is safe for concurrent use and is saved to a JSON file after every change
+type Watchlist struct {
+	mu      sync.Mutex
+	path    string // Empty means keep everything in memory only
+	domains map[string]*WatchedDomain
+	alerts  []Alert
+}
+
+// watchlistFile is the on-disk format of the watchlist
+type watchlistFile struct {
+	Domains []*WatchedDomain `json:"domains"`
+	Alerts  []Alert          `json:"alerts"`
+}
+
+// LoadWatchlist reads the watchlist from path, starting empty if the file doesn't exist yet
+func LoadWatchlist(path string) (*Watchlist, error) {
+	w := &Watchlist{
+		path:    path,
+		domains: make(map[string]*WatchedDomain),
+		alerts:  make([]Alert, 0),
+	}
+	if path == "" {
+		return w, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watchlist: %w", err)
+	}
+
+	var file watchlistFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse watchlist: %w", err)
+	}
+
+	for _, watched := range file.Domains {
+		if watched.KnownHosts == nil {
+			watched.KnownHosts = make(map[string]time.Time)
+		}
+		// Domains watched before the shared flag existed were shared by having no users or teams
+		if len(watched.Users) == 0 && len(watched.Teams) == 0 {
+			watched.Shared = true
+		}
+		w.domains[watched.Domain] = watched
+	}
+	if file.Alerts != nil {
+		w.alerts = file.Alerts
+	}
+
+	return w, nil
+}
+
+// Reload re-reads the watchlist file, e.g. after it was edited by hand
+// Nothing changes if the file can't be read or parsed
+func (w *Watchlist) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.path == "" {
+		return nil
+	}
+	fresh, err := LoadWatchlist(w.path)
+	if err != nil {
+		return err
+	}
+	w.domains = fresh.domains
+	w.alerts = fresh.alerts
+	return nil
+}
+
+// Add starts watching a domain for username ("" when accounts are disabled)
+func (w *Watchlist) Add(username, domain string) error {
+	domain = NormalizeName(domain)
+	if domain == "" {
+		return errors.New("please enter a domain name")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.addLocked(username, domain, time.Now()) {
+		return nil
+	}
+
+	return w.save()
+}
+
+// AddAll starts watching several domains at once for username, returning the ones it wasn't already watching
+func (w *Watchlist) AddAll(username string, domains []string) ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	added := make([]string, 0)
+	now := time.Now()
+	for _, domain := range domains {
+		domain = NormalizeName(domain)
+		if domain != "" && w.addLocked(username, domain, now) {
+			added = append(added, domain)
+		}
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	return added, w.save()
+}
+
+// addLocked watches domain for username, reporting whether anything changed
+// Domains added without accounts (username "") are shared
+// Callers must hold w.mu
+func (w *Watchlist) addLocked(username, domain string, now time.Time) bool {
+	watched, exists := w.domains[domain]
+	if !exists {
+		watched = &WatchedDomain{
+			Domain:     domain,
+			AddedAt:    now.UTC(),
+			KnownHosts: make(map[string]time.Time),
+			Shared:     username == "",
+		}
+		w.domains[domain] = watched
+		watched.watch(username)
+		return true
+	}
+	return watched.watch(username)
+}
+
+// AddForTeam adds a domain to a team's watchlist
+func (w *Watchlist) AddForTeam(team, domain string) error {
+	domain = NormalizeName(domain)
+	if domain == "" {
+		return errors.New("please enter a domain name")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watched, exists := w.domains[domain]
+	if !exists {
+		watched = &WatchedDomain{
+			Domain:     domain,
+			AddedAt:    time.Now().UTC(),
+			KnownHosts: make(map[string]time.Time),
+		}
+		w.domains[domain] = watched
+	} else if containsString(watched.Teams, team) {
+		return nil
+	}
+	watched.Teams = append(watched.Teams, team)
+	sort.Strings(watched.Teams)
+
+	return w.save()
+}
+
+// RemoveForTeam takes a domain off a team's watchlist, reporting whether it was on it
+// The domain is dropped once no user or team watches it
+func (w *Watchlist) RemoveForTeam(team, domain string) (bool, error) {
+	domain = NormalizeName(domain)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watched, exists := w.domains[domain]
+	if !exists || !containsString(watched.Teams, team) {
+		return false, nil
+	}
+	w.releaseTeam(watched, team)
+
+	return true, w.save()
+}
+
+// RemoveTeam takes every domain off a team's watchlist, e.g. when the team is deleted
+func (w *Watchlist) RemoveTeam(team string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, watched := range w.domains {
+		if containsString(watched.Teams, team) {
+			w.releaseTeam(watched, team)
+		}
+	}
+
+	return w.save()
+}
+
+// releaseTeam removes team from a domain, dropping the domain if nobody else watches it
+// Callers must hold w.mu
+func (w *Watchlist) releaseTeam(watched *WatchedDomain, team string) {
+	remaining := make([]string, 0, len(watched.Teams))
+	for _, name := range watched.Teams {
+		if name != team {
+			remaining = append(remaining, name)
+		}
+	}
+	watched.Teams = remaining
+	if watched.unwatched() {
+		delete(w.domains, watched.Domain)
+	}
+}
+
+// SeedHosts watches domain for username (if it isn't already) and marks hosts as known,
+// so they don't raise new-subdomain alerts when they first appear in CT
+func (w *Watchlist) SeedHosts(username, domain string, hosts []string, now time.Time) error {
+	domain = NormalizeName(domain)
+	if domain == "" {
+		return errors.New("please enter a domain name")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.addLocked(username, domain, now)
+	watched := w.domains[domain]
+	for _, host := range hosts {
+		if _, known := watched.KnownHosts[host]; !known {
+			watched.KnownHosts[host] = now
+		}
+	}
+
+	return w.save()
+}
+
+// Remove stops username watching a domain, reporting whether they were watching it
+// The domain is dropped once no user or team watches it. A shared domain can't be removed by one user
+// (ErrSharedDomain); without accounts (username "") it stops being shared, and only team watchlists keep it
+func (w *Watchlist) Remove(username, domain string) (bool, error) {
+	domain = NormalizeName(domain)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watched, exists := w.domains[domain]
+	if !exists || !watched.WatchedBy(username) {
+		return false, nil
+	}
+	if watched.Shared {
+		if username != "" {
+			return false, ErrSharedDomain
+		}
+		watched.Shared = false
+	}
+
+	remaining := make([]string, 0, len(watched.Users))
+	for _, user := range watched.Users {
+		if user != username && username != "" {
+			remaining = append(remaining, user)
+		}
+	}
+	watched.Users = remaining
+	if watched.unwatched() {
+		delete(w.domains, domain)
+	}
+
+	return true, w.save()
+}
+
+// List returns a copy of every watched domain, sorted by name
+func (w *Watchlist) List() []WatchedDomain {
+	return w.ListFor("")
+}
+
+// ListFor returns a copy of the domains username watches, sorted by name
+func (w *Watchlist) ListFor(username string) []WatchedDomain {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.listLocked(func(watched *WatchedDomain) bool {
+		return watched.WatchedBy(username)
+	})
+}
+
+// Get returns a copy of one watched domain
+func (w *Watchlist) Get(domain string) (WatchedDomain, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	domain = NormalizeName(domain)
+	list := w.listLocked(func(watched *WatchedDomain) bool {
+		return watched.Domain == domain
+	})
+	if len(list) == 0 {
+		return WatchedDomain{}, false
+	}
+	return list[0], true
+}
+
+// ListForTeam returns a copy of a team's domains, sorted by name
+func (w *Watchlist) ListForTeam(team string) []WatchedDomain {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.listLocked(func(watched *WatchedDomain) bool {
+		return containsString(watched.Teams, team)
+	})
+}
+
+// listLocked copies the domains include accepts, sorted by name
+// Callers must hold w.mu
+func (w *Watchlist) listLocked(include func(*WatchedDomain) bool) []WatchedDomain {
+	list := make([]WatchedDomain, 0, len(w.domains))
+	for _, watched := range w.domains {
+		if !include(watched) {
+			continue
+		}
+		domain := *watched
+		domain.Users = append([]string(nil), watched.Users...)
+		domain.Teams = append([]string(nil), watched.Teams...)
+		// Copy the map so callers can read it without holding the lock
+		domain.KnownHosts = make(map[string]time.Time, len(watched.KnownHosts))
+		for host, seen := range watched.KnownHosts {
+			domain.KnownHosts[host] = seen
+		}
+		list = append(list, domain)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Domain < list[j].Domain
+	})
+
+	return list
+}
+
+// RecentAlerts returns up to limit alerts for the domains username watches, newest first
+func (w *Watchlist) RecentAlerts(username string, limit int) []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.recentAlertsLocked(limit, func(domain string) bool {
+		// Without accounts, alerts outlive the domains they were raised for
+		if username == "" {
+			return true
+		}
+		watched, exists := w.domains[domain]
+		return exists && watched.WatchedBy(username)
+	})
+}
+
+// RecentTeamAlerts returns up to limit alerts for a team's domains, newest first
+func (w *Watchlist) RecentTeamAlerts(team string, limit int) []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.recentAlertsLocked(limit, func(domain string) bool {
+		watched, exists := w.domains[domain]
+		return exists && containsString(watched.Teams, team)
+	})
+}
+
+// recentAlertsLocked returns up to limit alerts for domains include accepts, newest first
+// Callers must hold w.mu
+func (w *Watchlist) recentAlertsLocked(limit int, include func(domain string) bool) []Alert {
+	recent := make([]Alert, 0, limit)
+	for i := len(w.alerts) - 1; i >= 0 && len(recent) < limit; i-- {
+		if include(w.alerts[i].Domain) {
+			recent = append(recent, w.alerts[i])
+		}
+	}
+
+	return recent
+}
+
+// AlertsSince returns the alerts raised at or after since, oldest first
+func (w *Watchlist) AlertsSince(since time.Time) []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	alerts := make([]Alert, 0)
+	for _, alert := range w.alerts {
+		if !alert.CreatedAt.Before(since) {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	return alerts
+}
+
+// RecordInventory compares a fresh inventory with what we knew about the domain,
+// raising new-subdomain alerts and remembering the new hostnames
+// The first check only records a baseline so existing hostnames don't all alert at once
+func (w *Watchlist) RecordInventory(domain string, inventory SubdomainInventory, now time.Time) ([]Alert, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watched, exists := w.domains[NormalizeName(domain)]
+	if !exists {
+		return nil, fmt.Errorf("%s is not on the watchlist", domain)
+	}
+
+	alerts := DetectNewSubdomains(watched.Domain, watched.KnownHosts, inventory, now)
+	firstCheck := watched.LastChecked.IsZero()
+
+	for _, alert := range alerts {
+		watched.KnownHosts[alert.Subject] = now
+	}
+	watched.LastChecked = now
+
+	if firstCheck {
+		alerts = alerts[:0]
+	}
+	if err := w.appendAlerts(alerts); err != nil {
+		return nil, err
+	}
+
+	return alerts, w.save()
+}
+
+// RecordCertificates returns the certificates first logged in CT since the domain's last check, and
+// remembers when the newest one was logged
+// Until a check has found some certificate, none are new, so existing ones don't all count at once
+func (w *Watchlist) RecordCertificates(domain string, groups []CertificateGroup) ([]CertificateGroup, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watched, exists := w.domains[NormalizeName(domain)]
+	if !exists {
+		return nil, fmt.Errorf("%s is not on the watchlist", domain)
+	}
+
+	fresh := make([]CertificateGroup, 0)
+	latest := watched.LatestEntry
+	for _, group := range groups {
+		logged := firstLogged(group)
+		if !watched.LatestEntry.IsZero() && logged.After(watched.LatestEntry) {
+			fresh = append(fresh, group)
+		}
+		if logged.After(latest) {
+			latest = logged
+		}
+	}
+	if latest.Equal(watched.LatestEntry) {
+		return fresh, nil
+	}
+	watched.LatestEntry = latest
+	return fresh, w.save()
+}
+
+// firstLogged is when any entry of a certificate was first logged, e.g. its precertificate
+func firstLogged(group CertificateGroup) time.Time {
+	var first time.Time
+	for _, entry := range group.Entries {
+		if first.IsZero() || entry.EntryTime.Before(first) {
+			first = entry.EntryTime
+		}
+	}
+	return first
+}
+
+// RaiseAlerts stores the alerts that weren't raised already within quiet, going by their type, domain and
+// subject, returning those
+// An alert more severe than the earlier one is raised anyway, so a limit going from warning to exceeded isn't missed
+func (w *Watchlist) RaiseAlerts(alerts []Alert, quiet time.Duration) ([]Alert, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	raised := make([]Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		repeat := false
+		for i := len(w.alerts) - 1; i >= 0 && !repeat; i-- {
+			earlier := w.alerts[i]
+			repeat = earlier.Type == alert.Type && earlier.Domain == alert.Domain && earlier.Subject == alert.Subject &&
+				alert.CreatedAt.Sub(earlier.CreatedAt) < quiet && !escalates(earlier, alert)
+		}
+		if !repeat {
+			raised = append(raised, alert)
+		}
+	}
+	if len(raised) == 0 {
+		return raised, nil
+	}
+	if err := w.appendAlerts(raised); err != nil {
+		return nil, err
+	}
+	return raised, w.save()
+}
+
+// escalates reports whether alert is more severe than earlier; alerts without a severity never escalate
+func escalates(earlier, alert Alert) bool {
+	if earlier.Severity == "" || alert.Severity == "" {
+		return false
+	}
+	return severityRank[alert.Severity] < severityRank[earlier.Severity]
+}
+
+// AcknowledgeAlert marks an alert for a domain username watches as seen to, returning it
+// Acknowledging it again keeps who acknowledged it first
+func (w *Watchlist) AcknowledgeAlert(username, id string, now time.Time) (Alert, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range w.alerts {
+		alert := &w.alerts[i]
+		if id == "" || alert.ID != id {
+			continue
+		}
+		if watched, exists := w.domains[alert.Domain]; username != "" && (!exists || !watched.WatchedBy(username)) {
+			return Alert{}, ErrAlertNotFound
+		}
+		if alert.AcknowledgedAt != nil {
+			return *alert, nil
+		}
+		now = now.UTC()
+		alert.AcknowledgedBy = username
+		alert.AcknowledgedAt = &now
+		return *alert, w.save()
+	}
+	return Alert{}, ErrAlertNotFound
+}
+
+// appendAlerts gives alerts their IDs and stores them, dropping the oldest beyond maxStoredAlerts
+// Callers must hold w.mu
+func (w *Watchlist) appendAlerts(alerts []Alert) error {
+	for i := range alerts {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return fmt.Errorf("failed to store alerts: %w", err)
+		}
+		alerts[i].ID = hex.EncodeToString(id)
+	}
+	w.alerts = append(w.alerts, alerts...)
+	if len(w.alerts) > maxStoredAlerts {
+		w.alerts = w.alerts[len(w.alerts)-maxStoredAlerts:]
+	}
+	return nil
+}
+
+// save writes the watchlist to disk
+// Callers must hold w.mu
+func (w *Watchlist) save() error {
+	if w.path == "" {
+		return nil
+	}
+
+	file := watchlistFile{Alerts: w.alerts}
+	for _, watched := range w.domains {
+		file.Domains = append(file.Domains, watched)
+	}
+	sort.Slice(file.Domains, func(i, j int) bool {
+		return file.Domains[i].Domain < file.Domains[j].Domain
+	})
+
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode watchlist: %w", err)
+	}
+
+	// Write to a temp file first so a crash can't leave a half-written watchlist
+	tmpPath := w.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("failed to save watchlist: %w", err)
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return fmt.Errorf("failed to save watchlist: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRaiseAlertsRateLimitEscalation(t *testing.T) {
+	const quiet = 7 * 24 * time.Hour
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	usage := func(severity string) LetsEncryptUsage {
+		return LetsEncryptUsage{Findings: []Finding{{Severity: severity, Check: "letsencrypt-rate-limit", Subject: "example.com", Message: severity}}}
+	}
+
+	tests := []struct {
+		name      string
+		first     string // Severity of the limit's first alert
+		then      string // Severity at the later check
+		after     time.Duration
+		wantRaise bool
+	}{
+		{name: "warning again", first: SeverityWarning, then: SeverityWarning, after: time.Hour, wantRaise: false},
+		{name: "warning to exceeded", first: SeverityWarning, then: SeverityCritical, after: time.Hour, wantRaise: true},
+		{name: "exceeded again", first: SeverityCritical, then: SeverityCritical, after: time.Hour, wantRaise: false},
+		{name: "exceeded to warning", first: SeverityCritical, then: SeverityWarning, after: time.Hour, wantRaise: false},
+		{name: "warning after the quiet period", first: SeverityWarning, then: SeverityWarning, after: quiet, wantRaise: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := LoadWatchlist("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			raised, err := w.RaiseAlerts(RateLimitAlerts("example.com", usage(tt.first), start), quiet)
+			if err != nil || len(raised) != 1 {
+				t.Fatalf("first RaiseAlerts() = %v, %v, want one alert", raised, err)
+			}
+
+			raised, err = w.RaiseAlerts(RateLimitAlerts("example.com", usage(tt.then), start.Add(tt.after)), quiet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(raised) == 1; got != tt.wantRaise {
+				t.Errorf("RaiseAlerts() %s later = %v, want raised %v", tt.after, raised, tt.wantRaise)
+			}
+			if tt.wantRaise && raised[0].Severity != tt.then {
+				t.Errorf("Severity = %q, want %q", raised[0].Severity, tt.then)
+			}
+		})
+	}
+}
+
+func TestRaiseAlertsWithoutSeverity(t *testing.T) {
+	w, err := LoadWatchlist("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	stored := Alert{Type: AlertRateLimit, Domain: "example.com", Subject: "example.com", CreatedAt: now}
+	if _, err := w.RaiseAlerts([]Alert{stored}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	critical := stored
+	critical.Severity = SeverityCritical
+	critical.CreatedAt = now.Add(time.Minute)
+	if raised, _ := w.RaiseAlerts([]Alert{critical}, time.Hour); len(raised) != 0 {
+		t.Errorf("RaiseAlerts() = %v, want an alert stored without a severity to still hold off repeats", raised)
+	}
+}
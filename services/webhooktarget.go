@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// webhookLookupTimeout bounds resolving a webhook's host when its URL is saved
+const webhookLookupTimeout = 5 * time.Second
+
+// ErrWebhookAddress is a webhook whose host is, or resolves to, an address on the server's own network
+var ErrWebhookAddress = errors.New("webhook URL must not point at a private, loopback or link-local address")
+
+// CheckWebhookURL resolves a webhook URL's host and refuses it when any of its addresses is internal, so
+// users can't have the server POST to hosts only it can reach
+// Delivery checks again as it connects (WebhookDialControl), since DNS can change after the URL is saved
+func CheckWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if internalAddress(ip) {
+			return ErrWebhookAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("could not resolve webhook host %s", host)
+	}
+	for _, addr := range addrs {
+		if internalAddress(addr.IP) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// WebhookDialControl is a net.Dialer Control hook that refuses connections to internal addresses, checked
+// on the address actually dialled so a host that resolves differently at delivery is still caught
+func WebhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalAddress(ip) {
+		return ErrWebhookAddress
+	}
+	return nil
+}
+
+// internalAddress reports whether ip is loopback, private, link-local, unspecified or multicast
+func internalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/ctsearch"
+)
+
+// Domain statuses for the embeddable widget
+const (
+	DomainOK       = "ok"       // A TLS certificate is valid, and not expiring within expiringSoonDays
+	DomainExpiring = "expiring" // The longest-lasting valid TLS certificate expires within expiringSoonDays
+	DomainExpired  = "expired"  // TLS certificates were issued, but none is valid now
+	DomainNone     = "none"     // No TLS certificates are logged
+)
+
+// DomainStatus is a domain's certificate status at a glance, for embedding in wikis and dashboards
+type DomainStatus struct {
+	Domain     string     `json:"domain"`
+	Status     string     `json:"status"`
+	Active     int        `json:"active"`               // Currently valid TLS certificates
+	CommonName string     `json:"commonName,omitempty"` // Of the valid certificate that lasts longest
+	Issuer     string     `json:"issuer,omitempty"`
+	NotAfter   *time.Time `json:"notAfter,omitempty"`
+	DaysLeft   int        `json:"daysLeft"` // Whole days until NotAfter; negative once the last certificate expired
+	CheckedAt  time.Time  `json:"checkedAt"`
+	Stale      bool       `json:"stale,omitempty"` // From an earlier check, because crt.sh couldn't be searched
+}
+
+// CheckDomainStatus summarizes a domain's TLS certificates: whether one is valid now, and when the one
+// that lasts longest expires
+func CheckDomainStatus(domain string, groups []CertificateGroup, now time.Time) DomainStatus {
+	status := DomainStatus{Domain: domain, Status: DomainNone, CheckedAt: now.UTC()}
+
+	// The valid certificate that lasts longest, or the last to expire when none is valid
+	var latestActive, latest *CertificateGroup
+	for i, group := range groups {
+		if group.Purpose != PurposeTLS {
+			continue
+		}
+		if isActive(group, now) {
+			status.Active++
+			if latestActive == nil || group.NotAfterTime.After(latestActive.NotAfterTime) {
+				latestActive = &groups[i]
+			}
+		}
+		if latest == nil || group.NotAfterTime.After(latest.NotAfterTime) {
+			latest = &groups[i]
+		}
+	}
+	if latestActive != nil {
+		latest = latestActive
+	}
+	if latest == nil {
+		return status
+	}
+
+	notAfter := latest.NotAfterTime
+	status.CommonName = latest.CommonName
+	status.Issuer = ctsearch.IssuerDisplayName(latest.IssuerName)
+	status.NotAfter = &notAfter
+	status.DaysLeft = int(notAfter.Sub(now).Hours() / 24)
+	switch {
+	case status.Active == 0:
+		status.Status = DomainExpired
+	case notAfter.Before(now.AddDate(0, 0, expiringSoonDays)):
+		status.Status = DomainExpiring
+	default:
+		status.Status = DomainOK
+	}
+	return status
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jonisgett/tsl-certificate-work/pkg/x509info"
+)
+
+// CertificateInfo is what we learn from parsing the certificate itself, see pkg/x509info
+type CertificateInfo = x509info.CertificateInfo
+
+// ChainValidation is the outcome of checking a chain the way a browser would
+type ChainValidation = x509info.ChainValidation
+
+// ChainIssue is one problem with a certificate chain
+type ChainIssue = x509info.ChainIssue
+
+// Certificate inspection and chain validation, as in pkg/x509info
+var (
+	RequiredSCTs      = x509info.RequiredSCTs
+	PreferredEntry    = x509info.PreferredEntry
+	ParseCertificates = x509info.ParseCertificates
+	ValidateChain     = x509info.ValidateChain
+)
+
+// FetchPEM downloads a single certificate from crt.sh by its ID
+// An ID crt.sh doesn't know is ErrCertificateNotFound
+// Certificates in memory or the disk cache aren't downloaded again
+func FetchPEM(ctx context.Context, id int64) ([]byte, error) {
+	cert, err := downloadCertificate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return encodePEM(cert), nil
+}
+
+// FetchCertificateInfos inspects several certificates by crt.sh ID, downloading those not cached through
+// the server's bounded, paced downloader
+// Certificates that couldn't be fetched or parsed are returned in the error map
+func FetchCertificateInfos(ctx context.Context, ids []int64) (map[int64]CertificateInfo, map[int64]error) {
+	certs, errs := downloadCertificates(ctx, ids)
+	infos := make(map[int64]CertificateInfo, len(certs))
+	for id, cert := range certs {
+		infos[id] = x509info.InspectCertificate(id, cert)
+	}
+	return infos, errs
+}
+
+// VerifyPurposes replaces the guessed purpose of each certificate that has been downloaded before with the
+// one from its extended key usages; the rest keep their guess, marked as such, as nothing is downloaded here
+func VerifyPurposes(groups []CertificateGroup) {
+	for i := range groups {
+		for _, entry := range groups[i].Entries {
+			if cert, ok := storedCertificate(entry.ID); ok {
+				groups[i].Purpose = x509info.CertificatePurpose(cert)
+				groups[i].PurposeKnown = true
+				break
+			}
+		}
+	}
+}
+
+// certificateNotFound turns x509info.ErrNotFound into ErrCertificateNotFound, leaving other errors alone
+func certificateNotFound(err error) error {
+	if errors.Is(err, x509info.ErrNotFound) {
+		return ErrCertificateNotFound
+	}
+	return err
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// hostRecordTypes are the record types whose owners are treated as hostnames
+var hostRecordTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true}
+
+// recordClasses can appear between the owner and the type
+var recordClasses = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+
+// Zone is the set of hostnames read from a BIND-style zone file
+type Zone struct {
+	Origin    string              `json:"origin"`
+	Records   int                 `json:"records"`
+	Hostnames map[string][]string `json:"hostnames"` // Name to the host record types it has
+}
+
+// ParseZoneFile reads the owner names of A, AAAA and CNAME records from a zone file
+// origin is used until the file sets $ORIGIN; names starting with "_" (SRV, DKIM, ...) are skipped
+func ParseZoneFile(r io.Reader, origin string) (Zone, error) {
+	zone := Zone{
+		Origin:    NormalizeName(origin),
+		Hostnames: make(map[string][]string),
+	}
+
+	owner := ""
+	lines, err := zoneEntries(r)
+	if err != nil {
+		return zone, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line.text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(fields[0], "$") {
+			if strings.EqualFold(fields[0], "$ORIGIN") && len(fields) > 1 {
+				zone.Origin = absoluteName(fields[1], zone.Origin)
+			}
+			// $TTL doesn't matter here; $INCLUDE and $GENERATE aren't supported
+			continue
+		}
+
+		// A line starting with whitespace continues the previous owner
+		if !line.continued {
+			if zone.Origin == "" && !strings.HasSuffix(fields[0], ".") {
+				return zone, fmt.Errorf("line %d: relative name %q but no origin; set $ORIGIN or enter the zone's domain", line.number, fields[0])
+			}
+			owner = absoluteName(fields[0], zone.Origin)
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return zone, fmt.Errorf("line %d: record has no owner name", line.number)
+		}
+
+		recordType := recordTypeField(fields)
+		if recordType == "" {
+			return zone, fmt.Errorf("line %d: no record type", line.number)
+		}
+		zone.Records++
+
+		// Out-of-zone glue isn't ours to inventory
+		if !hostRecordTypes[recordType] || !inDomain(owner, zone.Origin) || strings.HasPrefix(owner, "_") || strings.Contains(owner, "._") {
+			continue
+		}
+		if !containsString(zone.Hostnames[owner], recordType) {
+			zone.Hostnames[owner] = append(zone.Hostnames[owner], recordType)
+		}
+	}
+
+	if zone.Origin == "" {
+		return zone, errors.New("zone has no $ORIGIN; enter the zone's domain")
+	}
+	return zone, nil
+}
+
+// zoneLine is one logical zone file entry, with parentheses joined and comments removed
+type zoneLine struct {
+	number    int
+	text      string
+	continued bool // Started with whitespace, so it belongs to the previous owner
+}
+
+// zoneEntries splits a zone file into logical entries
+func zoneEntries(r io.Reader) ([]zoneLine, error) {
+	lines := make([]zoneLine, 0)
+	scanner := bufio.NewScanner(r)
+
+	var current *zoneLine
+	depth := 0
+	for number := 1; scanner.Scan(); number++ {
+		text := stripZoneComment(scanner.Text())
+		if current == nil {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			current = &zoneLine{number: number, continued: unicode.IsSpace(rune(text[0]))}
+		}
+
+		depth += strings.Count(text, "(") - strings.Count(text, ")")
+		text = strings.NewReplacer("(", " ", ")", " ").Replace(text)
+		current.text += " " + text
+
+		if depth <= 0 {
+			lines = append(lines, *current)
+			current = nil
+			depth = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read zone file: %w", err)
+	}
+	if current != nil {
+		return nil, fmt.Errorf("line %d: unbalanced parentheses", current.number)
+	}
+
+	return lines, nil
+}
+
+// stripZoneComment removes a ";" comment, ignoring semicolons inside quoted strings
+func stripZoneComment(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// recordTypeField skips the optional TTL and class and returns the record type
+func recordTypeField(fields []string) string {
+	for _, field := range fields {
+		upper := strings.ToUpper(field)
+		if recordClasses[upper] || unicode.IsDigit(rune(field[0])) {
+			continue
+		}
+		return upper
+	}
+	return ""
+}
+
+// absoluteName resolves "@" and relative names against origin
+func absoluteName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return NormalizeName(name)
+	case origin == "":
+		return NormalizeName(name)
+	default:
+		return NormalizeName(name + "." + origin)
+	}
+}
+
+// SortedHostnames returns the zone's hostnames in order
+func (z Zone) SortedHostnames() []string {
+	names := make([]string, 0, len(z.Hostnames))
+	for name := range z.Hostnames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ZoneCrossReference compares a zone's hostnames with what is visible in CT
+type ZoneCrossReference struct {
+	Origin              string     `json:"origin"`
+	Hosts               []ZoneHost `json:"hosts"`
+	WithCertificates    int        `json:"withCertificates"`
+	WithoutCertificates int        `json:"withoutCertificates"`
+	CTOnly              []string   `json:"ctOnly"` // Names in CT that aren't in the zone
+}
+
+// ZoneHost is a hostname from the zone with its CT visibility
+type ZoneHost struct {
+	Name         string    `json:"name"`
+	Types        []string  `json:"types"`
+	Certificates int       `json:"certificates"` // Certificates naming it directly
+	LastSeen     time.Time `json:"lastSeen"`
+	Covered      bool      `json:"covered"`             // A currently valid certificate covers it
+	CoveredBy    string    `json:"coveredBy,omitempty"` // The wildcard covering it, when it isn't named directly
+}
+
+// CrossReferenceZone marks which zone hostnames have CT-visible certificates and lists CT names missing from the zone
+func CrossReferenceZone(zone Zone, inventory SubdomainInventory) ZoneCrossReference {
+	result := ZoneCrossReference{
+		Origin: zone.Origin,
+		Hosts:  make([]ZoneHost, 0, len(zone.Hostnames)),
+		CTOnly: make([]string, 0),
+	}
+
+	seen := make(map[string]HostRecord)
+	for _, subdomain := range inventory.Subdomains {
+		for _, host := range subdomain.Hosts {
+			seen[host.Name] = host
+		}
+	}
+
+	for _, name := range zone.SortedHostnames() {
+		host := ZoneHost{Name: name, Types: zone.Hostnames[name]}
+		if record, ok := seen[name]; ok {
+			host.Certificates = record.Certificates
+			host.LastSeen = record.LastSeen
+			host.Covered = record.Covered
+		}
+		if host.Certificates == 0 {
+			// Only a wildcard certificate may cover it
+			for certName, record := range seen {
+				if certName != name && NameCovers(certName, name) {
+					host.CoveredBy = certName
+					host.Covered = record.Covered
+					break
+				}
+			}
+		}
+
+		if host.Certificates > 0 || host.CoveredBy != "" {
+			result.WithCertificates++
+		} else {
+			result.WithoutCertificates++
+		}
+		result.Hosts = append(result.Hosts, host)
+	}
+
+	for name := range seen {
+		if _, inZone := zone.Hostnames[name]; !inZone && !strings.HasPrefix(name, "*.") {
+			result.CTOnly = append(result.CTOnly, name)
+		}
+	}
+	sort.Strings(result.CTOnly)
+
+	return result
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// SMIMEData holds data to pass to the S/MIME template
+type SMIMEData struct {
+	Email  string
+	Report services.SMIMEReport
+	Error  string
+
+	status int // HTTP status for API responses
+}
+
+// smimeHandler lists the S/MIME certificates for an email address or domain
+func smimeHandler(w http.ResponseWriter, r *http.Request) {
+	data := SMIMEData{}
+	// Show just the form until an address is entered
+	if r.URL.Query().Has("email") {
+		data = runSMIMESearch(r.Context(), r.URL.Query())
+	}
+
+	tmpl, err := parseTemplate("smime.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiSMIMEHandler returns the S/MIME report as JSON
+func apiSMIMEHandler(w http.ResponseWriter, r *http.Request) {
+	data := runSMIMESearch(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, map[string]string{"error": data.Error})
+		return
+	}
+	writeJSON(w, data.status, data.Report)
+}
+
+// runSMIMESearch parses ?email= (an address, or @domain) and runs the search
+func runSMIMESearch(ctx context.Context, query url.Values) SMIMEData {
+	data := SMIMEData{
+		Email:  strings.TrimSpace(query.Get("email")),
+		status: http.StatusOK,
+	}
+
+	email, err := services.NormalizeEmailQuery(data.Email)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Email = email
+
+	data.Report, err = services.SearchSMIME(ctx, email, time.Now())
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+	}
+
+	return data
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// mailConfig holds the SMTP settings for summary emails
+type mailConfig struct {
+	Addr     string // host:port of the SMTP server
+	Username string // Optional - no authentication when empty; the password is smtpPassword
+	From     string
+	To       []string // The distribution list
+}
+
+// summaryMail is where scheduled summaries are sent (nil disables sending)
+var summaryMail *mailConfig
+
+// smtpPassword is the SMTP password, from SMTP_PASSWORD or -settings-file, never a flag; replaced on reload
+var smtpPassword struct {
+	sync.Mutex
+	value string
+}
+
+// runSummaryScheduler emails a watchlist summary covering each interval
+// The whole watchlist goes to the distribution list, and each team's domains to the team's alert emails
+func runSummaryScheduler(interval time.Duration) {
+	since := time.Now()
+	for {
+		time.Sleep(interval)
+		now := time.Now()
+		sendTeamSummaries(now.Add(-interval), now)
+		if len(summaryMail.To) == 0 {
+			since = now
+			continue
+		}
+		if err := sendSummary(since, now); err != nil {
+			log.Printf("summary: %v", err)
+			auditSystemAction("summary.failed", "", err.Error())
+			continue
+		}
+		auditSystemAction("summary.sent", strings.Join(summaryMail.To, ", "), since.Format("2006-01-02")+" to "+now.Format("2006-01-02"))
+		since = now
+	}
+}
+
+// summaryHandler previews the summary email for the last week in the browser (?team= for a team's)
+func summaryHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var summary services.WatchlistSummary
+	if slug := r.URL.Query().Get("team"); slug != "" {
+		team, ok := teams.Get(slug)
+		if !ok || teamRole(r, team) == "" {
+			http.NotFound(w, r)
+			return
+		}
+		summary = buildSummary(watchlist.ListForTeam(team.Slug), now.AddDate(0, 0, -7), now)
+		summary.Team = team.Name
+	} else {
+		summary = buildSummary(watchlist.List(), now.AddDate(0, 0, -7), now)
+	}
+
+	html, err := renderSummary(summary)
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(html)
+}
+
+// buildSummary fetches each domain and collects its digest for the period
+func buildSummary(domains []services.WatchedDomain, since, now time.Time) services.WatchlistSummary {
+	summary := services.WatchlistSummary{
+		Since:   since,
+		Until:   now,
+		Domains: make([]services.DomainSummary, 0),
+	}
+	alerts := watchlist.AlertsSince(since)
+
+	for _, watched := range domains {
+		certs, err := services.FetchCertificates(context.Background(), watched.Domain)
+		if err != nil {
+			summary.Domains = append(summary.Domains, services.DomainSummary{Domain: watched.Domain, Error: err.Error()})
+			continue
+		}
+		groups := services.GroupCertificates(certs)
+		summary.Domains = append(summary.Domains, services.SummarizeDomain(watched.Domain, groups, alerts, since, now))
+	}
+
+	return summary
+}
+
+// renderSummary executes the summary email template
+func renderSummary(summary services.WatchlistSummary) ([]byte, error) {
+	tmpl, err := parseTemplate("summary_email.html")
+	if err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, summary); err != nil {
+		return nil, err
+	}
+	return html.Bytes(), nil
+}
+
+// sendSummary builds, renders and emails the summary for the period
+func sendSummary(since, now time.Time) error {
+	if summaryMail == nil {
+		return errors.New("summary email is not configured")
+	}
+
+	html, err := renderSummary(buildSummary(watchlist.List(), since, now))
+	if err != nil {
+		return fmt.Errorf("failed to render summary: %w", err)
+	}
+
+	subject := fmt.Sprintf("Certificate summary %s to %s", since.Format("2006-01-02"), now.Format("2006-01-02"))
+	return sendMail(summaryMail, subject, html)
+}
+
+// sendTeamSummaries emails each team with alert emails the summary of its own domains
+func sendTeamSummaries(since, now time.Time) {
+	for _, team := range teams.List("") {
+		domains := watchlist.ListForTeam(team.Slug)
+		if len(team.AlertEmails) == 0 || len(domains) == 0 {
+			continue
+		}
+
+		summary := buildSummary(domains, since, now)
+		summary.Team = team.Name
+		html, err := renderSummary(summary)
+		if err == nil {
+			config := *summaryMail
+			config.To = team.AlertEmails
+			subject := fmt.Sprintf("Certificate summary for %s, %s to %s", team.Name, since.Format("2006-01-02"), now.Format("2006-01-02"))
+			err = sendMail(&config, subject, html)
+		}
+		if err != nil {
+			log.Printf("summary: team %s: %v", team.Slug, err)
+			auditSystemAction("summary.failed", "team:"+team.Slug, err.Error())
+			continue
+		}
+		auditSystemAction("summary.sent", "team:"+team.Slug, strings.Join(team.AlertEmails, ", "))
+	}
+}
+
+// sendMail sends an HTML email to the configured distribution list
+func sendMail(config *mailConfig, subject string, html []byte) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.Write(html)
+
+	var auth smtp.Auth
+	if config.Username != "" {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address: %w", err)
+		}
+		smtpPassword.Lock()
+		password := smtpPassword.value
+		smtpPassword.Unlock()
+		auth = smtp.PlainAuth("", config.Username, password, host)
+	}
+
+	if err := smtp.SendMail(config.Addr, auth, config.From, config.To, msg.Bytes()); err != nil {
+		return fmt.Errorf("failed to send summary email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// teamAlerts is how many recent alerts a team's page shows
+const teamAlerts = 20
+
+// teams holds the team workspaces
+var teams *services.TeamStore
+
+// TeamsData holds data to pass to the teams template
+type TeamsData struct {
+	Teams []TeamRow
+	Error string
+}
+
+// TeamRow is one team in the list, with the viewer's role in it
+type TeamRow struct {
+	services.Team
+	MyRole  string `json:"role"`
+	Domains int    `json:"domains"`
+}
+
+// TeamData holds data to pass to the team template
+type TeamData struct {
+	Team      services.Team            `json:"team"`
+	Role      string                   `json:"role"` // The viewer's role
+	Domains   []services.WatchedDomain `json:"domains"`
+	Alerts    []services.Alert         `json:"alerts"`
+	Roles     []string                 `json:"-"`
+	CanEdit   bool                     `json:"-"` // May add and remove domains
+	CanManage bool                     `json:"-"` // May manage members and alert channels
+	Error     string                   `json:"-"`
+}
+
+// teamsHandler lists the user's teams (GET) and creates teams (POST)
+func teamsHandler(w http.ResponseWriter, r *http.Request) {
+	data := TeamsData{}
+
+	if r.Method == http.MethodPost {
+		team, err := teams.Create(r.FormValue("slug"), r.FormValue("name"), currentUsername(r))
+		if err != nil {
+			data.Error = err.Error()
+		} else {
+			auditAction(r, "team.create", team.Slug, team.Name)
+			http.Redirect(w, r, "/team?slug="+team.Slug, http.StatusSeeOther)
+			return
+		}
+	}
+
+	data.Teams = listTeams(r)
+
+	tmpl, err := parseTemplate("teams.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// teamHandler shows a team's domains, alerts, members and alert channels (?slug=)
+// POSTs change them (?action=), subject to the user's role in the team
+func teamHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := teams.Get(r.FormValue("slug"))
+	role := teamRole(r, team)
+	if !ok || role == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := TeamData{Role: role}
+	if r.Method == http.MethodPost {
+		if err := updateTeam(r, team, role); err != nil {
+			data.Error = err.Error()
+		} else if r.FormValue("action") == "delete" {
+			http.Redirect(w, r, "/teams", http.StatusSeeOther)
+			return
+		}
+		team, _ = teams.Get(team.Slug)
+	}
+	data = teamData(team, role, data.Error)
+
+	tmpl, err := parseTemplate("team.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiTeamsHandler lists the user's teams, or returns one team's domains and alerts (?slug=)
+// POST creates a team (?slug=&name=) with the user as its owner
+func apiTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		slug := r.URL.Query().Get("slug")
+		if slug == "" {
+			writeJSON(w, http.StatusOK, listTeams(r))
+			return
+		}
+		team, ok := teams.Get(slug)
+		role := teamRole(r, team)
+		if !ok || role == "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "team not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, teamData(team, role, ""))
+	case http.MethodPost:
+		team, err := teams.Create(r.URL.Query().Get("slug"), r.URL.Query().Get("name"), currentUsername(r))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		auditAction(r, "team.create", team.Slug, team.Name)
+		writeJSON(w, http.StatusCreated, team)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// updateTeam applies a POSTed change to a team if role allows it
+func updateTeam(r *http.Request, team services.Team, role string) error {
+	action := r.FormValue("action")
+	required := services.RoleOwner
+	if action == "add-domain" || action == "remove-domain" {
+		required = services.RoleEditor
+	}
+	if !services.RoleAtLeast(role, required) {
+		return fmt.Errorf("you need to be a team %s to do that", required)
+	}
+
+	switch action {
+	case "add-domain":
+		domain, err := services.NormalizeDomain(r.FormValue("domain"))
+		if err != nil {
+			return err
+		}
+		if err := watchlist.AddForTeam(team.Slug, domain); err != nil {
+			return err
+		}
+		auditAction(r, "team.domain_add", team.Slug, services.NormalizeName(domain))
+		go checkWatchedDomain(watchlist, services.NormalizeName(domain))
+	case "remove-domain":
+		domain, err := services.NormalizeDomain(r.FormValue("domain"))
+		if err != nil {
+			return err
+		}
+		removed, err := watchlist.RemoveForTeam(team.Slug, domain)
+		if err != nil {
+			return err
+		}
+		if removed {
+			auditAction(r, "team.domain_remove", team.Slug, domain)
+		}
+	case "set-member":
+		username := strings.ToLower(strings.TrimSpace(r.FormValue("username")))
+		if users != nil {
+			if _, exists := users.Get(username); !exists {
+				return fmt.Errorf("there is no user named %q", username)
+			}
+		}
+		if err := teams.SetMember(team.Slug, username, r.FormValue("role")); err != nil {
+			return err
+		}
+		auditAction(r, "team.member_set", team.Slug, username+" as "+r.FormValue("role"))
+	case "remove-member":
+		removed, err := teams.RemoveMember(team.Slug, r.FormValue("username"))
+		if err != nil {
+			return err
+		}
+		if removed {
+			auditAction(r, "team.member_remove", team.Slug, r.FormValue("username"))
+		}
+	case "set-channels":
+		emails := splitList(strings.NewReplacer("\n", ",", "\r", "").Replace(r.FormValue("emails")))
+		if err := teams.SetAlertEmails(team.Slug, emails); err != nil {
+			return err
+		}
+		auditAction(r, "team.channels_set", team.Slug, strings.Join(emails, ", "))
+	case "delete":
+		if _, err := teams.Delete(team.Slug); err != nil {
+			return err
+		}
+		if err := watchlist.RemoveTeam(team.Slug); err != nil {
+			return err
+		}
+		auditAction(r, "team.delete", team.Slug, team.Name)
+	}
+
+	return nil
+}
+
+// teamData collects what the team page and API show
+func teamData(team services.Team, role, errorMessage string) TeamData {
+	return TeamData{
+		Team:      team,
+		Role:      role,
+		Domains:   watchlist.ListForTeam(team.Slug),
+		Alerts:    watchlist.RecentTeamAlerts(team.Slug, teamAlerts),
+		Roles:     []string{services.RoleViewer, services.RoleEditor, services.RoleOwner},
+		CanEdit:   services.RoleAtLeast(role, services.RoleEditor),
+		CanManage: services.RoleAtLeast(role, services.RoleOwner),
+		Error:     errorMessage,
+	}
+}
+
+// listTeams returns the teams the user can see, with their role in each
+// Admins, and everyone when accounts are disabled, see every team
+func listTeams(r *http.Request) []TeamRow {
+	user, _ := currentUser(r)
+	username := user.Username
+	if users == nil || user.Admin {
+		username = ""
+	}
+
+	rows := make([]TeamRow, 0)
+	for _, team := range teams.List(username) {
+		rows = append(rows, TeamRow{
+			Team:    team,
+			MyRole:  teamRole(r, team),
+			Domains: len(watchlist.ListForTeam(team.Slug)),
+		})
+	}
+	return rows
+}
+
+// teamRole returns the user's role in team, or "" if they can't see it
+// Admins, and everyone when accounts are disabled, act as owners of every team
+func teamRole(r *http.Request, team services.Team) string {
+	if users == nil {
+		return services.RoleOwner
+	}
+	user, _ := currentUser(r)
+	if role := team.Role(user.Username); role != "" {
+		return role
+	}
+	if user.Admin {
+		return services.RoleOwner
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// embeddedTemplates are built into the binary so it runs without the templates directory
+//
+//go:embed templates/*.html templates/partials/*.html
+var embeddedTemplates embed.FS
+
+// templateFS is where templates are loaded from: the embedded copies,
+// with any files in -templates-dir taking their place
+var templateFS fs.FS = mustSub(embeddedTemplates, "templates")
+
+// overlayFS reads files from dir, falling back to base for files dir doesn't have
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+// Open returns the override if there is one, otherwise the embedded file
+func (o overlayFS) Open(name string) (fs.File, error) {
+	file, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return file, err
+}
+
+// ReadDir lists a directory's files from both, so a glob finds the embedded files and any the directory adds
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	base, baseErr := fs.ReadDir(o.base, name)
+	extra, dirErr := fs.ReadDir(o.dir, name)
+	if baseErr != nil && dirErr != nil {
+		return nil, baseErr
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	for _, entry := range append(base, extra...) {
+		entries[entry.Name()] = entry
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+// useTemplateDir makes templates in dir override the embedded ones
+// They are read on every request, so edits show up without restarting
+func useTemplateDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to use templates directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("failed to use templates directory: %s is not a directory", dir)
+	}
+	templateFS = overlayFS{dir: os.DirFS(dir), base: mustSub(embeddedTemplates, "templates")}
+	return nil
+}
+
+// parseTemplate parses a template by file name, e.g. "index.html", with the partials pages share
+// Partials are parsed after the page, so a partial in -templates-dir fills in the branding hooks for every page
+func parseTemplate(name string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).ParseFS(templateFS, name, "partials/*.html")
+}
+
+// staticHandler serves the static subdirectory of -templates-dir at /static/, for logos and stylesheets the
+// branding partials refer to
+func staticHandler(dir string) http.Handler {
+	return http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(dir, "static"))))
+}
+
+// pageFuncs binds the template functions that depend on the visitor, their theme, language and timezone, to a request
+// Page handlers apply it before executing a template; emails and PDFs keep the defaults in templateFuncs
+func pageFuncs(r *http.Request) template.FuncMap {
+	funcs := themeFuncs(r)
+	maps.Copy(funcs, languageFuncs(r))
+	maps.Copy(funcs, timeFuncs(r))
+	return funcs
+}
+
+// mustSub returns the subdirectory of an embedded filesystem, which always exists
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// streamChunkSize is how much of a long page is rendered before {{flush}} sends it on
+const streamChunkSize = 32 << 10
+
+// pageStream sends a long page in pieces as it renders, rather than leaving them to proxies and the
+// compressor to hold back: {{flush}} in a template sends what has been written once there's
+// streamChunkSize of it, and always the first time, so the top of the page shows while the rest renders
+type pageStream struct {
+	w       http.ResponseWriter
+	pending int
+	flushed bool
+}
+
+func (s *pageStream) Write(b []byte) (int, error) {
+	s.pending += len(b)
+	return s.w.Write(b)
+}
+
+// funcs binds {{flush}} to the stream; templates executed some other way get the no-op in templateFuncs
+func (s *pageStream) funcs() template.FuncMap {
+	return template.FuncMap{"flush": func() string {
+		if s.pending > 0 && (!s.flushed || s.pending >= streamChunkSize) {
+			http.NewResponseController(s.w).Flush()
+			s.pending, s.flushed = 0, true
+		}
+		return ""
+	}}
+}
+
+// executeStreamed renders a page that can be long, like every certificate of a large domain, sending
+// it in pieces at each {{flush}}
+func executeStreamed(w http.ResponseWriter, r *http.Request, tmpl *template.Template, data any) error {
+	stream := &pageStream{w: w}
+	return tmpl.Funcs(pageFuncs(r)).Funcs(stream.funcs()).Execute(stream, data)
+}
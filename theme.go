@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Themes a visitor can choose, kept in themeCookie
+const (
+	themeLight  = "light"
+	themeDark   = "dark"
+	themeSystem = "system" // Follow the browser's light or dark setting
+)
+
+// themeCookie remembers the chosen theme for a year, signed in or not
+const themeCookie = "theme"
+
+// darkThemeCSS darkens a page by inverting it, which works with every template's own colors;
+// the hue is rotated back so links and badges keep their meaning, and images are inverted back
+const darkThemeCSS = `html { background: #0a0a0a; } body { filter: invert(1) hue-rotate(180deg); min-height: 100vh; } img, video { filter: invert(1) hue-rotate(180deg); }`
+
+// requestTheme is the theme the visitor chose, light when they haven't
+func requestTheme(r *http.Request) string {
+	if cookie, err := r.Cookie(themeCookie); err == nil {
+		switch cookie.Value {
+		case themeLight, themeDark, themeSystem:
+			return cookie.Value
+		}
+	}
+	return themeLight
+}
+
+// themeFuncs replaces the theme template functions with ones for the visitor's theme
+func themeFuncs(r *http.Request) template.FuncMap {
+	theme := requestTheme(r)
+	return template.FuncMap{
+		"theme":      func() string { return theme },
+		"themeStyle": func() template.HTML { return themeStyle(theme) },
+	}
+}
+
+// themeStyle is the style element a page needs for a theme, nothing for light
+func themeStyle(theme string) template.HTML {
+	switch theme {
+	case themeDark:
+		return template.HTML("<style>" + darkThemeCSS + "</style>")
+	case themeSystem:
+		return template.HTML("<style>@media (prefers-color-scheme: dark) { " + darkThemeCSS + " }</style>")
+	}
+	return ""
+}
+
+// themeHandler saves the theme posted from a form and goes back to the page it was on (?return= path)
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	theme := r.PostFormValue("theme")
+	switch theme {
+	case themeLight, themeDark, themeSystem:
+	default:
+		http.Error(w, tr(r, "Theme must be light, dark or system"), http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    theme,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, safeNext(r.PostFormValue("return")), http.StatusSeeOther)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// timezoneCookie remembers the timezone chosen on the homepage for a year, over the profile's
+const timezoneCookie = "tz"
+
+// requestTimezone is where to show times for the visitor: the tz cookie, then the timezone in their
+// notification settings, then UTC
+func requestTimezone(r *http.Request) *time.Location {
+	if cookie, err := r.Cookie(timezoneCookie); err == nil {
+		if location, err := services.LoadTimezone(cookie.Value); err == nil {
+			return location
+		}
+	}
+	if prefs := notificationPrefs.Get(currentUsername(r)); prefs.Timezone != "" {
+		if location, err := services.LoadTimezone(prefs.Timezone); err == nil {
+			return location
+		}
+	}
+	return time.UTC
+}
+
+// timeFuncs replaces the time template functions with ones for the visitor's timezone and language:
+// localTime formats a time there, and relativeTime and expiry say how far it is from now
+func timeFuncs(r *http.Request) template.FuncMap {
+	location := requestTimezone(r)
+	lang := requestLanguage(r)
+	return template.FuncMap{
+		"timezone":     func() string { return location.String() },
+		"localTime":    func(t time.Time) string { return localTime(t, location) },
+		"relativeTime": func(t time.Time) string { return relativeTime(lang, t) },
+		"expiry":       func(t time.Time) string { return expiry(lang, t) },
+	}
+}
+
+// localTime formats a time in a timezone, or "" for the zero time of a timestamp that didn't parse
+func localTime(t time.Time, location *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(location).Format(services.DateTimeLayout)
+}
+
+// relativeTime says how long before or after now a time is, e.g. "3 hours ago"
+func relativeTime(lang string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return services.RelativeTime(lang, t, time.Now())
+}
+
+// expiry says when something expires or expired, e.g. "expires in 23 days"
+func expiry(lang string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return services.RelativeExpiry(lang, t, time.Now())
+}
+
+// timezoneHandler saves the timezone posted from a form and goes back to the page it was on (?return= path)
+// An empty timezone forgets the choice, going back to the profile's timezone or UTC
+func timezoneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, tr(r, "Method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     timezoneCookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if name := r.PostFormValue("timezone"); name != "" {
+		location, err := services.LoadTimezone(name)
+		if err != nil {
+			http.Error(w, tr(r, "Unknown timezone, use an IANA name such as Europe/Berlin"), http.StatusBadRequest)
+			return
+		}
+		cookie.Value = location.String()
+		cookie.Expires = time.Now().AddDate(1, 0, 0)
+	} else {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, safeNext(r.PostFormValue("return")), http.StatusSeeOther)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// TLSAData holds data to pass to the TLSA generator template
+type TLSAData struct {
+	ID      string                   `json:"-"`
+	Host    string                   `json:"host"`
+	Port    int                      `json:"port"`
+	Name    string                   `json:"name"` // Owner name, e.g. "_443._tcp.www.example.com"
+	Subject string                   `json:"subject"`
+	CA      bool                     `json:"ca"`
+	Records []services.GeneratedTLSA `json:"records"`
+	Error   string                   `json:"error,omitempty"`
+	Invalid *services.DomainError    `json:"invalid,omitempty"`
+	status  int                      // HTTP status for API responses
+}
+
+// tlsaHandler generates TLSA records for a certificate from the results (?id= crt.sh ID, optional ?host= and ?port=)
+func tlsaHandler(w http.ResponseWriter, r *http.Request) {
+	data := TLSAData{Port: 443}
+	if r.URL.Query().Get("id") != "" {
+		data = runTLSAGenerator(r.Context(), r.URL.Query())
+	}
+
+	tmpl, err := parseTemplate("tlsa.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiTLSAHandler returns the generated TLSA records as JSON
+func apiTLSAHandler(w http.ResponseWriter, r *http.Request) {
+	data := runTLSAGenerator(r.Context(), r.URL.Query())
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runTLSAGenerator downloads the certificate and computes its TLSA records
+// The host defaults to the certificate's first DNS name that isn't a wildcard, and the port to 443
+func runTLSAGenerator(ctx context.Context, query url.Values) TLSAData {
+	data := TLSAData{
+		ID:     strings.TrimSpace(query.Get("id")),
+		Port:   443,
+		status: http.StatusOK,
+	}
+
+	id, err := strconv.ParseInt(data.ID, 10, 64)
+	if err != nil || id <= 0 {
+		data.Error = "Please enter a crt.sh certificate ID"
+		data.status = http.StatusBadRequest
+		return data
+	}
+	if raw := strings.TrimSpace(query.Get("port")); raw != "" {
+		port, err := strconv.Atoi(raw)
+		if err != nil || port < 1 || port > 65535 {
+			data.Error = "Port must be a number between 1 and 65535"
+			data.status = http.StatusBadRequest
+			return data
+		}
+		data.Port = port
+	}
+	if host := strings.TrimSpace(query.Get("host")); host != "" {
+		if data.Host, err = services.NormalizeField("host", host); err != nil {
+			data.status, data.Error = serviceError(err)
+			errors.As(err, &data.Invalid)
+			return data
+		}
+	}
+
+	pemData, err := services.FetchPEM(ctx, id)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		return data
+	}
+	cert, err := services.ParseCertificate(pemData)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadGateway
+		return data
+	}
+
+	if data.Host == "" {
+		for _, name := range cert.DNSNames {
+			if !strings.HasPrefix(name, "*.") {
+				data.Host = services.NormalizeName(name)
+				break
+			}
+		}
+	}
+	if data.Host != "" {
+		data.Name = services.TLSAName(data.Host, data.Port)
+	}
+	data.Subject = cert.Subject.String()
+	data.CA = cert.IsCA
+	data.Records = services.GenerateTLSARecords(cert)
+	return data
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// Delivery to users' webhooks
+const (
+	// webhookAttempts is how many times a payload is sent before it's given up on
+	webhookAttempts = 5
+
+	// webhookRetryDelay is how long to wait before the first retry; each retry waits twice as long as the last
+	webhookRetryDelay = 10 * time.Second
+
+	// webhookDeliveriesShown is how many deliveries the notifications page and API list
+	webhookDeliveriesShown = 50
+)
+
+// webhookDeliveries logs what was sent to users' webhooks and how it went, and what's still to send
+var webhookDeliveries *services.DeliveryLog
+
+// deliverWebhook POSTs a payload to a user's webhook in the background, retrying with backoff until it's
+// accepted or attempts run out, then audits the outcome
+func deliverWebhook(prefs services.NotificationPrefs, event string, payload []byte, summary string) {
+	delivery, err := webhookDeliveries.Start(prefs.Username, prefs.WebhookURL, event, payload)
+	if err != nil {
+		log.Printf("notify: %s: %v", notifyTarget(prefs.Username), err)
+		if delivery.ID == "" {
+			return
+		}
+	}
+	go sendDelivery(delivery, prefs.WebhookSecret, summary)
+}
+
+// resumeWebhookDeliveries restarts the deliveries that were still pending when the server stopped, from
+// their first attempt, signed with each user's current secret
+func resumeWebhookDeliveries() {
+	for _, delivery := range webhookDeliveries.Pending() {
+		go sendDelivery(delivery, notificationPrefs.Get(delivery.Username).WebhookSecret, "resumed delivery")
+	}
+}
+
+// sendDelivery makes a delivery's attempts
+func sendDelivery(delivery services.Delivery, secret, summary string) {
+	target := notifyTarget(delivery.Username)
+	delay := webhookRetryDelay
+	for tries := 1; ; tries++ {
+		attempt, err := postWebhook(delivery, secret)
+		if err == nil {
+			recordAttempt(delivery.ID, attempt, services.DeliveryDelivered)
+			auditSystemAction("notify.sent", target, fmt.Sprintf("%s by webhook (delivery %s)", summary, delivery.ID))
+			return
+		}
+		// Redirects aren't followed and other 4xx answers won't change on a retry, e.g. a deleted endpoint,
+		// nor will an internal address
+		permanent := attempt.Status >= 300 && attempt.Status < 500 && attempt.Status != http.StatusRequestTimeout && attempt.Status != http.StatusTooManyRequests ||
+			errors.Is(err, services.ErrWebhookAddress)
+		if permanent || tries == webhookAttempts {
+			recordAttempt(delivery.ID, attempt, services.DeliveryFailed)
+			log.Printf("notify: %s: %v", target, err)
+			auditSystemAction("notify.failed", target, fmt.Sprintf("webhook (delivery %s, %d attempt(s)): %v", delivery.ID, tries, err))
+			return
+		}
+		recordAttempt(delivery.ID, attempt, services.DeliveryPending)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// recordAttempt adds an attempt to the delivery log; one that can't be saved is still kept in memory
+func recordAttempt(id string, attempt services.DeliveryAttempt, state string) {
+	if err := webhookDeliveries.Record(id, attempt, state); err != nil {
+		log.Printf("notify: %v", err)
+	}
+}
+
+// redeliverWebhook sends a delivery of username's that was given up on again, in the background, signed
+// with their current secret
+func redeliverWebhook(username, id string) (services.Delivery, bool) {
+	delivery, ok, err := webhookDeliveries.Retry(username, id)
+	if err != nil {
+		log.Printf("notify: %v", err)
+	}
+	if !ok {
+		return delivery, false
+	}
+	go sendDelivery(delivery, notificationPrefs.Get(username).WebhookSecret, "redelivery")
+	return delivery, true
+}
+
+// postWebhook makes one attempt at a delivery, signing it when there's a secret
+// The attempt says only how the webhook answered, or roughly why it didn't, so the delivery log can't be
+// used to map out what the server can reach; the full error is returned for the server's log
+func postWebhook(delivery services.Delivery, secret string) (services.DeliveryAttempt, error) {
+	attempt := services.DeliveryAttempt{At: time.Now().UTC()}
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = "invalid webhook URL"
+		return attempt, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "certviewer-webhook")
+	req.Header.Set("X-Certviewer-Delivery", delivery.ID)
+	req.Header.Set("X-Certviewer-Event", delivery.Event)
+	req.Header.Set("X-Certviewer-Timestamp", strconv.FormatInt(attempt.At.Unix(), 10))
+	if secret != "" {
+		req.Header.Set("X-Certviewer-Signature", services.SignWebhook(secret, attempt.At, delivery.Payload))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		attempt.Error = attemptError(err)
+		return attempt, fmt.Errorf("failed to post to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	attempt.DurationMS = time.Since(attempt.At).Milliseconds()
+	attempt.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	return attempt, nil
+}
+
+// attemptError says why a webhook didn't answer, without the network detail
+func attemptError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, services.ErrWebhookAddress):
+		return services.ErrWebhookAddress.Error()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the webhook didn't answer in time"
+	}
+	return "could not connect to the webhook"
+}
+
+// notifyTarget names a user in notification logs and the audit log
+func notifyTarget(username string) string {
+	if username == "" {
+		return "anonymous"
+	}
+	return username
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// ZabbixItems are one watched domain's values for Zabbix items, from its last check
+type ZabbixItems struct {
+	Valid             bool   `json:"valid"`             // Some hostname has a valid certificate
+	DaysRemaining     int    `json:"daysRemaining"`     // Until the first hostname runs out of valid certificates; 0 when none is valid
+	NotAfter          int64  `json:"notAfter"`          // When that is, as a Unix timestamp; 0 when none is valid
+	Hostname          string `json:"hostname"`          // The hostname that runs out first
+	Issuer            string `json:"issuer"`            // Issuer of its newest certificate
+	Certificates      int    `json:"certificates"`      // Valid certificates
+	UnexpectedIssuers int    `json:"unexpectedIssuers"` // Certificates ever logged by an issuer outside -expected-issuers
+	LastCheck         int64  `json:"lastCheck"`         // When the domain was last checked, as a Unix timestamp
+}
+
+// apiZabbixDiscoveryHandler answers a Zabbix low-level discovery rule with the watched domains as {#DOMAIN}
+func apiZabbixDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	discovered := make([]map[string]string, 0)
+	for _, watched := range watchlist.ListFor(currentUsername(r)) {
+		discovered = append(discovered, map[string]string{"{#DOMAIN}": watched.Domain})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": discovered})
+}
+
+// apiZabbixItemsHandler returns the watched domains' item values from their last checks, keyed by domain,
+// for a master item whose dependent items pick one out with JSONPath, e.g. $["example.com"].daysRemaining
+// ?domain= returns one domain's values, and with ?key= (e.g. daysRemaining) just that value as text,
+// for an HTTP agent item per discovered domain
+// Domains not checked yet are left out, or 404 on their own
+func apiZabbixItemsHandler(w http.ResponseWriter, r *http.Request) {
+	domains := make([]string, 0)
+	for _, watched := range watchlist.ListFor(currentUsername(r)) {
+		domains = append(domains, watched.Domain)
+	}
+	now := time.Now()
+
+	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
+	if domain == "" {
+		all := make(map[string]ZabbixItems)
+		for _, metrics := range services.MetricsFor(domains) {
+			all[metrics.Domain] = zabbixItems(metrics, now)
+		}
+		writeJSON(w, http.StatusOK, all)
+		return
+	}
+
+	domain, err := services.NormalizeDomain(domain)
+	if err != nil {
+		status, message := serviceError(err)
+		writeJSON(w, status, map[string]string{"error": message})
+		return
+	}
+	var metrics []services.DomainMetrics
+	if slices.Contains(domains, domain) {
+		metrics = services.MetricsFor([]string{domain})
+	}
+	if len(metrics) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s isn't on your watchlist or hasn't been checked yet", domain)})
+		return
+	}
+	items := zabbixItems(metrics[0], now)
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSON(w, http.StatusOK, items)
+		return
+	}
+	// Keys are the JSON field names
+	encoded, _ := json.Marshal(items)
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber() // So timestamps print as written rather than in exponent form
+	var fields map[string]any
+	decoder.Decode(&fields)
+	value, ok := fields[key]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown key %q, use a field of the domain's items, e.g. daysRemaining", key)})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, value)
+}
+
+// zabbixItems turns a domain's metrics into its item values
+func zabbixItems(metrics services.DomainMetrics, now time.Time) ZabbixItems {
+	items := ZabbixItems{
+		Certificates:      metrics.ActiveCertificates,
+		UnexpectedIssuers: metrics.UnexpectedIssuer,
+		LastCheck:         metrics.CheckedAt.Unix(),
+	}
+	if !metrics.NotAfter.IsZero() {
+		items.Valid = true
+		items.DaysRemaining = max(metrics.DaysRemaining(now), 0)
+		items.NotAfter = metrics.NotAfter.Unix()
+		items.Hostname = metrics.Hostname
+		items.Issuer = metrics.Issuer
+	}
+	return items
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jonisgett/tsl-certificate-work/services"
+)
+
+// ZoneData holds data to pass to the zone import template
+type ZoneData struct {
+	Origin    string                      `json:"origin"`
+	Records   int                         `json:"records"`
+	Result    services.ZoneCrossReference `json:"result"`
+	Watched   bool                        `json:"watched"` // Seeded into the watchlist
+	Submitted bool                        `json:"-"`
+	Error     string                      `json:"error,omitempty"`
+	Invalid   *services.DomainError       `json:"invalid,omitempty"`
+
+	status int // HTTP status for API responses
+}
+
+// zoneHandler shows the zone upload form (GET) and the cross-reference (POST)
+func zoneHandler(w http.ResponseWriter, r *http.Request) {
+	var data ZoneData
+	if r.Method == http.MethodPost {
+		data = runZoneImport(w, r)
+	}
+
+	tmpl, err := parseTemplate("zone.html")
+	if err != nil {
+		http.Error(w, tr(r, "Could not load page"), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl.Funcs(pageFuncs(r)).Execute(w, data)
+}
+
+// apiZoneHandler imports a zone file sent as a multipart "file" field or as the raw request body
+func apiZoneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	data := runZoneImport(w, r)
+	if data.Error != "" {
+		writeJSON(w, data.status, ErrorResponse{Error: data.Error, Invalid: data.Invalid})
+		return
+	}
+	writeJSON(w, data.status, data)
+}
+
+// runZoneImport parses the uploaded zone, compares it with the origin's CT inventory,
+// and seeds the watchlist when ?watch= is set
+func runZoneImport(w http.ResponseWriter, r *http.Request) ZoneData {
+	data := ZoneData{
+		Submitted: true,
+		status:    http.StatusOK,
+	}
+
+	body, err := importBody(w, r)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	defer body.Close()
+
+	// Options come from the upload form, or the query string for raw bodies
+	origin := formOption(r, "origin")
+	watch := formOption(r, "watch") != ""
+
+	if origin != "" {
+		if origin, err = services.NormalizeField("origin", origin); err != nil {
+			data.status, data.Error = serviceError(err)
+			errors.As(err, &data.Invalid)
+			return data
+		}
+	}
+
+	zone, err := services.ParseZoneFile(body, origin)
+	if err != nil {
+		data.Error = err.Error()
+		data.status = http.StatusBadRequest
+		return data
+	}
+	data.Origin = zone.Origin
+	data.Records = zone.Records
+
+	certs, err := services.FetchCertificates(r.Context(), zone.Origin)
+	if err != nil {
+		data.status, data.Error = serviceError(err)
+		return data
+	}
+
+	now := time.Now()
+	inventory := services.BuildSubdomainInventory(zone.Origin, services.GroupCertificates(certs), now)
+	data.Result = services.CrossReferenceZone(zone, inventory)
+	auditAction(r, "zone.import", zone.Origin, fmt.Sprintf("%d hostnames, watch=%t", len(zone.Hostnames), watch))
+
+	if watch {
+		// Seed with the zone's names, then record what CT already shows as the baseline
+		if err := watchlist.SeedHosts(currentUsername(r), zone.Origin, zone.SortedHostnames(), now); err != nil {
+			data.Error = err.Error()
+			data.status = http.StatusInternalServerError
+			return data
+		}
+		if _, err := watchlist.RecordInventory(zone.Origin, inventory, now); err != nil {
+			data.Error = err.Error()
+			data.status = http.StatusInternalServerError
+			return data
+		}
+		data.Watched = true
+	}
+
+	return data
+}
+
+// formOption reads an upload form field, falling back to the query string
+func formOption(r *http.Request, name string) string {
+	if r.MultipartForm != nil && len(r.MultipartForm.Value[name]) > 0 {
+		return strings.TrimSpace(r.MultipartForm.Value[name][0])
+	}
+	return strings.TrimSpace(r.URL.Query().Get(name))
+}